@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"gorm.io/gorm"
+)
+
+const (
+	accountSuspendedMessage = "Your account has been suspended. Please contact an administrator."
+	sessionEndedMessage     = "Your session has ended. Please log in again."
+)
+
+// setSessionUser signs user in on session. The session is tied to a new
+// UserSession row, which can be revoked on its own, and to the user's
+// current session version, so bumping it ends the session. With a
+// server-side session store the session also gets a new ID, so an ID
+// planted in the browser before sign-in can't be used to share it.
+func (app *App) setSessionUser(r *http.Request, session *sessions.Session, user User) error {
+	token, err := app.createUserSession(r, user, time.Now())
+	if err != nil {
+		return err
+	}
+	app.deleteUserSession(session)
+	app.deleteImpersonatorSession(session)
+	clearImpersonation(session)
+	session.ID = ""
+	session.Values["user_id"] = user.ID
+	session.Values["session_version"] = user.SessionVersion
+	session.Values["session_token"] = token
+	return nil
+}
+
+// sessionVersion returns the session version stored by setSessionUser.
+func sessionVersion(session *sessions.Session) uint {
+	version, _ := session.Values["session_version"].(uint)
+	return version
+}
+
+// validUserSession reports whether the session row behind session still
+// exists and hasn't expired, and marks it as used.
+func (app *App) validUserSession(session *sessions.Session, userID interface{}) bool {
+	now := time.Now()
+	us, err := app.currentUserSession(session, userID, now)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false
+	}
+	if err == nil {
+		app.touchUserSession(us, now)
+	}
+	// Like the user lookup above, a database error doesn't end the session
+	return true
+}
+
+// isUserDisabled reports whether the user's account is suspended.
+func (app *App) isUserDisabled(userID interface{}) bool {
+	var user User
+	if err := app.db.Select("disabled").First(&user, userID).Error; err != nil {
+		return false
+	}
+	return user.Disabled
+}
+
+// isUserVerified reports whether the user has verified their email address.
+// Like isUserDisabled, it gives the user the benefit of the doubt when the
+// account can't be read.
+func (app *App) isUserVerified(userID interface{}) bool {
+	var user User
+	if err := app.db.Select("verified").First(&user, userID).Error; err != nil {
+		return true
+	}
+	return user.Verified
+}
+
+// rejectDisabledUsers ends the session of a suspended or deleted user, of a
+// demo account past its expiry, or one signed out by a password change or
+// revoked from the sessions list, on their next request, so suspending an
+// account takes effect without waiting for the session cookie to expire.
+// Users who haven't verified their email address keep their session but
+// are turned away from the item and stats pages.
+func (app *App) rejectDisabledUsers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, _ := app.store.Get(r, "session")
+		userID, ok := session.Values["user_id"]
+		if !ok || userID == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var user User
+		err := app.db.Select("disabled", "verified", "expires_at", "session_version").First(&user, userID).Error
+		status, message := http.StatusForbidden, accountSuspendedMessage
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			// The account was deleted, e.g. an expired demo account
+			status, message = http.StatusUnauthorized, sessionEndedMessage
+		case err != nil:
+			next.ServeHTTP(w, r)
+			return
+		case user.SessionVersion != sessionVersion(session), !app.validUserSession(session, userID):
+			status, message = http.StatusUnauthorized, sessionEndedMessage
+		case !user.Disabled:
+			if user.ExpiresAt == nil || time.Now().Before(*user.ExpiresAt) {
+				if !user.Verified && requiresVerifiedEmail(r.URL.Path) {
+					app.writeErrorPage(w, r, http.StatusForbidden, verifyEmailMessage)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			status, message = http.StatusUnauthorized, demoExpiredMessage
+		}
+
+		app.deleteUserSession(session)
+		session.Values["user_id"] = nil
+		session.Options.MaxAge = -1
+		if err := session.Save(r, w); err != nil {
+			log.Println("Error saving session:", err)
+		}
+		app.writeErrorPage(w, r, status, message)
+	})
+}
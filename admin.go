@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// recordAudit stores an audit log entry for an action taken by actorID.
+func (app *App) recordAudit(actorID uint, action string, targetUserID uint) {
+	entry := AuditLog{
+		ActorID:      actorID,
+		Action:       action,
+		TargetUserID: targetUserID,
+		CreatedAt:    time.Now(),
+	}
+	if err := app.db.Create(&entry).Error; err != nil {
+		log.Println("Error writing audit log:", err)
+	}
+}
+
+// requireAdmin checks that the session belongs to an admin. It writes the
+// error response and returns false otherwise. Requests that already passed
+// requireRole(RoleAdmin) are not checked twice.
+func (app *App) requireAdmin(w http.ResponseWriter, r *http.Request) (User, bool) {
+	if admin, ok := roleUser(r); ok && admin.Role == RoleAdmin {
+		return admin, true
+	}
+	return app.checkRole(w, r, RoleAdmin)
+}
+
+// adminTarget checks that the session belongs to an admin and loads the
+// user named by the {id} route variable. It writes the error response and
+// returns false when either check fails.
+func (app *App) adminTarget(w http.ResponseWriter, r *http.Request) (admin, user User, ok bool) {
+	if admin, ok = app.requireAdmin(w, r); !ok {
+		return admin, user, false
+	}
+
+	id, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err := app.db.First(&user, id).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`<div class="error">User not found.</div>`))
+		return admin, user, false
+	}
+	return admin, user, true
+}
+
+// adminResetPasswordHandler gives a user a temporary password that they must
+// change on their next login, and ends their sessions and remembered
+// devices.
+func (app *App) adminResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	admin, user, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	password, err := app.passwordPolicy().temporaryPassword()
+	if err != nil {
+		log.Println("Error generating password:", err)
+		writeServerError(w)
+		return
+	}
+	hashedPassword, err := app.passwords.Hash(password)
+	if err != nil {
+		log.Println("Error hashing password:", err)
+		writeServerError(w)
+		return
+	}
+	// Whoever knew the old password is signed out everywhere
+	err = app.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&user).Updates(map[string]interface{}{
+			"password_hash":        hashedPassword,
+			"must_change_password": true,
+			"session_version":      gorm.Expr("session_version + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID)
+	})
+	if err != nil {
+		log.Println("Error resetting password:", err)
+		writeServerError(w)
+		return
+	}
+	app.recordAudit(admin.ID, "reset_password", user.ID)
+
+	w.Header().Set("HX-Trigger", adminUsersChangedEvent)
+	app.tmpl.ExecuteTemplate(w, "admin_reset_password.templ", map[string]interface{}{
+		"User":     user,
+		"Password": password,
+	})
+}
+
+func (app *App) adminDisableUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.setUserDisabled(w, r, true)
+}
+
+func (app *App) adminEnableUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.setUserDisabled(w, r, false)
+}
+
+// setUserDisabled suspends or restores a user's account. Suspended users
+// can't log in and their sessions and API tokens stop working, but their
+// items are kept.
+func (app *App) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	admin, user, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+	if disabled && user.ID == admin.ID {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`<div class="error">You can't suspend your own account.</div>`))
+		return
+	}
+
+	if user.Disabled != disabled {
+		if err := app.db.Model(&user).Update("disabled", disabled).Error; err != nil {
+			log.Println("Error updating user:", err)
+			writeServerError(w)
+			return
+		}
+		action := "enable_user"
+		if disabled {
+			action = "disable_user"
+		}
+		app.recordAudit(admin.ID, action, user.ID)
+	}
+
+	w.Header().Set("HX-Trigger", adminUsersChangedEvent)
+	app.tmpl.ExecuteTemplate(w, "admin_user_status.templ", map[string]interface{}{
+		"User":     user,
+		"Disabled": disabled,
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAdminDisableAndEnableUser(t *testing.T) {
+	handler, app := newTestApp(t)
+	admin := createTestUser(t, app, "root@example.com", "Correct-Horse-1", RoleAdmin)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	adminClient := newTestClient(t, handler)
+	adminClient.login("root@example.com", "Correct-Horse-1")
+	signedInAlice := newTestClient(t, handler)
+	signedInAlice.login("alice@example.com", "Correct-Horse-1")
+
+	setDisabled := func(action string) {
+		t.Helper()
+		resp, body := adminClient.post(fmt.Sprintf("/admin/users/%d/%s", alice.ID, action), nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d\n%s", action, resp.StatusCode, body)
+		}
+	}
+	login := func() string {
+		t.Helper()
+		c := newTestClient(t, handler)
+		_, body := c.post("/login", url.Values{"email": {"alice@example.com"}, "password": {"Correct-Horse-1"}})
+		return body
+	}
+
+	setDisabled("disable")
+	var stored User
+	app.db.First(&stored, alice.ID)
+	if !stored.Disabled {
+		t.Fatal("alice isn't disabled")
+	}
+	if body := login(); strings.Contains(body, "<h1>Dashboard</h1>") || !strings.Contains(body, accountSuspendedMessage) {
+		t.Errorf("a disabled user signed in:\n%s", body)
+	}
+	// Sessions started earlier stop working too
+	if resp, body := signedInAlice.get("/items"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("existing session: status %d, want %d\n%s", resp.StatusCode, http.StatusForbidden, body)
+	}
+
+	setDisabled("enable")
+	app.db.First(&stored, alice.ID)
+	if stored.Disabled {
+		t.Fatal("alice is still disabled")
+	}
+	aliceClient := newTestClient(t, handler)
+	aliceClient.login("alice@example.com", "Correct-Horse-1")
+
+	// Admins can't lock themselves out
+	resp, _ := adminClient.post(fmt.Sprintf("/admin/users/%d/disable", admin.ID), nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("disabling yourself: status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	// Nor can other users use the routes
+	if resp, _ := aliceClient.post(fmt.Sprintf("/admin/users/%d/disable", admin.ID), nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("disable as a user: status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	app.db.First(&stored, admin.ID)
+	if stored.Disabled {
+		t.Error("the admin was disabled")
+	}
+}
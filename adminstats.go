@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// The admin stats cover signups in the last adminStatsWeeks weeks and rank
+// the adminStatsTopUsers users who created the most items in the last
+// adminStatsActiveDays days.
+const (
+	adminStatsWeeks      = 12
+	adminStatsActiveDays = 30
+	adminStatsTopUsers   = 10
+)
+
+// adminStats holds the site-wide numbers shown on the admin stats page.
+type adminStats struct {
+	TotalUsers      int64             `json:"total_users"`
+	TotalItems      int64             `json:"total_items"`
+	SignupsPerWeek  []weeklySignups   `json:"signups_per_week"`
+	MostActiveUsers []activeUserStats `json:"most_active_users"`
+	MaxWeeklySignup int64             `json:"-"` // scales the bars on the page
+}
+
+// weeklySignups counts the accounts created in the week starting on Week
+// (a date in the configured timezone).
+type weeklySignups struct {
+	Week  string `json:"week"`
+	Count int64  `json:"count"`
+}
+
+// activeUserStats is one row of the most active users ranking.
+type activeUserStats struct {
+	UserID      uint   `json:"user_id"`
+	Email       string `json:"email"`
+	RecentItems int64  `json:"recent_items"`
+}
+
+// getAdminStats computes the site-wide stats. Like getItemStats, the week
+// boundaries are computed in Go so the queries don't depend on database
+// date functions.
+func (app *App) getAdminStats(now time.Time) adminStats {
+	now = now.In(app.config.Location)
+
+	var stats adminStats
+	app.db.Model(&User{}).Count(&stats.TotalUsers)
+	app.db.Model(&Item{}).Count(&stats.TotalItems)
+
+	// Timestamps are stored in the server's local zone; see countItemsSince
+	weekStart := startOfWeek(now, app.config.FirstWeekday).AddDate(0, 0, -7*(adminStatsWeeks-1))
+	for i := 0; i < adminStatsWeeks; i++ {
+		weekEnd := weekStart.AddDate(0, 0, 7)
+		week := weeklySignups{Week: weekStart.Format("2006-01-02")}
+		app.db.Model(&User{}).Where("created_at >= ? AND created_at < ?", weekStart.In(time.Local), weekEnd.In(time.Local)).Count(&week.Count)
+		if week.Count > stats.MaxWeeklySignup {
+			stats.MaxWeeklySignup = week.Count
+		}
+		stats.SignupsPerWeek = append(stats.SignupsPerWeek, week)
+		weekStart = weekEnd
+	}
+
+	since := now.AddDate(0, 0, -adminStatsActiveDays)
+	stats.MostActiveUsers = []activeUserStats{}
+	app.db.Model(&Item{}).
+		Select("items.user_id, users.email, count(*) AS recent_items").
+		Joins("JOIN users ON users.id = items.user_id").
+		Where("items.created_at >= ?", since.In(time.Local)).
+		Group("items.user_id, users.email").
+		Order("recent_items desc, items.user_id").
+		Limit(adminStatsTopUsers).
+		Scan(&stats.MostActiveUsers)
+	return stats
+}
+
+// adminStatsHandler shows site-wide stats. Clients that accept JSON, such
+// as chart scripts, get the numbers as JSON; htmx requests get the
+// fragment and direct visits the full page.
+func (app *App) adminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := app.requireAdmin(w, r); !ok {
+		return
+	}
+	stats := app.getAdminStats(time.Now())
+	switch {
+	case wantsJSON(r):
+		writeJSON(w, http.StatusOK, stats)
+	case r.Header.Get("HX-Request") == "true":
+		app.tmpl.ExecuteTemplate(w, "admin_stats.templ", stats)
+	default:
+		app.renderPage(w, r, http.StatusOK, "admin_stats", stats)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// adminUserListLimit caps how many users the admin panel lists at once;
+// searching narrows the list down.
+const adminUserListLimit = 100
+
+// adminUsersChangedEvent is sent in an HX-Trigger header by handlers that
+// change a user, so the admin panel reloads its list.
+const adminUsersChangedEvent = "adminUsersChanged"
+
+// adminUsersHandler shows the user management page. htmx requests get the
+// fragment; direct visits get the full page.
+func (app *App) adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := app.requireAdmin(w, r); !ok {
+		return
+	}
+	data := map[string]interface{}{}
+	if r.Header.Get("HX-Request") == "true" {
+		app.tmpl.ExecuteTemplate(w, "admin_users.templ", data)
+		return
+	}
+	app.renderPage(w, r, http.StatusOK, "admin_users", data)
+}
+
+// adminUserListHandler returns the users whose email contains the "q"
+// query parameter, newest first.
+func (app *App) adminUserListHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := app.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	search := strings.TrimSpace(r.FormValue("q"))
+	query := app.db.Model(&User{})
+	if search != "" {
+		query = query.Where("email LIKE ?", "%"+search+"%")
+	}
+	var total int64
+	query.Count(&total)
+	var users []User
+	query.Order("created_at desc, id desc").Limit(adminUserListLimit).Find(&users)
+
+	app.tmpl.ExecuteTemplate(w, "admin_user_list.templ", map[string]interface{}{
+		"Users":     users,
+		"Total":     total,
+		"Truncated": total > int64(len(users)),
+		"Search":    search,
+		"AdminID":   admin.ID,
+	})
+}
+
+// adminCreateUserHandler creates an account with a temporary password that
+// the user must change on their first login. The address is trusted as
+// verified, since an admin entered it.
+func (app *App) adminCreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := app.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	renderError := func(message string) {
+		app.tmpl.ExecuteTemplate(w, "admin_user_created.templ", map[string]interface{}{
+			"Error": message,
+		})
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		renderError("Please enter a valid email address.")
+		return
+	}
+	role := r.FormValue("role")
+	if role != RoleUser && role != RoleAdmin {
+		renderError("Role must be user or admin.")
+		return
+	}
+	var existing int64
+	app.db.Model(&User{}).Where("email = ?", email).Count(&existing)
+	if existing > 0 {
+		renderError("An account with this email already exists.")
+		return
+	}
+
+	password, err := app.passwordPolicy().temporaryPassword()
+	if err != nil {
+		log.Println("Error generating password:", err)
+		writeServerError(w)
+		return
+	}
+	hash, err := app.passwords.Hash(password)
+	if err != nil {
+		log.Println("Error hashing password:", err)
+		writeServerError(w)
+		return
+	}
+	user := User{Email: email, PasswordHash: hash, Role: role, Verified: true, MustChangePassword: true}
+	if err := app.db.Create(&user).Error; err != nil {
+		log.Println("Error creating user:", err)
+		writeServerError(w)
+		return
+	}
+	app.recordAudit(admin.ID, "create_user", user.ID)
+
+	w.Header().Set("HX-Trigger", adminUsersChangedEvent)
+	app.tmpl.ExecuteTemplate(w, "admin_user_created.templ", map[string]interface{}{
+		"User":     user,
+		"Password": password,
+	})
+}
+
+// adminDeleteUserHandler deletes a user and everything they own. Admins
+// delete their own account from the account page instead, which also keeps
+// the last admin from being removed.
+func (app *App) adminDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	admin, user, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+	if user.ID == admin.ID {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`<div class="error">You can't delete your own account here.</div>`))
+		return
+	}
+
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		return deleteUsers(tx, []uint{user.ID})
+	})
+	if err != nil {
+		log.Println("Error deleting user:", err)
+		writeServerError(w)
+		return
+	}
+	app.removeDataExportFiles(user.ID)
+	app.recordAudit(admin.ID, "delete_user", user.ID)
+
+	w.Header().Set("HX-Trigger", adminUsersChangedEvent)
+	app.tmpl.ExecuteTemplate(w, "admin_user_status.templ", map[string]interface{}{
+		"User":    user,
+		"Deleted": true,
+	})
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// userResponse is the public JSON representation of a User. It deliberately
+// leaves out PasswordHash.
+type userResponse struct {
+	ID        uint      `json:"id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newUserResponse(user User) userResponse {
+	return userResponse{
+		ID:        user.ID,
+		Email:     user.Email,
+		Role:      user.Role,
+		CreatedAt: user.CreatedAt,
+	}
+}
+
+// writeJSON encodes v as the JSON response body with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// errorResponse is the JSON body of every API error.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeJSONError writes a {"error": message} body with the given status code.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
+
+// meHandler returns the currently authenticated user.
+func (app *App) meHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.apiUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var user User
+	if err := app.db.First(&user, userID).Error; err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newUserResponse(user))
+}
+
+// statsResponse is the JSON representation of the dashboard statistics.
+type statsResponse struct {
+	itemStats
+	Limit itemLimit `json:"limit"`
+}
+
+// apiStatsHandler returns the current user's item statistics.
+func (app *App) apiStatsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.apiUserID(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, statsResponse{
+		itemStats: app.getItemStats(userID, time.Now()),
+		Limit:     app.getItemLimit(userID),
+	})
+}
+
+// itemResponse is the JSON representation of an Item.
+type itemResponse struct {
+	ID          uint       `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Notes       string     `json:"notes"`
+	Status      string     `json:"status"`
+	Quantity    int        `json:"quantity"`
+	Value       string     `json:"value"`
+	Priority    string     `json:"priority"`
+	Color       string     `json:"color"`
+	Tags        []string   `json:"tags"`
+	CategoryID  *uint      `json:"category_id"`
+	ListID      uint       `json:"list_id"`
+	DueAt       *time.Time `json:"due_at"`
+	Completed   bool       `json:"completed"`
+	CompletedAt *time.Time `json:"completed_at"`
+	Pinned      bool       `json:"pinned"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func newItemResponse(item Item) itemResponse {
+	return itemResponse{
+		ID:          item.ID,
+		Name:        item.Name,
+		Description: item.Description,
+		Notes:       item.Notes,
+		Status:      item.Status,
+		Quantity:    item.Quantity,
+		Value:       formatValue(item.ValueCents),
+		Priority:    item.Priority,
+		Color:       item.Color,
+		Tags:        item.Tags,
+		CategoryID:  item.CategoryID,
+		ListID:      item.ListID,
+		DueAt:       item.DueAt,
+		Completed:   item.Completed,
+		CompletedAt: item.CompletedAt,
+		Pinned:      item.Pinned,
+		CreatedAt:   item.CreatedAt,
+	}
+}
+
+// itemsPageResponse is one page of items. The cursors and links are empty
+// at either end of the list.
+type itemsPageResponse struct {
+	Items      []itemResponse `json:"items"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+	PrevCursor string         `json:"prev_cursor,omitempty"`
+	Links      pageLinks      `json:"links"`
+}
+
+// pageLinks are the URLs of the neighbouring pages, with the request's
+// other parameters kept.
+type pageLinks struct {
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+const (
+	defaultAPIPageSize = 20
+	maxAPIPageSize     = 100
+)
+
+// apiItemsHandler lists the user's items newest first using keyset
+// pagination. Pass the returned next_cursor or prev_cursor as the cursor
+// parameter, or follow the matching link, to fetch the neighbouring page;
+// unlike offsets, cursors don't skip or repeat items when new ones are
+// added between requests. The links are also sent in a Link header.
+func (app *App) apiItemsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.apiUserID(w, r)
+	if !ok {
+		return
+	}
+
+	limit := defaultAPIPageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxAPIPageSize {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxAPIPageSize))
+			return
+		}
+		limit = n
+	}
+
+	items, next, prev, err := app.findItemsPage(userID, r.URL.Query().Get("search"), r.URL.Query().Get("status"), limit, r.URL.Query().Get("cursor"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid cursor")
+		return
+	}
+
+	response := itemsPageResponse{
+		Items:      []itemResponse{},
+		NextCursor: next,
+		PrevCursor: prev,
+		Links:      pageLinks{Next: cursorURL(r, next), Prev: cursorURL(r, prev)},
+	}
+	for _, item := range items {
+		response.Items = append(response.Items, newItemResponse(item))
+	}
+	var links []string
+	if response.Links.Next != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, response.Links.Next))
+	}
+	if response.Links.Prev != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, response.Links.Prev))
+	}
+	if links != nil {
+		w.Header().Add("Link", strings.Join(links, ", "))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// cursorURL returns the request's path and query with the cursor parameter
+// set to cursor, or "" when cursor is empty.
+func cursorURL(r *http.Request, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	return r.URL.Path + "?" + query.Encode()
+}
+
+// findItemsPage returns up to limit of the user's items matching search
+// and status, newest first, starting at cursor (empty for the first
+// page). next and prev are the cursors of the following and preceding
+// pages, or empty at either end of the list. It is shared by the REST,
+// GraphQL and gRPC APIs.
+func (app *App) findItemsPage(userID interface{}, search, status string, limit int, cursor string) (items []Item, next, prev string, err error) {
+	var c itemCursor
+	if cursor != "" {
+		if c, err = decodeItemCursor(cursor); err != nil {
+			return nil, "", "", err
+		}
+	}
+
+	// Fetch one extra row to learn whether there is another page in the
+	// direction of travel. Going back, rows come oldest first and are
+	// reversed below.
+	query := app.itemsQuery(userID, search, status)
+	switch {
+	case cursor == "":
+		query = query.Order("created_at desc, id desc")
+	case c.Before:
+		query = query.Scopes(newerThan(c)).Order("created_at asc, id asc")
+	default:
+		query = query.Scopes(olderThan(c)).Order("created_at desc, id desc")
+	}
+	query.Limit(limit + 1).Find(&items)
+	more := len(items) > limit
+	if more {
+		items = items[:limit]
+	}
+	app.loadTags(items)
+	if c.Before {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+	if len(items) == 0 {
+		return items, "", "", nil
+	}
+
+	first := itemCursor{CreatedAt: items[0].CreatedAt, ID: items[0].ID, Before: true}
+	last := itemCursor{CreatedAt: items[len(items)-1].CreatedAt, ID: items[len(items)-1].ID}
+	hasNewer, hasOlder := more, more
+	if c.Before {
+		hasOlder = app.itemsQuery(userID, search, status).Scopes(olderThan(last)).Take(&Item{}).Error == nil
+	} else {
+		hasNewer = cursor != "" && app.itemsQuery(userID, search, status).Scopes(newerThan(first)).Take(&Item{}).Error == nil
+	}
+	if hasOlder {
+		next = encodeItemCursor(last)
+	}
+	if hasNewer {
+		prev = encodeItemCursor(first)
+	}
+	return items, next, prev, nil
+}
+
+// olderThan and newerThan limit a query to the items after or before c in
+// the created_at desc, id desc ordering.
+func olderThan(c itemCursor) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		// Stored timestamps are in the server's local zone; compare in the
+		// same zone to keep SQLite's text comparison correct.
+		createdAt := c.CreatedAt.In(time.Local)
+		return db.Where("created_at < ? OR (created_at = ? AND id < ?)", createdAt, createdAt, c.ID)
+	}
+}
+
+func newerThan(c itemCursor) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		createdAt := c.CreatedAt.In(time.Local)
+		return db.Where("created_at > ? OR (created_at = ? AND id > ?)", createdAt, createdAt, c.ID)
+	}
+}
+
+// itemCursor identifies a position in the created_at desc, id desc ordering
+// of items. A Before cursor pages back towards newer items.
+type itemCursor struct {
+	CreatedAt time.Time
+	ID        uint
+	Before    bool
+}
+
+// encodeItemCursor returns an opaque string form of c. Forward cursors keep
+// the format issued before backward paging existed, so those still work.
+func encodeItemCursor(c itemCursor) string {
+	raw := fmt.Sprintf("%d:%d", c.CreatedAt.UnixNano(), c.ID)
+	if c.Before {
+		raw = "b:" + raw
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeItemCursor parses a string produced by encodeItemCursor.
+func decodeItemCursor(s string) (itemCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return itemCursor{}, err
+	}
+	position, before := strings.CutPrefix(string(raw), "b:")
+	nanos, id, found := strings.Cut(position, ":")
+	if !found {
+		return itemCursor{}, errors.New("malformed cursor")
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return itemCursor{}, err
+	}
+	itemID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return itemCursor{}, err
+	}
+	return itemCursor{CreatedAt: time.Unix(0, n), ID: uint(itemID), Before: before}, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestMeReturnsSessionUser(t *testing.T) {
+	handler, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	var me map[string]interface{}
+	resp := c.api("GET", "/api/v1/me", "", nil, &me)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if me["email"] != "alice@example.com" || me["id"] != float64(alice.ID) || me["role"] != RoleUser {
+		t.Errorf("me = %v, want alice's id, email and role", me)
+	}
+	for _, field := range []string{"password_hash", "PasswordHash", "totp_secret"} {
+		if _, ok := me[field]; ok {
+			t.Errorf("me exposes %s", field)
+		}
+	}
+}
+
+func TestMeWithToken(t *testing.T) {
+	handler, app := newTestApp(t)
+	bob := createTestUser(t, app, "bob@example.com", "Correct-Horse-1", RoleUser)
+	token := createTestToken(t, app, bob, 0)
+	c := newTestClient(t, handler)
+
+	var me userResponse
+	if resp := c.api("GET", "/api/v1/me", token, nil, &me); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if me.Email != "bob@example.com" {
+		t.Errorf("email = %q, want bob@example.com", me.Email)
+	}
+}
+
+func TestMeRequiresAuthentication(t *testing.T) {
+	handler, _ := newTestApp(t)
+	c := newTestClient(t, handler)
+
+	for name, token := range map[string]string{"no credentials": "", "unknown token": "0123456789abcdef"} {
+		resp := c.api("GET", "/api/v1/me", token, nil, nil)
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want %d", name, resp.StatusCode, http.StatusUnauthorized)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: Content-Type = %q, want application/json", name, ct)
+		}
+	}
+}
+
+func TestItemsCursorPaging(t *testing.T) {
+	handler, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	token := createTestToken(t, app, alice, 0)
+	c := newTestClient(t, handler)
+
+	// Pairs of items share a timestamp, so pages also break between rows
+	// that only their IDs tell apart
+	start := time.Now().Add(-time.Hour)
+	want := map[uint]bool{}
+	for i := 0; i < 25; i++ {
+		item := Item{UserID: alice.ID, Name: fmt.Sprintf("item %d", i), Status: ItemStatusActive, Quantity: 1, CreatedAt: start.Add(time.Duration(i/2) * time.Minute)}
+		app.db.Create(&item)
+		want[item.ID] = true
+	}
+
+	seen := map[uint]int{}
+	path := "/api/v1/items?limit=10"
+	for pages := 0; path != ""; pages++ {
+		if pages > 10 {
+			t.Fatal("paging doesn't end")
+		}
+		var page itemsPageResponse
+		if resp := c.api("GET", path, token, nil, &page); resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: status %d", path, resp.StatusCode)
+		}
+		for _, item := range page.Items {
+			seen[item.ID]++
+		}
+		if pages == 0 {
+			// A new item, newest of all, and one backdated into the
+			// pages still to come
+			var created itemResponse
+			if resp := c.api("POST", "/api/v1/items", token, map[string]string{"name": "new"}, &created); resp.StatusCode != http.StatusCreated {
+				t.Fatalf("creating an item: status %d", resp.StatusCode)
+			}
+			backdated := Item{UserID: alice.ID, Name: "backdated", Status: ItemStatusActive, Quantity: 1, CreatedAt: start.Add(-time.Minute)}
+			app.db.Create(&backdated)
+			want[backdated.ID] = true
+		}
+		path = ""
+		if page.NextCursor != "" {
+			path = "/api/v1/items?limit=10&cursor=" + url.QueryEscape(page.NextCursor)
+		}
+	}
+
+	for id := range want {
+		if seen[id] != 1 {
+			t.Errorf("item %d was returned %d times, want once", id, seen[id])
+		}
+	}
+	for id, n := range seen {
+		if !want[id] {
+			t.Errorf("item %d, created after its page was passed, was returned %d times", id, n)
+		}
+	}
+}
+
+func TestItemsMalformedCursor(t *testing.T) {
+	handler, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	token := createTestToken(t, app, alice, 0)
+	c := newTestClient(t, handler)
+
+	for _, cursor := range []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("no separator")),
+		base64.RawURLEncoding.EncodeToString([]byte("abc:1")),
+		base64.RawURLEncoding.EncodeToString([]byte("1:-1")),
+	} {
+		resp := c.api("GET", "/api/v1/items?cursor="+url.QueryEscape(cursor), token, nil, nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("cursor %q: status = %d, want %d", cursor, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestTokenRequiresVerifiedEmail(t *testing.T) {
+	handler, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	app.db.Model(&alice).Update("verified", false)
+	token := createTestToken(t, app, alice, 0)
+	c := newTestClient(t, handler)
+
+	// Like a session, the token can't reach the user's items...
+	for _, path := range []string{"/items", "/stats"} {
+		if resp := c.api("GET", path, token, nil, nil); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("GET %s unverified: status = %d, want %d", path, resp.StatusCode, http.StatusForbidden)
+		}
+	}
+	// ...but still identifies them
+	if resp := c.api("GET", "/api/v1/me", token, nil, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /api/v1/me unverified: status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	app.db.Model(&alice).Update("verified", true)
+	for _, path := range []string{"/items", "/stats"} {
+		if resp := c.api("GET", path, token, nil, nil); resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s verified: status = %d, want %d", path, resp.StatusCode, http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// itemRequest is the JSON body of POST and PUT /api/v1/items. On PUT,
+// omitted fields keep their current value.
+type itemRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Notes       *string `json:"notes"`
+	Quantity    *int    `json:"quantity"`
+	Status      *string `json:"status"`
+	Priority    *string `json:"priority"`
+	Color       *string `json:"color"`
+	// Value is the value of one unit, a decimal amount like 12.50 given as
+	// a number or a string.
+	Value *json.Number `json:"value"`
+	// Completed marks the item done, or pending again when false.
+	Completed *bool `json:"completed"`
+	Pinned    *bool `json:"pinned"`
+	// Tags replaces all of the item's tags.
+	Tags *[]string `json:"tags"`
+	// CategoryID moves the item to one of the user's categories, or out of
+	// its category when 0.
+	CategoryID *uint `json:"category_id"`
+	// ListID moves the item to one of the user's lists, or to their
+	// default list when 0.
+	ListID *uint `json:"list_id"`
+	// DueAt is a day like 2024-05-31 or an RFC 3339 time; "" clears it.
+	DueAt *string `json:"due_at"`
+}
+
+// decodeItemRequest reads an itemRequest body, writing a 400 response and
+// returning false when it isn't valid JSON.
+func decodeItemRequest(w http.ResponseWriter, r *http.Request) (itemRequest, bool) {
+	var req itemRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return req, false
+	}
+	return req, true
+}
+
+// apply validates req with the same rules as the HTML forms and copies the
+// fields it sets onto item.
+func (req itemRequest) apply(item *Item, namePolicy string) error {
+	if req.Name != nil {
+		name, err := sanitizeItemName(*req.Name, namePolicy)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			return errors.New("Item name cannot be empty")
+		}
+		item.Name = name
+	}
+	if req.Description != nil {
+		item.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.Notes != nil {
+		notes, err := cleanNotes(*req.Notes)
+		if err != nil {
+			return err
+		}
+		item.Notes = notes
+	}
+	if req.Quantity != nil {
+		if *req.Quantity < 0 || *req.Quantity > maxQuantity {
+			return fmt.Errorf("Quantity must be between 0 and %d", maxQuantity)
+		}
+		item.Quantity = *req.Quantity
+	}
+	if req.Value != nil {
+		value, err := parseValue(req.Value.String())
+		if err != nil {
+			return err
+		}
+		item.ValueCents = value
+	}
+	if req.Status != nil {
+		if *req.Status != ItemStatusActive && *req.Status != ItemStatusArchived {
+			return fmt.Errorf("Status must be %q or %q", ItemStatusActive, ItemStatusArchived)
+		}
+		item.Status = *req.Status
+	}
+	if req.Priority != nil {
+		priority, err := parsePriority(*req.Priority)
+		if err != nil {
+			return err
+		}
+		item.Priority = priority
+	}
+	if req.Color != nil {
+		color, err := parseColor(*req.Color)
+		if err != nil {
+			return err
+		}
+		item.Color = color
+	}
+	if req.Completed != nil {
+		setCompleted(item, *req.Completed, time.Now())
+	}
+	if req.Pinned != nil {
+		item.Pinned = *req.Pinned
+	}
+	if req.Tags != nil {
+		tags, err := cleanTags(*req.Tags, namePolicy)
+		if err != nil {
+			return err
+		}
+		item.Tags = tags
+	}
+	return nil
+}
+
+// applyItemRequest is apply plus the fields that depend on the app: due
+// dates are read in the configured timezone, and the category and list
+// must be the item owner's.
+func (app *App) applyItemRequest(req itemRequest, item *Item) error {
+	if err := req.apply(item, app.config.ItemNamePolicy); err != nil {
+		return err
+	}
+	if req.DueAt != nil {
+		due, err := parseDueDate(*req.DueAt, app.config.Location)
+		if err != nil {
+			return err
+		}
+		item.DueAt = due
+	}
+	if req.CategoryID != nil {
+		if err := app.setItemCategory(item, *req.CategoryID); err != nil {
+			return err
+		}
+	}
+	if req.ListID != nil {
+		return app.setItemList(item, *req.ListID)
+	}
+	return nil
+}
+
+// apiCreateItemHandler creates an item from a JSON body and answers 201
+// with the item and its Location.
+func (app *App) apiCreateItemHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.apiUserID(w, r)
+	if !ok {
+		return
+	}
+	req, ok := decodeItemRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Name == nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, "Item name cannot be empty")
+		return
+	}
+
+	item := Item{
+		UserID:    toUint(userID),
+		OrgID:     app.userOrgID(userID),
+		Status:    ItemStatusActive,
+		Quantity:  1,
+		CreatedAt: time.Now(),
+	}
+	if err := app.applyItemRequest(req, &item); err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if limit := app.getItemLimit(userID); limit.Reached {
+		writeJSONError(w, http.StatusForbidden, fmt.Sprintf("You have reached the limit of %d items", limit.Max))
+		return
+	}
+	if err := app.createItem(&item); err != nil {
+		log.Println("Error creating item:", err)
+		writeJSONError(w, http.StatusInternalServerError, "could not create item")
+		return
+	}
+	app.touchItems(userID)
+	app.notifyItem(WebhookItemCreated, item)
+
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+fmt.Sprint(item.ID))
+	writeJSON(w, http.StatusCreated, newItemResponse(item))
+}
+
+// apiItemHandler returns one item the user can see.
+func (app *App) apiItemHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.apiUserID(w, r)
+	if !ok {
+		return
+	}
+	var item Item
+	if err := app.db.Scopes(app.visibleItems(userID)).Where("id = ?", mux.Vars(r)["id"]).First(&item).Error; err != nil {
+		writeJSONError(w, http.StatusNotFound, "item not found")
+		return
+	}
+	app.loadItemTags(&item)
+	writeJSON(w, http.StatusOK, newItemResponse(item))
+}
+
+// apiUpdateItemHandler changes the fields given in the JSON body of one of
+// the user's own items.
+func (app *App) apiUpdateItemHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.apiUserID(w, r)
+	if !ok {
+		return
+	}
+	var item Item
+	if err := app.db.Scopes(app.ownedItems(userID)).Where("id = ?", mux.Vars(r)["id"]).First(&item).Error; err != nil {
+		writeJSONError(w, http.StatusNotFound, "item not found")
+		return
+	}
+	req, ok := decodeItemRequest(w, r)
+	if !ok {
+		return
+	}
+	app.loadItemTags(&item)
+	if err := app.applyItemRequest(req, &item); err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	// Select every column so a quantity of 0 is written too
+	err := app.db.Model(&item).Select("name", "description", "notes", "quantity", "value_cents", "status", "priority", "color", "completed", "completed_at", "pinned", "category_id", "list_id", "due_at").Updates(&item).Error
+	if err == nil && req.Tags != nil {
+		err = app.saveItemTags(&item)
+	}
+	if err != nil {
+		log.Println("Error updating item:", err)
+		writeJSONError(w, http.StatusInternalServerError, "could not update item")
+		return
+	}
+	app.touchItems(userID)
+	app.notifyItem(WebhookItemUpdated, item)
+	writeJSON(w, http.StatusOK, newItemResponse(item))
+}
+
+// apiDeleteItemHandler deletes one of the user's own items and answers 204.
+func (app *App) apiDeleteItemHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.apiUserID(w, r)
+	if !ok {
+		return
+	}
+	var item Item
+	if err := app.db.Scopes(app.ownedItems(userID)).Where("id = ?", mux.Vars(r)["id"]).First(&item).Error; err != nil {
+		writeJSONError(w, http.StatusNotFound, "item not found")
+		return
+	}
+	if err := app.deleteItem(&item); err != nil {
+		log.Println("Error deleting item:", err)
+		writeJSONError(w, http.StatusInternalServerError, "could not delete item")
+		return
+	}
+	app.touchItems(userID)
+	app.notifyItem(WebhookItemDeleted, item)
+	w.WriteHeader(http.StatusNoContent)
+}
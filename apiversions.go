@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// API version statuses
+const (
+	APIStatusStable     = "stable"
+	APIStatusBeta       = "beta"
+	APIStatusDeprecated = "deprecated"
+	APIStatusRetired    = "retired"
+)
+
+// apiVersion describes one version of the JSON API mounted at
+// /api/<Name>. To add a version, add an entry to apiVersions with a routes
+// function; reuse an older version's routes and override only what changes.
+//
+// To retire a version, set Deprecated to when it was deprecated, Sunset to
+// when it stops working and Successor to the version clients should move
+// to. Its responses then carry Deprecation, Sunset and Link headers, and
+// after the sunset every request answers 410 Gone.
+type apiVersion struct {
+	Name       string     `json:"version"`
+	Status     string     `json:"status"`
+	Deprecated *time.Time `json:"deprecated_at,omitempty"`
+	Sunset     *time.Time `json:"sunset_at,omitempty"`
+	Successor  string     `json:"successor,omitempty"`
+	routes     func(r *mux.Router)
+}
+
+// statusAt returns the version's status at now: deprecated once its
+// deprecation date has come, retired after its sunset.
+func (v apiVersion) statusAt(now time.Time) string {
+	switch {
+	case v.Sunset != nil && !now.Before(*v.Sunset):
+		return APIStatusRetired
+	case v.Deprecated != nil && !now.Before(*v.Deprecated):
+		return APIStatusDeprecated
+	}
+	return v.Status
+}
+
+// apiVersions returns every supported API version, oldest first.
+func (app *App) apiVersions() []apiVersion {
+	return []apiVersion{
+		{Name: "v1", Status: APIStatusStable, routes: app.apiV1Routes},
+		{Name: "v2", Status: APIStatusBeta, routes: app.apiV2Routes},
+	}
+}
+
+func (app *App) apiV1Routes(r *mux.Router) {
+	r.HandleFunc("/me", app.meHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/stats", app.apiStatsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/items", app.apiItemsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/items", app.idempotent(app.apiCreateItemHandler)).Methods("POST")
+	r.HandleFunc("/items/{id:[0-9]+}", app.apiItemHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/items/{id:[0-9]+}", app.apiUpdateItemHandler).Methods("PUT")
+	r.HandleFunc("/items/{id:[0-9]+}", app.apiDeleteItemHandler).Methods("DELETE")
+}
+
+// apiV2Routes is currently identical to v1. Breaking changes go here.
+func (app *App) apiV2Routes(r *mux.Router) {
+	app.apiV1Routes(r)
+}
+
+// registerAPIRoutes mounts every API version and the version listing on r.
+// Versioned routes get their version's lifecycle headers and are then
+// authenticated and rate limited by apiRateLimit; the routes registered
+// before them are public or carry their own token.
+func (app *App) registerAPIRoutes(r *mux.Router) {
+	api := r.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/versions", app.apiVersionsHandler).Methods("GET", "HEAD")
+	api.HandleFunc("/docs", app.apiDocsHandler).Methods("GET", "HEAD")
+	api.HandleFunc("/v1/openapi.json", app.openAPIHandler).Methods("GET", "HEAD")
+	api.HandleFunc("/v1/hooks/{token:[0-9a-f]+}", app.receiveIncomingHookHandler).Methods("POST")
+	for _, version := range app.apiVersions() {
+		versioned := api.PathPrefix("/" + version.Name).Subrouter()
+		versioned.Use(apiVersionLifecycle(version), app.apiRateLimit)
+		version.routes(versioned)
+	}
+}
+
+// apiVersionLifecycle announces a deprecated version's retirement on every
+// response: Deprecation (RFC 9745) with the date it was deprecated, Sunset
+// (RFC 8594) with the date it stops working, and a Link to its successor.
+// After the sunset it answers 410 Gone instead of running the handler.
+func apiVersionLifecycle(version apiVersion) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			if version.Deprecated != nil {
+				h.Set("Deprecation", fmt.Sprintf("@%d", version.Deprecated.Unix()))
+			}
+			if version.Sunset != nil {
+				h.Set("Sunset", version.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if version.Successor != "" {
+				h.Add("Link", fmt.Sprintf(`</api/%s>; rel="successor-version"`, version.Successor))
+			}
+
+			if version.statusAt(time.Now()) == APIStatusRetired {
+				message := fmt.Sprintf("API %s was retired on %s", version.Name, version.Sunset.UTC().Format("2006-01-02"))
+				if version.Successor != "" {
+					message += "; use /api/" + version.Successor
+				}
+				writeJSONError(w, http.StatusGone, message)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// apiVersionsHandler lists the supported API versions with their status
+// and, for deprecated versions, their retirement dates.
+func (app *App) apiVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	versions := app.apiVersions()
+	for i := range versions {
+		versions[i].Status = versions[i].statusAt(now)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"versions": versions,
+	})
+}
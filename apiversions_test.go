@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIVersionsServeItems(t *testing.T) {
+	handler, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	createTestItem(t, app, alice, "Milk")
+	token := createTestToken(t, app, alice, 0)
+	c := newTestClient(t, handler)
+
+	for _, version := range []string{"v1", "v2"} {
+		var page itemsPageResponse
+		resp := c.api("GET", "/api/"+version+"/items", token, nil, &page)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d", version, resp.StatusCode)
+		}
+		if len(page.Items) != 1 || page.Items[0].Name != "Milk" {
+			t.Errorf("%s: items = %+v, want Milk", version, page.Items)
+		}
+		// Neither version is deprecated
+		for _, header := range []string{"Deprecation", "Sunset", "Link"} {
+			if v := resp.Header.Get(header); v != "" {
+				t.Errorf("%s: %s = %q", version, header, v)
+			}
+		}
+	}
+	if resp := c.api("GET", "/api/v9/items", token, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown version: status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	var list struct {
+		Versions []apiVersion `json:"versions"`
+	}
+	if resp := c.api("GET", "/api/versions", "", nil, &list); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/versions: status %d", resp.StatusCode)
+	}
+	if len(list.Versions) != 2 || list.Versions[0].Name != "v1" || list.Versions[0].Status != APIStatusStable ||
+		list.Versions[1].Name != "v2" || list.Versions[1].Status != APIStatusBeta {
+		t.Errorf("versions = %+v, want v1 stable and v2 beta", list.Versions)
+	}
+}
+
+func TestAPIVersionLifecycle(t *testing.T) {
+	now := time.Now()
+	deprecated, sunset := now.Add(-24*time.Hour), now.Add(30*24*time.Hour)
+	past := now.Add(-time.Hour)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// A deprecated version still works but announces its retirement
+	version := apiVersion{Name: "v1", Status: APIStatusStable, Deprecated: &deprecated, Sunset: &sunset, Successor: "v2"}
+	rec := httptest.NewRecorder()
+	apiVersionLifecycle(version)(ok).ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/items", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("deprecated: status %d, want %d", rec.Code, http.StatusOK)
+	}
+	want := map[string]string{
+		"Deprecation": fmt.Sprintf("@%d", deprecated.Unix()),
+		"Sunset":      sunset.UTC().Format(http.TimeFormat),
+		"Link":        `</api/v2>; rel="successor-version"`,
+	}
+	for header, v := range want {
+		if got := rec.Header().Get(header); got != v {
+			t.Errorf("deprecated: %s = %q, want %q", header, got, v)
+		}
+	}
+	if got := version.statusAt(now); got != APIStatusDeprecated {
+		t.Errorf("deprecated: status = %q, want %q", got, APIStatusDeprecated)
+	}
+
+	// After the sunset it is gone
+	version.Sunset = &past
+	rec = httptest.NewRecorder()
+	apiVersionLifecycle(version)(ok).ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/items", nil))
+	if rec.Code != http.StatusGone || !strings.Contains(rec.Body.String(), "use /api/v2") {
+		t.Errorf("retired: status %d, want %d\n%s", rec.Code, http.StatusGone, rec.Body)
+	}
+	if got := version.statusAt(now); got != APIStatusRetired {
+		t.Errorf("retired: status = %q, want %q", got, APIStatusRetired)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// checkAssetDir verifies that dir exists, is a directory and contains at
+// least one file matching pattern. The error names the absolute path that
+// was checked and the environment variable that overrides it, since a
+// relative default depends on the working directory the app was started
+// from.
+func checkAssetDir(dir, pattern, envVar string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+
+	info, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		return fmt.Errorf("directory %s does not exist; start the app from the project root or set %s", abs, envVar)
+	case err != nil:
+		return fmt.Errorf("cannot read directory %s: %v; check its permissions or set %s", abs, err, envVar)
+	case !info.IsDir():
+		return fmt.Errorf("%s is not a directory; set %s to the right path", abs, envVar)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("directory %s has no %s files; set %s to the right path", abs, pattern, envVar)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestNewAppReportsBadTemplatesDir(t *testing.T) {
+	root := t.TempDir()
+	empty := filepath.Join(root, "empty")
+	file := filepath.Join(root, "file.templ")
+	if err := os.Mkdir(empty, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{filepath.Join(root, "missing"), "directory " + filepath.Join(root, "missing") + " does not exist; start the app from the project root or set TEMPLATES_DIR"},
+		{empty, "directory " + empty + " has no *.templ files; set TEMPLATES_DIR to the right path"},
+		{file, file + " is not a directory; set TEMPLATES_DIR to the right path"},
+	}
+	for _, tt := range tests {
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.DBPath = ":memory:"
+		cfg.PasswordHasher = PasswordHasherBcrypt
+		cfg.BcryptCost = bcrypt.MinCost
+		cfg.TemplatesDir = tt.dir
+		_, err = newApp(cfg)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %q, want it to say %q", tt.dir, err, tt.want)
+		}
+	}
+}
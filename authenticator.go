@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+)
+
+// errInvalidCredentials is returned by an Authenticator when the login or
+// password is wrong, as opposed to the backend being unavailable.
+var errInvalidCredentials = errors.New("invalid email or password")
+
+// Authenticator checks the credentials entered on the login form and
+// returns the matching user.
+type Authenticator interface {
+	Authenticate(ctx context.Context, login, password string) (User, error)
+}
+
+// newAuthenticator returns the authenticator for the configured backend.
+// With LDAP_URL set, credentials are checked against the directory, and
+// optionally against local password hashes when the directory doesn't
+// accept them.
+func newAuthenticator(cfg Config, app *App) Authenticator {
+	local := localAuthenticator{db: app.db, passwords: app.passwords}
+	if cfg.LDAPURL == "" {
+		return local
+	}
+	directory := &ldapAuthenticator{cfg: cfg, provision: app.findOrCreateExternalUser}
+	if cfg.LDAPLocalFallback {
+		return chainAuthenticator{directory, local}
+	}
+	return directory
+}
+
+// localAuthenticator checks passwords against the hashes in the users
+// table. A hash made with an outdated scheme or parameters is replaced
+// once the password is known to be right, so accounts move to the current
+// hasher as their users sign in.
+type localAuthenticator struct {
+	db        *gorm.DB
+	passwords PasswordHasher
+}
+
+func (a localAuthenticator) Authenticate(ctx context.Context, login, password string) (User, error) {
+	var user User
+	if err := a.db.WithContext(ctx).Where("email = ?", login).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return User{}, errInvalidCredentials
+		}
+		return User{}, err
+	}
+	if !a.passwords.Verify(user.PasswordHash, password) {
+		return User{}, errInvalidCredentials
+	}
+	if a.passwords.NeedsRehash(user.PasswordHash) {
+		if hash, err := a.passwords.Hash(password); err != nil {
+			log.Println("Error rehashing password:", err)
+		} else if err := a.db.WithContext(ctx).Model(&user).Update("password_hash", hash).Error; err != nil {
+			log.Println("Error saving rehashed password:", err)
+		} else {
+			user.PasswordHash = hash
+		}
+	}
+	return user, nil
+}
+
+// chainAuthenticator tries each authenticator in turn until one accepts
+// the credentials, so local accounts still work while the directory is
+// down. If none accepts them, the first backend error is reported.
+type chainAuthenticator []Authenticator
+
+func (c chainAuthenticator) Authenticate(ctx context.Context, login, password string) (User, error) {
+	var firstErr error
+	for _, a := range c {
+		user, err := a.Authenticate(ctx, login, password)
+		if err == nil {
+			return user, nil
+		}
+		if firstErr == nil && !errors.Is(err, errInvalidCredentials) {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return User{}, firstErr
+	}
+	return User{}, errInvalidCredentials
+}
+
+// ldapAuthenticator checks credentials by binding to an LDAP or Active
+// Directory server as the user. The bind DN is LDAP_USER_DN with the
+// login in place of %s, e.g. "uid=%s,ou=people,dc=example,dc=com" or, for
+// Active Directory, "%s@corp.example.com". Users are created on their
+// first sign-in.
+type ldapAuthenticator struct {
+	cfg       Config
+	provision func(identity externalIdentity, create bool) (User, error)
+}
+
+// ldapTimeout bounds connecting to and each request against the directory.
+const ldapTimeout = 10 * time.Second
+
+func (a *ldapAuthenticator) Authenticate(ctx context.Context, login, password string) (User, error) {
+	login = strings.TrimSpace(login)
+	// An empty password is an unauthenticated bind, which many servers
+	// accept for any DN
+	if login == "" || password == "" {
+		return User{}, errInvalidCredentials
+	}
+
+	conn, err := ldap.DialURL(a.cfg.LDAPURL, ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}))
+	if err != nil {
+		return User{}, fmt.Errorf("connecting to LDAP: %w", err)
+	}
+	defer conn.Close()
+	conn.SetTimeout(ldapTimeout)
+	if a.cfg.LDAPStartTLS {
+		u, _ := url.Parse(a.cfg.LDAPURL)
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}); err != nil {
+			return User{}, fmt.Errorf("LDAP StartTLS: %w", err)
+		}
+	}
+
+	dn := strings.ReplaceAll(a.cfg.LDAPUserDN, "%s", ldap.EscapeDN(login))
+	if err := conn.Bind(dn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return User{}, errInvalidCredentials
+		}
+		return User{}, fmt.Errorf("LDAP bind: %w", err)
+	}
+
+	identity, err := a.lookup(conn, dn, login)
+	if err != nil {
+		return User{}, err
+	}
+	return a.provision(identity, true)
+}
+
+// lookup reads the signed-in user's email address and groups from their
+// directory entry.
+func (a *ldapAuthenticator) lookup(conn *ldap.Conn, dn, login string) (externalIdentity, error) {
+	base, filter := dn, "(objectClass=*)"
+	scope := ldap.ScopeBaseObject
+	if !strings.Contains(dn, "=") {
+		// A UPN or DOMAIN\user bind name isn't a DN, so search for it
+		base, scope = a.cfg.LDAPBaseDN, ldap.ScopeWholeSubtree
+		filter = fmt.Sprintf("(userPrincipalName=%s)", ldap.EscapeFilter(dn))
+	}
+	attributes := []string{a.cfg.LDAPEmailAttribute}
+	if a.cfg.LDAPAdminGroup != "" {
+		attributes = append(attributes, "memberOf")
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(base, scope, ldap.NeverDerefAliases, 2, int(ldapTimeout.Seconds()), false,
+		filter, attributes, nil))
+	if err != nil {
+		return externalIdentity{}, fmt.Errorf("LDAP search: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return externalIdentity{}, fmt.Errorf("LDAP search for %q returned %d entries", dn, len(result.Entries))
+	}
+	entry := result.Entries[0]
+
+	email := entry.GetAttributeValue(a.cfg.LDAPEmailAttribute)
+	if email == "" && strings.Contains(login, "@") {
+		email = login
+	}
+	if email == "" {
+		return externalIdentity{}, fmt.Errorf("LDAP entry %q has no %s attribute", entry.DN, a.cfg.LDAPEmailAttribute)
+	}
+
+	identity := externalIdentity{Email: email}
+	if a.cfg.LDAPAdminGroup != "" {
+		identity.Role = RoleUser
+		for _, group := range entry.GetAttributeValues("memberOf") {
+			if strings.EqualFold(group, a.cfg.LDAPAdminGroup) {
+				identity.Role = RoleAdmin
+			}
+		}
+	}
+	return identity, nil
+}
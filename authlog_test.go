@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuthEventLogFailedLogin(t *testing.T) {
+	handler, app := newTestApp(t)
+	createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	path := filepath.Join(t.TempDir(), "auth.log")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	app.authEvents = &authEventLogger{out: f}
+	c := newTestClient(t, handler)
+
+	c.post("/login", url.Values{"email": {"alice@example.com"}, "password": {"Wrong-Horse-2"}})
+	c.post("/login", url.Values{"email": {"nobody@example.com"}, "password": {"Wrong-Horse-3"}})
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "Horse") {
+		t.Errorf("the log contains a password:\n%s", b)
+	}
+
+	var events []map[string]interface{}
+	scanner := bufio.NewScanner(strings.NewReader(string(b)))
+	for scanner.Scan() {
+		var event map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("decoding %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 2 {
+		t.Fatalf("%d events, want 2:\n%s", len(events), b)
+	}
+
+	want := []string{"alice@example.com", "nobody@example.com"}
+	for i, event := range events {
+		if event["event"] != AuthEventLogin || event["outcome"] != AuthOutcomeFailure {
+			t.Errorf("event %d = %v %v, want %s %s", i, event["event"], event["outcome"], AuthEventLogin, AuthOutcomeFailure)
+		}
+		if event["email"] != want[i] {
+			t.Errorf("event %d email = %v, want %s", i, event["email"], want[i])
+		}
+		if event["ip"] != "127.0.0.1" {
+			t.Errorf("event %d ip = %v, want 127.0.0.1", i, event["ip"])
+		}
+		if _, ok := event["password"]; ok {
+			t.Errorf("event %d has a password field", i)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pwnedPasswordsCacheSize caps how many hash prefixes the Pwned Passwords
+// checker keeps. Each one holds several hundred suffixes.
+const pwnedPasswordsCacheSize = 100
+
+// BreachChecker reports whether a password is known from a data breach.
+type BreachChecker interface {
+	Breached(ctx context.Context, password string) (bool, error)
+}
+
+// newBreachChecker returns the Pwned Passwords checker when
+// PWNED_PASSWORDS_ENABLED is set, and otherwise one that knows no breaches.
+func newBreachChecker(cfg Config) BreachChecker {
+	if !cfg.PwnedPasswordsEnabled {
+		return noopBreachChecker{}
+	}
+	return newPwnedPasswords(cfg.PwnedPasswordsURL, cfg.PwnedPasswordsTTL)
+}
+
+// noopBreachChecker knows no breached passwords.
+type noopBreachChecker struct{}
+
+func (noopBreachChecker) Breached(ctx context.Context, password string) (bool, error) {
+	return false, nil
+}
+
+// pwnedPasswords checks passwords against the Have I Been Pwned range API.
+// Only the first five hex digits of the password's SHA-1 hash are sent; the
+// API answers with the suffixes of every breached hash sharing them, which
+// are cached for ttl.
+type pwnedPasswords struct {
+	endpoint string
+	ttl      time.Duration
+	client   *http.Client
+
+	mu    sync.Mutex
+	cache map[string]pwnedRange
+}
+
+// pwnedRange holds the breached hash suffixes for one prefix.
+type pwnedRange struct {
+	suffixes  map[string]bool
+	fetchedAt time.Time
+}
+
+func newPwnedPasswords(endpoint string, ttl time.Duration) *pwnedPasswords {
+	return &pwnedPasswords{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/",
+		ttl:      ttl,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		cache:    make(map[string]pwnedRange),
+	}
+}
+
+func (p *pwnedPasswords) Breached(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	suffixes, err := p.lookup(ctx, prefix, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return suffixes[suffix], nil
+}
+
+// lookup returns the breached suffixes for prefix, from the cache if they
+// were fetched less than ttl ago.
+func (p *pwnedPasswords) lookup(ctx context.Context, prefix string, now time.Time) (map[string]bool, error) {
+	p.mu.Lock()
+	cached, ok := p.cache[prefix]
+	p.mu.Unlock()
+	if ok && now.Sub(cached.fetchedAt) < p.ttl {
+		return cached.suffixes, nil
+	}
+
+	suffixes, err := p.fetch(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.cache) >= pwnedPasswordsCacheSize {
+		p.evict(now)
+	}
+	p.cache[prefix] = pwnedRange{suffixes: suffixes, fetchedAt: now}
+	return suffixes, nil
+}
+
+// evict drops expired ranges, or the oldest one if none have expired. The
+// caller must hold p.mu.
+func (p *pwnedPasswords) evict(now time.Time) {
+	var oldest string
+	for prefix, r := range p.cache {
+		if now.Sub(r.fetchedAt) >= p.ttl {
+			delete(p.cache, prefix)
+			continue
+		}
+		if oldest == "" || r.fetchedAt.Before(p.cache[oldest].fetchedAt) {
+			oldest = prefix
+		}
+	}
+	if len(p.cache) >= pwnedPasswordsCacheSize {
+		delete(p.cache, oldest)
+	}
+}
+
+func (p *pwnedPasswords) fetch(ctx context.Context, prefix string) (map[string]bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.endpoint+prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Padding hides the real number of suffixes from anyone watching the
+	// response sizes; padded entries have a count of 0
+	req.Header.Set("Add-Padding", "true")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("pwned passwords: unexpected status %s", resp.Status)
+	}
+
+	suffixes := make(map[string]bool)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		suffix, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if ok && count != "0" {
+			suffixes[strings.ToUpper(suffix)] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("pwned passwords: %w", err)
+	}
+	return suffixes, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// stubBreachChecker knows the passwords in breached, or fails with err.
+type stubBreachChecker struct {
+	breached map[string]bool
+	err      error
+}
+
+func (c stubBreachChecker) Breached(ctx context.Context, password string) (bool, error) {
+	return c.breached[password], c.err
+}
+
+func TestRegisterRejectsBreachedPassword(t *testing.T) {
+	handler, app := newTestApp(t, func(cfg *Config) {
+		cfg.RegistrationEnabled = true
+		cfg.CaptchaProvider = CaptchaProviderNone
+	})
+	app.breaches = stubBreachChecker{breached: map[string]bool{"Correct-Horse-1": true}}
+	c := newTestClient(t, handler)
+
+	accounts := func(email string) int64 {
+		var count int64
+		app.db.Model(&User{}).Where("email = ?", email).Count(&count)
+		return count
+	}
+
+	resp, body := c.post("/register", url.Values{"email": {"alice@example.com"}, "password": {"Correct-Horse-1"}})
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "appeared in a data breach") {
+		t.Errorf("breached password: status %d\n%s", resp.StatusCode, body)
+	}
+	if n := accounts("alice@example.com"); n != 0 {
+		t.Fatalf("%d accounts created with a breached password", n)
+	}
+
+	c.post("/register", url.Values{"email": {"alice@example.com"}, "password": {"Battery-Staple-2"}})
+	if n := accounts("alice@example.com"); n != 1 {
+		t.Errorf("%d accounts created with a safe password, want 1", n)
+	}
+
+	// The check fails open when the breach service can't be reached
+	app.breaches = stubBreachChecker{err: errors.New("connection refused")}
+	c.post("/register", url.Values{"email": {"bob@example.com"}, "password": {"Correct-Horse-1"}})
+	if n := accounts("bob@example.com"); n != 1 {
+		t.Errorf("%d accounts created while the breach check failed, want 1", n)
+	}
+}
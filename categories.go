@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxCategoryNameLength caps category names, in characters.
+	maxCategoryNameLength = 50
+	// maxCategories caps how many categories one user can have.
+	maxCategories = 100
+	// categoryNone is the category filter value for items without a
+	// category.
+	categoryNone = "none"
+)
+
+// cleanCategoryName applies the item name policy to a category name.
+func cleanCategoryName(name, policy string) (string, error) {
+	name, err := sanitizeItemName(name, policy)
+	if err != nil {
+		return "", errors.New("Category names cannot contain control or invisible formatting characters")
+	}
+	if name == "" {
+		return "", errors.New("Category name cannot be empty")
+	}
+	if utf8.RuneCountInString(name) > maxCategoryNameLength {
+		return "", fmt.Errorf("Category names can be at most %d characters", maxCategoryNameLength)
+	}
+	return name, nil
+}
+
+// inCategory scopes an Item query to one category: a category ID, or
+// categoryNone for items without one. An empty value doesn't filter.
+func inCategory(category string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		switch category {
+		case "":
+			return db
+		case categoryNone:
+			return db.Where("items.category_id IS NULL")
+		default:
+			return db.Where("items.category_id = ?", category)
+		}
+	}
+}
+
+// userCategories returns the user's categories in name order.
+func (app *App) userCategories(userID interface{}) []Category {
+	var categories []Category
+	app.db.Where("user_id = ?", userID).Order("name COLLATE NOCASE, id").Find(&categories)
+	return categories
+}
+
+// setItemCategory puts item in the category with the given ID, or takes
+// it out of its category when id is 0. The category must belong to the
+// item's owner.
+func (app *App) setItemCategory(item *Item, id uint) error {
+	if id == 0 {
+		item.CategoryID = nil
+		item.Category = nil
+		return nil
+	}
+	var category Category
+	if err := app.db.Where("id = ? AND user_id = ?", id, item.UserID).First(&category).Error; err != nil {
+		return errors.New("Category not found")
+	}
+	item.CategoryID = &category.ID
+	item.Category = &category
+	return nil
+}
+
+// InCategory reports whether the item is in the category with the given ID.
+func (item Item) InCategory(id uint) bool {
+	return item.CategoryID != nil && *item.CategoryID == id
+}
+
+// equalIDs reports whether two optional IDs are the same.
+func equalIDs(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// parseCategoryID reads the category_id form value of the item forms; an
+// empty value means no category.
+func parseCategoryID(value string) (uint, error) {
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, errors.New("Category not found")
+	}
+	return uint(id), nil
+}
+
+// categoryResponse is the JSON form of a category.
+type categoryResponse struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	ItemCount int64     `json:"item_count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// categoryList returns the user's categories with how many items are in
+// each.
+func (app *App) categoryList(userID interface{}) []categoryResponse {
+	list := []categoryResponse{}
+	app.db.Model(&Category{}).
+		Select("categories.id, categories.name, categories.created_at, COUNT(items.id) AS item_count").
+		Joins("LEFT JOIN items ON items.category_id = categories.id").
+		Where("categories.user_id = ?", userID).
+		Group("categories.id, categories.name, categories.created_at").
+		Order("categories.name COLLATE NOCASE, categories.id").
+		Scan(&list)
+	return list
+}
+
+// categoriesHandler lists the user's categories: the categories.templ
+// fragment, or JSON {"categories": [...]}.
+func (app *App) categoriesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	if respondJSON(r) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"categories": app.categoryList(userID)})
+		return
+	}
+	app.renderCategories(w, userID, nil)
+}
+
+// categoryOptionsHandler returns the <option> elements of a category
+// select, with the one named by "category" selected. With filter=true the
+// options are those of the items view's filter: every category, no
+// category, or one category; otherwise they are the choices of the item
+// forms.
+func (app *App) categoryOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	app.tmpl.ExecuteTemplate(w, "category_options.templ", map[string]interface{}{
+		"Categories": app.userCategories(userID),
+		"Selected":   r.FormValue("category"),
+		"Filter":     r.FormValue("filter") == "true",
+	})
+}
+
+// renderCategories renders the category list for userID, merging data into
+// the template data.
+func (app *App) renderCategories(w http.ResponseWriter, userID interface{}, data map[string]interface{}) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data["Categories"] = app.categoryList(userID)
+	app.tmpl.ExecuteTemplate(w, "categories.templ", data)
+}
+
+// writeCategoryError reports a rejected category change: JSON clients get
+// status and the message, the browser gets the category list with the
+// message.
+func (app *App) writeCategoryError(w http.ResponseWriter, r *http.Request, userID interface{}, status int, message string) {
+	if respondJSON(r) {
+		writeJSONError(w, status, message)
+		return
+	}
+	app.renderCategories(w, userID, map[string]interface{}{"Error": message})
+}
+
+// categoryNameTaken reports whether the user has another category with
+// name, ignoring case.
+func (app *App) categoryNameTaken(userID interface{}, name string, exceptID uint) bool {
+	var count int64
+	app.db.Model(&Category{}).Where("user_id = ? AND LOWER(name) = LOWER(?) AND id <> ?", userID, name, exceptID).Count(&count)
+	return count > 0
+}
+
+// createCategoryHandler adds a category from the "name" form value.
+func (app *App) createCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	name, err := cleanCategoryName(r.FormValue("name"), app.config.ItemNamePolicy)
+	if err != nil {
+		app.writeCategoryError(w, r, userID, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	var count int64
+	app.db.Model(&Category{}).Where("user_id = ?", userID).Count(&count)
+	if count >= maxCategories {
+		app.writeCategoryError(w, r, userID, http.StatusForbidden, fmt.Sprintf("You can have at most %d categories", maxCategories))
+		return
+	}
+	if app.categoryNameTaken(userID, name, 0) {
+		app.writeCategoryError(w, r, userID, http.StatusConflict, fmt.Sprintf("You already have a category named %q", name))
+		return
+	}
+
+	category := Category{UserID: toUint(userID), Name: name, CreatedAt: time.Now()}
+	if err := app.db.Create(&category).Error; err != nil {
+		log.Println("Error creating category:", err)
+		writeServerError(w)
+		return
+	}
+	w.Header().Set("HX-Trigger", "categoriesChanged")
+	if respondJSON(r) {
+		writeJSON(w, http.StatusCreated, categoryResponse{ID: category.ID, Name: category.Name, CreatedAt: category.CreatedAt})
+		return
+	}
+	app.renderCategories(w, userID, nil)
+}
+
+// findCategory loads one of the user's categories by the route's id,
+// writing a 404 when there is none.
+func (app *App) findCategory(w http.ResponseWriter, r *http.Request, userID interface{}) (Category, bool) {
+	var category Category
+	if err := app.db.Where("id = ? AND user_id = ?", mux.Vars(r)["id"], userID).First(&category).Error; err != nil {
+		app.writeCategoryError(w, r, userID, http.StatusNotFound, "Category not found")
+		return category, false
+	}
+	return category, true
+}
+
+// categoryChanged records that renaming category changed the items in it: their updated_at moves on, and the page that made the change
+// reloads its item list and category selects.
+func (app *App) categoryChanged(w http.ResponseWriter, userID interface{}, category Category) {
+	err := app.db.Model(&Item{}).Where("category_id = ?", category.ID).Update("updated_at", time.Now()).Error
+	if err != nil {
+		log.Println("Error touching categorized items:", err)
+	}
+	app.touchItems(userID)
+	w.Header().Set("HX-Trigger", "itemsChanged, categoriesChanged")
+}
+
+// renameCategoryHandler renames one of the user's categories from the
+// "name" form value.
+func (app *App) renameCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	category, ok := app.findCategory(w, r, userID)
+	if !ok {
+		return
+	}
+	name, err := cleanCategoryName(r.FormValue("name"), app.config.ItemNamePolicy)
+	if err != nil {
+		app.writeCategoryError(w, r, userID, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if name != category.Name {
+		if app.categoryNameTaken(userID, name, category.ID) {
+			app.writeCategoryError(w, r, userID, http.StatusConflict, fmt.Sprintf("You already have a category named %q", name))
+			return
+		}
+		if err := app.db.Model(&category).Update("name", name).Error; err != nil {
+			log.Println("Error renaming category:", err)
+			writeServerError(w)
+			return
+		}
+		app.categoryChanged(w, userID, category)
+	}
+	if respondJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	app.renderCategories(w, userID, nil)
+}
+
+// deleteCategoryHandler deletes one of the user's categories. Its items are
+// kept without a category.
+func (app *App) deleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	category, ok := app.findCategory(w, r, userID)
+	if !ok {
+		return
+	}
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Item{}).Where("category_id = ?", category.ID).
+			Updates(map[string]interface{}{"category_id": nil, "updated_at": time.Now()}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&category).Error
+	})
+	if err != nil {
+		log.Println("Error deleting category:", err)
+		writeServerError(w)
+		return
+	}
+	app.touchItems(userID)
+	w.Header().Set("HX-Trigger", "itemsChanged, categoriesChanged")
+	if respondJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	app.renderCategories(w, userID, nil)
+}
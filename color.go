@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// itemColors is the palette of color labels items can carry, in the order
+// of the color selects. Each has a color-<name> class in base.templ.
+var itemColors = []string{"red", "orange", "yellow", "green", "blue", "purple", "gray"}
+
+// parseColor reads a color label, ignoring case and surrounding spaces. An
+// empty value means no color.
+func parseColor(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value != "" && !slices.Contains(itemColors, value) {
+		return "", fmt.Errorf("Color must be one of %s", strings.Join(itemColors, ", "))
+	}
+	return value, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Values of the "status" filter that show the active items still to be
+// done or already done.
+const (
+	ItemStatusPending = "pending"
+	ItemStatusDone    = "done"
+)
+
+// setCompleted marks item done or pending as of now. CompletedAt keeps the
+// time it was first marked done until it is marked pending again.
+func setCompleted(item *Item, completed bool, now time.Time) {
+	if completed == item.Completed {
+		return
+	}
+	item.Completed = completed
+	item.CompletedAt = nil
+	if completed {
+		item.CompletedAt = &now
+	}
+}
+
+// toggleItemHandler marks one of the user's items done, or pending again
+// when it is done, and returns its refreshed row; JSON clients get the
+// item.
+func (app *App) toggleItemHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	var item Item
+	if err := app.db.Scopes(app.ownedItems(userID)).Preload("Category").Where("id = ?", mux.Vars(r)["id"]).First(&item).Error; err != nil {
+		writeItemNotFound(w, r)
+		return
+	}
+	setCompleted(&item, !item.Completed, time.Now())
+	err := app.db.Model(&item).Select("completed", "completed_at").Updates(&item).Error
+	if err != nil {
+		log.Println("Error toggling item:", err)
+		writeServerError(w)
+		return
+	}
+	app.loadItemTags(&item)
+	app.touchItems(userID)
+	app.notifyItem(WebhookItemUpdated, item)
+	app.writeItemRow(w, r, userID, item, false, "")
+}
@@ -0,0 +1,798 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config holds all application settings. It is loaded once at startup by
+// loadConfig and passed to the parts of the app that need it.
+type Config struct {
+	// Port is the TCP port the HTTP server listens on.
+	Port string
+	// DBPath is the SQLite database file.
+	DBPath string
+	// TemplatesDir holds the .templ files and StaticDir the files served
+	// under /static/. Relative paths are resolved against the working
+	// directory.
+	TemplatesDir string
+	StaticDir    string
+
+	// TLSCertFile and TLSKeyFile switch the server to HTTPS when both are
+	// set. TLSMinVersion is the oldest TLS version accepted ("1.2" or
+	// "1.3"). HTTPRedirectAddr, if set, is a plain HTTP listen address
+	// that redirects to HTTPS.
+	TLSCertFile      string
+	TLSKeyFile       string
+	TLSMinVersion    string
+	HTTPRedirectAddr string
+
+	// Environment is "development" or "production". Production refuses
+	// settings that are only safe on a developer's machine.
+	Environment string
+
+	// SessionSecret signs the session cookie and emailed links.
+	// OldSessionSecrets are older secrets that are still accepted but
+	// no longer used to sign, so sessions survive a key rotation.
+	SessionSecret     string
+	OldSessionSecrets []string
+	// SessionMaxAge is the session cookie lifetime in seconds.
+	SessionMaxAge int
+	// SecureCookies marks the session cookie Secure (HTTPS only).
+	SecureCookies bool
+	// SessionStore is where session data lives: "cookie" keeps it in the
+	// signed cookie, "redis" keeps it in Redis at RedisURL so sessions can
+	// be ended on the server and shared by several app instances.
+	SessionStore string
+	RedisURL     string
+	// PasswordHasher is the scheme new password hashes use, "argon2id" or
+	// "bcrypt"; BcryptCost is the bcrypt work factor. Hashes of the other
+	// scheme or a lower cost are replaced when their user signs in.
+	PasswordHasher string
+	BcryptCost     int
+
+	// PasswordMinLength is the fewest characters a new password may have.
+	PasswordMinLength int
+
+	// PwnedPasswordsEnabled rejects new passwords found in the Have I Been
+	// Pwned range API at PwnedPasswordsURL. Answers are cached for
+	// PwnedPasswordsTTL.
+	PwnedPasswordsEnabled bool
+	PwnedPasswordsURL     string
+	PwnedPasswordsTTL     time.Duration
+	// RememberMeDuration is how long a "remember me" sign-in lasts on a
+	// device, independently of SessionMaxAge; 0 hides the checkbox.
+	RememberMeDuration time.Duration
+
+	// MaxItemsPerUser caps the number of items per user; 0 disables it.
+	MaxItemsPerUser int
+	// ItemLimitWarnPercent is the percentage of the cap at which users
+	// start seeing a warning.
+	ItemLimitWarnPercent int
+
+	// MultiTenant scopes items by organization as well as user and lets
+	// organization admins see every item in their organization.
+	MultiTenant bool
+
+	// ItemNamePolicy decides what happens to control and invisible
+	// formatting characters in item names: "strip" or "reject".
+	ItemNamePolicy string
+
+	// RecentSearchesLimit is how many distinct search terms are remembered
+	// per user; 0 disables recording.
+	RecentSearchesLimit int
+
+	// TokenRateLimit is the default requests-per-minute budget for API
+	// tokens that don't set their own, and MaxTokenRateLimit the most a
+	// user may set.
+	TokenRateLimit    int
+	MaxTokenRateLimit int
+	// APIRateLimit is the requests-per-minute budget for API calls made
+	// with a session cookie, per user.
+	APIRateLimit int
+	// UserRateLimit and IPRateLimit are the requests-per-minute budgets for
+	// state-changing requests and API calls, per signed-in user and per
+	// client IP; 0 turns a limit off.
+	UserRateLimit int
+	IPRateLimit   int
+	// RateLimitStore is where rate limit counters live: "memory" limits
+	// each app instance on its own, "redis" shares the limits between
+	// instances through RedisURL.
+	RateLimitStore string
+
+	// LoginBackoffBase is the delay added after the first failed login for
+	// an email and IP; it doubles with each further failure up to
+	// LoginBackoffMax.
+	LoginBackoffBase time.Duration
+	LoginBackoffMax  time.Duration
+
+	// LoginRateLimit is how many sign-in attempts one client IP may make
+	// per minute.
+	LoginRateLimit int
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For header
+	// is believed when working out a request's client IP.
+	TrustedProxies []netip.Prefix
+
+	// LoginLockoutThreshold failed passwords for one account within
+	// LoginLockoutWindow lock it for LoginLockoutDuration. 0 turns lockout
+	// off.
+	LoginLockoutThreshold int
+	LoginLockoutWindow    time.Duration
+	LoginLockoutDuration  time.Duration
+
+	// FieldEncryptionKey is a base64-encoded 32-byte key used to encrypt
+	// sensitive item fields at rest. Empty stores them as plaintext.
+	FieldEncryptionKey string
+
+	// RegistrationEnabled allows visitors to create their own accounts.
+	RegistrationEnabled bool
+	// RegistrationRateLimit is how many accounts one IP may register per
+	// hour.
+	RegistrationRateLimit int
+	// InviteOnly requires an invite code from an admin to register.
+	// Invites expire InviteTTL after they are created.
+	InviteOnly bool
+	InviteTTL  time.Duration
+	// CaptchaProvider is "hcaptcha", "recaptcha", or "" to skip CAPTCHA
+	// checks (development only). CaptchaSecret is the provider's server
+	// secret and CaptchaSiteKey the public key for the widget.
+	CaptchaProvider string
+	CaptchaSecret   string
+	CaptchaSiteKey  string
+	// CaptchaOnLogin also requires the CAPTCHA on password sign-in.
+	CaptchaOnLogin bool
+
+	// DemoMode offers POST /demo-login, which creates a throwaway account
+	// that is deleted DemoTTL after it was created.
+	DemoMode bool
+	DemoTTL  time.Duration
+
+	// OAuth client credentials. A provider is offered on the login page
+	// when both its client ID and secret are set.
+	GoogleClientID     string
+	GoogleClientSecret string
+	GitHubClientID     string
+	GitHubClientSecret string
+
+	// OpenID Connect single sign-on. OIDCIssuer is the issuer URL whose
+	// /.well-known/openid-configuration describes the provider. With
+	// OIDCAdminGroup set, users whose OIDCGroupsClaim contains it become
+	// admins and everyone else a regular user on each sign-in.
+	// OIDCUnverifiedEmail accepts addresses the provider doesn't mark
+	// email_verified.
+	OIDCIssuer          string
+	OIDCClientID        string
+	OIDCClientSecret    string
+	OIDCLabel           string
+	OIDCScopes          string
+	OIDCEmailClaim      string
+	OIDCGroupsClaim     string
+	OIDCAdminGroup      string
+	OIDCUnverifiedEmail bool
+
+	// SAML 2.0 single sign-on. SAMLIDPMetadata is the IdP's metadata URL
+	// or file; SAMLCertFile and SAMLKeyFile are this service provider's
+	// RSA certificate and key. Attributes are mapped like the OIDC claims.
+	SAMLIDPMetadata     string
+	SAMLCertFile        string
+	SAMLKeyFile         string
+	SAMLEntityID        string
+	SAMLLabel           string
+	SAMLEmailAttribute  string
+	SAMLGroupsAttribute string
+	SAMLAdminGroup      string
+
+	// LDAP or Active Directory sign-in. With LDAPURL set, the login form
+	// is checked by binding as LDAPUserDN, with the login in place of %s.
+	// LDAPBaseDN is searched for the user's entry when LDAPUserDN isn't a
+	// DN (an Active Directory UPN such as "%s@corp.example.com").
+	// LDAPLocalFallback also accepts local passwords the directory
+	// rejects, e.g. for a break-glass admin account.
+	LDAPURL            string
+	LDAPStartTLS       bool
+	LDAPUserDN         string
+	LDAPBaseDN         string
+	LDAPEmailAttribute string
+	LDAPAdminGroup     string
+	LDAPLocalFallback  bool
+
+	// PasswordLoginDisabled turns off email and password sign-in,
+	// registration and password resets, leaving only the identity
+	// providers, passkeys and API tokens.
+	PasswordLoginDisabled bool
+
+	// BaseURL is the public address of the app, used to build links in
+	// emails and as the passkey relying party. It must not come from the
+	// request's Host header.
+	BaseURL string
+
+	// SMTP settings for outgoing mail. With no SMTPHost, mail is logged
+	// instead of sent.
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+
+	// PasswordResetTTL is how long an emailed password reset link works.
+	PasswordResetTTL time.Duration
+
+	// EmailVerificationTTL is how long the link emailed at signup works.
+	EmailVerificationTTL time.Duration
+
+	// DataExportDir holds account data exports that are too large to build
+	// during the request. Files are deleted after DataExportTTL.
+	DataExportDir string
+	DataExportTTL time.Duration
+
+	// IdempotencyKeyTTL is how long the response to a request with an
+	// Idempotency-Key is kept to answer retries.
+	IdempotencyKeyTTL time.Duration
+
+	// MagicLinkEnabled lets users sign in with a single-use link emailed
+	// to them instead of a password. MagicLinkTTL is how long it works.
+	MagicLinkEnabled bool
+	MagicLinkTTL     time.Duration
+
+	// AuthEventLog is where authentication events are written as JSON
+	// lines: "stdout", "stderr", or "" to disable.
+	AuthEventLog string
+
+	// DebugPprof serves net/http/pprof under /debug/pprof/ to admins.
+	DebugPprof bool
+
+	// GRPCAddr is where the gRPC ItemService listens, for example ":9090";
+	// empty disables it. It uses the TLS certificate when one is set.
+	GRPCAddr string
+
+	// CORSAllowedOrigins are the origins, such as "https://app.example.com",
+	// whose pages may call /api/* from the browser; "*" allows any origin
+	// and an empty list disables CORS. CORSAllowCredentials lets them send
+	// the session cookie, and CORSMaxAge is how long browsers may cache a
+	// preflight answer.
+	CORSAllowedOrigins   []string
+	CORSAllowedMethods   []string
+	CORSAllowCredentials bool
+	CORSMaxAge           time.Duration
+
+	// StatsCacheMaxAge is how long browsers may reuse the /stats fragment
+	// without asking again; 0 makes them revalidate every time.
+	StatsCacheMaxAge time.Duration
+
+	// Location is the timezone used for date ranges in stats and for
+	// dates shown in templates.
+	Location *time.Location
+	// FirstWeekday is the day weeks start on for "this week" stats.
+	FirstWeekday time.Weekday
+}
+
+const minSessionSecretLength = 32
+
+// defaultSessionSecret is the SESSION_SECRET used when none is set. It is
+// public, so production refuses to start with it.
+const defaultSessionSecret = "your-secret-key-change-in-production"
+
+// Environments
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// loadConfig reads the configuration from environment variables, applying
+// defaults for anything unset. All invalid settings are reported together
+// in the returned error.
+func loadConfig() (Config, error) {
+	l := envLoader{}
+	cfg := Config{
+		Port:                  l.getString("PORT", "8082"),
+		DBPath:                l.getString("DB_PATH", "app.db"),
+		TemplatesDir:          l.getString("TEMPLATES_DIR", "templates"),
+		StaticDir:             l.getString("STATIC_DIR", "static"),
+		TLSCertFile:           l.getString("TLS_CERT_FILE", ""),
+		TLSKeyFile:            l.getString("TLS_KEY_FILE", ""),
+		TLSMinVersion:         l.getString("TLS_MIN_VERSION", "1.2"),
+		HTTPRedirectAddr:      l.getString("HTTP_REDIRECT_ADDR", ""),
+		Environment:           l.getString("APP_ENV", EnvDevelopment),
+		SessionSecret:         l.getString("SESSION_SECRET", defaultSessionSecret),
+		OldSessionSecrets:     l.getList("SESSION_SECRET_PREVIOUS"),
+		SessionMaxAge:         l.getInt("SESSION_MAX_AGE", 86400*7), // 7 days
+		SecureCookies:         l.getBool("SECURE_COOKIES", false),
+		SessionStore:          l.getString("SESSION_STORE", SessionStoreCookie),
+		RedisURL:              l.getString("REDIS_URL", ""),
+		RememberMeDuration:    l.getDuration("REMEMBER_ME_DURATION", 30*24*time.Hour),
+		PasswordHasher:        l.getString("PASSWORD_HASHER", PasswordHasherArgon2id),
+		BcryptCost:            l.getInt("BCRYPT_COST", bcrypt.DefaultCost),
+		PasswordMinLength:     l.getInt("PASSWORD_MIN_LENGTH", minPasswordLength),
+		PwnedPasswordsEnabled: l.getBool("PWNED_PASSWORDS_ENABLED", false),
+		PwnedPasswordsURL:     l.getString("PWNED_PASSWORDS_URL", "https://api.pwnedpasswords.com/range/"),
+		PwnedPasswordsTTL:     l.getDuration("PWNED_PASSWORDS_CACHE_TTL", 24*time.Hour),
+		MaxItemsPerUser:       l.getInt("MAX_ITEMS_PER_USER", 500),
+		ItemLimitWarnPercent:  l.getInt("ITEM_LIMIT_WARN_PERCENT", 90),
+		MultiTenant:           l.getBool("MULTI_TENANT", false),
+		ItemNamePolicy:        l.getString("ITEM_NAME_POLICY", ItemNamePolicyStrip),
+		RecentSearchesLimit:   l.getInt("RECENT_SEARCHES_LIMIT", 10),
+		TokenRateLimit:        l.getInt("TOKEN_RATE_LIMIT", 60),
+		MaxTokenRateLimit:     l.getInt("MAX_TOKEN_RATE_LIMIT", 600),
+		APIRateLimit:          l.getInt("API_RATE_LIMIT", 120),
+		UserRateLimit:         l.getInt("USER_RATE_LIMIT", 120),
+		IPRateLimit:           l.getInt("IP_RATE_LIMIT", 300),
+		RateLimitStore:        l.getString("RATE_LIMIT_STORE", RateLimitStoreMemory),
+		LoginBackoffBase:      l.getDuration("LOGIN_BACKOFF_BASE", 500*time.Millisecond),
+		LoginBackoffMax:       l.getDuration("LOGIN_BACKOFF_MAX", 10*time.Second),
+		LoginRateLimit:        l.getInt("LOGIN_RATE_LIMIT", 10),
+		TrustedProxies:        l.getPrefixes("TRUSTED_PROXIES"),
+		LoginLockoutThreshold: l.getInt("LOGIN_LOCKOUT_THRESHOLD", 10),
+		LoginLockoutWindow:    l.getDuration("LOGIN_LOCKOUT_WINDOW", 15*time.Minute),
+		LoginLockoutDuration:  l.getDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		FieldEncryptionKey:    l.getString("FIELD_ENCRYPTION_KEY", ""),
+		AuthEventLog:          l.getString("AUTH_EVENT_LOG", ""),
+		BaseURL:               l.getString("BASE_URL", ""),
+		GoogleClientID:        l.getString("GOOGLE_CLIENT_ID", ""),
+		GoogleClientSecret:    l.getString("GOOGLE_CLIENT_SECRET", ""),
+		GitHubClientID:        l.getString("GITHUB_CLIENT_ID", ""),
+		GitHubClientSecret:    l.getString("GITHUB_CLIENT_SECRET", ""),
+		OIDCIssuer:            l.getString("OIDC_ISSUER", ""),
+		OIDCClientID:          l.getString("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:      l.getString("OIDC_CLIENT_SECRET", ""),
+		OIDCLabel:             l.getString("OIDC_LABEL", "SSO"),
+		OIDCScopes:            l.getString("OIDC_SCOPES", "openid email profile"),
+		OIDCEmailClaim:        l.getString("OIDC_EMAIL_CLAIM", "email"),
+		OIDCGroupsClaim:       l.getString("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCAdminGroup:        l.getString("OIDC_ADMIN_GROUP", ""),
+		OIDCUnverifiedEmail:   l.getBool("OIDC_ALLOW_UNVERIFIED_EMAIL", false),
+		SAMLIDPMetadata:       l.getString("SAML_IDP_METADATA", ""),
+		SAMLCertFile:          l.getString("SAML_CERT_FILE", ""),
+		SAMLKeyFile:           l.getString("SAML_KEY_FILE", ""),
+		SAMLEntityID:          l.getString("SAML_ENTITY_ID", ""),
+		SAMLLabel:             l.getString("SAML_LABEL", "SAML"),
+		SAMLEmailAttribute:    l.getString("SAML_EMAIL_ATTRIBUTE", "email"),
+		SAMLGroupsAttribute:   l.getString("SAML_GROUPS_ATTRIBUTE", "groups"),
+		SAMLAdminGroup:        l.getString("SAML_ADMIN_GROUP", ""),
+		LDAPURL:               l.getString("LDAP_URL", ""),
+		LDAPStartTLS:          l.getBool("LDAP_START_TLS", false),
+		LDAPUserDN:            l.getString("LDAP_USER_DN", ""),
+		LDAPBaseDN:            l.getString("LDAP_BASE_DN", ""),
+		LDAPEmailAttribute:    l.getString("LDAP_EMAIL_ATTRIBUTE", "mail"),
+		LDAPAdminGroup:        l.getString("LDAP_ADMIN_GROUP", ""),
+		LDAPLocalFallback:     l.getBool("LDAP_LOCAL_FALLBACK", false),
+		PasswordLoginDisabled: l.getBool("PASSWORD_LOGIN_DISABLED", false),
+		SMTPHost:              l.getString("SMTP_HOST", ""),
+		SMTPPort:              l.getString("SMTP_PORT", "587"),
+		SMTPUsername:          l.getString("SMTP_USERNAME", ""),
+		SMTPPassword:          l.getString("SMTP_PASSWORD", ""),
+		MailFrom:              l.getString("MAIL_FROM", "no-reply@localhost"),
+		PasswordResetTTL:      l.getDuration("PASSWORD_RESET_TTL", time.Hour),
+		EmailVerificationTTL:  l.getDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour),
+		DataExportDir:         l.getString("DATA_EXPORT_DIR", filepath.Join(os.TempDir(), "htmx-auth-app-exports")),
+		DataExportTTL:         l.getDuration("DATA_EXPORT_TTL", 24*time.Hour),
+		IdempotencyKeyTTL:     l.getDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		MagicLinkEnabled:      l.getBool("MAGIC_LINK_ENABLED", false),
+		MagicLinkTTL:          l.getDuration("MAGIC_LINK_TTL", 15*time.Minute),
+		DemoMode:              l.getBool("DEMO_MODE", false),
+		DemoTTL:               l.getDuration("DEMO_TTL", time.Hour),
+		RegistrationEnabled:   l.getBool("REGISTRATION_ENABLED", false),
+		RegistrationRateLimit: l.getInt("REGISTRATION_RATE_LIMIT", 5),
+		InviteOnly:            l.getBool("INVITE_ONLY", false),
+		InviteTTL:             l.getDuration("INVITE_TTL", 7*24*time.Hour),
+		CaptchaProvider:       l.getString("CAPTCHA_PROVIDER", ""),
+		CaptchaSecret:         l.getString("CAPTCHA_SECRET", ""),
+		CaptchaSiteKey:        l.getString("CAPTCHA_SITE_KEY", ""),
+		CaptchaOnLogin:        l.getBool("CAPTCHA_ON_LOGIN", false),
+		DebugPprof:            l.getBool("DEBUG_PPROF", false),
+		GRPCAddr:              l.getString("GRPC_ADDR", ""),
+		CORSAllowedOrigins:    l.getList("CORS_ALLOWED_ORIGINS"),
+		CORSAllowedMethods:    l.getList("CORS_ALLOWED_METHODS"),
+		CORSAllowCredentials:  l.getBool("CORS_ALLOW_CREDENTIALS", false),
+		CORSMaxAge:            l.getDuration("CORS_MAX_AGE", 10*time.Minute),
+		StatsCacheMaxAge:      l.getDuration("STATS_CACHE_MAX_AGE", 30*time.Second),
+		Location:              l.getLocation("APP_TIMEZONE", time.UTC),
+		FirstWeekday:          l.getWeekday("FIRST_WEEKDAY", time.Monday),
+	}
+
+	errs := l.errs
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT: %q is not a valid port number", cfg.Port))
+	}
+	if cfg.DBPath == "" {
+		errs = append(errs, errors.New("DB_PATH: must not be empty"))
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		errs = append(errs, errors.New("TLS_CERT_FILE, TLS_KEY_FILE: must be set together"))
+	}
+	if _, ok := tlsVersions[cfg.TLSMinVersion]; !ok {
+		errs = append(errs, fmt.Errorf("TLS_MIN_VERSION: %q must be 1.2 or 1.3", cfg.TLSMinVersion))
+	}
+	if cfg.HTTPRedirectAddr != "" && cfg.TLSCertFile == "" {
+		errs = append(errs, errors.New("HTTP_REDIRECT_ADDR: requires TLS_CERT_FILE and TLS_KEY_FILE"))
+	}
+	switch cfg.Environment {
+	case EnvDevelopment, EnvProduction:
+	default:
+		errs = append(errs, fmt.Errorf("APP_ENV: %q must be development or production", cfg.Environment))
+	}
+	if len(cfg.SessionSecret) < minSessionSecretLength {
+		errs = append(errs, fmt.Errorf("SESSION_SECRET: must be at least %d characters", minSessionSecretLength))
+	}
+	if cfg.Environment == EnvProduction && cfg.SessionSecret == defaultSessionSecret {
+		errs = append(errs, errors.New("SESSION_SECRET: must be set to a secret value in production"))
+	}
+	for _, secret := range cfg.OldSessionSecrets {
+		if len(secret) < minSessionSecretLength {
+			errs = append(errs, fmt.Errorf("SESSION_SECRET_PREVIOUS: every secret must be at least %d characters", minSessionSecretLength))
+			break
+		}
+	}
+	if cfg.SessionMaxAge <= 0 {
+		errs = append(errs, errors.New("SESSION_MAX_AGE: must be positive"))
+	}
+	switch cfg.SessionStore {
+	case SessionStoreCookie, SessionStoreRedis:
+	default:
+		errs = append(errs, fmt.Errorf("SESSION_STORE: %q must be cookie or redis", cfg.SessionStore))
+	}
+	switch cfg.RateLimitStore {
+	case RateLimitStoreMemory, RateLimitStoreRedis:
+	default:
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_STORE: %q must be memory or redis", cfg.RateLimitStore))
+	}
+	if cfg.SessionStore == SessionStoreRedis || cfg.RateLimitStore == RateLimitStoreRedis {
+		if cfg.RedisURL == "" {
+			errs = append(errs, errors.New("REDIS_URL: required when SESSION_STORE or RATE_LIMIT_STORE is redis"))
+		} else if _, err := redis.ParseURL(cfg.RedisURL); err != nil {
+			errs = append(errs, fmt.Errorf("REDIS_URL: %v", err))
+		}
+	}
+	switch cfg.PasswordHasher {
+	case PasswordHasherArgon2id, PasswordHasherBcrypt:
+	default:
+		errs = append(errs, fmt.Errorf("PASSWORD_HASHER: %q must be argon2id or bcrypt", cfg.PasswordHasher))
+	}
+	if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+		errs = append(errs, fmt.Errorf("BCRYPT_COST: must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	if cfg.PasswordMinLength < minPasswordLength || cfg.PasswordMinLength > maxPasswordLength {
+		errs = append(errs, fmt.Errorf("PASSWORD_MIN_LENGTH: must be between %d and %d", minPasswordLength, maxPasswordLength))
+	}
+	if cfg.PwnedPasswordsEnabled {
+		if u, err := url.Parse(cfg.PwnedPasswordsURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("PWNED_PASSWORDS_URL: %q must be an absolute http or https URL", cfg.PwnedPasswordsURL))
+		}
+		if cfg.PwnedPasswordsTTL <= 0 {
+			errs = append(errs, errors.New("PWNED_PASSWORDS_CACHE_TTL: must be positive"))
+		}
+	}
+	if cfg.RememberMeDuration < 0 {
+		errs = append(errs, errors.New("REMEMBER_ME_DURATION: must not be negative"))
+	}
+	if cfg.MaxItemsPerUser < 0 {
+		errs = append(errs, errors.New("MAX_ITEMS_PER_USER: must not be negative"))
+	}
+	if cfg.ItemLimitWarnPercent < 1 || cfg.ItemLimitWarnPercent > 100 {
+		errs = append(errs, errors.New("ITEM_LIMIT_WARN_PERCENT: must be between 1 and 100"))
+	}
+	switch cfg.ItemNamePolicy {
+	case ItemNamePolicyStrip, ItemNamePolicyReject:
+	default:
+		errs = append(errs, fmt.Errorf("ITEM_NAME_POLICY: %q must be strip or reject", cfg.ItemNamePolicy))
+	}
+	if cfg.RecentSearchesLimit < 0 {
+		errs = append(errs, errors.New("RECENT_SEARCHES_LIMIT: must not be negative"))
+	}
+	if cfg.TokenRateLimit <= 0 {
+		errs = append(errs, errors.New("TOKEN_RATE_LIMIT: must be positive"))
+	}
+	if cfg.MaxTokenRateLimit <= 0 {
+		errs = append(errs, errors.New("MAX_TOKEN_RATE_LIMIT: must be positive"))
+	}
+	if cfg.APIRateLimit <= 0 {
+		errs = append(errs, errors.New("API_RATE_LIMIT: must be positive"))
+	}
+	if cfg.UserRateLimit < 0 {
+		errs = append(errs, errors.New("USER_RATE_LIMIT: must not be negative"))
+	}
+	if cfg.IPRateLimit < 0 {
+		errs = append(errs, errors.New("IP_RATE_LIMIT: must not be negative"))
+	}
+	if cfg.LoginBackoffBase < 0 {
+		errs = append(errs, errors.New("LOGIN_BACKOFF_BASE: must not be negative"))
+	}
+	if cfg.LoginBackoffMax < cfg.LoginBackoffBase {
+		errs = append(errs, errors.New("LOGIN_BACKOFF_MAX: must not be less than LOGIN_BACKOFF_BASE"))
+	}
+	if cfg.LoginRateLimit <= 0 {
+		errs = append(errs, errors.New("LOGIN_RATE_LIMIT: must be positive"))
+	}
+	if cfg.LoginLockoutThreshold < 0 {
+		errs = append(errs, errors.New("LOGIN_LOCKOUT_THRESHOLD: must not be negative"))
+	}
+	if cfg.LoginLockoutThreshold > 0 && cfg.LoginLockoutWindow <= 0 {
+		errs = append(errs, errors.New("LOGIN_LOCKOUT_WINDOW: must be positive"))
+	}
+	if cfg.LoginLockoutThreshold > 0 && cfg.LoginLockoutDuration <= 0 {
+		errs = append(errs, errors.New("LOGIN_LOCKOUT_DURATION: must be positive"))
+	}
+	if cfg.FieldEncryptionKey != "" {
+		if _, err := newFieldCipher(cfg.FieldEncryptionKey); err != nil {
+			errs = append(errs, fmt.Errorf("FIELD_ENCRYPTION_KEY: %v", err))
+		}
+	}
+	switch cfg.AuthEventLog {
+	case "", "stdout", "stderr":
+	default:
+		errs = append(errs, fmt.Errorf("AUTH_EVENT_LOG: %q must be stdout, stderr or empty", cfg.AuthEventLog))
+	}
+	for _, origin := range cfg.CORSAllowedOrigins {
+		if origin == "*" {
+			if cfg.CORSAllowCredentials {
+				errs = append(errs, errors.New("CORS_ALLOWED_ORIGINS: \"*\" can't be combined with CORS_ALLOW_CREDENTIALS"))
+			}
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.Path != "" || u.RawQuery != "" || u.User != nil {
+			errs = append(errs, fmt.Errorf("CORS_ALLOWED_ORIGINS: %q must be a scheme and host, such as https://app.example.com", origin))
+		}
+	}
+	if len(cfg.CORSAllowedMethods) == 0 {
+		cfg.CORSAllowedMethods = []string{"GET", "POST", "PUT", "DELETE"}
+	}
+	for i, method := range cfg.CORSAllowedMethods {
+		cfg.CORSAllowedMethods[i] = strings.ToUpper(method)
+		if strings.ContainsAny(method, " \t/") {
+			errs = append(errs, fmt.Errorf("CORS_ALLOWED_METHODS: %q is not an HTTP method", method))
+		}
+	}
+	if cfg.CORSMaxAge < 0 {
+		errs = append(errs, errors.New("CORS_MAX_AGE: must not be negative"))
+	}
+	if cfg.StatsCacheMaxAge < 0 {
+		errs = append(errs, errors.New("STATS_CACHE_MAX_AGE: must not be negative"))
+	}
+	if cfg.BaseURL == "" {
+		scheme := "http"
+		if cfg.TLSCertFile != "" {
+			scheme = "https"
+		}
+		cfg.BaseURL = scheme + "://localhost:" + cfg.Port
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	if u, err := url.Parse(cfg.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("BASE_URL: %q must be an absolute http or https URL", cfg.BaseURL))
+	}
+	if cfg.SMTPHost != "" && cfg.MailFrom == "" {
+		errs = append(errs, errors.New("MAIL_FROM: required when SMTP_HOST is set"))
+	}
+	if (cfg.GoogleClientID == "") != (cfg.GoogleClientSecret == "") {
+		errs = append(errs, errors.New("GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET: must be set together"))
+	}
+	if (cfg.GitHubClientID == "") != (cfg.GitHubClientSecret == "") {
+		errs = append(errs, errors.New("GITHUB_CLIENT_ID, GITHUB_CLIENT_SECRET: must be set together"))
+	}
+	cfg.OIDCIssuer = strings.TrimSuffix(cfg.OIDCIssuer, "/")
+	if cfg.OIDCIssuer != "" {
+		// Plain http is only allowed for an identity provider on this machine
+		if u, err := url.Parse(cfg.OIDCIssuer); err != nil || u.Host == "" ||
+			(u.Scheme != "https" && (u.Scheme != "http" || !isLoopbackHost(u.Hostname()))) {
+			errs = append(errs, fmt.Errorf("OIDC_ISSUER: %q must be an absolute https URL", cfg.OIDCIssuer))
+		}
+		if cfg.OIDCClientID == "" || cfg.OIDCClientSecret == "" {
+			errs = append(errs, errors.New("OIDC_CLIENT_ID, OIDC_CLIENT_SECRET: required when OIDC_ISSUER is set"))
+		}
+		if !strings.Contains(" "+cfg.OIDCScopes+" ", " openid ") {
+			errs = append(errs, errors.New("OIDC_SCOPES: must include openid"))
+		}
+	}
+	if cfg.SAMLIDPMetadata != "" {
+		if cfg.SAMLCertFile == "" || cfg.SAMLKeyFile == "" {
+			errs = append(errs, errors.New("SAML_CERT_FILE, SAML_KEY_FILE: required when SAML_IDP_METADATA is set"))
+		}
+		// The IdP posts back cross-site, which needs a SameSite=None cookie,
+		// and browsers only keep those over https
+		if u, err := url.Parse(cfg.BaseURL); err == nil && u.Scheme != "https" && !isLoopbackHost(u.Hostname()) {
+			errs = append(errs, errors.New("BASE_URL: must be https when SAML_IDP_METADATA is set"))
+		}
+		if cfg.SAMLEntityID == "" {
+			cfg.SAMLEntityID = cfg.BaseURL + "/saml/metadata"
+		}
+	}
+	if cfg.LDAPURL != "" {
+		if u, err := url.Parse(cfg.LDAPURL); err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("LDAP_URL: %q must be an ldap:// or ldaps:// URL", cfg.LDAPURL))
+		}
+		if cfg.LDAPStartTLS && !strings.HasPrefix(cfg.LDAPURL, "ldap://") {
+			errs = append(errs, errors.New("LDAP_START_TLS: only applies to ldap:// URLs"))
+		}
+		if !strings.Contains(cfg.LDAPUserDN, "%s") {
+			errs = append(errs, errors.New("LDAP_USER_DN: must contain %s where the login goes"))
+		} else if !strings.Contains(cfg.LDAPUserDN, "=") && cfg.LDAPBaseDN == "" {
+			errs = append(errs, errors.New("LDAP_BASE_DN: required when LDAP_USER_DN is not a DN"))
+		}
+		if cfg.PasswordLoginDisabled {
+			errs = append(errs, errors.New("PASSWORD_LOGIN_DISABLED: can't be used with LDAP_URL, which signs in with a password"))
+		}
+	}
+	if cfg.PasswordLoginDisabled && cfg.OIDCIssuer == "" && cfg.SAMLIDPMetadata == "" && cfg.GoogleClientID == "" && cfg.GitHubClientID == "" && !cfg.MagicLinkEnabled {
+		errs = append(errs, errors.New("PASSWORD_LOGIN_DISABLED: requires OIDC_ISSUER, SAML_IDP_METADATA, an OAuth provider or MAGIC_LINK_ENABLED, or nobody could sign in"))
+	}
+	if cfg.PasswordResetTTL <= 0 {
+		errs = append(errs, errors.New("PASSWORD_RESET_TTL: must be positive"))
+	}
+	if cfg.EmailVerificationTTL <= 0 {
+		errs = append(errs, errors.New("EMAIL_VERIFICATION_TTL: must be positive"))
+	}
+	if cfg.DataExportTTL <= 0 {
+		errs = append(errs, errors.New("DATA_EXPORT_TTL: must be positive"))
+	}
+	if cfg.IdempotencyKeyTTL <= 0 {
+		errs = append(errs, errors.New("IDEMPOTENCY_KEY_TTL: must be positive"))
+	}
+	if cfg.MagicLinkTTL <= 0 {
+		errs = append(errs, errors.New("MAGIC_LINK_TTL: must be positive"))
+	}
+	if cfg.DemoTTL <= 0 {
+		errs = append(errs, errors.New("DEMO_TTL: must be positive"))
+	}
+	if cfg.RegistrationRateLimit <= 0 {
+		errs = append(errs, errors.New("REGISTRATION_RATE_LIMIT: must be positive"))
+	}
+	if cfg.InviteOnly && !cfg.RegistrationEnabled {
+		errs = append(errs, errors.New("INVITE_ONLY: requires REGISTRATION_ENABLED"))
+	}
+	if cfg.InviteTTL <= 0 {
+		errs = append(errs, errors.New("INVITE_TTL: must be positive"))
+	}
+	switch cfg.CaptchaProvider {
+	case CaptchaProviderNone:
+	case CaptchaProviderHCaptcha, CaptchaProviderReCaptcha:
+		if cfg.CaptchaSecret == "" || cfg.CaptchaSiteKey == "" {
+			errs = append(errs, errors.New("CAPTCHA_SECRET, CAPTCHA_SITE_KEY: required when CAPTCHA_PROVIDER is set"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("CAPTCHA_PROVIDER: %q must be hcaptcha, recaptcha or empty", cfg.CaptchaProvider))
+	}
+	if cfg.CaptchaOnLogin && cfg.CaptchaProvider == CaptchaProviderNone {
+		errs = append(errs, errors.New("CAPTCHA_ON_LOGIN: requires CAPTCHA_PROVIDER"))
+	}
+	return cfg, errors.Join(errs...)
+}
+
+// sessionSecrets returns the current session secret followed by the old
+// ones, which are only used to check signatures.
+func (c Config) sessionSecrets() []string {
+	return append([]string{c.SessionSecret}, c.OldSessionSecrets...)
+}
+
+// sessionKeyPairs returns the session secrets as gorilla/sessions key
+// pairs: each is a signing key without an encryption key. Cookies are
+// signed with the first and accepted with any.
+func (c Config) sessionKeyPairs() [][]byte {
+	var pairs [][]byte
+	for _, secret := range c.sessionSecrets() {
+		pairs = append(pairs, []byte(secret), nil)
+	}
+	return pairs
+}
+
+// envLoader reads typed values from the environment and collects parse
+// errors so they can be reported together.
+type envLoader struct {
+	errs []error
+}
+
+func (l *envLoader) getString(name, def string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return def
+}
+
+func (l *envLoader) getInt(name string, def int) int {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: %q is not an integer", name, value))
+		return def
+	}
+	return n
+}
+
+func (l *envLoader) getBool(name string, def bool) bool {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: %q is not a boolean", name, value))
+		return def
+	}
+	return b
+}
+
+func (l *envLoader) getDuration(name string, def time.Duration) time.Duration {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: %q is not a duration", name, value))
+		return def
+	}
+	return d
+}
+
+// getList reads a comma-separated list, dropping empty entries.
+func (l *envLoader) getList(name string) []string {
+	var list []string
+	for _, field := range strings.Split(os.Getenv(name), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			list = append(list, field)
+		}
+	}
+	return list
+}
+
+// getPrefixes reads a comma-separated list of IP addresses and CIDR ranges.
+// A bare address is a single-address range.
+func (l *envLoader) getPrefixes(name string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(os.Getenv(name), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(field); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Errorf("%s: %q is not an IP address or CIDR range", name, field))
+			continue
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+}
+
+func (l *envLoader) getWeekday(name string, def time.Weekday) time.Weekday {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return def
+	}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(value, day.String()) {
+			return day
+		}
+	}
+	l.errs = append(l.errs, fmt.Errorf("%s: %q is not a day of the week", name, value))
+	return def
+}
+
+func (l *envLoader) getLocation(name string, def *time.Location) *time.Location {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return def
+	}
+	loc, err := time.LoadLocation(value)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: %q is not a known timezone", name, value))
+		return def
+	}
+	return loc
+}
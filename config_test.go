@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.Port != "8082" || cfg.MaxItemsPerUser != 500 || cfg.Environment != EnvDevelopment {
+		t.Errorf("defaults = port %q, max items %d, env %q", cfg.Port, cfg.MaxItemsPerUser, cfg.Environment)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want []string
+	}{
+		{
+			env:  map[string]string{"PORT": "99999"},
+			want: []string{`PORT: "99999" is not a valid port number`},
+		},
+		{
+			env:  map[string]string{"SESSION_MAX_AGE": "week"},
+			want: []string{`SESSION_MAX_AGE: "week" is not an integer`},
+		},
+		{
+			env:  map[string]string{"SECURE_COOKIES": "maybe"},
+			want: []string{`SECURE_COOKIES: "maybe" is not a boolean`},
+		},
+		{
+			env:  map[string]string{"MAGIC_LINK_TTL": "soon"},
+			want: []string{`MAGIC_LINK_TTL: "soon" is not a duration`},
+		},
+		{
+			env:  map[string]string{"TRUSTED_PROXIES": "10.0.0.0/8,proxy"},
+			want: []string{`TRUSTED_PROXIES: "proxy" is not an IP address or CIDR range`},
+		},
+		{
+			env:  map[string]string{"APP_TIMEZONE": "Mars/Olympus"},
+			want: []string{`APP_TIMEZONE: "Mars/Olympus" is not a known timezone`},
+		},
+		{
+			env:  map[string]string{"APP_ENV": "production"},
+			want: []string{"SESSION_SECRET: must be set to a secret value in production"},
+		},
+		{
+			env:  map[string]string{"ITEM_NAME_POLICY": "ignore"},
+			want: []string{`ITEM_NAME_POLICY: "ignore" must be strip or reject`},
+		},
+		{
+			env:  map[string]string{"INVITE_ONLY": "true"},
+			want: []string{"INVITE_ONLY: requires REGISTRATION_ENABLED"},
+		},
+		{
+			// Every problem is reported, not just the first
+			env: map[string]string{
+				"PORT":                    "http",
+				"BASE_URL":                "https://app.example.com",
+				"MAX_ITEMS_PER_USER":      "-1",
+				"ITEM_LIMIT_WARN_PERCENT": "0",
+				"LOGIN_BACKOFF_MAX":       "1ms",
+			},
+			want: []string{
+				`PORT: "http" is not a valid port number`,
+				"MAX_ITEMS_PER_USER: must not be negative",
+				"ITEM_LIMIT_WARN_PERCENT: must be between 1 and 100",
+				"LOGIN_BACKOFF_MAX: must not be less than LOGIN_BACKOFF_BASE",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.want, "; "), func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			_, err := loadConfig()
+			if err == nil {
+				t.Fatalf("loadConfig with %v succeeded", tt.env)
+			}
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(tt.want) {
+				t.Errorf("got %d errors, want %d:\n%v", len(lines), len(tt.want), err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error doesn't include %q:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// corsAllowedHeaders are the request headers cross-origin API calls
+	// may send.
+	corsAllowedHeaders = "Authorization, Content-Type, Idempotency-Key, X-CSRF-Token"
+
+	// corsExposedHeaders are the response headers cross-origin pages may
+	// read besides the CORS-safelisted ones.
+	corsExposedHeaders = "Idempotent-Replayed, Location, Retry-After, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, X-Total-Count"
+)
+
+// corsOriginAllowed reports whether pages on origin may call the API.
+func (app *App) corsOriginAllowed(origin string) bool {
+	for _, allowed := range app.config.CORSAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiCORS adds CORS headers to /api/* responses for the origins in
+// CORS_ALLOWED_ORIGINS and answers their preflight requests. It wraps the
+// whole router because preflight OPTIONS requests match no route. Other
+// origins get no CORS headers, so browsers keep the responses from them;
+// the requests are still handled, as they would be without CORS.
+func (app *App) apiCORS(next http.Handler) http.Handler {
+	if len(app.config.CORSAllowedOrigins) == 0 {
+		return next
+	}
+	methods := strings.Join(app.config.CORSAllowedMethods, ", ")
+	maxAge := strconv.Itoa(int(app.config.CORSMaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" || !app.corsOriginAllowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Origin", origin)
+		if app.config.CORSAllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Set("Access-Control-Allow-Methods", methods)
+			h.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			h.Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Data export statuses
+const (
+	DataExportPending = "pending"
+	DataExportReady   = "ready"
+	DataExportFailed  = "failed"
+)
+
+// dataExportSyncItems is the most items an account may have for its data
+// export to be built while the request waits. Larger exports are built in
+// the background.
+const dataExportSyncItems = 1000
+
+// dataExportProfile is profile.json in a data export. Secrets such as the
+// password hash, TOTP secret and token hashes are left out.
+type dataExportProfile struct {
+	ID             uint                   `json:"id"`
+	Email          string                 `json:"email"`
+	PendingEmail   string                 `json:"pending_email,omitempty"`
+	Role           string                 `json:"role"`
+	Verified       bool                   `json:"verified"`
+	TOTPEnabled    bool                   `json:"two_factor_enabled"`
+	Organization   string                 `json:"organization,omitempty"`
+	CreatedAt      time.Time              `json:"created_at"`
+	Passkeys       []dataExportPasskey    `json:"passkeys"`
+	APITokens      []dataExportToken      `json:"api_tokens"`
+	WebhookURL     string                 `json:"webhook_url,omitempty"`
+	RecentSearches []dataExportSearchTerm `json:"recent_searches"`
+}
+
+type dataExportPasskey struct {
+	Name       string     `json:"name"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type dataExportToken struct {
+	Name         string     `json:"name"`
+	Prefix       string     `json:"prefix"`
+	RequestCount int64      `json:"request_count"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type dataExportSearchTerm struct {
+	Term      string    `json:"term"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// dataExportHandler downloads a ZIP of everything the app stores about the
+// signed-in user: profile.json, items.json and items.csv. Large accounts
+// get a page that waits for the export to be built in the background.
+func (app *App) dataExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	var count int64
+	if err := app.db.Model(&Item{}).Where("user_id = ?", user.ID).Count(&count).Error; err != nil {
+		writeServerError(w)
+		return
+	}
+	if count <= dataExportSyncItems {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, dataExportFilename(time.Now())))
+		if err := app.writeDataExport(w, user); err != nil {
+			log.Println("Error writing data export:", err)
+		}
+		return
+	}
+
+	// Reuse an export that is still being built or can still be
+	// downloaded, so reloading the page doesn't start another
+	now := time.Now()
+	var export DataExport
+	err := app.db.Where("user_id = ? AND status <> ? AND expires_at > ?", user.ID, DataExportFailed, now).Order("id desc").First(&export).Error
+	if err != nil {
+		export = DataExport{UserID: user.ID, Status: DataExportPending, ExpiresAt: now.Add(app.config.DataExportTTL)}
+		if err := app.db.Create(&export).Error; err != nil {
+			log.Println("Error creating data export:", err)
+			writeServerError(w)
+			return
+		}
+		go app.buildDataExport(export, user)
+	}
+	http.Redirect(w, r, fmt.Sprintf("/account/export/%d", export.ID), http.StatusSeeOther)
+}
+
+// dataExportStatusHandler shows whether a background export is ready. While
+// it is pending, the page polls itself.
+func (app *App) dataExportStatusHandler(w http.ResponseWriter, r *http.Request) {
+	export, ok := app.userDataExport(w, r)
+	if !ok {
+		return
+	}
+	if r.Header.Get("HX-Request") == "true" {
+		app.tmpl.ExecuteTemplate(w, "data_export.templ", export)
+		return
+	}
+	app.renderPage(w, r, http.StatusOK, "data_export", export)
+}
+
+// downloadDataExportHandler sends a finished background export.
+func (app *App) downloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	export, ok := app.userDataExport(w, r)
+	if !ok {
+		return
+	}
+	if export.Status != DataExportReady {
+		app.writeErrorPage(w, r, http.StatusConflict, "This export isn't ready yet.")
+		return
+	}
+	f, err := os.Open(app.dataExportPath(export))
+	if err != nil {
+		app.notFoundHandler(w, r)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, dataExportFilename(export.CreatedAt)))
+	io.Copy(w, f)
+}
+
+// userDataExport loads the unexpired export named in the URL if it belongs
+// to the signed-in user, and otherwise writes the error response.
+func (app *App) userDataExport(w http.ResponseWriter, r *http.Request) (DataExport, bool) {
+	var export DataExport
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return export, false
+	}
+	err := app.db.Where("id = ? AND user_id = ? AND expires_at > ?", mux.Vars(r)["id"], user.ID, time.Now()).First(&export).Error
+	if err != nil {
+		app.notFoundHandler(w, r)
+		return export, false
+	}
+	return export, true
+}
+
+// buildDataExport writes a background export to DataExportDir and marks it
+// ready, or failed if anything goes wrong.
+func (app *App) buildDataExport(export DataExport, user User) {
+	status := DataExportReady
+	if err := app.writeDataExportFile(export, user); err != nil {
+		log.Println("Error building data export:", err)
+		status = DataExportFailed
+	}
+	if err := app.db.Model(&export).Update("status", status).Error; err != nil {
+		log.Println("Error updating data export:", err)
+	}
+}
+
+func (app *App) writeDataExportFile(export DataExport, user User) error {
+	if err := os.MkdirAll(app.config.DataExportDir, 0o700); err != nil {
+		return err
+	}
+	// Write to a temporary file first so a half-written export is never
+	// served
+	f, err := os.CreateTemp(app.config.DataExportDir, "export-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := app.writeDataExport(f, user); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), app.dataExportPath(export))
+}
+
+// dataExportPath is where a background export's ZIP is stored. File names
+// start with the user ID so removeDataExportFiles can find them.
+func (app *App) dataExportPath(export DataExport) string {
+	return filepath.Join(app.config.DataExportDir, fmt.Sprintf("%d-%d.zip", export.UserID, export.ID))
+}
+
+// removeDataExportFiles deletes any background exports of userID, for
+// example when their account is deleted.
+func (app *App) removeDataExportFiles(userID uint) {
+	matches, _ := filepath.Glob(filepath.Join(app.config.DataExportDir, fmt.Sprintf("%d-*.zip", userID)))
+	for _, path := range matches {
+		if err := os.Remove(path); err != nil {
+			log.Println("Error removing data export:", err)
+		}
+	}
+}
+
+func dataExportFilename(t time.Time) string {
+	return "my-data-" + t.Format("20060102") + ".zip"
+}
+
+// writeDataExport writes the ZIP of user's data to w.
+func (app *App) writeDataExport(w io.Writer, user User) error {
+	profile := dataExportProfile{
+		ID:             user.ID,
+		Email:          user.Email,
+		PendingEmail:   user.PendingEmail,
+		Role:           user.Role,
+		Verified:       user.Verified,
+		TOTPEnabled:    user.TOTPEnabled,
+		CreatedAt:      user.CreatedAt,
+		Passkeys:       []dataExportPasskey{},
+		APITokens:      []dataExportToken{},
+		RecentSearches: []dataExportSearchTerm{},
+	}
+	if user.OrgID != nil {
+		var org Organization
+		if app.db.First(&org, *user.OrgID).Error == nil {
+			profile.Organization = org.Name
+		}
+	}
+	var passkeys []WebAuthnCredential
+	if err := app.db.Where("user_id = ?", user.ID).Order("id").Find(&passkeys).Error; err != nil {
+		return err
+	}
+	for _, p := range passkeys {
+		profile.Passkeys = append(profile.Passkeys, dataExportPasskey{Name: p.Name, LastUsedAt: p.LastUsedAt, CreatedAt: p.CreatedAt})
+	}
+	var tokens []UserToken
+	if err := app.db.Where("user_id = ?", user.ID).Order("id").Find(&tokens).Error; err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		profile.APITokens = append(profile.APITokens, dataExportToken{
+			Name:         t.Name,
+			Prefix:       t.Prefix,
+			RequestCount: t.RequestCount,
+			LastUsedAt:   t.LastUsedAt,
+			RevokedAt:    t.RevokedAt,
+			CreatedAt:    t.CreatedAt,
+		})
+	}
+	var webhook Webhook
+	if app.db.Where("user_id = ?", user.ID).First(&webhook).Error == nil {
+		profile.WebhookURL = webhook.URL
+	}
+	var searches []RecentSearch
+	if err := app.db.Where("user_id = ?", user.ID).Order("id").Find(&searches).Error; err != nil {
+		return err
+	}
+	for _, s := range searches {
+		profile.RecentSearches = append(profile.RecentSearches, dataExportSearchTerm{Term: s.Term, CreatedAt: s.CreatedAt})
+	}
+
+	var items []Item
+	if err := app.db.Where("user_id = ?", user.ID).Order("created_at desc, id desc").Find(&items).Error; err != nil {
+		return err
+	}
+	app.loadTags(items)
+
+	zw := zip.NewWriter(w)
+	now := time.Now()
+	create := func(name string) (io.Writer, error) {
+		return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+	}
+	f, err := create("profile.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(profile); err != nil {
+		return err
+	}
+	if f, err = create("items.json"); err != nil {
+		return err
+	}
+	if err := encodeItemsJSON(f, items); err != nil {
+		return err
+	}
+	if f, err = create("items.csv"); err != nil {
+		return err
+	}
+	if err := encodeItemsCSV(f, items); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// startDataExportCleanup deletes expired background exports every hour.
+func (app *App) startDataExportCleanup() {
+	go func() {
+		for range time.Tick(time.Hour) {
+			app.deleteExpiredDataExports(time.Now())
+		}
+	}()
+}
+
+// deleteExpiredDataExports removes exports that expired before now, and any
+// export file older than DataExportTTL.
+func (app *App) deleteExpiredDataExports(now time.Time) {
+	if err := app.db.Where("expires_at < ?", now).Delete(&DataExport{}).Error; err != nil {
+		log.Println("Error deleting expired data exports:", err)
+		return
+	}
+	entries, err := os.ReadDir(app.config.DataExportDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < app.config.DataExportTTL {
+			continue
+		}
+		if err := os.Remove(filepath.Join(app.config.DataExportDir, entry.Name())); err != nil {
+			log.Println("Error removing data export:", err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// userOwnedModels are the tables whose rows belong to a user through a
+// user_id column. Add new ones here so they are removed with the account.
+var userOwnedModels = []interface{}{
+	&Item{},
+	&UserToken{},
+	&WebAuthnCredential{},
+	&PasswordReset{},
+	&MagicLink{},
+	&ExternalLogin{},
+	&DataExport{},
+	&UserSession{},
+	&RememberToken{},
+	&LoginEvent{},
+	&Webhook{},
+	&IncomingHook{},
+	&IdempotencyKey{},
+	&RecentSearch{},
+	&Tag{},
+	&ItemTag{},
+	&Category{},
+	&List{},
+}
+
+// deleteUsers removes the users with the given IDs and everything they own.
+// Audit log entries about them are kept. Run it inside a transaction.
+func deleteUsers(tx *gorm.DB, ids []uint) error {
+	for _, model := range userOwnedModels {
+		if err := tx.Where("user_id IN ?", ids).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Where("id IN ?", ids).Delete(&User{}).Error
+}
+
+// deleteAccountPageHandler asks the signed-in user to confirm deleting their
+// account.
+func (app *App) deleteAccountPageHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	data := map[string]interface{}{"User": user}
+	if r.Header.Get("HX-Request") == "true" {
+		app.tmpl.ExecuteTemplate(w, "delete_account.templ", data)
+		return
+	}
+	app.renderPage(w, r, http.StatusOK, "delete_account", data)
+}
+
+// deleteAccountHandler deletes the signed-in user's account and all of its
+// data once they confirm with their password, or with their email address
+// when password sign-in is turned off, then ends the session. The user's
+// sessions on other devices end on their next request, since the account
+// is gone.
+func (app *App) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	renderError := func(status int, message string) {
+		w.WriteHeader(status)
+		app.tmpl.ExecuteTemplate(w, "delete_account.templ", map[string]interface{}{
+			"User":  user,
+			"Error": message,
+		})
+	}
+
+	if app.config.PasswordLoginDisabled {
+		if !strings.EqualFold(strings.TrimSpace(r.FormValue("email")), user.Email) {
+			renderError(http.StatusForbidden, "The email address doesn't match your account")
+			return
+		}
+	} else if !app.passwords.Verify(user.PasswordHash, r.FormValue("password")) {
+		app.authEvents.log(r, AuthEventAccountDelete, AuthOutcomeFailure, user, "")
+		renderError(http.StatusForbidden, "Password is incorrect")
+		return
+	}
+	if user.Role == RoleAdmin {
+		var admins int64
+		app.db.Model(&User{}).Where("role = ?", RoleAdmin).Count(&admins)
+		if admins <= 1 {
+			renderError(http.StatusConflict, "You're the only admin. Make someone else an admin before deleting your account.")
+			return
+		}
+	}
+
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		return deleteUsers(tx, []uint{user.ID})
+	})
+	if err != nil {
+		log.Println("Error deleting account:", err)
+		writeServerError(w)
+		return
+	}
+	app.removeDataExportFiles(user.ID)
+	app.authEvents.log(r, AuthEventAccountDelete, AuthOutcomeSuccess, user, "")
+
+	session, _ := app.store.Get(r, "session")
+	session.Values["user_id"] = nil
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		log.Println("Error saving session:", err)
+	}
+	app.tmpl.ExecuteTemplate(w, "login.templ", map[string]interface{}{
+		"Success": "Your account has been deleted.",
+	})
+}
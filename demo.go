@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const demoExpiredMessage = "This demo account has expired. Start a new demo to keep exploring."
+
+// demoLoginsPerHour limits how many demo accounts one IP can create.
+const demoLoginsPerHour = 10
+
+// demoItems are the example items every demo account starts with.
+var demoItems = []Item{
+	{Name: "Buy groceries", Description: "Milk, eggs and bread", Quantity: 3},
+	{Name: "Read a book", Description: "Try searching for \"book\" or archiving this item"},
+	{Name: "Plan weekend trip", Quantity: 1},
+	{Name: "Water the plants", Status: ItemStatusArchived},
+}
+
+// demoLoginHandler creates a throwaway account with a few example items,
+// signs it in and returns the dashboard. The account is deleted DemoTTL
+// later by the cleanup worker.
+func (app *App) demoLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config.DemoMode {
+		app.notFoundHandler(w, r)
+		return
+	}
+
+	now := time.Now()
+	if limit := app.limiter.allow("demo:"+clientIP(r), demoLoginsPerHour, time.Hour, now); !limit.Allowed {
+		setRateLimitHeaders(w, limit, now)
+		w.WriteHeader(http.StatusTooManyRequests)
+		app.tmpl.ExecuteTemplate(w, "login.templ", map[string]interface{}{
+			"Error": "Too many demo accounts from your network. Please try again later.",
+		})
+		return
+	}
+
+	user, err := app.createDemoUser(now)
+	if err != nil {
+		log.Println("Error creating demo user:", err)
+		writeServerError(w)
+		return
+	}
+	app.logLogin(r, AuthOutcomeSuccess, user, "")
+
+	session, _ := app.store.Get(r, "session")
+	if err := app.setSessionUser(r, session, user); err != nil {
+		log.Println("Error starting session:", err)
+		writeServerError(w)
+		return
+	}
+	if err := session.Save(r, w); err != nil {
+		log.Println("Error saving session:", err)
+		writeServerError(w)
+		return
+	}
+	app.tmpl.ExecuteTemplate(w, "dashboard.templ", map[string]interface{}{
+		"User": user,
+	})
+}
+
+// createDemoUser creates a demo account with a random email and an unusable
+// password, seeded with demoItems.
+func (app *App) createDemoUser(now time.Time) (User, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return User{}, err
+	}
+	// Nobody knows this password, so the account can only be used through
+	// the session created here
+	hash, err := app.passwords.Hash(string(random))
+	if err != nil {
+		return User{}, err
+	}
+
+	expiresAt := now.Add(app.config.DemoTTL)
+	user := User{
+		Email:        "demo-" + hex.EncodeToString(random[:6]) + "@demo.invalid",
+		PasswordHash: hash,
+		Demo:         true,
+		Verified:     true,
+		ExpiresAt:    &expiresAt,
+	}
+	if err := app.db.Create(&user).Error; err != nil {
+		return User{}, err
+	}
+
+	for i, seed := range demoItems {
+		item := seed
+		item.UserID = user.ID
+		if item.Status == "" {
+			item.Status = ItemStatusActive
+		}
+		if item.Quantity == 0 {
+			item.Quantity = 1
+		}
+		// Space the items out so they list in a stable order
+		item.CreatedAt = now.Add(-time.Duration(len(demoItems)-i) * time.Minute)
+		if err := app.createItem(&item); err != nil {
+			return User{}, err
+		}
+	}
+	return user, nil
+}
+
+// startDemoCleanup deletes expired demo accounts in the background while
+// demo mode is on.
+func (app *App) startDemoCleanup() {
+	if !app.config.DemoMode {
+		return
+	}
+	interval := app.config.DemoTTL / 2
+	if interval > 5*time.Minute {
+		interval = 5 * time.Minute
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if n, err := app.deleteExpiredDemoUsers(time.Now()); err != nil {
+				log.Println("Error deleting expired demo accounts:", err)
+			} else if n > 0 {
+				log.Printf("Deleted %d expired demo accounts", n)
+			}
+		}
+	}()
+}
+
+// deleteExpiredDemoUsers removes demo accounts that expired before now,
+// together with everything they own.
+func (app *App) deleteExpiredDemoUsers(now time.Time) (int64, error) {
+	var ids []uint
+	if err := app.db.Model(&User{}).Where("demo = ? AND expires_at < ?", true, now).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		return deleteUsers(tx, ids)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
+
+// isDemoUser reports whether userID is a demo account. Demo accounts can't
+// set up anything that reaches outside the app, such as webhooks.
+func (app *App) isDemoUser(userID interface{}) bool {
+	var user User
+	if err := app.db.Select("demo").First(&user, userID).Error; err != nil {
+		return false
+	}
+	return user.Demo
+}
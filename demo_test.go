@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDemoLogin(t *testing.T) {
+	handler, app := newTestApp(t, func(cfg *Config) {
+		cfg.DemoMode = true
+		cfg.DemoTTL = 30 * time.Minute
+	})
+	c := newTestClient(t, handler)
+
+	start := time.Now()
+	resp, body := c.post("/demo-login", nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "<h1>Dashboard</h1>") {
+		t.Fatalf("status %d\n%s", resp.StatusCode, body)
+	}
+	if !strings.Contains(body, "Demo account: your data will be deleted at") {
+		t.Errorf("the dashboard doesn't say when the demo expires:\n%s", body)
+	}
+
+	var user User
+	if err := app.db.Where("demo = ?", true).First(&user).Error; err != nil {
+		t.Fatalf("no demo account: %v", err)
+	}
+	if !user.Verified || user.ExpiresAt == nil || user.ExpiresAt.Before(start.Add(29*time.Minute)) || user.ExpiresAt.After(time.Now().Add(30*time.Minute)) {
+		t.Errorf("demo account = %+v, want it verified and expiring in 30 minutes", user)
+	}
+
+	// The session sees the seeded items, with the archived one hidden
+	_, body = c.get("/items")
+	for _, seed := range demoItems {
+		if listed := strings.Contains(body, seed.Name); listed != (seed.Status != ItemStatusArchived) {
+			t.Errorf("%q listed = %v", seed.Name, listed)
+		}
+	}
+	var count int64
+	app.db.Model(&Item{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != int64(len(demoItems)) {
+		t.Errorf("%d demo items, want %d", count, len(demoItems))
+	}
+
+	// Once it expires the session ends and the cleanup removes the account
+	app.db.Model(&user).Update("expires_at", time.Now().Add(-time.Minute))
+	if resp, body := c.get("/items"); resp.StatusCode != http.StatusUnauthorized || !strings.Contains(body, demoExpiredMessage) {
+		t.Errorf("expired demo: status %d\n%s", resp.StatusCode, body)
+	}
+	if n, err := app.deleteExpiredDemoUsers(time.Now()); n != 1 || err != nil {
+		t.Errorf("deleteExpiredDemoUsers = %d, %v; want 1", n, err)
+	}
+	app.db.Model(&Item{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("%d items left after the cleanup", count)
+	}
+}
+
+func TestDemoLoginDisabled(t *testing.T) {
+	handler, _ := newTestApp(t)
+	c := newTestClient(t, handler)
+	if resp, _ := c.post("/demo-login", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
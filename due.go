@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Views of the items list by due date, chosen with the "filter" parameter.
+const (
+	ItemViewOverdue = "overdue"
+	ItemViewToday   = "today"
+)
+
+// ItemSortDue is the "sort" parameter value that lists items by due date,
+// soonest first and items without one last.
+const ItemSortDue = "due"
+
+// parseDueDate reads a due date: a day like 2024-05-31, taken as that day
+// in loc, or an RFC 3339 time. An empty value means no due date. Due dates
+// are kept in UTC so the database compares them correctly.
+func parseDueDate(value string, loc *time.Location) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		if t, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, errors.New("Due date must be a date like 2024-05-31")
+		}
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+// dueView scopes an Item query to one of the due date views as of now: items
+// due before today and not yet done for ItemViewOverdue, items due today
+// for ItemViewToday. Days are those of now's location. Other views don't
+// filter.
+func dueView(view string, now time.Time) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		today := startOfDay(now)
+		switch view {
+		case ItemViewOverdue:
+			return db.Where("items.due_at < ? AND NOT items.completed", today.UTC())
+		case ItemViewToday:
+			return db.Where("items.due_at >= ? AND items.due_at < ?", today.UTC(), today.AddDate(0, 0, 1).UTC())
+		default:
+			return db
+		}
+	}
+}
+
+// equalTimes reports whether two optional times are the same instant.
+func equalTimes(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// isOverdue reports whether item was due before the day of now.
+func isOverdue(item Item, now time.Time) bool {
+	return item.DueAt != nil && item.DueAt.Before(startOfDay(now))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+)
+
+// normalizedItemName is the SQL expression items are grouped by when
+// looking for duplicates: the name trimmed and lowercased.
+const normalizedItemName = "LOWER(TRIM(name))"
+
+// duplicateGroup is a set of a user's items that share a normalized name.
+type duplicateGroup struct {
+	Name  string         `json:"name"`
+	Count int            `json:"count"`
+	Items []itemResponse `json:"items"`
+}
+
+// findDuplicateGroups returns the user's items grouped by normalized name,
+// keeping only groups with more than one member. Groups are ordered by size,
+// largest first; members oldest first.
+func (app *App) findDuplicateGroups(userID interface{}) ([]duplicateGroup, error) {
+	var rows []struct {
+		Normalized string
+		Count      int
+	}
+	scope := app.visibleItems(userID)
+	err := app.db.Model(&Item{}).
+		Select(normalizedItemName + " AS normalized, COUNT(*) AS count").
+		Scopes(scope).
+		Group("normalized").
+		Having("COUNT(*) > 1").
+		Order("count desc, normalized").
+		Scan(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return []duplicateGroup{}, err
+	}
+
+	names := make([]string, len(rows))
+	groups := make([]duplicateGroup, len(rows))
+	index := make(map[string]int, len(rows))
+	for i, row := range rows {
+		names[i] = row.Normalized
+		groups[i] = duplicateGroup{Name: row.Normalized, Count: row.Count, Items: []itemResponse{}}
+		index[row.Normalized] = i
+	}
+
+	var members []struct {
+		Item
+		Normalized string
+	}
+	err = app.db.Model(&Item{}).
+		Select("*, "+normalizedItemName+" AS normalized").
+		Scopes(scope).
+		Where(normalizedItemName+" IN ?", names).
+		Order("created_at, id").
+		Scan(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		if i, ok := index[m.Normalized]; ok {
+			groups[i].Items = append(groups[i].Items, newItemResponse(m.Item))
+		}
+	}
+	return groups, nil
+}
+
+// duplicatesHandler lists groups of items with the same normalized name so
+// the user can review and delete the extras. It answers JSON to clients that
+// accept it and an HTML fragment otherwise.
+func (app *App) duplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		userID, ok := app.apiUserID(w, r)
+		if !ok {
+			return
+		}
+		groups, err := app.findDuplicateGroups(userID)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"groups": groups})
+		return
+	}
+
+	// Check authentication
+	session, _ := app.store.Get(r, "session")
+	userID, ok := session.Values["user_id"]
+	if !ok || userID == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`<div class="error">Unauthorized. Please log in.</div>`))
+		return
+	}
+
+	groups, err := app.findDuplicateGroups(userID)
+	if err != nil {
+		writeServerError(w)
+		return
+	}
+	app.tmpl.ExecuteTemplate(w, "duplicates.templ", map[string]interface{}{
+		"Groups": groups,
+	})
+}
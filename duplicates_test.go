@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDuplicateGroups(t *testing.T) {
+	handler, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	bob := createTestUser(t, app, "bob@example.com", "Correct-Horse-1", RoleUser)
+	start := time.Now().Add(-time.Hour)
+	var milk []uint
+	for i, name := range []string{"Milk", " milk", "MILK ", "Eggs", "eggs", "Bread"} {
+		item := Item{UserID: alice.ID, Name: name, Status: ItemStatusActive, Quantity: 1, CreatedAt: start.Add(time.Duration(i) * time.Minute)}
+		if err := app.createItem(&item); err != nil {
+			t.Fatal(err)
+		}
+		if i < 3 {
+			milk = append(milk, item.ID)
+		}
+	}
+	createTestItem(t, app, bob, "Bread") // another user's copy isn't a duplicate
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	var list struct {
+		Groups []duplicateGroup `json:"groups"`
+	}
+	if resp := c.api("GET", "/items/duplicates", "", nil, &list); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if len(list.Groups) != 2 {
+		t.Fatalf("groups = %+v, want milk and eggs", list.Groups)
+	}
+	// Largest group first, its members oldest first
+	group := list.Groups[0]
+	if group.Name != "milk" || group.Count != 3 || len(group.Items) != 3 {
+		t.Fatalf("first group = %+v, want 3 milk items", group)
+	}
+	for i, item := range group.Items {
+		if item.ID != milk[i] {
+			t.Errorf("milk item %d has ID %d, want %d", i, item.ID, milk[i])
+		}
+	}
+	if group := list.Groups[1]; group.Name != "eggs" || group.Count != 2 || len(group.Items) != 2 {
+		t.Errorf("second group = %+v, want 2 eggs items", group)
+	}
+
+	resp, body := c.get("/items/duplicates")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "milk") || strings.Contains(body, "Bread") {
+		t.Errorf("HTML: status %d\n%s", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emailChangesPerHour limits how often a user can ask to change their email
+// address, since each request sends mail to an address they choose.
+const emailChangesPerHour = 3
+
+// signEmailChange returns the HMAC that authenticates a link confirming
+// that userID wants to change their address to email. A link for an older
+// pending address stops working once another change is requested.
+func signEmailChange(secret string, userID uint, email string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "change-email\x00%d\x00%s\x00%d", userID, email, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkEmailChangeToken returns the user an email change token was issued
+// for, or false if it is malformed, expired, forged or for an address that
+// is no longer pending.
+func (app *App) checkEmailChangeToken(token string, now time.Time) (User, bool) {
+	var user User
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return user, false
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return user, false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return user, false
+	}
+	if err := app.db.First(&user, id).Error; err != nil || user.PendingEmail == "" {
+		return user, false
+	}
+	for _, secret := range app.config.sessionSecrets() {
+		want := signEmailChange(secret, user.ID, user.PendingEmail, expires)
+		if hmac.Equal([]byte(parts[2]), []byte(want)) {
+			return user, true
+		}
+	}
+	return user, false
+}
+
+// emailSettingsHandler shows the user's email address and any change
+// waiting for confirmation.
+func (app *App) emailSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	app.tmpl.ExecuteTemplate(w, "account_email.templ", map[string]interface{}{"User": user})
+}
+
+// changeEmailHandler records the "email" form value as the user's pending
+// address and sends a confirmation link to it. The account's email only
+// changes once the link is opened, so a mistyped address can't take over
+// sign-in or password resets.
+func (app *App) changeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	email := strings.TrimSpace(r.FormValue("email"))
+	data := map[string]interface{}{"User": user, "Email": email}
+	renderError := func(message string) {
+		data["Error"] = message
+		app.tmpl.ExecuteTemplate(w, "account_email.templ", data)
+	}
+
+	if user.Demo {
+		renderError("Demo accounts can't change their email address")
+		return
+	}
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		renderError("Please enter a valid email address")
+		return
+	}
+	if strings.EqualFold(email, user.Email) {
+		renderError("That is already your email address")
+		return
+	}
+	var existing int64
+	app.db.Model(&User{}).Where("email = ?", email).Count(&existing)
+	if existing > 0 {
+		renderError("An account with this email already exists")
+		return
+	}
+
+	now := time.Now()
+	limit := app.limiter.allow("email-change:"+strconv.Itoa(int(user.ID)), emailChangesPerHour, time.Hour, now)
+	if !limit.Allowed {
+		setRateLimitHeaders(w, limit, now)
+		w.WriteHeader(http.StatusTooManyRequests)
+		renderError("You've asked for several changes already. Please try again later.")
+		return
+	}
+
+	if err := app.db.Model(&user).Update("pending_email", email).Error; err != nil {
+		log.Println("Error saving pending email:", err)
+		writeServerError(w)
+		return
+	}
+	expires := now.Add(app.config.EmailVerificationTTL).Unix()
+	token := fmt.Sprintf("%d.%d.%s", user.ID, expires, signEmailChange(app.config.SessionSecret, user.ID, email, expires))
+	link := app.config.BaseURL + "/account/email/confirm?token=" + url.QueryEscape(token)
+	go func() {
+		body := fmt.Sprintf("Someone asked to change the email address of the account %s to this address.\n\n"+
+			"Open this link within %s to confirm the change:\n%s\n\n"+
+			"If it wasn't you, you can ignore this email; nothing changes until the link is opened.\n",
+			user.Email, app.config.EmailVerificationTTL, link)
+		if err := app.mailer.Send(email, "Confirm your new email address", body); err != nil {
+			log.Println("Error sending email change confirmation:", err)
+		}
+	}()
+
+	user.PendingEmail = email
+	data["User"] = user
+	data["Email"] = ""
+	data["Success"] = "We've sent a confirmation link to " + email + ". Your email address changes once you open it."
+	app.tmpl.ExecuteTemplate(w, "account_email.templ", data)
+}
+
+// cancelEmailChangeHandler drops the user's pending email address, so the
+// link sent to it stops working.
+func (app *App) cancelEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if err := app.db.Model(&user).Update("pending_email", "").Error; err != nil {
+		log.Println("Error clearing pending email:", err)
+		writeServerError(w)
+		return
+	}
+	app.tmpl.ExecuteTemplate(w, "account_email.templ", map[string]interface{}{"User": user})
+}
+
+// confirmEmailChangeHandler switches the account behind a confirmation link
+// to its pending address, which counts as verified, and tells the old
+// address about the change.
+func (app *App) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.checkEmailChangeToken(r.URL.Query().Get("token"), time.Now())
+	if !ok {
+		app.writeErrorPage(w, r, http.StatusBadRequest, "This confirmation link is invalid or has expired. Sign in to request a new one.")
+		return
+	}
+
+	oldEmail, newEmail := user.Email, user.PendingEmail
+	// Someone may have signed up with the address since the link was sent
+	var existing int64
+	app.db.Model(&User{}).Where("email = ?", newEmail).Count(&existing)
+	if existing > 0 {
+		app.writeErrorPage(w, r, http.StatusConflict, "An account with this email already exists.")
+		return
+	}
+	err := app.db.Model(&user).Updates(map[string]interface{}{
+		"email":         newEmail,
+		"pending_email": "",
+		"verified":      true,
+	}).Error
+	if err != nil {
+		log.Println("Error changing email:", err)
+		writeServerError(w)
+		return
+	}
+	user.Email = newEmail
+	app.authEvents.log(r, AuthEventEmailChange, AuthOutcomeSuccess, user, "")
+
+	go func() {
+		body := fmt.Sprintf("The email address of your account was changed from %s to %s.\n\n"+
+			"If you didn't make this change, please contact an administrator.\n",
+			oldEmail, newEmail)
+		if err := app.mailer.Send(oldEmail, "Your email address was changed", body); err != nil {
+			log.Println("Error sending email change notice:", err)
+		}
+	}()
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestItemFieldsEncryptedAtRest(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	handler, app := newTestApp(t, func(cfg *Config) {
+		cfg.FieldEncryptionKey = base64.StdEncoding.EncodeToString(key)
+	})
+	createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	c.post("/items", url.Values{"name": {"Safe"}, "description": {"combination 12-34-56"}, "notes": {"behind the painting"}})
+
+	var raw struct {
+		ID          uint
+		Description string
+		Notes       string
+	}
+	if err := app.db.Raw("SELECT id, description, notes FROM items WHERE name = ?", "Safe").Scan(&raw).Error; err != nil || raw.ID == 0 {
+		t.Fatalf("item not stored: %v", err)
+	}
+	for column, value := range map[string]string{"description": raw.Description, "notes": raw.Notes} {
+		if !strings.HasPrefix(value, encryptedPrefix) || strings.Contains(value, "combination") || strings.Contains(value, "painting") {
+			t.Errorf("stored %s = %q, want ciphertext", column, value)
+		}
+	}
+
+	var item Item
+	app.db.First(&item, raw.ID)
+	if item.Description != "combination 12-34-56" || item.Notes != "behind the painting" {
+		t.Errorf("loaded description, notes = %q, %q; want the plaintext", item.Description, item.Notes)
+	}
+	if _, body := c.get(fmt.Sprintf("/items/%d", raw.ID)); !strings.Contains(body, "combination 12-34-56") {
+		t.Errorf("item detail doesn't show the decrypted description:\n%s", body)
+	}
+}
+
+func TestPlaintextFieldsEncryptedOnNextWrite(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	_, app := newTestApp(t, func(cfg *Config) {
+		cfg.FieldEncryptionKey = base64.StdEncoding.EncodeToString(key)
+	})
+	user := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+
+	// A row written before encryption was turned on
+	app.db.Exec("INSERT INTO items (user_id, name, description, status, quantity, created_at, updated_at) VALUES (?, 'Old', 'plain text', 'active', 1, datetime('now'), datetime('now'))", user.ID)
+	var item Item
+	if err := app.db.Where("name = ?", "Old").First(&item).Error; err != nil {
+		t.Fatal(err)
+	}
+	if item.Description != "plain text" {
+		t.Fatalf("description = %q, want the stored plaintext", item.Description)
+	}
+
+	item.Name = "Renamed"
+	app.db.Save(&item)
+	var stored string
+	app.db.Raw("SELECT description FROM items WHERE id = ?", item.ID).Scan(&stored)
+	if !strings.HasPrefix(stored, encryptedPrefix) {
+		t.Errorf("description after a save = %q, want ciphertext", stored)
+	}
+	var reloaded Item
+	app.db.First(&reloaded, item.ID)
+	if reloaded.Description != "plain text" {
+		t.Errorf("reloaded description = %q, want %q", reloaded.Description, "plain text")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// wantsJSON reports whether an error response for r should be JSON rather
+// than HTML: API routes and clients that ask for JSON get JSON.
+func wantsJSON(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/") ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// writeErrorPage writes an error response in the format the client expects:
+// JSON, an HTML fragment for HTMX requests, or a full HTML page. message is
+// only shown on the HTML variants; JSON gets the lowercase status text.
+func (app *App) writeErrorPage(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if wantsJSON(r) {
+		writeJSONError(w, status, strings.ToLower(http.StatusText(status)))
+		return
+	}
+
+	data := map[string]interface{}{
+		"Status":     status,
+		"StatusText": http.StatusText(status),
+		"Message":    message,
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Header.Get("HX-Request") == "true" {
+		w.WriteHeader(status)
+		app.tmpl.ExecuteTemplate(w, "error.templ", data)
+		return
+	}
+	app.renderPage(w, r, status, "error", data)
+}
+
+// notFoundHandler replaces mux's plain-text 404.
+func (app *App) notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeErrorPage(w, r, http.StatusNotFound, "The page you're looking for doesn't exist.")
+}
+
+// methodNotAllowedHandler replaces mux's bare 405 and lists the methods the
+// path does support in the Allow header.
+func (app *App) methodNotAllowedHandler(router *mux.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"} {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			var match mux.RouteMatch
+			if router.Match(probe, &match) && match.MatchErr == nil {
+				allowed = append(allowed, method)
+			}
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		app.writeErrorPage(w, r, http.StatusMethodNotAllowed, "This method isn't supported here.")
+	}
+}
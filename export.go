@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats
+const (
+	ExportFormatCSV  = "csv"
+	ExportFormatJSON = "json"
+)
+
+// maxExportIDs caps how many IDs a selected export may name.
+const maxExportIDs = 1000
+
+var itemCSVHeader = []string{"id", "name", "description", "status", "quantity", "created_at"}
+
+// writeItemsCSV writes items as CSV with a header row. Cells that a
+// spreadsheet would treat as a formula are prefixed with a quote.
+func writeItemsCSV(w http.ResponseWriter, items []Item, filename string) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, filename))
+	return encodeItemsCSV(w, items)
+}
+
+// encodeItemsCSV writes the CSV body for writeItemsCSV.
+func encodeItemsCSV(w io.Writer, items []Item) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(itemCSVHeader); err != nil {
+		return err
+	}
+	for _, item := range items {
+		record := []string{
+			strconv.FormatUint(uint64(item.ID), 10),
+			csvSafe(item.Name),
+			csvSafe(item.Description),
+			item.Status,
+			strconv.Itoa(item.Quantity),
+			item.CreatedAt.UTC().Format(time.RFC3339),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeItemsJSON writes items as a JSON array of itemResponse.
+func writeItemsJSON(w http.ResponseWriter, items []Item, filename string) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+	return encodeItemsJSON(w, items)
+}
+
+// encodeItemsJSON writes the JSON body for writeItemsJSON.
+func encodeItemsJSON(w io.Writer, items []Item) error {
+	out := make([]itemResponse, 0, len(items))
+	for _, item := range items {
+		out = append(out, newItemResponse(item))
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// exportRequest is the body of POST /items/export. Form posts send the same
+// fields as repeated "ids" values (or one comma-separated value) and
+// "format".
+type exportRequest struct {
+	IDs    []uint `json:"ids"`
+	Format string `json:"format"`
+}
+
+func parseExportRequest(r *http.Request) (exportRequest, error) {
+	var req exportRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, fmt.Errorf("invalid JSON body")
+		}
+		return req, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return req, fmt.Errorf("invalid form body")
+	}
+	req.Format = r.PostForm.Get("format")
+	for _, value := range r.PostForm["ids"] {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			id, err := strconv.ParseUint(field, 10, 0)
+			if err != nil {
+				return req, fmt.Errorf("invalid item id %q", field)
+			}
+			req.IDs = append(req.IDs, uint(id))
+		}
+	}
+	return req, nil
+}
+
+// exportSelectedHandler downloads the chosen items as CSV or JSON. IDs of
+// items the user can't see are ignored.
+func (app *App) exportSelectedHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.apiUserID(w, r)
+	if !ok {
+		return
+	}
+
+	req, err := parseExportRequest(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Format == "" {
+		req.Format = ExportFormatCSV
+	}
+	if req.Format != ExportFormatCSV && req.Format != ExportFormatJSON {
+		writeJSONError(w, http.StatusBadRequest, "format must be csv or json")
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "select at least one item")
+		return
+	}
+	if len(req.IDs) > maxExportIDs {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("at most %d items can be exported at once", maxExportIDs))
+		return
+	}
+
+	var items []Item
+	if err := app.db.Scopes(app.visibleItems(userID)).Where("id IN ?", req.IDs).Order("created_at desc, id desc").Find(&items).Error; err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	app.loadTags(items)
+
+	filename := "items-" + time.Now().In(app.config.Location).Format("20060102")
+	if req.Format == ExportFormatJSON {
+		writeItemsJSON(w, items, filename)
+		return
+	}
+	writeItemsCSV(w, items, filename)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestExportSelectedItems(t *testing.T) {
+	handler, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	bob := createTestUser(t, app, "bob@example.com", "Correct-Horse-1", RoleUser)
+	milk := createTestItem(t, app, alice, "Milk")
+	createTestItem(t, app, alice, "Bread")
+	eggs := createTestItem(t, app, alice, "Eggs")
+	bobs := createTestItem(t, app, bob, "Bob's tea")
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	want := []string{fmt.Sprint(milk.ID), fmt.Sprint(eggs.ID)}
+	sort.Strings(want)
+	forms := map[string]url.Values{
+		// The list's checkboxes, one "ids" value each
+		"checkboxes":      {"ids": want},
+		"comma-separated": {"ids": {strings.Join(want, ",")}},
+		// IDs of other people's items are ignored
+		"with another user's item": {"ids": append(want, fmt.Sprint(bobs.ID))},
+	}
+	for name, form := range forms {
+		resp, body := c.post("/items/export", form)
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/csv") {
+			t.Fatalf("%s: status %d, Content-Type %q\n%s", name, resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		}
+		records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(records) != 3 {
+			t.Fatalf("%s: %d rows after the header, want 2:\n%s", name, len(records)-1, body)
+		}
+		got := []string{records[1][0], records[2][0]}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: exported IDs %v, want %v", name, got, want)
+		}
+	}
+
+	var items []itemResponse
+	resp := c.api("POST", "/items/export", "", map[string]interface{}{"ids": []uint{milk.ID, eggs.ID}, "format": ExportFormatJSON}, &items)
+	if resp.StatusCode != http.StatusOK || len(items) != 2 {
+		t.Errorf("JSON: status %d, %d items, want 2", resp.StatusCode, len(items))
+	}
+
+	if resp, body := c.post("/items/export", url.Values{}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("nothing selected: status %d, want %d\n%s", resp.StatusCode, http.StatusBadRequest, body)
+	}
+}
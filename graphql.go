@@ -0,0 +1,918 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file holds a small GraphQL implementation: a parser for query
+// documents and an executor over the fixed schema in graphqlschema.go.
+// It supports queries with variables, aliases, fragments and __typename;
+// mutations, subscriptions, directives and introspection are rejected.
+
+// maxGraphQLFields bounds the number of fields a document may select, after
+// expanding fragments, so one request can't fan out into many queries.
+const maxGraphQLFields = 200
+
+// gqlError is one entry of the "errors" list of a GraphQL response.
+type gqlError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// gqlResponse is the body of every /graphql response.
+type gqlResponse struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []gqlError  `json:"errors,omitempty"`
+}
+
+// ---- Document ----
+
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	name       string
+	variables  []gqlVariableDef
+	selections []*gqlSelection
+}
+
+type gqlVariableDef struct {
+	name       string
+	typ        string
+	defaultVal interface{}
+	hasDefault bool
+}
+
+type gqlFragment struct {
+	name       string
+	selections []*gqlSelection
+}
+
+// gqlSelection is a field, a fragment spread (spread set) or an inline
+// fragment (inline set).
+type gqlSelection struct {
+	alias      string
+	name       string
+	args       map[string]interface{}
+	selections []*gqlSelection
+	spread     string
+	inline     bool
+}
+
+// responseKey is the key the field's value is returned under.
+func (s *gqlSelection) responseKey() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+// gqlVariable and gqlEnum mark variable references and enum values in
+// parsed argument values; other values are plain Go values.
+type (
+	gqlVariable string
+	gqlEnum     string
+)
+
+// ---- Lexer ----
+
+type gqlToken struct {
+	kind  byte // 'p' punctuator, 'n' name, 'i' int, 'f' float, 's' string, 0 EOF
+	value string
+	pos   int
+}
+
+func gqlLex(src string) ([]gqlToken, error) {
+	var tokens []gqlToken
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' && src[i] != '\r' {
+				i++
+			}
+		case strings.IndexByte("!$():=@[]{}|&", c) >= 0:
+			tokens = append(tokens, gqlToken{kind: 'p', value: string(c), pos: i})
+			i++
+		case c == '.':
+			if !strings.HasPrefix(src[i:], "...") {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+			tokens = append(tokens, gqlToken{kind: 'p', value: "...", pos: i})
+			i += 3
+		case c == '_' || isGQLLetter(c):
+			start := i
+			for i < len(src) && (src[i] == '_' || isGQLLetter(src[i]) || isGQLDigit(src[i])) {
+				i++
+			}
+			tokens = append(tokens, gqlToken{kind: 'n', value: src[start:i], pos: start})
+		case c == '-' || isGQLDigit(c):
+			start := i
+			kind := byte('i')
+			if c == '-' {
+				i++
+			}
+			for i < len(src) && isGQLDigit(src[i]) {
+				i++
+			}
+			if i < len(src) && src[i] == '.' {
+				kind = 'f'
+				i++
+				for i < len(src) && isGQLDigit(src[i]) {
+					i++
+				}
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				kind = 'f'
+				i++
+				if i < len(src) && (src[i] == '+' || src[i] == '-') {
+					i++
+				}
+				for i < len(src) && isGQLDigit(src[i]) {
+					i++
+				}
+			}
+			tokens = append(tokens, gqlToken{kind: kind, value: src[start:i], pos: start})
+		case c == '"':
+			if strings.HasPrefix(src[i:], `"""`) {
+				end := strings.Index(src[i+3:], `"""`)
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated string at %d", i)
+				}
+				tokens = append(tokens, gqlToken{kind: 's', value: src[i+3 : i+3+end], pos: i})
+				i += end + 6
+				continue
+			}
+			start := i
+			i++
+			for i < len(src) && src[i] != '"' && src[i] != '\n' {
+				if src[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(src) || src[i] != '"' {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			var value string
+			if err := json.Unmarshal([]byte(src[start:i]), &value); err != nil {
+				return nil, fmt.Errorf("invalid string at %d", start)
+			}
+			tokens = append(tokens, gqlToken{kind: 's', value: value, pos: start})
+		default:
+			r, _ := utf8.DecodeRuneInString(src[i:])
+			return nil, fmt.Errorf("unexpected character %q at %d", r, i)
+		}
+	}
+	return append(tokens, gqlToken{pos: len(src)}), nil
+}
+
+func isGQLLetter(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+func isGQLDigit(c byte) bool  { return c >= '0' && c <= '9' }
+
+// ---- Parser ----
+
+type gqlParser struct {
+	tokens []gqlToken
+	pos    int
+}
+
+// parseGraphQL parses a query document.
+func parseGraphQL(src string) (*gqlDocument, error) {
+	tokens, err := gqlLex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &gqlParser{tokens: tokens}
+	doc := &gqlDocument{fragments: map[string]*gqlFragment{}}
+	for p.peek().kind != 0 {
+		tok := p.peek()
+		switch {
+		case tok.kind == 'p' && tok.value == "{":
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &gqlOperation{selections: selections})
+		case tok.kind == 'n' && tok.value == "query":
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case tok.kind == 'n' && (tok.value == "mutation" || tok.value == "subscription"):
+			return nil, fmt.Errorf("%ss are not supported", tok.value)
+		case tok.kind == 'n' && tok.value == "fragment":
+			fragment, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[fragment.name]; ok {
+				return nil, fmt.Errorf("fragment %q is defined more than once", fragment.name)
+			}
+			doc.fragments[fragment.name] = fragment
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("document has no operations")
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) peek() gqlToken { return p.tokens[p.pos] }
+
+func (p *gqlParser) next() gqlToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != 0 {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *gqlParser) unexpected() error {
+	tok := p.peek()
+	if tok.kind == 0 {
+		return fmt.Errorf("unexpected end of document")
+	}
+	return fmt.Errorf("unexpected %q at %d", tok.value, tok.pos)
+}
+
+// skip consumes the punctuator punct if it is next.
+func (p *gqlParser) skip(punct string) bool {
+	if tok := p.peek(); tok.kind == 'p' && tok.value == punct {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expect(punct string) error {
+	if !p.skip(punct) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *gqlParser) name() (string, error) {
+	if p.peek().kind != 'n' {
+		return "", p.unexpected()
+	}
+	return p.next().value, nil
+}
+
+func (p *gqlParser) operation() (*gqlOperation, error) {
+	p.next() // "query"
+	op := &gqlOperation{}
+	if p.peek().kind == 'n' {
+		op.name = p.next().value
+	}
+	if p.skip("(") {
+		for !p.skip(")") {
+			if err := p.expect("$"); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			typ, err := p.typeRef()
+			if err != nil {
+				return nil, err
+			}
+			def := gqlVariableDef{name: name, typ: typ}
+			if p.skip("=") {
+				if def.defaultVal, err = p.value(true); err != nil {
+					return nil, err
+				}
+				def.hasDefault = true
+			}
+			op.variables = append(op.variables, def)
+		}
+	}
+	if err := p.noDirectives(); err != nil {
+		return nil, err
+	}
+	var err error
+	op.selections, err = p.selectionSet()
+	return op, err
+}
+
+func (p *gqlParser) fragment() (*gqlFragment, error) {
+	p.next() // "fragment"
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.next(); tok.kind != 'n' || tok.value != "on" {
+		return nil, fmt.Errorf("expected \"on\" at %d", tok.pos)
+	}
+	if _, err := p.name(); err != nil {
+		return nil, err
+	}
+	if err := p.noDirectives(); err != nil {
+		return nil, err
+	}
+	selections, err := p.selectionSet()
+	return &gqlFragment{name: name, selections: selections}, err
+}
+
+// typeRef parses a type reference such as String, Int! or [ID!] and returns
+// it as written.
+func (p *gqlParser) typeRef() (string, error) {
+	var typ string
+	if p.skip("[") {
+		inner, err := p.typeRef()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+	if p.skip("!") {
+		typ += "!"
+	}
+	return typ, nil
+}
+
+func (p *gqlParser) noDirectives() error {
+	if tok := p.peek(); tok.kind == 'p' && tok.value == "@" {
+		return fmt.Errorf("directives are not supported")
+	}
+	return nil
+}
+
+func (p *gqlParser) selectionSet() ([]*gqlSelection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []*gqlSelection
+	for !p.skip("}") {
+		selection, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, selection)
+	}
+	if len(selections) == 0 {
+		return nil, fmt.Errorf("empty selection set")
+	}
+	return selections, nil
+}
+
+func (p *gqlParser) selection() (*gqlSelection, error) {
+	if p.skip("...") {
+		if tok := p.peek(); tok.kind == 'n' && tok.value != "on" {
+			p.next()
+			return &gqlSelection{spread: tok.value}, p.noDirectives()
+		}
+		if tok := p.peek(); tok.kind == 'n' && tok.value == "on" {
+			p.next()
+			if _, err := p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.noDirectives(); err != nil {
+			return nil, err
+		}
+		selections, err := p.selectionSet()
+		return &gqlSelection{inline: true, selections: selections}, err
+	}
+
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	field := &gqlSelection{name: name}
+	if p.skip(":") {
+		field.alias = name
+		if field.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.skip("(") {
+		field.args = map[string]interface{}{}
+		for !p.skip(")") {
+			argName, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if field.args[argName], err = p.value(false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := p.noDirectives(); err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind == 'p' && tok.value == "{" {
+		if field.selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return field, nil
+}
+
+// value parses an argument value. Variables aren't allowed in constant
+// values such as variable defaults.
+func (p *gqlParser) value(constant bool) (interface{}, error) {
+	tok := p.next()
+	switch tok.kind {
+	case 'i':
+		n, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %s", tok.value)
+		}
+		return n, nil
+	case 'f':
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", tok.value)
+		}
+		return f, nil
+	case 's':
+		return tok.value, nil
+	case 'n':
+		switch tok.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return gqlEnum(tok.value), nil
+	case 'p':
+		switch tok.value {
+		case "$":
+			if constant {
+				return nil, fmt.Errorf("variables are not allowed here")
+			}
+			name, err := p.name()
+			return gqlVariable(name), err
+		case "[":
+			list := []interface{}{}
+			for !p.skip("]") {
+				v, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			return list, nil
+		case "{":
+			object := map[string]interface{}{}
+			for !p.skip("}") {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				if object[name], err = p.value(constant); err != nil {
+					return nil, err
+				}
+			}
+			return object, nil
+		}
+	}
+	if tok.kind != 0 {
+		p.pos--
+	}
+	return nil, p.unexpected()
+}
+
+// ---- Schema ----
+
+// gqlType is an object type of the schema.
+type gqlType struct {
+	name   string
+	fields []*gqlField
+}
+
+func (t *gqlType) field(name string) *gqlField {
+	for _, f := range t.fields {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// gqlField is a field of an object type. typ is a type reference like
+// "[Item!]!"; args maps argument names to their (scalar) types.
+type gqlField struct {
+	name    string
+	typ     string
+	args    []gqlArg
+	resolve func(ctx *graphQLContext, source interface{}, args map[string]interface{}) (interface{}, error)
+}
+
+type gqlArg struct {
+	name string
+	typ  string
+}
+
+// gqlSchema maps type names to object types. Query is the root type.
+type gqlSchema map[string]*gqlType
+
+// gqlNamedType strips list brackets and non-null markers from a type
+// reference.
+func gqlNamedType(typ string) string {
+	return strings.Trim(typ, "[]!")
+}
+
+// isGQLScalar reports whether name is a built-in scalar type.
+func isGQLScalar(name string) bool {
+	switch name {
+	case "String", "Int", "Float", "Boolean", "ID":
+		return true
+	}
+	return false
+}
+
+// sdl prints the schema in the GraphQL schema definition language, Query
+// first and then the other types in order.
+func (s gqlSchema) sdl(order []string) string {
+	var b bytes.Buffer
+	for i, name := range order {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "type %s {\n", name)
+		for _, f := range s[name].fields {
+			b.WriteString("  " + f.name)
+			if len(f.args) > 0 {
+				args := make([]string, len(f.args))
+				for i, a := range f.args {
+					args[i] = a.name + ": " + a.typ
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + f.typ + "\n")
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+// ---- Validation and execution ----
+
+// gqlRequest executes one operation of a parsed document.
+type gqlRequest struct {
+	schema gqlSchema
+	doc    *gqlDocument
+	vars   map[string]interface{}
+	ctx    *graphQLContext
+	errors []gqlError
+}
+
+// executeGraphQL validates and runs the operation named operationName (or
+// the only operation) of src. Request errors, such as a query for an
+// unknown field, are returned as err and nothing is executed; field errors
+// are reported in the response next to the partial data.
+func executeGraphQL(schema gqlSchema, ctx *graphQLContext, src, operationName string, variables map[string]interface{}) (gqlResponse, error) {
+	doc, err := parseGraphQL(src)
+	if err != nil {
+		return gqlResponse{}, fmt.Errorf("syntax error: %v", err)
+	}
+	var op *gqlOperation
+	switch {
+	case operationName != "":
+		for _, candidate := range doc.operations {
+			if candidate.name == operationName {
+				op = candidate
+			}
+		}
+		if op == nil {
+			return gqlResponse{}, fmt.Errorf("unknown operation %q", operationName)
+		}
+	case len(doc.operations) == 1:
+		op = doc.operations[0]
+	default:
+		return gqlResponse{}, fmt.Errorf("operationName is required when the document has several operations")
+	}
+
+	req := &gqlRequest{schema: schema, doc: doc, ctx: ctx, vars: map[string]interface{}{}}
+	for _, def := range op.variables {
+		value, ok := variables[def.name]
+		if !ok && def.hasDefault {
+			value, ok = def.defaultVal, true
+		}
+		if (!ok || value == nil) && strings.HasSuffix(def.typ, "!") {
+			return gqlResponse{}, fmt.Errorf("variable $%s of type %s is required", def.name, def.typ)
+		}
+		if ok && value != nil {
+			coerced, err := coerceGQLInput(def.typ, value)
+			if err != nil {
+				return gqlResponse{}, fmt.Errorf("variable $%s: %v", def.name, err)
+			}
+			req.vars[def.name] = coerced
+		}
+	}
+
+	count := 0
+	if err := req.validate(schema["Query"], op.selections, map[string]bool{}, &count); err != nil {
+		return gqlResponse{}, err
+	}
+	data := req.executeSelections(schema["Query"], nil, op.selections, nil)
+	return gqlResponse{Data: data, Errors: req.errors}, nil
+}
+
+// validate checks selections against typ before anything runs.
+func (req *gqlRequest) validate(typ *gqlType, selections []*gqlSelection, fragments map[string]bool, count *int) error {
+	for _, s := range selections {
+		switch {
+		case s.spread != "":
+			fragment, ok := req.doc.fragments[s.spread]
+			if !ok {
+				return fmt.Errorf("unknown fragment %q", s.spread)
+			}
+			if fragments[s.spread] {
+				return fmt.Errorf("fragment %q spreads itself", s.spread)
+			}
+			fragments[s.spread] = true
+			if err := req.validate(typ, fragment.selections, fragments, count); err != nil {
+				return err
+			}
+			delete(fragments, s.spread)
+			continue
+		case s.inline:
+			if err := req.validate(typ, s.selections, fragments, count); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if *count++; *count > maxGraphQLFields {
+			return fmt.Errorf("query selects more than %d fields", maxGraphQLFields)
+		}
+		if s.name == "__typename" {
+			if s.args != nil || s.selections != nil {
+				return fmt.Errorf("__typename takes no arguments or selections")
+			}
+			continue
+		}
+		field := typ.field(s.name)
+		if field == nil {
+			return fmt.Errorf("cannot query field %q on type %s", s.name, typ.name)
+		}
+		if _, err := req.fieldArgs(field, s); err != nil {
+			return err
+		}
+		named := gqlNamedType(field.typ)
+		if isGQLScalar(named) {
+			if s.selections != nil {
+				return fmt.Errorf("field %q of type %s must not have a selection", s.name, field.typ)
+			}
+			continue
+		}
+		if s.selections == nil {
+			return fmt.Errorf("field %q of type %s must have a selection of subfields", s.name, field.typ)
+		}
+		if err := req.validate(req.schema[named], s.selections, fragments, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldArgs resolves variables in the arguments of selection s and coerces
+// them to field's argument types.
+func (req *gqlRequest) fieldArgs(field *gqlField, s *gqlSelection) (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	for name, value := range s.args {
+		var arg *gqlArg
+		for i := range field.args {
+			if field.args[i].name == name {
+				arg = &field.args[i]
+			}
+		}
+		if arg == nil {
+			return nil, fmt.Errorf("unknown argument %q on field %q", name, s.name)
+		}
+		value = req.resolveVariables(value)
+		if value == nil {
+			continue
+		}
+		coerced, err := coerceGQLInput(arg.typ, value)
+		if err != nil {
+			return nil, fmt.Errorf("argument %q on field %q: %v", name, s.name, err)
+		}
+		args[name] = coerced
+	}
+	return args, nil
+}
+
+func (req *gqlRequest) resolveVariables(value interface{}) interface{} {
+	switch v := value.(type) {
+	case gqlVariable:
+		return req.vars[string(v)]
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = req.resolveVariables(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := map[string]interface{}{}
+		for key, item := range v {
+			out[key] = req.resolveVariables(item)
+		}
+		return out
+	}
+	return value
+}
+
+// coerceGQLInput converts a literal or JSON variable value to the scalar
+// type typ: String and ID become string, Int int, Float float64 and
+// Boolean bool.
+func coerceGQLInput(typ string, value interface{}) (interface{}, error) {
+	named := gqlNamedType(typ)
+	if strings.HasPrefix(typ, "[") {
+		return nil, fmt.Errorf("list values are not supported")
+	}
+	switch named {
+	case "String":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case "ID":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		case float64:
+			if v == math.Trunc(v) {
+				return strconv.FormatFloat(v, 'f', 0, 64), nil
+			}
+		}
+	case "Int":
+		switch v := value.(type) {
+		case int: // already coerced variables
+			return v, nil
+		case int64:
+			if v >= math.MinInt32 && v <= math.MaxInt32 {
+				return int(v), nil
+			}
+		case float64: // JSON variables
+			if v == math.Trunc(v) && v >= math.MinInt32 && v <= math.MaxInt32 {
+				return int(v), nil
+			}
+		}
+	case "Float":
+		switch v := value.(type) {
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown input type %s", named)
+	}
+	return nil, fmt.Errorf("expected a value of type %s", named)
+}
+
+// executeSelections resolves selections on source, an object of typ, and
+// returns the fields in the order they were selected.
+func (req *gqlRequest) executeSelections(typ *gqlType, source interface{}, selections []*gqlSelection, path []interface{}) *gqlObject {
+	result := &gqlObject{values: map[string]interface{}{}}
+	keys, grouped := req.collectFields(selections, nil, map[string][]*gqlSelection{})
+	for _, key := range keys {
+		fields := grouped[key]
+		s := fields[0]
+		fieldPath := append(append([]interface{}{}, path...), key)
+		if s.name == "__typename" {
+			result.set(key, typ.name)
+			continue
+		}
+		field := typ.field(s.name)
+		args, _ := req.fieldArgs(field, s) // checked by validate
+		value, err := field.resolve(req.ctx, source, args)
+		if err != nil {
+			req.errors = append(req.errors, gqlError{Message: err.Error(), Path: fieldPath})
+			result.set(key, nil)
+			continue
+		}
+		var sub []*gqlSelection
+		for _, f := range fields {
+			sub = append(sub, f.selections...)
+		}
+		result.set(key, req.completeValue(field.typ, value, sub, fieldPath))
+	}
+	return result
+}
+
+// collectFields flattens fragments and groups fields by response key,
+// keeping the order in which keys first appear.
+func (req *gqlRequest) collectFields(selections []*gqlSelection, keys []string, grouped map[string][]*gqlSelection) ([]string, map[string][]*gqlSelection) {
+	for _, s := range selections {
+		switch {
+		case s.spread != "":
+			keys, grouped = req.collectFields(req.doc.fragments[s.spread].selections, keys, grouped)
+		case s.inline:
+			keys, grouped = req.collectFields(s.selections, keys, grouped)
+		default:
+			key := s.responseKey()
+			if _, ok := grouped[key]; !ok {
+				keys = append(keys, key)
+			}
+			grouped[key] = append(grouped[key], s)
+		}
+	}
+	return keys, grouped
+}
+
+// completeValue shapes a resolved value according to the field type:
+// lists are completed item by item and objects by their sub-selections.
+func (req *gqlRequest) completeValue(typ string, value interface{}, selections []*gqlSelection, path []interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil
+	}
+	inner := strings.TrimSuffix(typ, "!")
+	if strings.HasPrefix(inner, "[") {
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			list[i] = req.completeValue(inner[1:len(inner)-1], rv.Index(i).Interface(), selections, append(append([]interface{}{}, path...), i))
+		}
+		return list
+	}
+	if named := gqlNamedType(typ); !isGQLScalar(named) {
+		return req.executeSelections(req.schema[named], value, selections, path)
+	}
+	return value
+}
+
+// gqlObject is a JSON object that keeps its keys in insertion order, as
+// GraphQL responses follow the order of the query.
+type gqlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *gqlObject) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		b.Write(k)
+		b.WriteByte(':')
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
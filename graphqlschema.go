@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// graphQLContext is what the resolvers of one /graphql request share.
+type graphQLContext struct {
+	app    *App
+	userID uint
+	now    time.Time
+}
+
+// itemConnection is the source of an ItemConnection: one page of items and
+// the filters that produced it, so totalCount is only counted when asked
+// for.
+type itemConnection struct {
+	items  []Item
+	next   string
+	search string
+	status string
+}
+
+// graphQLTypeOrder lists the schema's types in the order the SDL prints
+// them.
+var graphQLTypeOrder = []string{"Query", "User", "Item", "ItemConnection", "PageInfo", "Stats", "ItemLimit"}
+
+// graphQLSchema describes the /graphql API. Times are RFC 3339 strings.
+var graphQLSchema = gqlSchema{
+	"Query": {name: "Query", fields: []*gqlField{
+		{name: "viewer", typ: "User!", resolve: func(ctx *graphQLContext, _ interface{}, _ map[string]interface{}) (interface{}, error) {
+			var user User
+			if err := ctx.app.db.First(&user, ctx.userID).Error; err != nil {
+				return nil, errors.New("user not found")
+			}
+			return user, nil
+		}},
+		{name: "item", typ: "Item", args: []gqlArg{{"id", "ID!"}}, resolve: func(ctx *graphQLContext, _ interface{}, args map[string]interface{}) (interface{}, error) {
+			id, ok := args["id"].(string)
+			if !ok {
+				return nil, errors.New("id is required")
+			}
+			var item Item
+			if err := ctx.app.db.Scopes(ctx.app.visibleItems(ctx.userID)).Where("id = ?", id).First(&item).Error; err != nil {
+				return nil, nil
+			}
+			ctx.app.loadItemTags(&item)
+			return item, nil
+		}},
+		{
+			name: "items",
+			typ:  "ItemConnection!",
+			args: []gqlArg{{"search", "String"}, {"status", "String"}, {"first", "Int"}, {"after", "String"}},
+			resolve: func(ctx *graphQLContext, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				search, _ := args["search"].(string)
+				status, _ := args["status"].(string)
+				after, _ := args["after"].(string)
+				first := defaultAPIPageSize
+				if n, ok := args["first"].(int); ok {
+					if n < 1 || n > maxAPIPageSize {
+						return nil, fmt.Errorf("first must be between 1 and %d", maxAPIPageSize)
+					}
+					first = n
+				}
+				items, next, _, err := ctx.app.findItemsPage(ctx.userID, search, status, first, after)
+				if err != nil {
+					return nil, errors.New("invalid cursor")
+				}
+				return itemConnection{items: items, next: next, search: search, status: status}, nil
+			},
+		},
+		{name: "stats", typ: "Stats!", resolve: func(ctx *graphQLContext, _ interface{}, _ map[string]interface{}) (interface{}, error) {
+			return statsResponse{
+				itemStats: ctx.app.getItemStats(ctx.userID, ctx.now),
+				Limit:     ctx.app.getItemLimit(ctx.userID),
+			}, nil
+		}},
+	}},
+	"User": {name: "User", fields: []*gqlField{
+		gqlLeaf("id", "ID!", func(v interface{}) interface{} { return strconv.Itoa(int(v.(User).ID)) }),
+		gqlLeaf("email", "String!", func(v interface{}) interface{} { return v.(User).Email }),
+		gqlLeaf("role", "String!", func(v interface{}) interface{} { return v.(User).Role }),
+		gqlLeaf("createdAt", "String!", func(v interface{}) interface{} { return v.(User).CreatedAt.Format(time.RFC3339) }),
+	}},
+	"Item": {name: "Item", fields: []*gqlField{
+		gqlLeaf("id", "ID!", func(v interface{}) interface{} { return strconv.Itoa(int(v.(Item).ID)) }),
+		gqlLeaf("name", "String!", func(v interface{}) interface{} { return v.(Item).Name }),
+		gqlLeaf("description", "String!", func(v interface{}) interface{} { return v.(Item).Description }),
+		gqlLeaf("notes", "String!", func(v interface{}) interface{} { return v.(Item).Notes }),
+		gqlLeaf("status", "String!", func(v interface{}) interface{} { return v.(Item).Status }),
+		gqlLeaf("quantity", "Int!", func(v interface{}) interface{} { return v.(Item).Quantity }),
+		gqlLeaf("value", "String!", func(v interface{}) interface{} { return formatValue(v.(Item).ValueCents) }),
+		gqlLeaf("priority", "String!", func(v interface{}) interface{} { return v.(Item).Priority }),
+		gqlLeaf("tags", "[String!]!", func(v interface{}) interface{} { return v.(Item).Tags }),
+		gqlLeaf("listId", "ID!", func(v interface{}) interface{} { return strconv.Itoa(int(v.(Item).ListID)) }),
+		gqlLeaf("categoryId", "ID", func(v interface{}) interface{} {
+			if id := v.(Item).CategoryID; id != nil {
+				return strconv.Itoa(int(*id))
+			}
+			return nil
+		}),
+		gqlLeaf("dueAt", "String", func(v interface{}) interface{} {
+			if due := v.(Item).DueAt; due != nil {
+				return due.Format(time.RFC3339)
+			}
+			return nil
+		}),
+		gqlLeaf("color", "String", func(v interface{}) interface{} {
+			if color := v.(Item).Color; color != "" {
+				return color
+			}
+			return nil
+		}),
+		gqlLeaf("pinned", "Boolean!", func(v interface{}) interface{} { return v.(Item).Pinned }),
+		gqlLeaf("completed", "Boolean!", func(v interface{}) interface{} { return v.(Item).Completed }),
+		gqlLeaf("completedAt", "String", func(v interface{}) interface{} {
+			if completed := v.(Item).CompletedAt; completed != nil {
+				return completed.Format(time.RFC3339)
+			}
+			return nil
+		}),
+		gqlLeaf("createdAt", "String!", func(v interface{}) interface{} { return v.(Item).CreatedAt.Format(time.RFC3339) }),
+	}},
+	"ItemConnection": {name: "ItemConnection", fields: []*gqlField{
+		gqlLeaf("nodes", "[Item!]!", func(v interface{}) interface{} { return v.(itemConnection).items }),
+		gqlLeaf("pageInfo", "PageInfo!", func(v interface{}) interface{} { return v }),
+		{name: "totalCount", typ: "Int!", resolve: func(ctx *graphQLContext, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			conn := source.(itemConnection)
+			var count int64
+			err := ctx.app.itemsQuery(ctx.userID, conn.search, conn.status).Model(&Item{}).Count(&count).Error
+			return count, err
+		}},
+	}},
+	"PageInfo": {name: "PageInfo", fields: []*gqlField{
+		gqlLeaf("hasNextPage", "Boolean!", func(v interface{}) interface{} { return v.(itemConnection).next != "" }),
+		gqlLeaf("endCursor", "String", func(v interface{}) interface{} {
+			if next := v.(itemConnection).next; next != "" {
+				return next
+			}
+			return nil
+		}),
+	}},
+	"Stats": {name: "Stats", fields: []*gqlField{
+		gqlLeaf("totalItems", "Int!", func(v interface{}) interface{} { return v.(statsResponse).TotalItems }),
+		gqlLeaf("activeItems", "Int!", func(v interface{}) interface{} { return v.(statsResponse).ActiveItems }),
+		gqlLeaf("archivedItems", "Int!", func(v interface{}) interface{} { return v.(statsResponse).ArchivedItems }),
+		gqlLeaf("addedToday", "Int!", func(v interface{}) interface{} { return v.(statsResponse).AddedToday }),
+		gqlLeaf("thisWeek", "Int!", func(v interface{}) interface{} { return v.(statsResponse).ThisWeek }),
+		gqlLeaf("thisMonth", "Int!", func(v interface{}) interface{} { return v.(statsResponse).ThisMonth }),
+		gqlLeaf("totalQuantity", "Int!", func(v interface{}) interface{} { return v.(statsResponse).TotalQuantity }),
+		gqlLeaf("activeQuantity", "Int!", func(v interface{}) interface{} { return v.(statsResponse).ActiveQuantity }),
+		gqlLeaf("totalValue", "String!", func(v interface{}) interface{} { return v.(statsResponse).TotalValue }),
+		gqlLeaf("valueAddedToday", "String!", func(v interface{}) interface{} { return v.(statsResponse).ValueAddedToday }),
+		gqlLeaf("currency", "String!", func(v interface{}) interface{} { return v.(statsResponse).Currency }),
+		gqlLeaf("limit", "ItemLimit!", func(v interface{}) interface{} { return v.(statsResponse).Limit }),
+	}},
+	"ItemLimit": {name: "ItemLimit", fields: []*gqlField{
+		gqlLeaf("max", "Int!", func(v interface{}) interface{} { return v.(itemLimit).Max }),
+		gqlLeaf("count", "Int!", func(v interface{}) interface{} { return v.(itemLimit).Count }),
+		gqlLeaf("remaining", "Int!", func(v interface{}) interface{} { return v.(itemLimit).Remaining }),
+		gqlLeaf("warning", "Boolean!", func(v interface{}) interface{} { return v.(itemLimit).Warning }),
+		gqlLeaf("reached", "Boolean!", func(v interface{}) interface{} { return v.(itemLimit).Reached }),
+	}},
+}
+
+// gqlLeaf returns a field without arguments whose value is read from its
+// source object by get.
+func gqlLeaf(name, typ string, get func(source interface{}) interface{}) *gqlField {
+	return &gqlField{name: name, typ: typ, resolve: func(_ *graphQLContext, source interface{}, _ map[string]interface{}) (interface{}, error) {
+		return get(source), nil
+	}}
+}
+
+// graphQLRequest is the body of POST /graphql, as in the GraphQL over HTTP
+// convention.
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphQLHandler runs a GraphQL query, sent as a JSON body to POST or as
+// query, operationName and variables parameters to GET. Callers
+// authenticate like the REST API, with a bearer token or the session
+// cookie, and share its rate limit. Errors in the request itself answer
+// 400; errors while resolving a field are reported next to the data.
+func (app *App) graphQLHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.authenticateAPI(w, r)
+	if !ok {
+		return
+	}
+
+	var req graphQLRequest
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, gqlResponse{Errors: []gqlError{{Message: "invalid JSON body"}}})
+			return
+		}
+	} else {
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if vars := r.URL.Query().Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				writeJSON(w, http.StatusBadRequest, gqlResponse{Errors: []gqlError{{Message: "variables must be a JSON object"}}})
+				return
+			}
+		}
+	}
+	if req.Query == "" {
+		writeJSON(w, http.StatusBadRequest, gqlResponse{Errors: []gqlError{{Message: "query is required"}}})
+		return
+	}
+
+	ctx := &graphQLContext{app: app, userID: userID, now: time.Now()}
+	response, err := executeGraphQL(graphQLSchema, ctx, req.Query, req.OperationName, req.Variables)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, gqlResponse{Errors: []gqlError{{Message: err.Error()}}})
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// graphQLSchemaHandler serves the schema in SDL so clients can generate
+// types from it. It is public, like the OpenAPI document.
+func (app *App) graphQLSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(graphQLSchema.sdl(graphQLTypeOrder)))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"htmx-auth-app/itempb"
+)
+
+// The gRPC ItemService is defined in itempb/items.proto. After changing
+// the .proto, regenerate itempb with protoc-gen-go and protoc-gen-go-grpc:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//		--go-grpc_out=. --go-grpc_opt=paths=source_relative itempb/items.proto
+
+// grpcUserKey is the context key under which grpcAuth stores the user ID.
+type grpcUserKey struct{}
+
+// startGRPCServer serves the ItemService on GRPC_ADDR in the background,
+// if it is set.
+func (app *App) startGRPCServer() {
+	if app.config.GRPCAddr == "" {
+		return
+	}
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(app.grpcAuth)}
+	if app.config.TLSCertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(app.config.TLSCertFile, app.config.TLSKeyFile)
+		if err != nil {
+			log.Fatal("Error loading gRPC TLS certificate:", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	lis, err := net.Listen("tcp", app.config.GRPCAddr)
+	if err != nil {
+		log.Fatal("Error starting gRPC listener:", err)
+	}
+
+	srv := grpc.NewServer(opts...)
+	itempb.RegisterItemServiceServer(srv, &itemService{app: app})
+	log.Printf("gRPC listening on %s", app.config.GRPCAddr)
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			log.Println("gRPC server stopped:", err)
+		}
+	}()
+}
+
+// grpcAuth authenticates every call with the API token in the
+// "authorization: Bearer <token>" metadata and charges it to the token's
+// rate limit, like the REST API. Session cookies aren't accepted.
+func (app *App) grpcAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	auth := md.Get("authorization")
+	if len(auth) == 0 || !strings.HasPrefix(auth[0], "Bearer ") {
+		return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	token, err := app.lookupToken(strings.TrimPrefix(auth[0], "Bearer "))
+	switch {
+	case errors.Is(err, errAccountSuspended):
+		return nil, status.Error(codes.PermissionDenied, "account suspended")
+	case errors.Is(err, errEmailUnverified):
+		// Every call works with items, which need a verified address
+		return nil, status.Error(codes.PermissionDenied, "email address not verified")
+	case err != nil:
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	now := time.Now()
+	limit := app.limiter.allow(tokenRateLimitKey(token), app.tokenRateLimit(token), time.Minute, now)
+	grpc.SetHeader(ctx, metadata.Pairs(
+		"x-ratelimit-limit", strconv.Itoa(limit.Limit),
+		"x-ratelimit-remaining", strconv.Itoa(limit.Remaining),
+		"x-ratelimit-reset", strconv.FormatInt(now.Add(limit.ResetAfter).Unix(), 10),
+	))
+	if !limit.Allowed {
+		return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	app.recordTokenUse(token, now)
+	return handler(context.WithValue(ctx, grpcUserKey{}, token.UserID), req)
+}
+
+// itemService implements itempb.ItemServiceServer on top of the same item
+// rules as the web and REST handlers.
+type itemService struct {
+	itempb.UnimplementedItemServiceServer
+	app *App
+}
+
+// newItemMessage converts an Item to its protobuf message.
+func newItemMessage(item Item) *itempb.Item {
+	return &itempb.Item{
+		Id:          uint64(item.ID),
+		Name:        item.Name,
+		Description: item.Description,
+		Status:      item.Status,
+		Quantity:    int32(item.Quantity),
+		CreatedAt:   timestamppb.New(item.CreatedAt),
+	}
+}
+
+func (s *itemService) CreateItem(ctx context.Context, req *itempb.CreateItemRequest) (*itempb.Item, error) {
+	app := s.app
+	userID := ctx.Value(grpcUserKey{}).(uint)
+
+	input := itemRequest{Name: &req.Name, Description: &req.Description}
+	if req.Quantity != nil {
+		quantity := int(req.GetQuantity())
+		input.Quantity = &quantity
+	}
+	item := Item{
+		UserID:    userID,
+		OrgID:     app.userOrgID(userID),
+		Status:    ItemStatusActive,
+		Quantity:  1,
+		CreatedAt: time.Now(),
+	}
+	if err := input.apply(&item, app.config.ItemNamePolicy); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if limit := app.getItemLimit(userID); limit.Reached {
+		return nil, status.Errorf(codes.FailedPrecondition, "You have reached the limit of %d items", limit.Max)
+	}
+	if err := app.createItem(&item); err != nil {
+		log.Println("Error creating item:", err)
+		return nil, status.Error(codes.Internal, "could not create item")
+	}
+	app.touchItems(userID)
+	app.notifyItem(WebhookItemCreated, item)
+	return newItemMessage(item), nil
+}
+
+func (s *itemService) ListItems(ctx context.Context, req *itempb.ListItemsRequest) (*itempb.ListItemsResponse, error) {
+	userID := ctx.Value(grpcUserKey{}).(uint)
+	pageSize := int(req.PageSize)
+	switch {
+	case pageSize == 0:
+		pageSize = defaultAPIPageSize
+	case pageSize < 0 || pageSize > maxAPIPageSize:
+		return nil, status.Errorf(codes.InvalidArgument, "page_size must be between 1 and %d", maxAPIPageSize)
+	}
+
+	items, next, _, err := s.app.findItemsPage(userID, req.Search, req.Status, pageSize, req.PageToken)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+	}
+	resp := &itempb.ListItemsResponse{NextPageToken: next}
+	for _, item := range items {
+		resp.Items = append(resp.Items, newItemMessage(item))
+	}
+	return resp, nil
+}
+
+func (s *itemService) DeleteItem(ctx context.Context, req *itempb.DeleteItemRequest) (*itempb.DeleteItemResponse, error) {
+	app := s.app
+	userID := ctx.Value(grpcUserKey{}).(uint)
+	var item Item
+	if err := app.db.Scopes(app.ownedItems(userID)).Where("id = ?", req.Id).First(&item).Error; err != nil {
+		return nil, status.Error(codes.NotFound, "item not found")
+	}
+	if err := app.deleteItem(&item); err != nil {
+		log.Println("Error deleting item:", err)
+		return nil, status.Error(codes.Internal, "could not delete item")
+	}
+	app.touchItems(userID)
+	app.notifyItem(WebhookItemDeleted, item)
+	return &itempb.DeleteItemResponse{}, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxIdempotencyKeyLength caps the length of Idempotency-Key values.
+const maxIdempotencyKeyLength = 255
+
+// idempotencyRecorder passes a response through while keeping a copy of
+// its status and body.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotencyUserID returns the user a request acts for, or 0 when it
+// isn't authenticated; the handler then rejects it as usual.
+func (app *App) idempotencyUserID(r *http.Request) uint {
+	if userID, ok := r.Context().Value(apiPrincipalKey{}).(uint); ok {
+		return userID
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token, err := app.lookupToken(strings.TrimPrefix(auth, "Bearer "))
+		if err != nil {
+			return 0
+		}
+		return token.UserID
+	}
+	session, _ := app.store.Get(r, "session")
+	if userID, ok := session.Values["user_id"]; ok && userID != nil {
+		return toUint(userID)
+	}
+	return 0
+}
+
+// writeIdempotencyError rejects a request because of its Idempotency-Key,
+// as JSON or as an HTML fragment.
+func writeIdempotencyError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if wantsJSON(r) && r.Header.Get("HX-Request") != "true" {
+		writeJSONError(w, status, message)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(`<div class="error">` + message + `</div>`))
+}
+
+// idempotent makes a POST handler safe to retry. A request carrying an
+// Idempotency-Key header, or an idempotency_key form field, runs once per
+// user and key; repeating it within IDEMPOTENCY_KEY_TTL returns the stored
+// response with an Idempotent-Replayed header instead of running the
+// handler again. Reusing a key for a different request answers 422, and
+// a retry that arrives while the first request is still running 409.
+// Responses that are worth retrying (401, 429 and server errors) aren't
+// kept, so the key can be used again.
+func (app *App) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			writeIdempotencyError(w, r, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" && strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			if form, err := url.ParseQuery(string(body)); err == nil {
+				key = form.Get("idempotency_key")
+			}
+		}
+		userID := app.idempotencyUserID(r)
+		if key == "" || userID == 0 {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeIdempotencyError(w, r, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+		now := time.Now()
+		record := IdempotencyKey{
+			UserID:      userID,
+			Key:         key,
+			RequestHash: hex.EncodeToString(sum[:]),
+			ExpiresAt:   now.Add(app.config.IdempotencyKeyTTL),
+		}
+		// The unique index on user and key lets only the first request in
+		// and tells later ones to look at its record
+		app.db.Where("user_id = ? AND key = ? AND expires_at < ?", userID, key, now).Delete(&IdempotencyKey{})
+		if err := app.db.Create(&record).Error; err != nil {
+			var existing IdempotencyKey
+			if err := app.db.Where("user_id = ? AND key = ?", userID, key).First(&existing).Error; err != nil {
+				log.Println("Error reading idempotency key:", err)
+				writeIdempotencyError(w, r, http.StatusInternalServerError, "Something went wrong. Please try again.")
+				return
+			}
+			switch {
+			case existing.RequestHash != record.RequestHash:
+				writeIdempotencyError(w, r, http.StatusUnprocessableEntity, "This Idempotency-Key was already used for a different request")
+			case existing.Status == 0:
+				writeIdempotencyError(w, r, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+			default:
+				h := w.Header()
+				if existing.ContentType != "" {
+					h.Set("Content-Type", existing.ContentType)
+				}
+				if existing.Location != "" {
+					h.Set("Location", existing.Location)
+				}
+				h.Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.Status)
+				w.Write(existing.Body)
+			}
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		if rec.status == http.StatusUnauthorized || rec.status == http.StatusTooManyRequests || rec.status >= 500 {
+			app.db.Delete(&record)
+			return
+		}
+		err = app.db.Model(&record).Updates(map[string]interface{}{
+			"status":       rec.status,
+			"content_type": w.Header().Get("Content-Type"),
+			"location":     w.Header().Get("Location"),
+			"body":         rec.body.Bytes(),
+		}).Error
+		if err != nil {
+			log.Println("Error saving idempotent response:", err)
+		}
+	}
+}
+
+// startIdempotencyKeyCleanup deletes expired idempotency keys every hour.
+func (app *App) startIdempotencyKeyCleanup() {
+	go func() {
+		for range time.Tick(time.Hour) {
+			app.deleteExpiredIdempotencyKeys(time.Now())
+		}
+	}()
+}
+
+func (app *App) deleteExpiredIdempotencyKeys(now time.Time) {
+	if err := app.db.Where("expires_at < ?", now).Delete(&IdempotencyKey{}).Error; err != nil {
+		log.Println("Error deleting expired idempotency keys:", err)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// Impersonation keeps the admin's own sign-in in the session next to the
+// impersonated user's: user_id, session_token and session_version belong to
+// the user, and the impersonator_ values to the admin, so switching back
+// doesn't need the admin's password.
+
+// Audit log actions for impersonation
+const (
+	AuditImpersonateStart   = "impersonate_start"
+	AuditImpersonateStop    = "impersonate_stop"
+	AuditImpersonatedAction = "impersonated_action"
+)
+
+// impersonatorID returns the ID of the admin impersonating the session's
+// user, if any.
+func impersonatorID(session *sessions.Session) (uint, bool) {
+	id, ok := session.Values["impersonator_id"].(uint)
+	return id, ok && id != 0
+}
+
+// clearImpersonation forgets the admin behind an impersonated session.
+func clearImpersonation(session *sessions.Session) {
+	delete(session.Values, "impersonator_id")
+	delete(session.Values, "impersonator_token")
+	delete(session.Values, "impersonator_version")
+}
+
+// impersonator loads the admin impersonating the session's user. It returns
+// false when there is none, or when the admin's own session has ended or
+// they are no longer an admin.
+func (app *App) impersonator(session *sessions.Session) (User, bool) {
+	var admin User
+	id, ok := impersonatorID(session)
+	if !ok {
+		return admin, false
+	}
+	if err := app.db.First(&admin, id).Error; err != nil || admin.Role != RoleAdmin || admin.Disabled {
+		return admin, false
+	}
+	version, _ := session.Values["impersonator_version"].(uint)
+	token, _ := session.Values["impersonator_token"].(string)
+	if admin.SessionVersion != version {
+		return admin, false
+	}
+	var us UserSession
+	err := app.db.Where("token_hash = ? AND user_id = ? AND expires_at > ?", hashToken(token), admin.ID, time.Now()).First(&us).Error
+	return admin, err == nil
+}
+
+// deleteImpersonatorSession removes the session row of the admin behind an
+// impersonated session, for example when it is logged out.
+func (app *App) deleteImpersonatorSession(session *sessions.Session) {
+	token, _ := session.Values["impersonator_token"].(string)
+	if token == "" {
+		return
+	}
+	if err := app.db.Where("token_hash = ?", hashToken(token)).Delete(&UserSession{}).Error; err != nil {
+		log.Println("Error deleting session:", err)
+	}
+}
+
+// recordImpersonatedAction stores an audit log entry for a request adminID
+// made while impersonating userID.
+func (app *App) recordImpersonatedAction(adminID, userID uint, detail string) {
+	entry := AuditLog{
+		ActorID:      adminID,
+		Action:       AuditImpersonatedAction,
+		TargetUserID: userID,
+		Detail:       detail,
+		CreatedAt:    time.Now(),
+	}
+	if err := app.db.Create(&entry).Error; err != nil {
+		log.Println("Error writing audit log:", err)
+	}
+}
+
+// adminImpersonateHandler signs the admin in as another user for support.
+// Admins and suspended accounts can't be impersonated.
+func (app *App) adminImpersonateHandler(w http.ResponseWriter, r *http.Request) {
+	admin, user, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+	if user.ID == admin.ID || user.Role == RoleAdmin || user.Disabled {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<div class="error">Only active accounts of other non-admin users can be impersonated.</div>`))
+		return
+	}
+
+	session, _ := app.store.Get(r, "session")
+	token, err := app.createUserSession(r, user, time.Now())
+	if err != nil {
+		log.Println("Error starting session:", err)
+		writeServerError(w)
+		return
+	}
+	session.Values["impersonator_id"] = admin.ID
+	session.Values["impersonator_token"] = session.Values["session_token"]
+	session.Values["impersonator_version"] = session.Values["session_version"]
+	session.Values["user_id"] = user.ID
+	session.Values["session_version"] = user.SessionVersion
+	session.Values["session_token"] = token
+	if err := session.Save(r, w); err != nil {
+		log.Println("Error saving session:", err)
+		writeServerError(w)
+		return
+	}
+	app.recordAudit(admin.ID, AuditImpersonateStart, user.ID)
+
+	app.tmpl.ExecuteTemplate(w, "dashboard.templ", map[string]interface{}{
+		"User":         user,
+		"Impersonator": admin,
+	})
+}
+
+// stopImpersonationHandler switches an impersonated session back to the
+// admin. If the admin's own session has ended in the meantime, the whole
+// session ends instead.
+func (app *App) stopImpersonationHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	session, _ := app.store.Get(r, "session")
+	if _, ok := impersonatorID(session); !ok {
+		app.notFoundHandler(w, r)
+		return
+	}
+	admin, ok := app.impersonator(session)
+	app.deleteUserSession(session)
+	if !ok {
+		app.deleteImpersonatorSession(session)
+		clearImpersonation(session)
+		session.Values["user_id"] = nil
+		session.Options.MaxAge = -1
+		if err := session.Save(r, w); err != nil {
+			log.Println("Error saving session:", err)
+		}
+		app.tmpl.ExecuteTemplate(w, "login.templ", map[string]interface{}{
+			"Error": sessionEndedMessage,
+		})
+		return
+	}
+
+	session.Values["user_id"] = admin.ID
+	session.Values["session_version"] = session.Values["impersonator_version"]
+	session.Values["session_token"] = session.Values["impersonator_token"]
+	clearImpersonation(session)
+	if err := session.Save(r, w); err != nil {
+		log.Println("Error saving session:", err)
+		writeServerError(w)
+		return
+	}
+	app.recordAudit(admin.ID, AuditImpersonateStop, user.ID)
+	app.tmpl.ExecuteTemplate(w, "admin_users.templ", map[string]interface{}{})
+}
+
+// auditImpersonation ends impersonated sessions whose admin has signed out
+// or lost the admin role, and flags every state-changing request made while
+// impersonating in the audit log.
+func (app *App) auditImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, _ := app.store.Get(r, "session")
+		userID, _ := session.Values["user_id"].(uint)
+		if _, ok := impersonatorID(session); !ok || userID == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		admin, ok := app.impersonator(session)
+		if !ok {
+			app.deleteUserSession(session)
+			app.deleteImpersonatorSession(session)
+			clearImpersonation(session)
+			session.Values["user_id"] = nil
+			session.Options.MaxAge = -1
+			if err := session.Save(r, w); err != nil {
+				log.Println("Error saving session:", err)
+			}
+			app.writeErrorPage(w, r, http.StatusUnauthorized, sessionEndedMessage)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			app.recordImpersonatedAction(admin.ID, userID, r.Method+" "+r.URL.Path)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// incomingHookPathPrefix is where incoming hooks receive their POSTs; the
+// rest of the path is the hook's secret token.
+const incomingHookPathPrefix = "/api/v1/hooks/"
+
+// maxIncomingHooks caps how many incoming hooks a user can have.
+const maxIncomingHooks = 10
+
+// maxHookFieldLength caps the JSON paths of an incoming hook's field
+// mapping.
+const maxHookFieldLength = 200
+
+// field returns the JSON path mapped to an item field, or def when the hook
+// doesn't map it.
+func (hook IncomingHook) field(path, def string) string {
+	if path == "" {
+		return def
+	}
+	return path
+}
+
+// Mapping describes the hook's field mapping for display, or "" when it
+// uses the default field names.
+func (hook IncomingHook) Mapping() string {
+	var parts []string
+	if hook.NameField != "" {
+		parts = append(parts, "name ← "+hook.NameField)
+	}
+	if hook.DescriptionField != "" {
+		parts = append(parts, "description ← "+hook.DescriptionField)
+	}
+	if hook.QuantityField != "" {
+		parts = append(parts, "quantity ← "+hook.QuantityField)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// lookupJSONPath returns the value at a dotted path such as "alert.title"
+// or "items.0.name" in a decoded JSON document.
+func lookupJSONPath(doc interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			value, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			doc = value
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, doc != nil
+}
+
+// hookString converts a mapped JSON value to text. Numbers and booleans are
+// accepted so a numeric alert ID can become an item name.
+func hookString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", errors.New("must be a string")
+}
+
+// hookQuantity converts a mapped JSON value to a quantity. Whole numbers
+// and strings holding one are accepted.
+func hookQuantity(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32 {
+			return int(v), nil
+		}
+	case string:
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			return n, nil
+		}
+	}
+	return 0, errors.New("Quantity must be a whole number")
+}
+
+// itemRequest builds the item fields from a hook's JSON payload using its
+// field mapping.
+func (hook IncomingHook) itemRequest(payload interface{}) (itemRequest, error) {
+	var req itemRequest
+	namePath := hook.field(hook.NameField, "name")
+	value, ok := lookupJSONPath(payload, namePath)
+	if !ok {
+		return req, fmt.Errorf("Item name cannot be empty: %q is missing from the payload", namePath)
+	}
+	name, err := hookString(value)
+	if err != nil {
+		return req, fmt.Errorf("%s %s", namePath, err)
+	}
+	req.Name = &name
+
+	if value, ok := lookupJSONPath(payload, hook.field(hook.DescriptionField, "description")); ok {
+		description, err := hookString(value)
+		if err != nil {
+			return req, fmt.Errorf("%s %s", hook.field(hook.DescriptionField, "description"), err)
+		}
+		req.Description = &description
+	}
+	if value, ok := lookupJSONPath(payload, hook.field(hook.QuantityField, "quantity")); ok {
+		quantity, err := hookQuantity(value)
+		if err != nil {
+			return req, err
+		}
+		req.Quantity = &quantity
+	}
+	return req, nil
+}
+
+// receiveIncomingHookHandler creates an item from the JSON body POSTed to
+// an incoming hook's URL. The token in the URL is the only credential, so
+// unknown tokens answer 404 and each hook has its own rate limit
+// (TOKEN_RATE_LIMIT).
+func (app *App) receiveIncomingHookHandler(w http.ResponseWriter, r *http.Request) {
+	var hook IncomingHook
+	if err := app.db.Where("token_hash = ?", hashToken(mux.Vars(r)["token"])).First(&hook).Error; err != nil {
+		writeJSONError(w, http.StatusNotFound, "hook not found")
+		return
+	}
+	if app.isUserDisabled(hook.UserID) {
+		writeJSONError(w, http.StatusForbidden, "account suspended")
+		return
+	}
+
+	now := time.Now()
+	limit := app.limiter.allow("hook:"+strconv.Itoa(int(hook.ID)), app.config.TokenRateLimit, time.Minute, now)
+	setRateLimitHeaders(w, limit, now)
+	if !limit.Allowed {
+		writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		return
+	}
+	app.db.Model(&hook).Updates(map[string]interface{}{
+		"request_count": gorm.Expr("request_count + 1"),
+		"last_used_at":  now,
+	})
+
+	var payload interface{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&payload); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	req, err := hook.itemRequest(payload)
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	item := Item{
+		UserID:    hook.UserID,
+		OrgID:     app.userOrgID(hook.UserID),
+		Status:    ItemStatusActive,
+		Quantity:  1,
+		CreatedAt: now,
+	}
+	if err := req.apply(&item, app.config.ItemNamePolicy); err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if limit := app.getItemLimit(hook.UserID); limit.Reached {
+		writeJSONError(w, http.StatusForbidden, fmt.Sprintf("You have reached the limit of %d items", limit.Max))
+		return
+	}
+	if err := app.createItem(&item); err != nil {
+		log.Println("Error creating item from incoming hook:", err)
+		writeJSONError(w, http.StatusInternalServerError, "could not create item")
+		return
+	}
+	app.touchItems(hook.UserID)
+	app.notifyItem(WebhookItemCreated, item)
+	writeJSON(w, http.StatusCreated, newItemResponse(item))
+}
+
+// incomingHooksHandler lists the user's incoming hooks.
+func (app *App) incomingHooksHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	app.renderIncomingHooks(w, user.ID, nil)
+}
+
+// renderIncomingHooks renders the incoming hook list for userID, merging
+// data into the template data.
+func (app *App) renderIncomingHooks(w http.ResponseWriter, userID uint, data map[string]interface{}) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	var hooks []IncomingHook
+	app.db.Where("user_id = ?", userID).Order("created_at desc").Find(&hooks)
+	data["Hooks"] = hooks
+	data["MaxHooks"] = maxIncomingHooks
+	app.tmpl.ExecuteTemplate(w, "incoming_hooks.templ", data)
+}
+
+// createIncomingHookHandler creates an incoming hook with an optional field
+// mapping. Only the token's hash is stored, so the full URL is shown this
+// one time.
+func (app *App) createIncomingHookHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	hook := IncomingHook{
+		UserID:           user.ID,
+		Name:             strings.TrimSpace(r.FormValue("name")),
+		NameField:        strings.TrimSpace(r.FormValue("name_field")),
+		DescriptionField: strings.TrimSpace(r.FormValue("description_field")),
+		QuantityField:    strings.TrimSpace(r.FormValue("quantity_field")),
+	}
+	form := map[string]interface{}{"Form": hook}
+	if user.Demo {
+		w.WriteHeader(http.StatusForbidden)
+		form["Error"] = "Incoming hooks are not available for demo accounts"
+		app.renderIncomingHooks(w, user.ID, form)
+		return
+	}
+	if hook.Name == "" || len(hook.Name) > maxTokenNameLength {
+		form["Error"] = "Hook name is required (up to 100 characters)"
+		app.renderIncomingHooks(w, user.ID, form)
+		return
+	}
+	for _, path := range []string{hook.NameField, hook.DescriptionField, hook.QuantityField} {
+		if len(path) > maxHookFieldLength || strings.ContainsAny(path, " \t") || strings.Contains(path, "..") ||
+			strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") {
+			form["Error"] = fmt.Sprintf("%q is not a valid field path; use dotted keys such as alert.title", path)
+			app.renderIncomingHooks(w, user.ID, form)
+			return
+		}
+	}
+	var count int64
+	app.db.Model(&IncomingHook{}).Where("user_id = ?", user.ID).Count(&count)
+	if count >= maxIncomingHooks {
+		form["Error"] = fmt.Sprintf("You can have up to %d incoming hooks", maxIncomingHooks)
+		app.renderIncomingHooks(w, user.ID, form)
+		return
+	}
+
+	value, err := generateAPIToken()
+	if err != nil {
+		log.Println("Error generating incoming hook token:", err)
+		writeServerError(w)
+		return
+	}
+	hook.TokenHash = hashToken(value)
+	hook.Prefix = value[:tokenPrefixLength]
+	if err := app.db.Create(&hook).Error; err != nil {
+		log.Println("Error creating incoming hook:", err)
+		writeServerError(w)
+		return
+	}
+
+	app.renderIncomingHooks(w, user.ID, map[string]interface{}{
+		"Created": hook,
+		"HookURL": app.config.BaseURL + incomingHookPathPrefix + value,
+	})
+}
+
+// deleteIncomingHookHandler deletes one of the user's incoming hooks; its
+// URL stops working immediately.
+func (app *App) deleteIncomingHookHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	err := app.db.Where("id = ? AND user_id = ?", mux.Vars(r)["id"], user.ID).Delete(&IncomingHook{}).Error
+	if err != nil {
+		log.Println("Error deleting incoming hook:", err)
+		writeServerError(w)
+		return
+	}
+	app.renderIncomingHooks(w, user.ID, nil)
+}
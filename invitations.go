@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// errInvitationUsed is returned by claimInvitation when another
+// registration used the invite first.
+var errInvitationUsed = errors.New("invitation already used")
+
+// newInviteCode returns a random code that is easy to read out or type:
+// 16 characters of base32.
+func newInviteCode() (string, error) {
+	raw := make([]byte, 10)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(raw), nil
+}
+
+// findInvitation returns the unused, unexpired invitation for code.
+func (app *App) findInvitation(code string, now time.Time) (Invitation, error) {
+	var invitation Invitation
+	err := app.db.Where("code_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(code), now).First(&invitation).Error
+	return invitation, err
+}
+
+// claimInvitation marks invitation as used by userID. It fails with
+// errInvitationUsed if it was used in the meantime, so two registrations
+// can't share one code. Run it in the transaction that creates the user.
+func claimInvitation(tx *gorm.DB, invitation Invitation, userID uint, now time.Time) error {
+	claim := tx.Model(&Invitation{}).Where("id = ? AND used_at IS NULL", invitation.ID).Updates(map[string]interface{}{
+		"used_at": now,
+		"used_by": userID,
+	})
+	if claim.Error != nil {
+		return claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return errInvitationUsed
+	}
+	return nil
+}
+
+// adminCreateInvitationHandler creates a single-use invite and shows its
+// code and registration link once.
+func (app *App) adminCreateInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := app.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if !app.config.InviteOnly {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`<div class="error">Registration isn't invite-only; set INVITE_ONLY to use invites.</div>`))
+		return
+	}
+
+	code, err := newInviteCode()
+	if err != nil {
+		log.Println("Error generating invite code:", err)
+		writeServerError(w)
+		return
+	}
+	invitation := Invitation{
+		CodeHash:  hashToken(code),
+		CreatedBy: admin.ID,
+		ExpiresAt: time.Now().Add(app.config.InviteTTL),
+	}
+	if err := app.db.Create(&invitation).Error; err != nil {
+		log.Println("Error creating invitation:", err)
+		writeServerError(w)
+		return
+	}
+	app.recordAudit(admin.ID, "create_invitation", 0)
+
+	app.tmpl.ExecuteTemplate(w, "admin_invitation.templ", map[string]interface{}{
+		"Code":      code,
+		"Link":      app.config.BaseURL + "/register?invite=" + url.QueryEscape(code),
+		"ExpiresAt": invitation.ExpiresAt,
+	})
+}
@@ -0,0 +1,810 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Item statuses
+const (
+	ItemStatusActive   = "active"
+	ItemStatusArchived = "archived"
+)
+
+// Page sizes of the items list. Clients may ask for up to
+// maxItemPageSize items with page_size.
+const (
+	defaultItemPageSize = 50
+	maxItemPageSize     = 200
+)
+
+// itemPage is one page of the items list: its 1-based Number, its Size and
+// the Total number of items matching the filters.
+type itemPage struct {
+	Number int
+	Size   int
+	Total  int64
+}
+
+// parseItemPage reads the page and page_size parameters. Missing or invalid
+// values give the first page of defaultItemPageSize items.
+func parseItemPage(r *http.Request) itemPage {
+	page := itemPage{Number: 1, Size: defaultItemPageSize}
+	if n, err := strconv.Atoi(r.FormValue("page")); err == nil && n > 1 {
+		page.Number = n
+	}
+	if n, err := strconv.Atoi(r.FormValue("page_size")); err == nil && n > 0 {
+		page.Size = min(n, maxItemPageSize)
+	}
+	return page
+}
+
+// Pages returns how many pages the items fill; an empty list has one.
+func (p itemPage) Pages() int {
+	return max(1, int((p.Total+int64(p.Size)-1)/int64(p.Size)))
+}
+
+// Offset returns how many items come before the page.
+func (p itemPage) Offset() int {
+	return (p.Number - 1) * p.Size
+}
+
+// First and Last return the 1-based positions of the page's first and last
+// items, for "Showing 51–100 of 240".
+func (p itemPage) First() int {
+	return min(p.Offset()+1, int(p.Total))
+}
+
+func (p itemPage) Last() int {
+	return min(p.Offset()+p.Size, int(p.Total))
+}
+
+func (p itemPage) HasPrev() bool { return p.Number > 1 }
+func (p itemPage) HasNext() bool { return p.Number < p.Pages() }
+func (p itemPage) Prev() int     { return p.Number - 1 }
+func (p itemPage) Next() int     { return p.Number + 1 }
+
+// itemFilter holds the filters of the items view: Search and Status as for
+// itemsQuery, Tag as for taggedWith, Category as for inCategory, ListID as
+// for inList, View as for dueView and Priority as for withPriority. Sort is
+// ItemSortDue or ItemSortPriority to list items by due date or priority
+// instead of in the manual order.
+type itemFilter struct {
+	Search   string
+	Status   string
+	Tag      string
+	Category string
+	ListID   uint
+	View     string
+	Priority string
+	Sort     string
+}
+
+// parseItemFilter reads the filters the items view sends from
+// #item-filters.
+func parseItemFilter(r *http.Request) itemFilter {
+	listID, _ := parseListID(r.FormValue("list"))
+	return itemFilter{
+		Search:   r.FormValue("search"),
+		Status:   r.FormValue("status"),
+		Tag:      r.FormValue("tag"),
+		Category: r.FormValue("category"),
+		ListID:   listID,
+		View:     r.FormValue("filter"),
+		Priority: r.FormValue("priority"),
+		Sort:     r.FormValue("sort"),
+	}
+}
+
+// defaultItemFilter is the unfiltered view of the active items in one list,
+// or in every list for 0, that handlers return after adding or removing
+// an item.
+func defaultItemFilter(listID uint) itemFilter {
+	return itemFilter{Status: ItemStatusActive, ListID: listID}
+}
+
+// query builds the query for the items matching f.
+func (f itemFilter) query(app *App, userID interface{}) *gorm.DB {
+	now := time.Now().In(app.config.Location)
+	return app.itemsQuery(userID, f.Search, f.Status).
+		Scopes(taggedWith(f.Tag), inCategory(f.Category), inList(f.ListID), dueView(f.View, now), withPriority(f.Priority))
+}
+
+// order is the ORDER BY of the items matching f: pinned items first, then
+// by sortOrder.
+func (f itemFilter) order() string {
+	return pinnedFirst + f.sortOrder()
+}
+
+// sortOrder is the chosen order of the items matching f: by priority when
+// sorting by it, by due date when sorting by it or looking at a due date
+// view, otherwise the user's manual order.
+func (f itemFilter) sortOrder() string {
+	if f.Sort == ItemSortPriority {
+		return priorityOrder + ", items.created_at desc"
+	}
+	if f.Sort == ItemSortDue || f.View == ItemViewOverdue || f.View == ItemViewToday {
+		return "items.due_at IS NULL, items.due_at, items.created_at desc"
+	}
+	return manualOrder
+}
+
+// reorderable reports whether the items matching f are shown in the
+// manual order, so they can be dragged into a new one.
+func (f itemFilter) reorderable() bool {
+	return f.sortOrder() == manualOrder
+}
+
+// active reports whether f narrows the list beyond the default view of
+// all active items, in every list or the one being shown.
+func (f itemFilter) active() bool {
+	return f.Search != "" || (f.Status != "" && f.Status != ItemStatusActive) || strings.TrimSpace(f.Tag) != "" || f.Category != "" || f.View != "" || f.Priority != ""
+}
+
+// findItems returns one page of a user's items matching filter, with their
+// tags and categories, in filter's order, and the page with its Total
+// filled in. Only active items are returned unless the status filter is
+// "archived" or "all". A page past the end, as after deleting the last
+// item on it, becomes the last page.
+func (app *App) findItems(userID interface{}, filter itemFilter, page itemPage) ([]Item, itemPage) {
+	filter.query(app, userID).Model(&Item{}).Count(&page.Total)
+	page.Number = min(page.Number, page.Pages())
+
+	var items []Item
+	app.withOwners(filter.query(app, userID)).
+		Preload("Category").
+		Order(filter.order()).
+		Limit(page.Size).
+		Offset(page.Offset()).
+		Find(&items)
+	app.loadTags(items)
+	return items, page
+}
+
+// itemListData returns items.templ data for one page of a user's items.
+func (app *App) itemListData(userID interface{}, filter itemFilter, page itemPage) map[string]interface{} {
+	items, page := app.findItems(userID, filter, page)
+	return map[string]interface{}{
+		"Items":        items,
+		"Page":         page,
+		"TotalCount":   page.Total,
+		"FilterActive": filter.active(),
+		"Reorderable":  filter.reorderable(),
+	}
+}
+
+// withOwners preloads each item's User in multi-tenant mode, where an
+// organization admin's list includes other people's items.
+func (app *App) withOwners(query *gorm.DB) *gorm.DB {
+	if app.config.MultiTenant {
+		return query.Preload("User")
+	}
+	return query
+}
+
+// itemsQuery builds the query behind findItems.
+func (app *App) itemsQuery(userID interface{}, search, status string) *gorm.DB {
+	query := app.db.Scopes(app.visibleItems(userID))
+
+	switch status {
+	case "all":
+	case ItemStatusArchived:
+		query = query.Where("status = ?", ItemStatusArchived)
+	case ItemStatusPending:
+		query = query.Where("status = ? AND NOT completed", ItemStatusActive)
+	case ItemStatusDone:
+		query = query.Where("status = ? AND completed", ItemStatusActive)
+	default:
+		query = query.Where("status = ?", ItemStatusActive)
+	}
+
+	if search != "" {
+		query = query.Where("name LIKE ? OR id LIKE ?", "%"+search+"%", "%"+search+"%")
+	}
+	return query
+}
+
+// itemsETag returns a weak ETag for the item lists userID sees at r. It is
+// built from the number of items visible to them and the latest update
+// among those items, so any create, change or delete gives a new tag,
+// without loading the items themselves.
+func (app *App) itemsETag(userID interface{}, r *http.Request) string {
+	var state struct {
+		Count  int64
+		Latest sql.NullString
+	}
+	app.db.Model(&Item{}).Scopes(app.visibleItems(userID)).
+		Select("COUNT(*) AS count, MAX(updated_at) AS latest").
+		Scan(&state)
+
+	format := "html"
+	if respondJSON(r) {
+		format = "json"
+	}
+	// Due date views and overdue marks change at midnight without any
+	// item changing, so the day is part of the tag
+	today := time.Now().In(app.config.Location).Format("2006-01-02")
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%v|%d|%s|%s|%s", userID, state.Count, state.Latest.String, format, today)
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// addItemListData adds the item limit status, total count, empty-state
+// flags, the table rows and the current user (to tell their items from
+// others') to items.templ data, and sets the X-Total-Count header to the number
+// of items matching the current filters. Handlers set data["FilterActive"]
+// when a search or non-default status filter produced the list, and
+// data["Page"] (see itemListData) when it is one page of a longer list.
+func (app *App) addItemListData(w http.ResponseWriter, data map[string]interface{}, userID interface{}) {
+	limit := app.getItemLimit(userID)
+	data["Limit"] = limit
+	data["CurrentUserID"] = toUint(userID)
+	data["ShowOwners"] = app.config.MultiTenant
+	data["IsEmpty"] = limit.Count == 0
+
+	if _, ok := data["TotalCount"]; !ok {
+		items, _ := data["Items"].([]Item)
+		data["TotalCount"] = int64(len(items))
+	}
+	if _, ok := data["Page"]; !ok {
+		// An unpaged list, such as fuzzy search results, is a single page
+		total := data["TotalCount"].(int64)
+		data["Page"] = itemPage{Number: 1, Size: max(int(total), 1), Total: total}
+	}
+	page := data["Page"].(itemPage)
+	items, _ := data["Items"].([]Item)
+	rows := make([]itemRow, len(items))
+	currency := app.userCurrency(userID)
+	for i, item := range items {
+		rows[i] = app.newItemRow(item, userID, page.First()+i, page)
+		rows[i].Currency = currency
+	}
+	data["Rows"] = rows
+	w.Header().Set("X-Total-Count", fmt.Sprint(data["TotalCount"]))
+}
+
+func (app *App) archiveItemHandler(w http.ResponseWriter, r *http.Request) {
+	app.setItemStatus(w, r, ItemStatusArchived)
+}
+
+func (app *App) unarchiveItemHandler(w http.ResponseWriter, r *http.Request) {
+	app.setItemStatus(w, r, ItemStatusActive)
+}
+
+// setItemStatus moves one of the user's items to the given status and
+// returns the updated items list; JSON clients get the item.
+func (app *App) setItemStatus(w http.ResponseWriter, r *http.Request, status string) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Update item (only if it belongs to the user)
+	var item Item
+	if err := app.db.Scopes(app.ownedItems(userID)).Preload("Category").Where("id = ?", mux.Vars(r)["id"]).First(&item).Error; err != nil {
+		writeItemNotFound(w, r)
+		return
+	}
+	app.loadItemTags(&item)
+	if item.Status != status {
+		if err := app.db.Model(&item).Update("status", status).Error; err != nil {
+			log.Println("Error updating item status:", err)
+			writeServerError(w)
+			return
+		}
+		app.touchItems(userID)
+		app.notifyItem(WebhookItemUpdated, item)
+	}
+	if respondJSON(r) {
+		writeJSON(w, http.StatusOK, newItemResponse(item))
+		return
+	}
+
+	// Return updated items list, keeping the current filters and page
+	app.writeItemList(w, r, userID, app.itemListData(userID, parseItemFilter(r), parseItemPage(r)))
+}
+
+// cloneItemHandler copies one of the user's items into a new item named
+// "<name> (copy)" and returns the updated items list; JSON clients get the
+// copy.
+func (app *App) cloneItemHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+
+	var source Item
+	if err := app.db.Scopes(app.ownedItems(userID)).Where("id = ?", mux.Vars(r)["id"]).First(&source).Error; err != nil {
+		writeItemNotFound(w, r)
+		return
+	}
+
+	// Enforce the per-user item cap
+	if limit := app.getItemLimit(userID); limit.Reached {
+		app.writeItemError(w, r, userID, http.StatusForbidden, fmt.Sprintf("You have reached the limit of %d items", limit.Max))
+		return
+	}
+
+	app.loadItemTags(&source)
+	clone := Item{
+		UserID:      source.UserID,
+		OrgID:       source.OrgID,
+		Name:        source.Name + " (copy)",
+		Description: source.Description,
+		Notes:       source.Notes,
+		Status:      ItemStatusActive,
+		Quantity:    source.Quantity,
+		CreatedAt:   time.Now(),
+		Tags:        source.Tags,
+		CategoryID:  source.CategoryID,
+		ListID:      source.ListID,
+		ValueCents:  source.ValueCents,
+		Priority:    source.Priority,
+		Color:       source.Color,
+		DueAt:       source.DueAt,
+	}
+	if err := app.createItem(&clone); err != nil {
+		log.Println("Error cloning item:", err)
+		writeServerError(w)
+		return
+	}
+	app.touchItems(userID)
+	app.notifyItem(WebhookItemCreated, clone)
+
+	if respondJSON(r) {
+		app.db.Preload("Category").First(&clone, clone.ID)
+		app.loadItemTags(&clone)
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/items/%d", clone.ID))
+		writeJSON(w, http.StatusCreated, newItemResponse(clone))
+		return
+	}
+
+	// Return updated items list
+	app.writeItemList(w, r, userID, app.itemListData(userID, defaultItemFilter(parseItemFilter(r).ListID), parseItemPage(r)))
+}
+
+// markAllItemsHandler moves every item the current filters show to the
+// "target" status in one UPDATE: all the filters of the list, including
+// its list, category, tag, due date view and priority, and with "fuzzy"
+// set the fuzzy search results. Only the user's own items change, even for
+// organization admins. The form must carry confirm=true so a stray request
+// can't rewrite the whole list. JSON clients get the number of items
+// changed as {"updated": n}.
+func (app *App) markAllItemsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+
+	filter := parseItemFilter(r)
+	fuzzy := r.FormValue("fuzzy") == "true" && filter.Search != ""
+	target := r.FormValue("target")
+	data := map[string]interface{}{}
+
+	switch {
+	case target != ItemStatusActive && target != ItemStatusArchived:
+		data["Error"] = "Choose a status to apply"
+	case r.FormValue("confirm") != "true":
+		data["Error"] = "Please confirm the bulk update"
+	default:
+		// Load the affected items first so webhooks can report each one,
+		// and update exactly those
+		query := filter.query(app, userID)
+		if fuzzy {
+			var ids []uint
+			for _, item := range app.fuzzyFindItems(userID, filter) {
+				ids = append(ids, item.ID)
+			}
+			query = app.db.Where("id IN ?", ids)
+		}
+		var changed []Item
+		if err := query.Scopes(app.ownedItems(userID)).Where("status <> ?", target).Find(&changed).Error; err != nil {
+			log.Println("Error loading items:", err)
+			writeServerError(w)
+			return
+		}
+		ids := make([]uint, len(changed))
+		for i, item := range changed {
+			ids[i] = item.ID
+		}
+
+		var updated int64
+		if len(ids) > 0 {
+			result := app.db.Model(&Item{}).Where("id IN ? AND status <> ?", ids, target).Update("status", target)
+			if result.Error != nil {
+				log.Println("Error marking items:", result.Error)
+				writeServerError(w)
+				return
+			}
+			updated = result.RowsAffected
+		}
+		if updated > 0 {
+			app.touchItems(userID)
+		}
+		for _, item := range changed {
+			item.Status = target
+			app.notifyItem(WebhookItemUpdated, item)
+		}
+		if respondJSON(r) {
+			writeJSON(w, http.StatusOK, map[string]int64{"updated": updated})
+			return
+		}
+		data["Success"] = fmt.Sprintf("Marked %d items as %s", updated, target)
+	}
+	if message, ok := data["Error"].(string); ok {
+		if respondJSON(r) {
+			writeJSONError(w, http.StatusBadRequest, message)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	// Return updated items list, keeping the current filters
+	if fuzzy {
+		data["Items"] = app.fuzzyFindItems(userID, filter)
+		data["FilterActive"] = true
+	} else {
+		for key, value := range app.itemListData(userID, filter, parseItemPage(r)) {
+			data[key] = value
+		}
+	}
+	app.writeItemList(w, r, userID, data)
+}
+
+// maxQuantity bounds item quantities so increments can't overflow.
+const maxQuantity = 1_000_000_000
+
+// maxNotesLength caps item notes, in characters.
+const maxNotesLength = 10000
+
+// cleanNotes trims item notes and checks their length.
+func cleanNotes(notes string) (string, error) {
+	notes = strings.TrimSpace(notes)
+	if utf8.RuneCountInString(notes) > maxNotesLength {
+		return "", fmt.Errorf("Notes cannot be longer than %d characters", maxNotesLength)
+	}
+	return notes, nil
+}
+
+// parseQuantity reads a quantity form value, defaulting to 1 when empty.
+func parseQuantity(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 1, nil
+	}
+	quantity, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("Quantity must be a whole number")
+	}
+	if quantity < 0 {
+		return 0, errors.New("Quantity cannot be negative")
+	}
+	if quantity > maxQuantity {
+		return 0, fmt.Errorf("Quantity cannot be more than %d", maxQuantity)
+	}
+	return quantity, nil
+}
+
+// createItem inserts item with its Tags, in the owner's default list unless
+// ListID is set. GORM replaces a zero Quantity with the column default of
+// 1, so a requested quantity of 0 is written separately.
+func (app *App) createItem(item *Item) error {
+	if item.ListID == 0 {
+		listID, err := app.defaultListID(item.UserID)
+		if err != nil {
+			return err
+		}
+		item.ListID = listID
+	}
+	quantity := item.Quantity
+	if err := app.db.Create(item).Error; err != nil {
+		return err
+	}
+	if quantity == 0 {
+		item.Quantity = 0
+		if err := app.db.Model(item).Update("quantity", 0).Error; err != nil {
+			return err
+		}
+	}
+	if len(item.Tags) > 0 {
+		return app.saveItemTags(item)
+	}
+	return nil
+}
+
+func (app *App) incrementItemHandler(w http.ResponseWriter, r *http.Request) {
+	app.adjustQuantity(w, r, 1)
+}
+
+func (app *App) decrementItemHandler(w http.ResponseWriter, r *http.Request) {
+	app.adjustQuantity(w, r, -1)
+}
+
+// adjustQuantity changes one of the user's item quantities by sign times
+// the optional "by" form value (default 1) in a single UPDATE, never going
+// below zero or above maxQuantity, and returns the item's quantity
+// fragment; JSON clients get the item.
+func (app *App) adjustQuantity(w http.ResponseWriter, r *http.Request, sign int) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+
+	by := 1
+	if value := r.FormValue("by"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxQuantity {
+			if respondJSON(r) {
+				writeJSONError(w, http.StatusBadRequest, "invalid amount")
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`<div class="error">Invalid amount.</div>`))
+			return
+		}
+		by = n
+	}
+	delta := sign * by
+
+	owned := app.ownedItems(userID)
+	result := app.db.Model(&Item{}).Scopes(owned).Where("id = ?", mux.Vars(r)["id"]).
+		Update("quantity", gorm.Expr(
+			"CASE WHEN quantity + ? < 0 THEN 0 WHEN quantity + ? > ? THEN ? ELSE quantity + ? END",
+			delta, delta, maxQuantity, maxQuantity, delta))
+	if result.Error != nil {
+		writeServerError(w)
+		return
+	}
+
+	var item Item
+	if result.RowsAffected == 0 || app.db.Scopes(owned).Preload("Category").Where("id = ?", mux.Vars(r)["id"]).First(&item).Error != nil {
+		writeItemNotFound(w, r)
+		return
+	}
+	app.loadItemTags(&item)
+	// The stats sum quantities, so they change too
+	app.touchItems(userID)
+	app.notifyItem(WebhookItemUpdated, item)
+	if respondJSON(r) {
+		writeJSON(w, http.StatusOK, newItemResponse(item))
+		return
+	}
+	app.tmpl.ExecuteTemplate(w, "item_quantity.templ", item)
+}
+
+// itemRow is the item_row.templ data for one row of the items table.
+type itemRow struct {
+	Item Item
+	// Number is the item's position in the whole list, for the # column,
+	// and Page the page the row is on, which its buttons keep.
+	Number        int
+	Page          itemPage
+	ShowOwners    bool
+	CurrentUserID uint
+	Overdue       bool
+	// Currency is the code of the viewer's currency, for the item's value.
+	Currency string
+	// Editing shows the name in the inline edit form, with Error above it
+	// and the user's Categories to choose from.
+	Editing    bool
+	Error      string
+	Categories []Category
+}
+
+func (app *App) newItemRow(item Item, userID interface{}, number int, page itemPage) itemRow {
+	return itemRow{
+		Item:          item,
+		Number:        number,
+		Page:          page,
+		ShowOwners:    app.config.MultiTenant,
+		CurrentUserID: toUint(userID),
+		Overdue:       item.Status == ItemStatusActive && !item.Completed && isOverdue(item, time.Now().In(app.config.Location)),
+	}
+}
+
+// writeItemRow answers a single-item route with the item's refreshed table
+// row, or with the item as JSON. A row swapped in on its own doesn't know
+// where it sits in the list, so the row's buttons send its position as
+// "n" along with the page.
+func (app *App) writeItemRow(w http.ResponseWriter, r *http.Request, userID interface{}, item Item, editing bool, message string) {
+	if respondJSON(r) {
+		writeJSON(w, http.StatusOK, newItemResponse(item))
+		return
+	}
+	number, _ := strconv.Atoi(r.FormValue("n"))
+	row := app.newItemRow(item, userID, number, parseItemPage(r))
+	row.Currency = app.userCurrency(userID)
+	row.Editing = editing
+	row.Error = message
+	if editing {
+		row.Categories = app.userCategories(userID)
+	}
+	app.tmpl.ExecuteTemplate(w, "item_row.templ", row)
+}
+
+// writeItemNotFound answers a single-item route for an item that doesn't
+// exist or that the user may not see or change.
+func writeItemNotFound(w http.ResponseWriter, r *http.Request) {
+	if respondJSON(r) {
+		writeJSONError(w, http.StatusNotFound, "item not found")
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(`<div class="error">Item not found.</div>`))
+}
+
+// itemDetailHandler shows one of the items the user can see with all of
+// its fields: a dialog over the dashboard for htmx requests, a page of its
+// own when opened directly, or JSON. Fields added to items belong here
+// even when the list has no room for them.
+func (app *App) itemDetailHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	var item Item
+	if err := app.withOwners(app.db.Scopes(app.visibleItems(userID))).Preload("Category").Preload("List").Where("id = ?", mux.Vars(r)["id"]).First(&item).Error; err != nil {
+		writeItemNotFound(w, r)
+		return
+	}
+	app.loadItemTags(&item)
+	if respondJSON(r) {
+		writeJSON(w, http.StatusOK, newItemResponse(item))
+		return
+	}
+
+	data := map[string]interface{}{
+		"Item":      item,
+		"ShowOwner": app.config.MultiTenant && item.UserID != toUint(userID),
+		"Currency":  app.userCurrency(userID),
+	}
+	if r.Header.Get("HX-Request") == "true" {
+		app.tmpl.ExecuteTemplate(w, "item_detail.templ", data)
+		return
+	}
+	data["Standalone"] = true
+	app.renderPage(w, r, http.StatusOK, "item_detail", data)
+}
+
+// itemRowHandler returns one of the items the user can see as a table row,
+// or as JSON; the inline editor's Cancel button uses it.
+func (app *App) itemRowHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	var item Item
+	if err := app.withOwners(app.db.Scopes(app.visibleItems(userID))).Preload("Category").Where("id = ?", mux.Vars(r)["id"]).First(&item).Error; err != nil {
+		writeItemNotFound(w, r)
+		return
+	}
+	app.loadItemTags(&item)
+	app.writeItemRow(w, r, userID, item, false, "")
+}
+
+// editItemHandler returns the row of one of the user's own items with its
+// name in the inline edit form.
+func (app *App) editItemHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	var item Item
+	if err := app.db.Scopes(app.ownedItems(userID)).Preload("Category").Where("id = ?", mux.Vars(r)["id"]).First(&item).Error; err != nil {
+		writeItemNotFound(w, r)
+		return
+	}
+	app.loadItemTags(&item)
+	app.writeItemRow(w, r, userID, item, true, "")
+}
+
+// updateItemHandler renames one of the user's own items from the "name"
+// form value (PUT or PATCH), replaces its notes, comma-separated tags,
+// category, due date, priority, color, value and quantity when the form
+// has "notes", "tags", "category_id", "due_at", "priority", "color",
+// "value" and "quantity", and returns its refreshed row.
+// Invalid values return the row still in the editor with the error; JSON
+// clients get the item, or 422.
+func (app *App) updateItemHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	var item Item
+	if err := app.db.Scopes(app.ownedItems(userID)).Preload("Category").Where("id = ?", mux.Vars(r)["id"]).First(&item).Error; err != nil {
+		writeItemNotFound(w, r)
+		return
+	}
+	app.loadItemTags(&item)
+
+	name := r.FormValue("name")
+	req := itemRequest{Name: &name}
+	if notes, ok := r.Form["notes"]; ok && len(notes) > 0 {
+		req.Notes = &notes[0]
+	}
+	var tags []string
+	if values, ok := r.Form["tags"]; ok && len(values) > 0 {
+		tags = strings.Split(values[0], ",")
+		req.Tags = &tags
+	}
+	updated := item
+	if values, ok := r.Form["due_at"]; ok && len(values) > 0 {
+		req.DueAt = &values[0]
+	}
+	if values, ok := r.Form["priority"]; ok && len(values) > 0 {
+		req.Priority = &values[0]
+	}
+	if values, ok := r.Form["color"]; ok && len(values) > 0 {
+		req.Color = &values[0]
+	}
+	if values, ok := r.Form["value"]; ok && len(values) > 0 {
+		value := json.Number(values[0])
+		req.Value = &value
+	}
+	var err error
+	if values, ok := r.Form["quantity"]; ok && len(values) > 0 && strings.TrimSpace(values[0]) != "" {
+		var quantity int
+		if quantity, err = parseQuantity(values[0]); err == nil {
+			req.Quantity = &quantity
+		}
+	}
+	if err == nil {
+		err = app.applyItemRequest(req, &updated)
+	}
+	if values, ok := r.Form["category_id"]; ok && len(values) > 0 && err == nil {
+		var categoryID uint
+		if categoryID, err = parseCategoryID(values[0]); err == nil {
+			err = app.setItemCategory(&updated, categoryID)
+		}
+	}
+	if err != nil {
+		if respondJSON(r) {
+			writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		// Show what was typed so it can be corrected
+		item.Name = name
+		if req.Notes != nil {
+			item.Notes = *req.Notes
+		}
+		if req.Tags != nil {
+			item.Tags = tags
+		}
+		app.writeItemRow(w, r, userID, item, true, err.Error())
+		return
+	}
+
+	tagsChanged := !slices.Equal(updated.Tags, item.Tags)
+	categoryChanged := !equalIDs(updated.CategoryID, item.CategoryID)
+	dueChanged := !equalTimes(updated.DueAt, item.DueAt)
+	if updated.Name != item.Name || updated.Notes != item.Notes || updated.Priority != item.Priority || updated.Color != item.Color || updated.ValueCents != item.ValueCents || updated.Quantity != item.Quantity || tagsChanged || categoryChanged || dueChanged {
+		if err := app.db.Model(&updated).Select("name", "notes", "quantity", "value_cents", "priority", "color", "category_id", "due_at").Updates(&updated).Error; err != nil {
+			log.Println("Error updating item:", err)
+			writeServerError(w)
+			return
+		}
+		if tagsChanged {
+			if err := app.saveItemTags(&updated); err != nil {
+				log.Println("Error saving item tags:", err)
+				writeServerError(w)
+				return
+			}
+		}
+		app.touchItems(userID)
+		app.notifyItem(WebhookItemUpdated, updated)
+	}
+	app.writeItemRow(w, r, userID, updated, false, "")
+}
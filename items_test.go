@@ -0,0 +1,434 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdjustQuantity(t *testing.T) {
+	handler, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	item := createTestItem(t, app, alice, "Eggs")
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	quantity := func() int {
+		var stored Item
+		app.db.First(&stored, item.ID)
+		return stored.Quantity
+	}
+	adjust := func(action string, form url.Values) (*http.Response, string) {
+		return c.post(fmt.Sprintf("/items/%d/%s", item.ID, action), form)
+	}
+
+	tests := []struct {
+		action string
+		by     string
+		want   int
+	}{
+		{"increment", "", 2},
+		{"increment", "10", 12},
+		{"decrement", "", 11},
+		{"decrement", "5", 6},
+		{"decrement", "100", 0}, // clamped at zero
+		{"decrement", "", 0},
+	}
+	for _, tt := range tests {
+		form := url.Values{}
+		if tt.by != "" {
+			form.Set("by", tt.by)
+		}
+		resp, body := adjust(tt.action, form)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s by %q: status %d\n%s", tt.action, tt.by, resp.StatusCode, body)
+		}
+		if got := quantity(); got != tt.want {
+			t.Errorf("%s by %q: quantity = %d, want %d", tt.action, tt.by, got, tt.want)
+		}
+		if !strings.Contains(body, fmt.Sprintf("<span>%d</span>", tt.want)) {
+			t.Errorf("%s by %q: fragment doesn't show %d:\n%s", tt.action, tt.by, tt.want, body)
+		}
+	}
+
+	// Clamped at the maximum
+	app.db.Model(&item).Update("quantity", maxQuantity-1)
+	adjust("increment", url.Values{"by": {"5"}})
+	if got := quantity(); got != maxQuantity {
+		t.Errorf("increment past the maximum: quantity = %d, want %d", got, maxQuantity)
+	}
+
+	for _, by := range []string{"0", "-1", "x", fmt.Sprint(maxQuantity + 1)} {
+		if resp, _ := adjust("increment", url.Values{"by": {by}}); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("increment by %q: status = %d, want %d", by, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestUpdateItemQuantity(t *testing.T) {
+	handler, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	item := createTestItem(t, app, alice, "Eggs")
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+	path := fmt.Sprintf("/items/%d", item.ID)
+
+	if resp, body := c.do("PUT", path, strings.NewReader(url.Values{"name": {"Eggs"}, "quantity": {"5"}}.Encode()), nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d\n%s", resp.StatusCode, body)
+	}
+	var stored Item
+	app.db.First(&stored, item.ID)
+	if stored.Quantity != 5 {
+		t.Errorf("quantity = %d, want 5", stored.Quantity)
+	}
+
+	invalid := map[string]string{
+		"-1":                        "Quantity cannot be negative",
+		"x":                         "Quantity must be a whole number",
+		fmt.Sprint(maxQuantity + 1): "Quantity cannot be more than",
+	}
+	for quantity, message := range invalid {
+		_, body := c.do("PUT", path, strings.NewReader(url.Values{"name": {"Eggs"}, "quantity": {quantity}}.Encode()), nil)
+		if !strings.Contains(body, message) {
+			t.Errorf("quantity %q: no %q in\n%s", quantity, message, body)
+		}
+		app.db.First(&stored, item.ID)
+		if stored.Quantity != 5 {
+			t.Errorf("quantity %q: stored quantity = %d, want 5", quantity, stored.Quantity)
+		}
+	}
+}
+
+func TestItemLimitWarning(t *testing.T) {
+	handler, app := newTestApp(t, func(cfg *Config) {
+		cfg.MaxItemsPerUser = 100
+		cfg.ItemLimitWarnPercent = 90
+	})
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	for i := 0; i < 89; i++ {
+		createTestItem(t, app, alice, fmt.Sprint("Item ", i))
+	}
+	if _, body := c.get("/items"); strings.Contains(body, "close to your item limit") {
+		t.Errorf("warning shown at 89%%:\n%s", body)
+	}
+
+	for i := 89; i < 91; i++ {
+		createTestItem(t, app, alice, fmt.Sprint("Item ", i))
+	}
+	if limit := app.getItemLimit(alice.ID); !limit.Warning || limit.Reached || limit.Remaining != 9 {
+		t.Errorf("limit at 91%% = %+v, want a warning with 9 remaining", limit)
+	}
+	if _, body := c.get("/items"); !strings.Contains(body, "close to your item limit: 9 of 100 remaining") {
+		t.Errorf("no warning at 91%%:\n%s", body)
+	}
+}
+
+func TestArchiveItem(t *testing.T) {
+	handler, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	milk := createTestItem(t, app, alice, "Milk")
+	createTestItem(t, app, alice, "Bread")
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	listed := func(path string) bool {
+		t.Helper()
+		resp, body := c.get(path)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: status %d\n%s", path, resp.StatusCode, body)
+		}
+		return strings.Contains(body, "Milk")
+	}
+	status := func() string {
+		var stored Item
+		app.db.First(&stored, milk.ID)
+		return stored.Status
+	}
+
+	if resp, body := c.post(fmt.Sprintf("/items/%d/archive", milk.ID), nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("archive: status %d\n%s", resp.StatusCode, body)
+	}
+	if got := status(); got != ItemStatusArchived {
+		t.Errorf("status after archiving = %q, want %q", got, ItemStatusArchived)
+	}
+	if listed("/items") {
+		t.Error("the archived item is in the default list")
+	}
+	if _, body := c.get("/items"); !strings.Contains(body, "Bread") {
+		t.Error("the default list is missing the active item")
+	}
+	if !listed("/items?status=archived") {
+		t.Error("the archived item isn't in the archived list")
+	}
+
+	if resp, body := c.post(fmt.Sprintf("/items/%d/unarchive", milk.ID), nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unarchive: status %d\n%s", resp.StatusCode, body)
+	}
+	if got := status(); got != ItemStatusActive {
+		t.Errorf("status after unarchiving = %q, want %q", got, ItemStatusActive)
+	}
+	if !listed("/items") {
+		t.Error("the unarchived item isn't in the default list")
+	}
+}
+
+func TestItemListCounts(t *testing.T) {
+	handler, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	for _, name := range []string{"Milk", "Oat milk", "Bread", "Eggs"} {
+		createTestItem(t, app, alice, name)
+	}
+	archived := createTestItem(t, app, alice, "Goat milk")
+	app.db.Model(&archived).Update("status", ItemStatusArchived)
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	tests := []struct {
+		query        string
+		filterActive bool
+		total        string
+	}{
+		{"", false, "4"},
+		{"search=milk", true, "2"},
+		{"search=milk&page_size=1", true, "2"}, // every match, not just this page's
+		{"search=milk&status=all", true, "3"},
+		{"status=archived", true, "1"},
+		{"search=tea", true, "0"},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", "/items?"+tt.query, nil)
+		data := app.itemListData(alice.ID, parseItemFilter(r), parseItemPage(r))
+		if data["FilterActive"] != tt.filterActive {
+			t.Errorf("%q: FilterActive = %v, want %v", tt.query, data["FilterActive"], tt.filterActive)
+		}
+
+		resp, body := c.get("/items?" + tt.query)
+		if got := resp.Header.Get("X-Total-Count"); got != tt.total {
+			t.Errorf("%q: X-Total-Count = %s, want %s", tt.query, got, tt.total)
+		}
+		if tt.total == "0" && !strings.Contains(body, "No items match your search.") {
+			t.Errorf("%q: no empty search message:\n%s", tt.query, body)
+		}
+	}
+}
+
+func TestCloneItem(t *testing.T) {
+	handler, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	due := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	source := Item{
+		UserID:      alice.ID,
+		Name:        "Milk",
+		Description: "Semi-skimmed",
+		Notes:       "From the *farm shop*",
+		Status:      ItemStatusActive,
+		Quantity:    3,
+		ValueCents:  129,
+		Priority:    ItemPriorityHigh,
+		Color:       "blue",
+		DueAt:       &due,
+		CreatedAt:   time.Now(),
+		Tags:        []string{"dairy", "fridge"},
+	}
+	if err := app.createItem(&source); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	resp, body := c.post(fmt.Sprintf("/items/%d/clone", source.ID), nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "Milk (copy)") {
+		t.Fatalf("status %d\n%s", resp.StatusCode, body)
+	}
+
+	var clone Item
+	if err := app.db.Where("name = ?", "Milk (copy)").First(&clone).Error; err != nil {
+		t.Fatalf("no copy: %v", err)
+	}
+	app.loadItemTags(&clone)
+	if clone.ID == source.ID {
+		t.Fatal("the copy has the original's ID")
+	}
+	if clone.UserID != alice.ID || clone.ListID != source.ListID || clone.Description != source.Description ||
+		clone.Notes != source.Notes || clone.Status != ItemStatusActive || clone.Quantity != source.Quantity ||
+		clone.ValueCents != source.ValueCents || clone.Priority != source.Priority || clone.Color != source.Color ||
+		clone.DueAt == nil || !clone.DueAt.Equal(due) {
+		t.Errorf("copy = %+v, want the fields of %+v", clone, source)
+	}
+	if strings.Join(clone.Tags, ",") != "dairy,fridge" {
+		t.Errorf("copy tags = %q, want dairy and fridge", clone.Tags)
+	}
+
+	// The original is left as it was
+	var original Item
+	app.db.First(&original, source.ID)
+	app.loadItemTags(&original)
+	if original.Name != "Milk" || original.Quantity != 3 || strings.Join(original.Tags, ",") != "dairy,fridge" {
+		t.Errorf("original = %+v, want it unchanged", original)
+	}
+	var count int64
+	app.db.Model(&Item{}).Where("user_id = ?", alice.ID).Count(&count)
+	if count != 2 {
+		t.Errorf("%d items, want 2", count)
+	}
+}
+
+func TestItemNotFound(t *testing.T) {
+	handler, app := newTestApp(t)
+	createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	bob := createTestUser(t, app, "bob@example.com", "Correct-Horse-1", RoleUser)
+	bobs := createTestItem(t, app, bob, "Bob's milk")
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	requests := []struct{ method, path string }{
+		{"GET", "/items/%d"},
+		{"PUT", "/items/%d"},
+		{"DELETE", "/items/%d"},
+		{"POST", "/items/%d/archive"},
+		{"POST", "/items/%d/clone"},
+		{"POST", "/items/%d/increment"},
+	}
+	for _, id := range []uint{bobs.ID, bobs.ID + 1000} {
+		for _, req := range requests {
+			path := fmt.Sprintf(req.path, id)
+			form := strings.NewReader(url.Values{"name": {"Mine now"}}.Encode())
+
+			resp, body := c.do(req.method, path, form, nil)
+			if resp.StatusCode != http.StatusNotFound || body != `<div class="error">Item not found.</div>` {
+				t.Errorf("%s %s: status %d\n%s", req.method, path, resp.StatusCode, body)
+			}
+
+			form = strings.NewReader(url.Values{"name": {"Mine now"}}.Encode())
+			resp, body = c.do(req.method, path, form, http.Header{"HX-Request": nil, "Accept": {"application/json"}})
+			if resp.StatusCode != http.StatusNotFound || strings.TrimSpace(body) != `{"error":"item not found"}` {
+				t.Errorf("%s %s as JSON: status %d\n%s", req.method, path, resp.StatusCode, body)
+			}
+		}
+	}
+
+	var stored Item
+	app.db.First(&stored, bobs.ID)
+	if stored.Name != "Bob's milk" || stored.Status != ItemStatusActive || stored.Quantity != 1 {
+		t.Errorf("bob's item = %+v, want it unchanged", stored)
+	}
+	var count int64
+	app.db.Model(&Item{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d items, want 1", count)
+	}
+}
+
+func TestAdjustQuantityOwnItemsOnly(t *testing.T) {
+	handler, app := newTestApp(t)
+	createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	bob := createTestUser(t, app, "bob@example.com", "Correct-Horse-1", RoleUser)
+	item := createTestItem(t, app, bob, "Bob's eggs")
+	path := fmt.Sprintf("/items/%d/increment", item.ID)
+
+	c := newTestClient(t, handler)
+	if resp, _ := c.post(path, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("signed out: status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	c.login("alice@example.com", "Correct-Horse-1")
+	if resp, _ := c.post(path, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("another user's item: status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	// JSON clients authenticate with a token, like the API
+	var changed itemResponse
+	if resp := c.api("POST", path, createTestToken(t, app, bob, 0), nil, &changed); resp.StatusCode != http.StatusOK {
+		t.Fatalf("owner's token: status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if changed.Quantity != 2 {
+		t.Errorf("quantity = %d, want 2", changed.Quantity)
+	}
+}
+
+func TestCreateItemRejectsNegativeQuantity(t *testing.T) {
+	handler, app := newTestApp(t)
+	createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	_, body := c.post("/items", url.Values{"name": {"Eggs"}, "quantity": {"-3"}})
+	if !strings.Contains(body, "Quantity cannot be negative") {
+		t.Errorf("response doesn't explain the error:\n%s", body)
+	}
+	var count int64
+	app.db.Model(&Item{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d items stored, want none", count)
+	}
+}
+
+func TestMarkAllAppliesEveryFilter(t *testing.T) {
+	handler, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	bob := createTestUser(t, app, "bob@example.com", "Correct-Horse-1", RoleUser)
+	dairy := Category{UserID: alice.ID, Name: "Dairy"}
+	app.db.Create(&dairy)
+	work := List{UserID: alice.ID, Name: "Work"}
+	app.db.Create(&work)
+
+	items := map[string]Item{}
+	for _, name := range []string{"Milk", "Cheese", "Bread", "Paper", "Yoghurt"} {
+		items[name] = createTestItem(t, app, alice, name)
+	}
+	createTestItem(t, app, bob, "Bob's milk")
+	app.db.Model(&Item{}).Where("id IN ?", []uint{items["Milk"].ID, items["Cheese"].ID, items["Yoghurt"].ID}).Update("category_id", dairy.ID)
+	app.db.Model(&Item{}).Where("id IN ?", []uint{items["Paper"].ID, items["Yoghurt"].ID}).Update("list_id", work.ID)
+	cold := items["Milk"]
+	cold.Tags = []string{"cold"}
+	if err := app.saveItemTags(&cold); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+	archived := func() []string {
+		var names []string
+		app.db.Model(&Item{}).Where("status = ?", ItemStatusArchived).Order("name").Pluck("name", &names)
+		return names
+	}
+	restore := func() {
+		app.db.Model(&Item{}).Where("1 = 1").Update("status", ItemStatusActive)
+	}
+
+	tests := []struct {
+		filter url.Values
+		want   string
+	}{
+		{url.Values{"category": {fmt.Sprint(dairy.ID)}}, "Cheese,Milk,Yoghurt"},
+		{url.Values{"category": {categoryNone}}, "Bread,Paper"},
+		{url.Values{"list": {fmt.Sprint(work.ID)}}, "Paper,Yoghurt"},
+		{url.Values{"list": {fmt.Sprint(work.ID)}, "category": {fmt.Sprint(dairy.ID)}}, "Yoghurt"},
+		{url.Values{"tag": {"Cold"}}, "Milk"},
+		{url.Values{"search": {"e"}, "category": {fmt.Sprint(dairy.ID)}}, "Cheese"},
+		{url.Values{"search": {"chese"}, "fuzzy": {"true"}}, "Cheese"},
+		{url.Values{}, "Bread,Cheese,Milk,Paper,Yoghurt"},
+	}
+	for _, tt := range tests {
+		restore()
+		form := url.Values{"target": {ItemStatusArchived}, "confirm": {"true"}}
+		for name, v := range tt.filter {
+			form[name] = v
+		}
+		resp, body := c.post("/items/mark-all", form)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%v: status %d\n%s", tt.filter, resp.StatusCode, body)
+		}
+		if got := strings.Join(archived(), ","); got != tt.want {
+			t.Errorf("%v archived %s, want %s", tt.filter, got, tt.want)
+		}
+		if want := fmt.Sprintf("Marked %d items", len(strings.Split(tt.want, ","))); !strings.Contains(body, want) {
+			t.Errorf("%v: response doesn't say %q", tt.filter, want)
+		}
+	}
+}
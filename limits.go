@@ -0,0 +1,31 @@
+package main
+
+// itemLimit describes how close a user is to the per-user item cap.
+type itemLimit struct {
+	Max       int   `json:"max"`
+	Count     int64 `json:"count"`
+	Remaining int64 `json:"remaining"`
+	Warning   bool  `json:"warning"`
+	Reached   bool  `json:"reached"`
+}
+
+// getItemLimit computes the item limit status for a user. When no cap is
+// configured every field except Count is left at its zero value.
+func (app *App) getItemLimit(userID interface{}) itemLimit {
+	var count int64
+	app.db.Model(&Item{}).Where("user_id = ?", userID).Count(&count)
+
+	maxItems := app.config.MaxItemsPerUser
+	limit := itemLimit{Max: maxItems, Count: count}
+	if maxItems <= 0 {
+		return limit
+	}
+
+	limit.Remaining = int64(maxItems) - count
+	if limit.Remaining < 0 {
+		limit.Remaining = 0
+	}
+	limit.Reached = limit.Remaining == 0
+	limit.Warning = count*100 >= int64(maxItems)*int64(app.config.ItemLimitWarnPercent)
+	return limit
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultListName names the list every user starts with.
+	defaultListName = "My Items"
+	// maxListNameLength caps list names, in characters.
+	maxListNameLength = 50
+	// maxLists caps how many lists one user can have.
+	maxLists = 50
+)
+
+// AfterCreate gives every new account its default list, in the same
+// transaction as the account, so signup, invitations, admin-created and
+// demo accounts all start with one.
+func (u *User) AfterCreate(tx *gorm.DB) error {
+	return tx.Create(&List{UserID: u.ID, Name: defaultListName, IsDefault: true, CreatedAt: time.Now()}).Error
+}
+
+// createDefaultLists gives each account from before lists existed a default
+// list and moves their items into it. It runs at every startup and only
+// touches accounts without a default list.
+func createDefaultLists(db *gorm.DB) error {
+	var userIDs []uint
+	err := db.Model(&User{}).
+		Where("id NOT IN (SELECT user_id FROM lists WHERE is_default)").
+		Pluck("id", &userIDs).Error
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			list := List{UserID: userID, Name: defaultListName, IsDefault: true, CreatedAt: time.Now()}
+			if err := tx.Create(&list).Error; err != nil {
+				return err
+			}
+			return tx.Model(&Item{}).
+				Where("user_id = ? AND (list_id IS NULL OR list_id = 0)", userID).
+				Update("list_id", list.ID).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultListID returns the ID of the user's default list, creating the
+// list if the account somehow has none.
+func (app *App) defaultListID(userID interface{}) (uint, error) {
+	list := List{UserID: toUint(userID), Name: defaultListName, IsDefault: true, CreatedAt: time.Now()}
+	err := app.db.Where("user_id = ? AND is_default", userID).Attrs(list).FirstOrCreate(&list).Error
+	return list.ID, err
+}
+
+// inList scopes an Item query to one list. 0 doesn't filter.
+func inList(listID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if listID == 0 {
+			return db
+		}
+		return db.Where("items.list_id = ?", listID)
+	}
+}
+
+// userLists returns the user's lists, the default list first and the rest
+// by name.
+func (app *App) userLists(userID interface{}) []List {
+	var lists []List
+	app.db.Where("user_id = ?", userID).Order("is_default DESC, name COLLATE NOCASE, id").Find(&lists)
+	return lists
+}
+
+// setItemList moves item to the list with the given ID, which must belong
+// to the item's owner; 0 means their default list.
+func (app *App) setItemList(item *Item, id uint) error {
+	if id == 0 {
+		listID, err := app.defaultListID(item.UserID)
+		if err != nil {
+			return err
+		}
+		item.ListID = listID
+		return nil
+	}
+	var list List
+	if err := app.db.Where("id = ? AND user_id = ?", id, item.UserID).First(&list).Error; err != nil {
+		return errors.New("List not found")
+	}
+	item.ListID = list.ID
+	item.List = &list
+	return nil
+}
+
+// parseListID reads a list ID form value; an empty value is 0.
+func parseListID(value string) (uint, error) {
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, errors.New("List not found")
+	}
+	return uint(id), nil
+}
+
+// routeList returns the list a /lists/{id}/items request is for, or the
+// list chosen by the "list" form value on the other item routes (0 for
+// every list). A list in the route must be one of the user's, or the
+// request is answered with 404.
+func (app *App) routeList(w http.ResponseWriter, r *http.Request, userID interface{}) (uint, bool) {
+	id, ok := mux.Vars(r)["list"]
+	if !ok {
+		listID, _ := parseListID(r.FormValue("list"))
+		return listID, true
+	}
+	var list List
+	if err := app.db.Where("id = ? AND user_id = ?", id, userID).First(&list).Error; err != nil {
+		writeItemNotFound(w, r)
+		return 0, false
+	}
+	return list.ID, true
+}
+
+// listResponse is the JSON form of a list.
+type listResponse struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	IsDefault bool      `json:"is_default"`
+	ItemCount int64     `json:"item_count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// listSummaries returns the user's lists in userLists order with how many
+// items are in each.
+func (app *App) listSummaries(userID interface{}) []listResponse {
+	summaries := []listResponse{}
+	app.db.Model(&List{}).
+		Select("lists.id, lists.name, lists.is_default, lists.created_at, COUNT(items.id) AS item_count").
+		Joins("LEFT JOIN items ON items.list_id = lists.id").
+		Where("lists.user_id = ?", userID).
+		Group("lists.id, lists.name, lists.is_default, lists.created_at").
+		Order("lists.is_default DESC, lists.name COLLATE NOCASE, lists.id").
+		Scan(&summaries)
+	return summaries
+}
+
+// cleanListName applies the item name policy to a list name.
+func cleanListName(name, policy string) (string, error) {
+	name, err := sanitizeItemName(name, policy)
+	if err != nil {
+		return "", errors.New("List names cannot contain control or invisible formatting characters")
+	}
+	if name == "" {
+		return "", errors.New("List name cannot be empty")
+	}
+	if utf8.RuneCountInString(name) > maxListNameLength {
+		return "", fmt.Errorf("List names can be at most %d characters", maxListNameLength)
+	}
+	return name, nil
+}
+
+// listsHandler shows the user's lists: the lists.templ fragment, or JSON
+// {"lists": [...]}.
+func (app *App) listsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	if respondJSON(r) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"lists": app.listSummaries(userID)})
+		return
+	}
+	app.renderLists(w, userID, nil)
+}
+
+// listOptionsHandler returns the <option> elements of the items view's
+// list filter, with the list named by "list" selected.
+func (app *App) listOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	app.tmpl.ExecuteTemplate(w, "list_options.templ", map[string]interface{}{
+		"Lists":    app.userLists(userID),
+		"Selected": r.FormValue("list"),
+	})
+}
+
+// renderLists renders the list management fragment for userID, merging
+// data into the template data.
+func (app *App) renderLists(w http.ResponseWriter, userID interface{}, data map[string]interface{}) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data["Lists"] = app.listSummaries(userID)
+	app.tmpl.ExecuteTemplate(w, "lists.templ", data)
+}
+
+// writeListError reports a rejected list change: JSON clients get status
+// and the message, the browser gets the lists with the message.
+func (app *App) writeListError(w http.ResponseWriter, r *http.Request, userID interface{}, status int, message string) {
+	if respondJSON(r) {
+		writeJSONError(w, status, message)
+		return
+	}
+	app.renderLists(w, userID, map[string]interface{}{"Error": message})
+}
+
+// listNameTaken reports whether the user has another list with name,
+// ignoring case.
+func (app *App) listNameTaken(userID interface{}, name string, exceptID uint) bool {
+	var count int64
+	app.db.Model(&List{}).Where("user_id = ? AND LOWER(name) = LOWER(?) AND id <> ?", userID, name, exceptID).Count(&count)
+	return count > 0
+}
+
+// createListHandler adds a list from the "name" form value.
+func (app *App) createListHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	name, err := cleanListName(r.FormValue("name"), app.config.ItemNamePolicy)
+	if err != nil {
+		app.writeListError(w, r, userID, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	var count int64
+	app.db.Model(&List{}).Where("user_id = ?", userID).Count(&count)
+	if count >= maxLists {
+		app.writeListError(w, r, userID, http.StatusForbidden, fmt.Sprintf("You can have at most %d lists", maxLists))
+		return
+	}
+	if app.listNameTaken(userID, name, 0) {
+		app.writeListError(w, r, userID, http.StatusConflict, fmt.Sprintf("You already have a list named %q", name))
+		return
+	}
+
+	list := List{UserID: toUint(userID), Name: name, CreatedAt: time.Now()}
+	if err := app.db.Create(&list).Error; err != nil {
+		log.Println("Error creating list:", err)
+		writeServerError(w)
+		return
+	}
+	w.Header().Set("HX-Trigger", "listsChanged")
+	if respondJSON(r) {
+		w.Header().Set("Location", fmt.Sprintf("/lists/%d/items", list.ID))
+		writeJSON(w, http.StatusCreated, listResponse{ID: list.ID, Name: list.Name, CreatedAt: list.CreatedAt})
+		return
+	}
+	app.renderLists(w, userID, nil)
+}
+
+// findList loads one of the user's lists by the route's id, writing a 404
+// when there is none.
+func (app *App) findList(w http.ResponseWriter, r *http.Request, userID interface{}) (List, bool) {
+	var list List
+	if err := app.db.Where("id = ? AND user_id = ?", mux.Vars(r)["list"], userID).First(&list).Error; err != nil {
+		app.writeListError(w, r, userID, http.StatusNotFound, "List not found")
+		return list, false
+	}
+	return list, true
+}
+
+// renameListHandler renames one of the user's lists from the "name" form
+// value.
+func (app *App) renameListHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	list, ok := app.findList(w, r, userID)
+	if !ok {
+		return
+	}
+	name, err := cleanListName(r.FormValue("name"), app.config.ItemNamePolicy)
+	if err != nil {
+		app.writeListError(w, r, userID, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if name != list.Name {
+		if app.listNameTaken(userID, name, list.ID) {
+			app.writeListError(w, r, userID, http.StatusConflict, fmt.Sprintf("You already have a list named %q", name))
+			return
+		}
+		if err := app.db.Model(&list).Update("name", name).Error; err != nil {
+			log.Println("Error renaming list:", err)
+			writeServerError(w)
+			return
+		}
+		w.Header().Set("HX-Trigger", "listsChanged")
+	}
+	if respondJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	app.renderLists(w, userID, nil)
+}
+
+// deleteListHandler deletes one of the user's lists other than the
+// default one. Its items are moved to the default list rather than
+// deleted.
+func (app *App) deleteListHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	list, ok := app.findList(w, r, userID)
+	if !ok {
+		return
+	}
+	if list.IsDefault {
+		app.writeListError(w, r, userID, http.StatusConflict, "The default list can't be deleted")
+		return
+	}
+	defaultID, err := app.defaultListID(userID)
+	if err == nil {
+		err = app.db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Model(&Item{}).Where("list_id = ?", list.ID).
+				Updates(map[string]interface{}{"list_id": defaultID, "updated_at": time.Now()}).Error
+			if err != nil {
+				return err
+			}
+			return tx.Delete(&list).Error
+		})
+	}
+	if err != nil {
+		log.Println("Error deleting list:", err)
+		writeServerError(w)
+		return
+	}
+	app.touchItems(userID)
+	w.Header().Set("HX-Trigger", "itemsChanged, listsChanged")
+	if respondJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	app.renderLists(w, userID, nil)
+}
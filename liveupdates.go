@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// maxLiveConnections caps the open /ws connections per user; further
+	// connections are refused until one closes.
+	maxLiveConnections = 10
+
+	// livePingInterval is how often idle connections are pinged; a peer
+	// that doesn't answer within livePongTimeout is dropped.
+	livePingInterval = 30 * time.Second
+	livePongTimeout  = 60 * time.Second
+)
+
+// liveUpgrader upgrades /ws requests. Its default origin check refuses
+// pages on other sites, which would otherwise ride on the session cookie.
+var liveUpgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+
+// liveClient is one open /ws connection. Events are queued on send and
+// written by the connection's own goroutine.
+type liveClient struct {
+	send chan []byte
+}
+
+// liveHub tracks the open /ws connections of each user and broadcasts item
+// events to them.
+type liveHub struct {
+	mu      sync.Mutex
+	clients map[uint]map[*liveClient]bool
+}
+
+func newLiveHub() *liveHub {
+	return &liveHub{clients: map[uint]map[*liveClient]bool{}}
+}
+
+// register adds a connection for userID, or returns nil when the user
+// already has maxLiveConnections open.
+func (h *liveHub) register(userID uint) *liveClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.clients[userID]) >= maxLiveConnections {
+		return nil
+	}
+	if h.clients[userID] == nil {
+		h.clients[userID] = map[*liveClient]bool{}
+	}
+	c := &liveClient{send: make(chan []byte, 16)}
+	h.clients[userID][c] = true
+	return c
+}
+
+// unregister removes a connection and closes its send channel. It is safe
+// to call more than once.
+func (h *liveHub) unregister(userID uint, c *liveClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.clients[userID][c] {
+		return
+	}
+	delete(h.clients[userID], c)
+	if len(h.clients[userID]) == 0 {
+		delete(h.clients, userID)
+	}
+	close(c.send)
+}
+
+// broadcast sends an item event to every connection of the item's owner.
+// A connection too slow to keep up is dropped; the browser reconnects and
+// reloads the list.
+func (h *liveHub) broadcast(event string, item Item) {
+	msg, err := json.Marshal(webhookPayload{Event: event, Item: newItemResponse(item), Timestamp: time.Now().UTC()})
+	if err != nil {
+		log.Println("Error encoding live update:", err)
+		return
+	}
+	h.mu.Lock()
+	var slow []*liveClient
+	for c := range h.clients[item.UserID] {
+		select {
+		case c.send <- msg:
+		default:
+			slow = append(slow, c)
+		}
+	}
+	h.mu.Unlock()
+	for _, c := range slow {
+		h.unregister(item.UserID, c)
+	}
+}
+
+// notifyItem reports an item change to the owner's webhook and to their
+// open browsers. Every handler that creates, updates or deletes an item
+// calls it after the change is saved.
+func (app *App) notifyItem(event string, item Item) {
+	if item.Tags == nil {
+		app.loadItemTags(&item)
+	}
+	app.webhooks.notify(event, item)
+	app.live.broadcast(event, item)
+}
+
+// liveUpdatesHandler upgrades to a WebSocket that streams the user's item
+// events as JSON, in the same shape as webhook payloads. Messages from the
+// browser are ignored.
+func (app *App) liveUpdatesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	client := app.live.register(user.ID)
+	if client == nil {
+		http.Error(w, "Too many open connections", http.StatusTooManyRequests)
+		return
+	}
+	conn, err := liveUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written the error response
+		app.live.unregister(user.ID, client)
+		return
+	}
+
+	go app.writeLiveUpdates(conn, client)
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(livePongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(livePongTimeout))
+	})
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	app.live.unregister(user.ID, client)
+}
+
+// writeLiveUpdates writes the client's queued events and periodic pings
+// until its send channel is closed or a write fails.
+func (app *App) writeLiveUpdates(conn *websocket.Conn, client *liveClient) {
+	ticker := time.NewTicker(livePingInterval)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+	for {
+		select {
+		case msg, ok := <-client.send:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// lockedOutMessage is shown instead of checking the password while an
+// account is locked.
+func lockedOutMessage(remaining time.Duration) string {
+	minutes := int(math.Ceil(remaining.Minutes()))
+	if minutes <= 1 {
+		return "This account is locked after too many failed sign-in attempts. Please try again in a minute."
+	}
+	return fmt.Sprintf("This account is locked after too many failed sign-in attempts. Please try again in %d minutes.", minutes)
+}
+
+// lockedAccount returns how much longer the account for email is locked, or
+// false if it isn't locked or doesn't exist.
+func (app *App) lockedAccount(email string, now time.Time) (User, time.Duration, bool) {
+	var user User
+	if app.config.LoginLockoutThreshold <= 0 {
+		return user, 0, false
+	}
+	if err := app.db.Where("email = ?", email).First(&user).Error; err != nil {
+		return user, 0, false
+	}
+	if user.LockedUntil == nil || !now.Before(*user.LockedUntil) {
+		return user, 0, false
+	}
+	return user, user.LockedUntil.Sub(now), true
+}
+
+// recordFailedLogin counts a failed password for the account with email and
+// locks it once LoginLockoutThreshold failures fall within
+// LoginLockoutWindow. Unknown emails are ignored. Unlike loginBackoff, the
+// count is per account and stored in the database, so it holds across
+// client IPs and restarts.
+func (app *App) recordFailedLogin(r *http.Request, email string, now time.Time) {
+	if app.config.LoginLockoutThreshold <= 0 {
+		return
+	}
+	var user User
+	if err := app.db.Where("email = ?", email).First(&user).Error; err != nil {
+		return
+	}
+
+	// Start a new window once the previous one has passed
+	windowStart := now.Add(-app.config.LoginLockoutWindow)
+	updates := map[string]interface{}{
+		"failed_logins":       gorm.Expr("CASE WHEN failed_logins_since IS NULL OR failed_logins_since < ? THEN 1 ELSE failed_logins + 1 END", windowStart),
+		"failed_logins_since": gorm.Expr("CASE WHEN failed_logins_since IS NULL OR failed_logins_since < ? THEN ? ELSE failed_logins_since END", windowStart, now),
+	}
+	if err := app.db.Model(&user).Updates(updates).Error; err != nil {
+		log.Println("Error recording failed login:", err)
+		return
+	}
+
+	// Lock once, by the request that reaches the threshold
+	lockedUntil := now.Add(app.config.LoginLockoutDuration)
+	lock := app.db.Model(&User{}).Where("id = ? AND failed_logins >= ?", user.ID, app.config.LoginLockoutThreshold).Updates(map[string]interface{}{
+		"locked_until":        lockedUntil,
+		"failed_logins":       0,
+		"failed_logins_since": nil,
+	})
+	if lock.Error != nil {
+		log.Println("Error locking account:", lock.Error)
+		return
+	}
+	if lock.RowsAffected > 0 {
+		app.authEvents.log(r, AuthEventLockout, AuthOutcomeLocked, user, "")
+	}
+}
+
+// clearFailedLogins resets the failure count after a successful login.
+func (app *App) clearFailedLogins(user User) {
+	if user.FailedLogins == 0 && user.FailedLoginsSince == nil {
+		return
+	}
+	app.db.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"failed_logins":       0,
+		"failed_logins_since": nil,
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginBackoffWindow is how long a run of failed logins is remembered.
+const loginBackoffWindow = 15 * time.Minute
+
+// loginBackoff slows down repeated failed logins. Every consecutive failure
+// for the same email and client IP doubles the delay before the next attempt
+// is answered, up to max. A successful login resets it.
+type loginBackoff struct {
+	base time.Duration
+	max  time.Duration
+
+	mu       sync.Mutex
+	failures map[string]loginFailures
+}
+
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+func newLoginBackoff(base, max time.Duration) *loginBackoff {
+	return &loginBackoff{
+		base:     base,
+		max:      max,
+		failures: make(map[string]loginFailures),
+	}
+}
+
+// loginBackoffKey identifies a login attempt by email and client IP.
+func loginBackoffKey(email, ip string) string {
+	return strings.ToLower(strings.TrimSpace(email)) + "|" + ip
+}
+
+// delay returns how long to wait before answering the next attempt for key.
+func (b *loginBackoff) delay(key string, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f, ok := b.failures[key]
+	if !ok || b.base <= 0 {
+		return 0
+	}
+	if now.Sub(f.last) > loginBackoffWindow {
+		delete(b.failures, key)
+		return 0
+	}
+
+	d := b.base
+	for i := 1; i < f.count && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	return d
+}
+
+// fail records a failed attempt for key.
+func (b *loginBackoff) fail(key string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Forget stale entries now and then so the map can't grow forever
+	if len(b.failures) > 10000 {
+		for k, f := range b.failures {
+			if now.Sub(f.last) > loginBackoffWindow {
+				delete(b.failures, k)
+			}
+		}
+	}
+
+	f := b.failures[key]
+	b.failures[key] = loginFailures{count: f.count + 1, last: now}
+}
+
+// reset clears the failures for key after a successful login.
+func (b *loginBackoff) reset(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.failures, key)
+}
+
+// sleepContext waits for d, returning early with the context's error if ctx
+// is cancelled first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginBackoffDelay(t *testing.T) {
+	b := newLoginBackoff(500*time.Millisecond, 5*time.Second)
+	now := time.Now()
+	key := loginBackoffKey("Alice@example.com ", "192.0.2.1")
+
+	if d := b.delay(key, now); d != 0 {
+		t.Errorf("delay before any failure = %v, want 0", d)
+	}
+	for _, want := range []time.Duration{
+		500 * time.Millisecond,
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		5 * time.Second, // capped
+		5 * time.Second,
+	} {
+		b.fail(key, now)
+		if d := b.delay(key, now); d != want {
+			t.Errorf("delay = %v, want %v", d, want)
+		}
+	}
+
+	// Keys are per email and IP, ignoring case and spaces in the email
+	if d := b.delay(loginBackoffKey("alice@example.com", "192.0.2.1"), now); d != 5*time.Second {
+		t.Errorf("delay for the same email in lowercase = %v, want 5s", d)
+	}
+	if d := b.delay(loginBackoffKey("alice@example.com", "192.0.2.2"), now); d != 0 {
+		t.Errorf("delay from another IP = %v, want 0", d)
+	}
+	if d := b.delay(loginBackoffKey("bob@example.com", "192.0.2.1"), now); d != 0 {
+		t.Errorf("delay for another email = %v, want 0", d)
+	}
+
+	// Failures are forgotten after the window and on a successful login
+	if d := b.delay(key, now.Add(loginBackoffWindow+time.Second)); d != 0 {
+		t.Errorf("delay after the window = %v, want 0", d)
+	}
+	b.fail(key, now)
+	b.fail(key, now)
+	b.reset(key)
+	if d := b.delay(key, now); d != 0 {
+		t.Errorf("delay after a reset = %v, want 0", d)
+	}
+}
+
+func TestLoginBackoffDisabled(t *testing.T) {
+	b := newLoginBackoff(0, 0)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		b.fail("key", now)
+	}
+	if d := b.delay("key", now); d != 0 {
+		t.Errorf("delay with no base = %v, want 0", d)
+	}
+}
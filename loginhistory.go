@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// loginHistoryLimit is how many sign-in attempts the recent activity list
+// shows.
+const loginHistoryLimit = 20
+
+// loginHistoryRetention is how long sign-in attempts are kept.
+const loginHistoryRetention = 90 * 24 * time.Hour
+
+// Result describes the outcome of the sign-in attempt for its user.
+func (e LoginEvent) Result() string {
+	switch e.Outcome {
+	case AuthOutcomeSuccess:
+		return "Signed in"
+	case AuthOutcomeLocked:
+		return "Blocked: account locked"
+	case AuthOutcomeDisabled:
+		return "Blocked: account disabled"
+	}
+	return "Failed"
+}
+
+// Device describes the browser and operating system the attempt came from.
+func (e LoginEvent) Device() string {
+	return describeUserAgent(e.UserAgent)
+}
+
+// logLogin records a sign-in attempt in the auth event log and, when the
+// account exists, in the user's login history. user may be the zero User,
+// in which case email identifies the attempt.
+func (app *App) logLogin(r *http.Request, outcome string, user User, email string) {
+	app.authEvents.log(r, AuthEventLogin, outcome, user, email)
+	userID := user.ID
+	if userID == 0 && email != "" {
+		// A wrong password for an existing account
+		app.db.Model(&User{}).Where("email = ?", email).Pluck("id", &userID)
+	}
+	if userID == 0 {
+		return
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	event := LoginEvent{
+		UserID:    userID,
+		IP:        clientIP(r),
+		UserAgent: userAgent,
+		Outcome:   outcome,
+	}
+	if err := app.db.Create(&event).Error; err != nil {
+		log.Println("Error recording login:", err)
+	}
+}
+
+// loginActivityHandler returns the signed-in user's recent sign-in
+// attempts, newest first, so they can spot ones that weren't theirs.
+func (app *App) loginActivityHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	var events []LoginEvent
+	app.db.Where("user_id = ?", user.ID).Order("created_at desc, id desc").Limit(loginHistoryLimit).Find(&events)
+	app.tmpl.ExecuteTemplate(w, "login_activity.templ", map[string]interface{}{
+		"Events": events,
+	})
+}
+
+// startLoginHistoryCleanup deletes sign-in attempts older than
+// loginHistoryRetention every hour.
+func (app *App) startLoginHistoryCleanup() {
+	go func() {
+		for range time.Tick(time.Hour) {
+			app.deleteOldLoginEvents(time.Now())
+		}
+	}()
+}
+
+func (app *App) deleteOldLoginEvents(now time.Time) {
+	if err := app.db.Where("created_at < ?", now.Add(-loginHistoryRetention)).Delete(&LoginEvent{}).Error; err != nil {
+		log.Println("Error deleting old login history:", err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// magicLinksPerHour limits sign-in link requests per IP and per email
+// address.
+const magicLinksPerHour = 5
+
+const magicLinkSentMessage = "If an account exists for that email, we've sent you a sign-in link."
+
+// requestMagicLinkHandler emails a single-use sign-in link to the "email"
+// form value. Like a password reset request, the response doesn't reveal
+// whether the account exists.
+func (app *App) requestMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config.MagicLinkEnabled {
+		app.notFoundHandler(w, r)
+		return
+	}
+	email := strings.TrimSpace(r.FormValue("email"))
+	data := map[string]interface{}{"Email": email}
+
+	now := time.Now()
+	ipLimit := app.limiter.allow("magic-link:ip:"+clientIP(r), magicLinksPerHour, time.Hour, now)
+	if !ipLimit.Allowed {
+		setRateLimitHeaders(w, ipLimit, now)
+		w.WriteHeader(http.StatusTooManyRequests)
+		data["Error"] = "Too many sign-in link requests. Please try again later."
+		app.tmpl.ExecuteTemplate(w, "login.templ", data)
+		return
+	}
+	data["Success"] = magicLinkSentMessage
+
+	emailLimit := app.limiter.allow("magic-link:email:"+strings.ToLower(email), magicLinksPerHour, time.Hour, now)
+	var user User
+	if !emailLimit.Allowed || app.db.Where("email = ?", email).First(&user).Error != nil || user.Disabled || user.Demo {
+		app.tmpl.ExecuteTemplate(w, "login.templ", data)
+		return
+	}
+
+	token, err := app.createMagicLink(MagicLink{UserID: user.ID}, now)
+	if err != nil {
+		log.Println("Error creating magic link:", err)
+		writeServerError(w)
+		return
+	}
+	link := app.config.BaseURL + "/login/magic?token=" + url.QueryEscape(token)
+	go func() {
+		body := fmt.Sprintf("Someone asked for a link to sign in as %s.\n\n"+
+			"Open this link within %s to sign in. It works only once:\n%s\n\n"+
+			"If it wasn't you, you can ignore this email.\n",
+			user.Email, app.config.MagicLinkTTL, link)
+		if err := app.mailer.Send(user.Email, "Your sign-in link", body); err != nil {
+			log.Println("Error sending magic link email:", err)
+		}
+	}()
+
+	app.tmpl.ExecuteTemplate(w, "login.templ", data)
+}
+
+// magicLinkLoginHandler signs in the user behind a valid link from
+// requestMagicLinkHandler or confirmExternalLogin, connecting the external
+// sign-in for the latter. The link is used up and, since opening it proves
+// the user owns the address, their email counts as verified. Links that
+// connect an external sign-in work even with MAGIC_LINK_ENABLED off.
+func (app *App) magicLinkLoginHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	var link MagicLink
+	query := app.db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(r.URL.Query().Get("token")), now)
+	if !app.config.MagicLinkEnabled {
+		query = query.Where("provider <> ''")
+	}
+	err := query.First(&link).Error
+	if err != nil {
+		app.renderLoginPage(w, r, http.StatusBadRequest, "This sign-in link is invalid or has expired. Please request a new one.")
+		return
+	}
+
+	// Claim the link first so two concurrent requests can't both use it
+	claim := app.db.Model(&MagicLink{}).Where("id = ? AND used_at IS NULL", link.ID).Update("used_at", now)
+	if claim.Error != nil {
+		log.Println("Error claiming magic link:", claim.Error)
+		writeServerError(w)
+		return
+	}
+	if claim.RowsAffected == 0 {
+		app.renderLoginPage(w, r, http.StatusBadRequest, "This sign-in link has already been used. Please request a new one.")
+		return
+	}
+
+	var user User
+	if err := app.db.First(&user, link.UserID).Error; err != nil {
+		app.renderLoginPage(w, r, http.StatusBadRequest, "This sign-in link is invalid or has expired. Please request a new one.")
+		return
+	}
+	if !user.Verified {
+		if err := app.db.Model(&user).Update("verified", true).Error; err != nil {
+			log.Println("Error verifying email:", err)
+			writeServerError(w)
+			return
+		}
+	}
+	if link.Provider != "" {
+		login := ExternalLogin{UserID: user.ID}
+		err := app.db.Where(ExternalLogin{Provider: link.Provider, Subject: link.Subject}).FirstOrCreate(&login).Error
+		if err != nil {
+			log.Println("Error connecting external login:", err)
+			writeServerError(w)
+			return
+		}
+		if login.UserID != user.ID {
+			app.renderLoginPage(w, r, http.StatusConflict, "That sign-in is already connected to another account.")
+			return
+		}
+	}
+
+	session, _ := app.store.Get(r, "session")
+	app.startExternalLogin(w, r, session, user)
+}
+
+// createMagicLink stores link with a new sign-in token and returns the
+// token. Only its hash is kept in the database.
+func (app *App) createMagicLink(link MagicLink, now time.Time) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	link.TokenHash = hashToken(token)
+	link.ExpiresAt = now.Add(app.config.MagicLinkTTL)
+	if err := app.db.Create(&link).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
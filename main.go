@@ -1,315 +1,1107 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
+	"math"
 	"net/http"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/crewjam/saml"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 // Models
 type User struct {
-	ID           uint      `gorm:"primaryKey"`
-	Email        string    `gorm:"unique;not null"`
-	PasswordHash string    `gorm:"not null"`
-	CreatedAt    time.Time
+	ID                 uint   `gorm:"primaryKey"`
+	Email              string `gorm:"unique;not null"`
+	PasswordHash       string `gorm:"not null"`
+	Role               string `gorm:"not null;default:user"`
+	MustChangePassword bool   `gorm:"not null;default:false"`
+	Disabled           bool   `gorm:"not null;default:false"`
+	Verified           bool   `gorm:"not null;default:false"` // email address confirmed
+	PendingEmail       string // new address waiting for confirmation
+	TOTPSecret         string `gorm:"serializer:encrypted"` // set while two-factor auth is being set up or on
+	TOTPEnabled        bool   `gorm:"not null;default:false"`
+	TOTPLastStep       int64  // last accepted TOTP time step, so codes can't be replayed
+	SessionVersion     uint   `gorm:"not null;default:0"` // bumped to end every other session, e.g. on a password change
+	FailedLogins       int    `gorm:"not null;default:0"` // failed passwords since FailedLoginsSince
+	FailedLoginsSince  *time.Time
+	LockedUntil        *time.Time // password sign-in is refused until then
+	OrgID              *uint      `gorm:"index"` // only used in multi-tenant mode
+	Demo               bool       `gorm:"not null;default:false"`
+	Currency           string     `gorm:"not null;default:USD"`
+	ExpiresAt          *time.Time `gorm:"index"` // demo accounts are deleted after this
+	ItemsChangedAt     *time.Time
+	CreatedAt          time.Time
+}
+
+type Organization struct {
+	ID        uint   `gorm:"primaryKey"`
+	Name      string `gorm:"unique;not null"`
+	CreatedAt time.Time
 }
 
 type Item struct {
+	ID          uint       `gorm:"primaryKey"`
+	UserID      uint       `gorm:"not null;index"`
+	OrgID       *uint      `gorm:"index"` // owner's organization in multi-tenant mode
+	ListID      uint       `gorm:"index"`
+	CategoryID  *uint      `gorm:"index"`
+	Name        string     `gorm:"not null"`
+	Description string     `gorm:"serializer:encrypted"` // encrypted at rest when FIELD_ENCRYPTION_KEY is set
+	Notes       string     `gorm:"serializer:encrypted"` // Markdown; encrypted like Description
+	Status      string     `gorm:"not null;default:active;index"`
+	Quantity    int        `gorm:"not null;default:1"`
+	ValueCents  int64      `gorm:"not null;default:0"` // value of one unit, in hundredths of the owner's currency
+	Priority    string     `gorm:"not null;default:normal;index"`
+	Position    int        `gorm:"not null;default:0;index"` // place in the user's manual order; 0 until moved
+	Pinned      bool       `gorm:"not null;default:false;index"`
+	Color       string     `gorm:"not null;default:''"` // one of itemColors, or "" for none
+	DueAt       *time.Time `gorm:"index"`               // in UTC
+	Completed   bool       `gorm:"not null;default:false;index"`
+	CompletedAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	User        User      `gorm:"foreignKey:UserID"`
+	List        *List     `gorm:"foreignKey:ListID"`
+	Category    *Category `gorm:"foreignKey:CategoryID"`
+	Tags        []string  `gorm:"-"` // tag names, filled in by loadTags
+}
+
+// List is one of a user's independent item lists, such as "Groceries" or
+// "Work". Every user has a default list, which new items go to unless
+// another list is chosen.
+type List struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	Name      string `gorm:"not null"`
+	IsDefault bool   `gorm:"not null;default:false"`
+	CreatedAt time.Time
+}
+
+// Category is a folder a user files their items in. An item is in at
+// most one category.
+type Category struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	Name      string `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+// Tag is a label a user attaches to their items. Names are lowercase and
+// unique per user.
+type Tag struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;uniqueIndex:idx_tags_user_name"`
+	Name      string `gorm:"not null;uniqueIndex:idx_tags_user_name"`
+	CreatedAt time.Time
+}
+
+// ItemTag attaches a tag to an item.
+type ItemTag struct {
+	ItemID uint `gorm:"primaryKey;autoIncrement:false"`
+	TagID  uint `gorm:"primaryKey;autoIncrement:false;index"`
+	UserID uint `gorm:"not null;index"`
+}
+
+type UserToken struct {
+	ID           uint   `gorm:"primaryKey"`
+	UserID       uint   `gorm:"not null;index"`
+	Name         string `gorm:"not null"`
+	TokenHash    string `gorm:"unique;not null"`
+	Prefix       string `gorm:"not null"`
+	RateLimit    int    `gorm:"not null;default:0"` // requests per minute, 0 uses the default
+	RequestCount int64  `gorm:"not null;default:0"`
+	LastUsedAt   *time.Time
+	RevokedAt    *time.Time
+	CreatedAt    time.Time
+}
+
+type PasswordReset struct {
 	ID        uint      `gorm:"primaryKey"`
 	UserID    uint      `gorm:"not null;index"`
-	Name      string    `gorm:"not null"`
+	TokenHash string    `gorm:"unique;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
 	CreatedAt time.Time
-	User      User      `gorm:"foreignKey:UserID"`
 }
 
-// Global variables
-var (
-	db    *gorm.DB
-	store *sessions.CookieStore
-	tmpl  *template.Template
-)
+// Invitation is a single-use code an admin hands out to let someone register
+// while INVITE_ONLY is set. Only the code's hash is stored.
+type Invitation struct {
+	ID        uint      `gorm:"primaryKey"`
+	CodeHash  string    `gorm:"unique;not null"`
+	CreatedBy uint      `gorm:"not null;index"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	UsedBy    *uint // the user who registered with it
+	CreatedAt time.Time
+}
+
+type MagicLink struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	TokenHash string    `gorm:"unique;not null"`
+	Provider  string    `gorm:"not null;default:''"` // with Subject, the external sign-in the link connects, if any
+	Subject   string    `gorm:"not null;default:''"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// ExternalLogin connects a user's account at an OAuth or OpenID Connect
+// provider, by the provider's stable subject ID, to their account here.
+type ExternalLogin struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	Provider  string `gorm:"not null;uniqueIndex:idx_external_login"`
+	Subject   string `gorm:"not null;uniqueIndex:idx_external_login"`
+	CreatedAt time.Time
+}
+
+type DataExport struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	Status    string    `gorm:"not null;default:pending"` // pending, ready or failed
+	ExpiresAt time.Time `gorm:"not null;index"`
+	CreatedAt time.Time
+}
+
+// IdempotencyKey remembers the response to a POST sent with an
+// Idempotency-Key so a retry gets the same response instead of repeating
+// the request. Status is 0 while the first request is still running.
+type IdempotencyKey struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"not null;uniqueIndex:idx_idempotency_keys_user_key"`
+	Key         string `gorm:"not null;uniqueIndex:idx_idempotency_keys_user_key"`
+	RequestHash string `gorm:"not null"`
+	Status      int    `gorm:"not null;default:0"`
+	ContentType string
+	Location    string
+	Body        []byte
+	ExpiresAt   time.Time `gorm:"not null;index"`
+	CreatedAt   time.Time
+}
+
+type UserSession struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"not null;index"`
+	TokenHash  string `gorm:"unique;not null"`
+	UserAgent  string
+	IP         string
+	LastSeenAt time.Time `gorm:"not null"`
+	RememberID *uint     // the remember-me token that started the session
+	ExpiresAt  time.Time `gorm:"not null;index"`
+	CreatedAt  time.Time
+}
+
+type RememberToken struct {
+	ID            uint   `gorm:"primaryKey"`
+	UserID        uint   `gorm:"not null;index"`
+	Series        string `gorm:"unique;not null"`
+	TokenHash     string `gorm:"not null"`
+	PrevTokenHash string
+	RotatedAt     time.Time `gorm:"not null"`
+	ExpiresAt     time.Time `gorm:"not null;index"`
+	CreatedAt     time.Time
+}
+
+// LoginEvent is one sign-in attempt on an account, shown to its user as
+// recent activity.
+type LoginEvent struct {
+	ID        uint `gorm:"primaryKey"`
+	UserID    uint `gorm:"not null;index"`
+	IP        string
+	UserAgent string
+	Outcome   string    `gorm:"not null"` // an AuthOutcome
+	CreatedAt time.Time `gorm:"index"`
+}
+
+type Webhook struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"unique;not null"`
+	URL       string `gorm:"not null"`
+	Secret    string `gorm:"not null"`
+	Events    string `gorm:"not null;default:''"` // comma-separated event types; empty sends all
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// IncomingHook lets an external system create items by POSTing JSON to a
+// secret URL. The *Field columns map JSON paths such as "alert.title" to
+// item fields; empty uses "name", "description" and "quantity".
+type IncomingHook struct {
+	ID               uint   `gorm:"primaryKey"`
+	UserID           uint   `gorm:"not null;index"`
+	Name             string `gorm:"not null"`
+	TokenHash        string `gorm:"unique;not null"`
+	Prefix           string `gorm:"not null"`
+	NameField        string
+	DescriptionField string
+	QuantityField    string
+	RequestCount     int64 `gorm:"not null;default:0"`
+	LastUsedAt       *time.Time
+	CreatedAt        time.Time
+}
+
+type RecentSearch struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	Term      string `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+type AuditLog struct {
+	ID           uint   `gorm:"primaryKey"`
+	ActorID      uint   `gorm:"not null;index"`
+	Action       string `gorm:"not null"`
+	TargetUserID uint   `gorm:"index"`
+	Detail       string // e.g. the request an admin made while impersonating
+	CreatedAt    time.Time
+}
+
+// App holds the dependencies shared by the handlers.
+type App struct {
+	config Config
+	db     *gorm.DB
+	store  sessions.Store
+	tmpl   *template.Template
+
+	limiter       rateLimitCounter
+	captcha       CaptchaVerifier
+	mailer        Mailer
+	authEvents    *authEventLogger
+	loginBackoff  *loginBackoff
+	webhooks      *webhookDispatcher
+	live          *liveHub
+	statsWatchers *statsWatchers
+	oauth         []*oauthProvider
+	oauthClient   *http.Client
+	saml          *saml.ServiceProvider // nil unless SAML is configured
+	authenticator Authenticator
+	passwords     PasswordHasher
+	breaches      BreachChecker
+}
 
 func main() {
-	// Initialize database
-	initDB()
-	
-	// Initialize session store
-	store = sessions.NewCookieStore([]byte("your-secret-key-change-in-production"))
-	store.Options = &sessions.Options{
-		Path:     "/",
-		MaxAge:   86400 * 7, // 7 days
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-	}
-	
-	// Parse templates with custom functions
-	var err error
-	funcMap := template.FuncMap{
-		"substr": func(s string, start, length int) string {
-			if start >= len(s) {
-				return ""
-			}
-			end := start + length
-			if end > len(s) {
-				end = len(s)
-			}
-			return s[start:end]
-		},
-		"upper": func(s string) string {
-			return strings.ToUpper(s)
-		},
-		"add": func(a, b int) int {
-			return a + b
-		},
-	}
-	tmpl = template.New("").Funcs(funcMap)
-	tmpl, err = tmpl.ParseGlob("templates/*.templ")
+	// Load configuration
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	app, err := newApp(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.SessionSecret == defaultSessionSecret {
+		log.Println("Warning: SESSION_SECRET is not set; sessions are signed with a public default key. Set it, and APP_ENV=production, before deploying")
+	}
+	if cfg.RegistrationEnabled && cfg.CaptchaProvider == CaptchaProviderNone {
+		log.Println("Warning: registration is enabled without CAPTCHA_PROVIDER; sign-ups are only rate limited")
+	}
+	if strings.HasPrefix(cfg.LDAPURL, "ldap://") && !cfg.LDAPStartTLS {
+		log.Println("Warning: LDAP_URL is not encrypted; passwords are sent to the directory in clear text. Use ldaps:// or LDAP_START_TLS")
+	}
+	if err := checkAssetDir(cfg.StaticDir, "*", "STATIC_DIR"); err != nil {
+		log.Printf("Warning: static files will not be served: %v", err)
+	}
+	app.startGRPCServer()
+	app.startDemoCleanup()
+	app.startDataExportCleanup()
+	app.startSessionCleanup()
+	app.startIdempotencyKeyCleanup()
+	app.startLoginHistoryCleanup()
+
+	scheme := "http"
+	if cfg.TLSCertFile != "" {
+		scheme = "https"
+	}
+	fmt.Printf("Server starting on %s://localhost:%s\n", scheme, cfg.Port)
+	fmt.Println("Login with: admin@example.com / Passw0rd!")
+	log.Fatal(serve(cfg, app.routes()))
+}
+
+// newApp builds an App and its dependencies from cfg. Setting DBPath to
+// ":memory:" gives a throwaway database, which together with routes makes
+// it possible to exercise handlers with net/http/httptest.
+func newApp(cfg Config) (*App, error) {
+	db, err := initDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := parseTemplates(cfg)
+	if err != nil {
+		return nil, err
+	}
+	sp, err := newSAMLServiceProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	store, err := newSessionStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	limiter, err := newRateLimitCounter(cfg)
 	if err != nil {
-		log.Fatal("Error parsing templates:", err)
+		return nil, err
 	}
-	
-	// Setup routes
+
+	app := &App{
+		config: cfg,
+		db:     db,
+		store:  store,
+		tmpl:   tmpl,
+
+		limiter:       limiter,
+		captcha:       newCaptchaVerifier(cfg),
+		mailer:        newMailer(cfg),
+		authEvents:    newAuthEventLogger(cfg.AuthEventLog),
+		loginBackoff:  newLoginBackoff(cfg.LoginBackoffBase, cfg.LoginBackoffMax),
+		webhooks:      newWebhookDispatcher(db),
+		live:          newLiveHub(),
+		statsWatchers: newStatsWatchers(),
+		oauth:         oauthProviders(cfg),
+		oauthClient:   &http.Client{Timeout: oauthTimeout},
+		saml:          sp,
+		passwords:     newPasswordHasher(cfg),
+		breaches:      newBreachChecker(cfg),
+	}
+	app.authenticator = newAuthenticator(cfg, app)
+	return app, nil
+}
+
+// routes returns the application's HTTP handler.
+func (app *App) routes() http.Handler {
+	// Read-only routes also answer HEAD so monitoring tools don't get a 405;
+	// net/http drops the body of HEAD responses
 	r := mux.NewRouter()
-	r.HandleFunc("/", homeHandler).Methods("GET")
-	r.HandleFunc("/login", loginHandler).Methods("POST")
-	r.HandleFunc("/logout", logoutHandler).Methods("POST")
-	r.HandleFunc("/items", itemsHandler).Methods("GET")
-	r.HandleFunc("/items", createItemHandler).Methods("POST")
-	r.HandleFunc("/items/{id}", deleteItemHandler).Methods("DELETE")
-	r.HandleFunc("/stats", statsHandler).Methods("GET")
-	
+	r.HandleFunc("/healthz", app.healthHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/", app.homeHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/login", app.loginHandler).Methods("POST")
+	r.HandleFunc("/login/2fa", app.loginTwoFactorHandler).Methods("POST")
+	r.HandleFunc("/login/magic", app.magicLinkLoginHandler).Methods("GET")
+	r.HandleFunc("/login/magic", app.requestMagicLinkHandler).Methods("POST")
+	r.HandleFunc("/webauthn/login/begin", app.beginPasskeyLoginHandler).Methods("POST")
+	r.HandleFunc("/webauthn/login/finish", app.finishPasskeyLoginHandler).Methods("POST")
+	r.HandleFunc("/auth/{provider}/login", app.oauthLoginHandler).Methods("GET")
+	r.HandleFunc("/auth/{provider}/callback", app.oauthCallbackHandler).Methods("GET")
+	r.HandleFunc("/saml/metadata", app.samlMetadataHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/saml/login", app.samlLoginHandler).Methods("GET")
+	r.HandleFunc("/saml/acs", app.samlACSHandler).Methods("POST")
+	r.HandleFunc("/logout", app.logoutHandler).Methods("POST")
+	r.HandleFunc("/logout/everywhere", app.logoutEverywhereHandler).Methods("POST")
+	r.HandleFunc("/impersonate/stop", app.stopImpersonationHandler).Methods("POST")
+	r.HandleFunc("/demo-login", app.demoLoginHandler).Methods("POST")
+	r.HandleFunc("/register", app.registerPageHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/register", app.registerHandler).Methods("POST")
+	r.HandleFunc("/account/password", app.passwordSettingsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/account/password", app.changePasswordHandler).Methods("POST")
+	r.HandleFunc("/password/reset", app.passwordResetPageHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/password/reset", app.requestPasswordResetHandler).Methods("POST")
+	r.HandleFunc("/password/reset/confirm", app.confirmPasswordResetHandler).Methods("POST")
+	r.HandleFunc("/verify-email", app.verifyEmailHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/account/verify/resend", app.resendVerificationHandler).Methods("POST")
+	r.HandleFunc("/account/email", app.emailSettingsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/account/email", app.changeEmailHandler).Methods("POST")
+	r.HandleFunc("/account/email", app.cancelEmailChangeHandler).Methods("DELETE")
+	r.HandleFunc("/account/email/confirm", app.confirmEmailChangeHandler).Methods("GET")
+	r.HandleFunc("/account/2fa", app.twoFactorHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/account/2fa/setup", app.setupTwoFactorHandler).Methods("POST")
+	r.HandleFunc("/account/2fa/confirm", app.confirmTwoFactorHandler).Methods("POST")
+	r.HandleFunc("/account/2fa/disable", app.disableTwoFactorHandler).Methods("POST")
+	r.HandleFunc("/account/passkeys", app.passkeysHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/account/passkeys/{id:[0-9]+}", app.deletePasskeyHandler).Methods("DELETE")
+	r.HandleFunc("/account/sessions", app.userSessionsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/account/sessions", app.revokeOtherUserSessionsHandler).Methods("DELETE")
+	r.HandleFunc("/account/sessions/{id:[0-9]+}", app.revokeUserSessionHandler).Methods("DELETE")
+	r.HandleFunc("/account/activity", app.loginActivityHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/webauthn/register/begin", app.beginPasskeyRegistrationHandler).Methods("POST")
+	r.HandleFunc("/webauthn/register/finish", app.finishPasskeyRegistrationHandler).Methods("POST")
+	r.HandleFunc("/account/delete", app.deleteAccountPageHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/account/delete", app.deleteAccountHandler).Methods("POST")
+	r.HandleFunc("/account/export", app.dataExportHandler).Methods("GET")
+	r.HandleFunc("/account/export/{id:[0-9]+}", app.dataExportStatusHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/account/export/{id:[0-9]+}/download", app.downloadDataExportHandler).Methods("GET")
+	r.HandleFunc("/account/tokens", app.tokensHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/account/tokens", app.createTokenHandler).Methods("POST")
+	r.HandleFunc("/account/tokens/{id:[0-9]+}", app.revokeTokenHandler).Methods("DELETE")
+	r.HandleFunc("/account/currency", app.currencySettingsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/account/currency", app.saveCurrencyHandler).Methods("POST")
+	r.HandleFunc("/account/webhook", app.webhookHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/account/webhook", app.saveWebhookHandler).Methods("POST")
+	r.HandleFunc("/account/webhook", app.deleteWebhookHandler).Methods("DELETE")
+	r.HandleFunc("/account/hooks", app.incomingHooksHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/account/hooks", app.createIncomingHookHandler).Methods("POST")
+	r.HandleFunc("/account/hooks/{id:[0-9]+}", app.deleteIncomingHookHandler).Methods("DELETE")
+	r.HandleFunc("/items", app.itemsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/ws", app.liveUpdatesHandler).Methods("GET")
+	r.HandleFunc("/items/recent-searches", app.recentSearchesHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/items/duplicates", app.duplicatesHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/items", app.idempotent(app.createItemHandler)).Methods("POST")
+	r.HandleFunc("/items/export", app.exportSelectedHandler).Methods("POST")
+	r.HandleFunc("/items/mark-all", app.markAllItemsHandler).Methods("POST")
+	r.HandleFunc("/items/reorder", app.reorderItemsHandler).Methods("POST")
+	r.HandleFunc("/items/{id:[0-9]+}", app.itemDetailHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/items/{id:[0-9]+}/row", app.itemRowHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/items/{id:[0-9]+}", app.updateItemHandler).Methods("PUT", "PATCH")
+	r.HandleFunc("/items/{id:[0-9]+}/edit", app.editItemHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/items/{id}", app.deleteItemHandler).Methods("DELETE")
+	r.HandleFunc("/items/{id}/archive", app.archiveItemHandler).Methods("POST")
+	r.HandleFunc("/items/{id}/unarchive", app.unarchiveItemHandler).Methods("POST")
+	r.HandleFunc("/items/{id}/clone", app.cloneItemHandler).Methods("POST")
+	r.HandleFunc("/items/{id:[0-9]+}/toggle", app.toggleItemHandler).Methods("POST")
+	r.HandleFunc("/items/{id:[0-9]+}/pin", app.pinItemHandler).Methods("POST")
+	r.HandleFunc("/items/{id}/increment", app.incrementItemHandler).Methods("POST")
+	r.HandleFunc("/items/{id}/decrement", app.decrementItemHandler).Methods("POST")
+	r.HandleFunc("/lists", app.listsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/lists/options", app.listOptionsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/lists", app.createListHandler).Methods("POST")
+	r.HandleFunc("/lists/{list:[0-9]+}", app.renameListHandler).Methods("PUT", "PATCH")
+	r.HandleFunc("/lists/{list:[0-9]+}", app.deleteListHandler).Methods("DELETE")
+	r.HandleFunc("/lists/{list:[0-9]+}/items", app.itemsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/lists/{list:[0-9]+}/items", app.idempotent(app.createItemHandler)).Methods("POST")
+	r.HandleFunc("/tags", app.tagsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/categories", app.categoriesHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/categories/options", app.categoryOptionsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/categories", app.createCategoryHandler).Methods("POST")
+	r.HandleFunc("/categories/{id:[0-9]+}", app.renameCategoryHandler).Methods("PUT", "PATCH")
+	r.HandleFunc("/categories/{id:[0-9]+}", app.deleteCategoryHandler).Methods("DELETE")
+	r.HandleFunc("/tags/{id:[0-9]+}", app.renameTagHandler).Methods("PUT", "PATCH")
+	r.HandleFunc("/tags/{id:[0-9]+}", app.deleteTagHandler).Methods("DELETE")
+	r.HandleFunc("/stats", app.statsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/stats/stream", app.statsStreamHandler).Methods("GET")
+	app.registerAPIRoutes(r)
+	r.HandleFunc("/graphql", app.graphQLHandler).Methods("GET", "POST")
+	r.HandleFunc("/graphql/schema", app.graphQLSchemaHandler).Methods("GET", "HEAD")
+
+	// Admin routes are wrapped one by one rather than put on a subrouter,
+	// which would answer a wrong method with 404 instead of 405
+	adminOnly := app.requireRole(RoleAdmin)
+	r.Handle("/admin/stats", adminOnly(http.HandlerFunc(app.adminStatsHandler))).Methods("GET", "HEAD")
+	r.Handle("/admin/users", adminOnly(http.HandlerFunc(app.adminUsersHandler))).Methods("GET", "HEAD")
+	r.Handle("/admin/invitations", adminOnly(http.HandlerFunc(app.adminCreateInvitationHandler))).Methods("POST")
+	r.Handle("/admin/users", adminOnly(http.HandlerFunc(app.adminCreateUserHandler))).Methods("POST")
+	r.Handle("/admin/users/list", adminOnly(http.HandlerFunc(app.adminUserListHandler))).Methods("GET", "HEAD")
+	r.Handle("/admin/users/{id:[0-9]+}", adminOnly(http.HandlerFunc(app.adminDeleteUserHandler))).Methods("DELETE")
+	r.Handle("/admin/users/{id}/impersonate", adminOnly(http.HandlerFunc(app.adminImpersonateHandler))).Methods("POST")
+	r.Handle("/admin/users/{id}/reset-password", adminOnly(http.HandlerFunc(app.adminResetPasswordHandler))).Methods("POST")
+	r.Handle("/admin/orgs", adminOnly(http.HandlerFunc(app.adminCreateOrgHandler))).Methods("POST")
+	r.Handle("/admin/users/{id}/org", adminOnly(http.HandlerFunc(app.adminAssignOrgHandler))).Methods("POST")
+	r.Handle("/admin/users/{id}/disable", adminOnly(http.HandlerFunc(app.adminDisableUserHandler))).Methods("POST")
+	r.Handle("/admin/users/{id}/enable", adminOnly(http.HandlerFunc(app.adminEnableUserHandler))).Methods("POST")
+
+	// Profiles exist only while DEBUG_PPROF is on, and only for admins
+	if app.config.DebugPprof {
+		r.PathPrefix("/debug/pprof/").Handler(adminOnly(pprofHandler()))
+	}
+
 	// Serve static files
-	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("static/"))))
-	
-	fmt.Println("Server starting on http://localhost:8082")
-	fmt.Println("Login with: admin@example.com / Passw0rd!")
-	log.Fatal(http.ListenAndServe(":8082", r))
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir(app.config.StaticDir))))
+
+	// Styled HTML or JSON errors instead of mux's plain-text defaults
+	r.Use(app.rateLimit, app.csrfProtect, app.rememberMe, app.rejectDisabledUsers, app.auditImpersonation)
+	r.NotFoundHandler = http.HandlerFunc(app.notFoundHandler)
+	r.MethodNotAllowedHandler = app.methodNotAllowedHandler(r)
+
+	// Rate limits and logs see the client behind a trusted proxy, not the
+	// proxy itself. CORS runs before routing so preflight requests, which
+	// match no route, are answered too.
+	return forwardedClientIP(app.config.TrustedProxies, app.apiCORS(r))
 }
 
-func initDB() {
-	var err error
-	db, err = gorm.Open(sqlite.Open("app.db"), &gorm.Config{})
+func initDB(cfg Config) (*gorm.DB, error) {
+	// Sensitive fields are encrypted when a key is configured
+	if err := registerEncryptedSerializer(cfg.FieldEncryptionKey); err != nil {
+		return nil, fmt.Errorf("invalid FIELD_ENCRYPTION_KEY: %w", err)
+	}
+
+	db, err := gorm.Open(sqlite.Open(cfg.DBPath), &gorm.Config{})
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
-	
+
+	// Every connection to ":memory:" opens a separate empty database, so
+	// keep a single connection for in-memory databases
+	if cfg.DBPath == ":memory:" {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	// Accounts that existed before email verification are treated as verified
+	backfillVerified := db.Migrator().HasTable(&User{}) && !db.Migrator().HasColumn(&User{}, "Verified")
+
 	// Auto migrate
-	db.AutoMigrate(&User{}, &Item{})
-	
+	if err := db.AutoMigrate(&User{}, &Organization{}, &Item{}, &UserToken{}, &WebAuthnCredential{}, &PasswordReset{}, &Invitation{}, &MagicLink{}, &ExternalLogin{}, &DataExport{}, &UserSession{}, &RememberToken{}, &LoginEvent{}, &Webhook{}, &IncomingHook{}, &IdempotencyKey{}, &RecentSearch{}, &AuditLog{}, &Tag{}, &ItemTag{}, &Category{}, &List{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	if backfillVerified {
+		if err := db.Model(&User{}).Where("1 = 1").Update("verified", true).Error; err != nil {
+			return nil, fmt.Errorf("failed to mark existing users verified: %w", err)
+		}
+	}
+	if err := createDefaultLists(db); err != nil {
+		return nil, fmt.Errorf("failed to create default lists: %w", err)
+	}
+
 	// Seed admin user if not exists
 	var user User
 	result := db.Where("email = ?", "admin@example.com").First(&user)
 	if result.Error == gorm.ErrRecordNotFound {
-		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("Passw0rd!"), bcrypt.DefaultCost)
+		hashedPassword, _ := newPasswordHasher(cfg).Hash("Passw0rd!")
 		adminUser := User{
 			Email:        "admin@example.com",
-			PasswordHash: string(hashedPassword),
+			PasswordHash: hashedPassword,
+			Role:         RoleAdmin,
+			Verified:     true,
 			CreatedAt:    time.Now(),
 		}
 		db.Create(&adminUser)
 		fmt.Println("Admin user created: admin@example.com / Passw0rd!")
 	}
+	return db, nil
+}
+
+// parseTemplates parses templates with custom functions.
+func parseTemplates(cfg Config) (*template.Template, error) {
+	funcMap := templateFuncs(cfg)
+	if err := checkTemplateFuncs(funcMap); err != nil {
+		return nil, fmt.Errorf("unsafe template function: %w", err)
+	}
+	if err := checkAssetDir(cfg.TemplatesDir, "*.templ", "TEMPLATES_DIR"); err != nil {
+		return nil, fmt.Errorf("templates: %w", err)
+	}
+	tmpl, err := template.New("").Funcs(funcMap).ParseGlob(filepath.Join(cfg.TemplatesDir, "*.templ"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing templates: %w", err)
+	}
+	return tmpl, nil
+}
+
+// healthHandler reports whether the app and its database are reachable.
+func (app *App) healthHandler(w http.ResponseWriter, r *http.Request) {
+	sqlDB, err := app.db.DB()
+	if err == nil {
+		err = sqlDB.PingContext(r.Context())
+	}
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
-func homeHandler(w http.ResponseWriter, r *http.Request) {
-	session, _ := store.Get(r, "session")
+// toUint converts a user ID taken from the session to a uint.
+func toUint(userID interface{}) uint {
+	uid, _ := strconv.ParseUint(fmt.Sprintf("%v", userID), 10, 32)
+	return uint(uid)
+}
+
+// writeServerError writes a generic 500 error fragment.
+func writeServerError(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte(`<div class="error">Something went wrong. Please try again.</div>`))
+}
+
+func (app *App) homeHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := app.store.Get(r, "session")
 	userID, ok := session.Values["user_id"]
-	
+
 	if ok && userID != nil {
 		// User is logged in, show dashboard
 		var user User
-		db.First(&user, userID)
+		app.db.First(&user, userID)
 		data := map[string]interface{}{
 			"User": user,
 		}
-		tmpl.ExecuteTemplate(w, "base.templ", map[string]interface{}{
-			"Content": "dashboard",
-			"Data":    data,
-		})
+		if admin, ok := app.impersonator(session); ok {
+			data["Impersonator"] = admin
+		}
+		app.renderPage(w, r, http.StatusOK, "dashboard", data)
+	} else if pending := pendingLoginStep(session); pending != "" {
+		// Sign-in through an identity provider stopped at a later step
+		app.renderPage(w, r, http.StatusOK, pending, map[string]interface{}{})
 	} else {
 		// User not logged in, show login
-		tmpl.ExecuteTemplate(w, "base.templ", map[string]interface{}{
-			"Content": "login",
-			"Data":    map[string]interface{}{},
-		})
+		app.renderPage(w, r, http.StatusOK, "login", map[string]interface{}{})
 	}
 }
 
-func loginHandler(w http.ResponseWriter, r *http.Request) {
+func (app *App) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.PasswordLoginDisabled {
+		w.WriteHeader(http.StatusForbidden)
+		app.tmpl.ExecuteTemplate(w, "login.templ", map[string]interface{}{
+			"Error": "Password sign-in is disabled. Please use single sign-on.",
+		})
+		return
+	}
+
 	email := r.FormValue("email")
 	password := r.FormValue("password")
-	
-	var user User
-	result := db.Where("email = ?", email).First(&user)
-	
-	if result.Error != nil || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+
+	// Cap attempts per IP, whichever accounts they target
+	now := time.Now()
+	if limit := app.limiter.allow("login:"+clientIP(r), app.config.LoginRateLimit, time.Minute, now); !limit.Allowed {
+		setRateLimitHeaders(w, limit, now)
+		w.WriteHeader(http.StatusTooManyRequests)
+		app.tmpl.ExecuteTemplate(w, "login.templ", map[string]interface{}{
+			"Error": fmt.Sprintf("Too many sign-in attempts from your network. Please wait %d seconds and try again.", int(math.Ceil(limit.RetryAfter.Seconds()))),
+			"Email": email,
+		})
+		return
+	}
+
+	// The CAPTCHA is checked before the credentials, so a script can't use
+	// the form to test passwords
+	if app.config.CaptchaOnLogin {
+		ok, err := app.captcha.Verify(captchaToken(r))
+		if err != nil {
+			log.Println("Error verifying captcha:", err)
+		}
+		if !ok {
+			app.logLogin(r, AuthOutcomeFailure, User{}, email)
+			app.tmpl.ExecuteTemplate(w, "login.templ", map[string]interface{}{
+				"Error": "Please complete the verification challenge",
+				"Email": email,
+			})
+			return
+		}
+	}
+
+	// Slow down repeated failures for this email and IP
+	backoffKey := loginBackoffKey(email, clientIP(r))
+	if err := sleepContext(r.Context(), app.loginBackoff.delay(backoffKey, time.Now())); err != nil {
+		return
+	}
+
+	// A locked account is turned away without checking the password, so
+	// guessing can't continue
+	if locked, remaining, ok := app.lockedAccount(email, time.Now()); ok {
+		app.logLogin(r, AuthOutcomeLocked, locked, "")
+		app.tmpl.ExecuteTemplate(w, "login.templ", map[string]interface{}{
+			"Error": lockedOutMessage(remaining),
+			"Email": email,
+		})
+		return
+	}
+
+	user, err := app.authenticator.Authenticate(r.Context(), email, password)
+	if err != nil && !errors.Is(err, errInvalidCredentials) {
+		// The directory is unreachable or misconfigured; don't count this
+		// against the user
+		log.Println("Error authenticating:", err)
+		app.tmpl.ExecuteTemplate(w, "login.templ", map[string]interface{}{
+			"Error": "Sign-in is temporarily unavailable. Please try again later.",
+			"Email": email,
+		})
+		return
+	}
+	if err != nil {
 		// Login failed - return login partial with error
+		app.logLogin(r, AuthOutcomeFailure, user, email)
+		app.loginBackoff.fail(backoffKey, time.Now())
+		app.recordFailedLogin(r, email, time.Now())
 		data := map[string]interface{}{
 			"Error": "Invalid email or password",
 			"Email": email,
 		}
-		tmpl.ExecuteTemplate(w, "login.templ", data)
+		app.tmpl.ExecuteTemplate(w, "login.templ", data)
+		return
+	}
+
+	app.loginBackoff.reset(backoffKey)
+	app.clearFailedLogins(user)
+
+	// Suspended accounts keep their data but can't sign in
+	if user.Disabled {
+		app.logLogin(r, AuthOutcomeDisabled, user, "")
+		data := map[string]interface{}{
+			"Error": accountSuspendedMessage,
+			"Email": email,
+		}
+		app.tmpl.ExecuteTemplate(w, "login.templ", data)
 		return
 	}
-	
+
+	session, _ := app.store.Get(r, "session")
+	// Kept in the session so it survives the two-factor step
+	session.Values["remember_me"] = app.config.RememberMeDuration > 0 && r.FormValue("remember") == "on"
+
+	// With two-factor authentication on, the session only starts once
+	// loginTwoFactorHandler has checked a code
+	if user.TOTPEnabled {
+		session.Values["totp_user_id"] = user.ID
+		session.Values["totp_started"] = time.Now().Unix()
+		if err := session.Save(r, w); err != nil {
+			log.Println("Error saving session:", err)
+			writeServerError(w)
+			return
+		}
+		app.tmpl.ExecuteTemplate(w, "login_2fa.templ", map[string]interface{}{})
+		return
+	}
+
+	app.completeLogin(w, r, session, user)
+}
+
+// completeLogin starts a session for user once all login stages have
+// passed and renders the dashboard. If they asked to be remembered, the
+// device also gets a remember-me cookie.
+func (app *App) completeLogin(w http.ResponseWriter, r *http.Request, session *sessions.Session, user User) {
+	remember, _ := session.Values["remember_me"].(bool)
+	delete(session.Values, "remember_me")
+
+	// Users with a temporary password must choose a new one before they
+	// get a full session
+	if user.MustChangePassword {
+		session.Values["password_change_user_id"] = user.ID
+		if err := session.Save(r, w); err != nil {
+			log.Println("Error saving session:", err)
+			writeServerError(w)
+			return
+		}
+		app.logLogin(r, AuthOutcomeSuccess, user, "")
+		app.tmpl.ExecuteTemplate(w, "change_password.templ", map[string]interface{}{})
+		return
+	}
+
 	// Login successful - create session and return dashboard
-	session, _ := store.Get(r, "session")
-	session.Values["user_id"] = user.ID
-	session.Save(r, w)
-	
+	if err := app.setSessionUser(r, session, user); err != nil {
+		log.Println("Error starting session:", err)
+		writeServerError(w)
+		return
+	}
+	if remember {
+		if err := app.issueRememberToken(w, session, user, time.Now()); err != nil {
+			// The sign-in still works, just without being remembered
+			log.Println("Error issuing remember-me token:", err)
+		}
+	}
+	if err := session.Save(r, w); err != nil {
+		// Don't render the dashboard if the session cookie was never set
+		log.Println("Error saving session:", err)
+		writeServerError(w)
+		return
+	}
+	app.logLogin(r, AuthOutcomeSuccess, user, "")
+
 	data := map[string]interface{}{
 		"User": user,
 	}
-	tmpl.ExecuteTemplate(w, "dashboard.templ", data)
+	app.tmpl.ExecuteTemplate(w, "dashboard.templ", data)
 }
 
-func logoutHandler(w http.ResponseWriter, r *http.Request) {
-	session, _ := store.Get(r, "session")
+func (app *App) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := app.store.Get(r, "session")
+	if userID, ok := session.Values["user_id"]; ok && userID != nil {
+		var user User
+		app.db.First(&user, userID)
+		app.authEvents.log(r, AuthEventLogout, AuthOutcomeSuccess, user, "")
+	}
+	app.deleteUserSession(session)
+	app.deleteImpersonatorSession(session)
+	app.forgetRememberedDevice(w, r)
 	session.Values["user_id"] = nil
 	session.Options.MaxAge = -1
-	session.Save(r, w)
-	
+	if err := session.Save(r, w); err != nil {
+		log.Println("Error saving session:", err)
+		writeServerError(w)
+		return
+	}
+
 	// Return login partial
-	tmpl.ExecuteTemplate(w, "login.templ", map[string]interface{}{})
+	app.tmpl.ExecuteTemplate(w, "login.templ", map[string]interface{}{})
 }
 
-func itemsHandler(w http.ResponseWriter, r *http.Request) {
-	// Check authentication
-	session, _ := store.Get(r, "session")
-	userID, ok := session.Values["user_id"]
-	if !ok || userID == nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte(`<div class="error">Unauthorized. Please log in.</div>`))
-		return
-	}
-	
-	// Get search parameter
-	search := r.URL.Query().Get("search")
-	
-	// Get user's items with optional search
-	var items []Item
-	query := db.Where("user_id = ?", userID)
-	
-	if search != "" {
-		query = query.Where("name LIKE ? OR id LIKE ?", "%"+search+"%", "%"+search+"%")
-	}
-	
-	query.Order("created_at desc").Find(&items)
-	
-	data := map[string]interface{}{
-		"Items": items,
+// logoutEverywhereHandler ends all of the user's sessions, on every device,
+// by bumping their session version, then ends this one.
+func (app *App) logoutEverywhereHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("session_version", gorm.Expr("session_version + 1")).Error; err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID)
+	})
+	if err != nil {
+		log.Println("Error ending sessions:", err)
+		writeServerError(w)
+		return
+	}
+	app.authEvents.log(r, AuthEventLogout, AuthOutcomeSuccess, user, "")
+	app.clearRememberCookie(w)
+
+	session, _ := app.store.Get(r, "session")
+	session.Values["user_id"] = nil
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		log.Println("Error saving session:", err)
 	}
-	tmpl.ExecuteTemplate(w, "items.templ", data)
+	app.tmpl.ExecuteTemplate(w, "login.templ", map[string]interface{}{
+		"Success": "You've been signed out on all devices.",
+	})
 }
 
-func createItemHandler(w http.ResponseWriter, r *http.Request) {
-	// Check authentication
-	session, _ := store.Get(r, "session")
-	userID, ok := session.Values["user_id"]
-	if !ok || userID == nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte(`<div class="error">Unauthorized. Please log in.</div>`))
+// itemsHandler lists the user's items, or those in the list of a
+// /lists/{list}/items route, with optional search, status, tag and
+// category filters, as the items fragment or, for JSON clients, as JSON.
+func (app *App) itemsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Get a page of the user's items, from one list or all of them, with
+	// optional search, status, tag and category filters. Fuzzy results are
+	// ranked and capped, so they aren't paged.
+	listID, ok := app.routeList(w, r, userID)
+	if !ok {
+		return
+	}
+	filter := parseItemFilter(r)
+	filter.ListID = listID
+	search := filter.Search
+	app.recordSearch(userID, search)
+
+	// Polling clients revalidate with If-None-Match; answer 304 until one of
+	// the items they can see changes instead of rendering the list again
+	etag := app.itemsETag(userID, r)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Vary", "Accept, HX-Request")
+	if etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if r.FormValue("fuzzy") == "true" && search != "" {
+		app.writeItemList(w, r, userID, map[string]interface{}{
+			"Items":        app.fuzzyFindItems(userID, filter),
+			"FilterActive": true,
+		})
+		return
+	}
+	app.writeItemList(w, r, userID, app.itemListData(userID, filter, parseItemPage(r)))
+}
+
+// createItemHandler adds an item from the form (or a form-encoded body from
+// a JSON client) to the list of a /lists/{list}/items route, the list
+// chosen by the "list" form value, or the user's default list. The browser
+// gets the updated list; JSON clients get 201 with the item, or the error
+// with a matching status.
+func (app *App) createItemHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	listID, ok := app.routeList(w, r, userID)
+	if !ok {
+		return
+	}
+
+	name, err := sanitizeItemName(r.FormValue("name"), app.config.ItemNamePolicy)
+	if err != nil {
+		app.writeItemError(w, r, userID, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	description := strings.TrimSpace(r.FormValue("description"))
+	quantity, err := parseQuantity(r.FormValue("quantity"))
+	if err != nil {
+		app.writeItemError(w, r, userID, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	notes, err := cleanNotes(r.FormValue("notes"))
+	if err != nil {
+		app.writeItemError(w, r, userID, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	tags, err := parseTags(r.FormValue("tags"), app.config.ItemNamePolicy)
+	if err != nil {
+		app.writeItemError(w, r, userID, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	categoryID, err := parseCategoryID(r.FormValue("category_id"))
+	if err != nil {
+		app.writeItemError(w, r, userID, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	dueAt, err := parseDueDate(r.FormValue("due_at"), app.config.Location)
+	if err != nil {
+		app.writeItemError(w, r, userID, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	priority, err := parsePriority(r.FormValue("priority"))
+	if err != nil {
+		app.writeItemError(w, r, userID, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	color, err := parseColor(r.FormValue("color"))
+	if err != nil {
+		app.writeItemError(w, r, userID, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	value, err := parseValue(r.FormValue("value"))
+	if err != nil {
+		app.writeItemError(w, r, userID, http.StatusUnprocessableEntity, err.Error())
 		return
 	}
-	
-	name := r.FormValue("name")
 	if name == "" {
-		// Return error in items list format
-		var items []Item
-		db.Where("user_id = ?", userID).Order("created_at desc").Find(&items)
-		data := map[string]interface{}{
-			"Items": items,
-			"Error": "Item name cannot be empty",
-		}
-		tmpl.ExecuteTemplate(w, "items.templ", data)
+		app.writeItemError(w, r, userID, http.StatusUnprocessableEntity, "Item name cannot be empty")
 		return
 	}
-	
-	// Convert userID to uint
-	uid, _ := strconv.ParseUint(fmt.Sprintf("%v", userID), 10, 32)
-	
+
+	// Enforce the per-user item cap
+	if limit := app.getItemLimit(userID); limit.Reached {
+		app.writeItemError(w, r, userID, http.StatusForbidden, fmt.Sprintf("You have reached the limit of %d items", limit.Max))
+		return
+	}
+
 	// Create item
 	item := Item{
-		UserID:    uint(uid),
-		Name:      name,
-		CreatedAt: time.Now(),
+		UserID:      toUint(userID),
+		OrgID:       app.userOrgID(userID),
+		Name:        name,
+		Description: description,
+		Notes:       notes,
+		Status:      ItemStatusActive,
+		Quantity:    quantity,
+		ValueCents:  value,
+		Priority:    priority,
+		Color:       color,
+		DueAt:       dueAt,
+		CreatedAt:   time.Now(),
+		Tags:        tags,
 	}
-	db.Create(&item)
-	
-	// Return updated items list
-	var items []Item
-	db.Where("user_id = ?", userID).Order("created_at desc").Find(&items)
-	
-	data := map[string]interface{}{
-		"Items": items,
+	if err := app.setItemCategory(&item, categoryID); err != nil {
+		app.writeItemError(w, r, userID, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err := app.setItemList(&item, listID); err != nil {
+		app.writeItemError(w, r, userID, http.StatusUnprocessableEntity, err.Error())
+		return
 	}
-	tmpl.ExecuteTemplate(w, "items.templ", data)
+	app.createItem(&item)
+	app.touchItems(userID)
+	app.notifyItem(WebhookItemCreated, item)
+
+	if respondJSON(r) {
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/items/%d", item.ID))
+		writeJSON(w, http.StatusCreated, newItemResponse(item))
+		return
+	}
+
+	// Return updated items list
+	app.writeItemList(w, r, userID, app.itemListData(userID, defaultItemFilter(listID), parseItemPage(r)))
 }
 
-func deleteItemHandler(w http.ResponseWriter, r *http.Request) {
-	// Check authentication
-	session, _ := store.Get(r, "session")
-	userID, ok := session.Values["user_id"]
-	if !ok || userID == nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte(`<div class="error">Unauthorized. Please log in.</div>`))
+// deleteItemHandler deletes one of the user's items. The browser gets the
+// updated list; JSON clients get 204, or 404 for items they don't own.
+func (app *App) deleteItemHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
 		return
 	}
-	
+
 	// Get item ID from URL
 	vars := mux.Vars(r)
 	itemID := vars["id"]
-	
-	// Delete item (only if it belongs to the user)
-	db.Where("id = ? AND user_id = ?", itemID, userID).Delete(&Item{})
-	
-	// Return updated items list
-	var items []Item
-	db.Where("user_id = ?", userID).Order("created_at desc").Find(&items)
-	
-	data := map[string]interface{}{
-		"Items": items,
+
+	// Delete item (only if it belongs to the user); other users' items,
+	// including those in other organizations, are not found
+	var item Item
+	if err := app.db.Scopes(app.ownedItems(userID)).Where("id = ?", itemID).First(&item).Error; err != nil {
+		writeItemNotFound(w, r)
+		return
+	}
+	if err := app.deleteItem(&item); err != nil {
+		log.Println("Error deleting item:", err)
+	}
+	app.touchItems(userID)
+	app.notifyItem(WebhookItemDeleted, item)
+
+	if respondJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
 	}
-	tmpl.ExecuteTemplate(w, "items.templ", data)
+
+	// Return updated items list
+	app.writeItemList(w, r, userID, app.itemListData(userID, defaultItemFilter(parseItemFilter(r).ListID), parseItemPage(r)))
 }
 
-func statsHandler(w http.ResponseWriter, r *http.Request) {
-	// Check authentication
-	session, _ := store.Get(r, "session")
-	userID, ok := session.Values["user_id"]
-	if !ok || userID == nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte(`<div class="error">Unauthorized. Please log in.</div>`))
-		return
-	}
-	
-	// Get total items count
-	var totalItems int64
-	db.Model(&Item{}).Where("user_id = ?", userID).Count(&totalItems)
-	
-	// Get today's items count
-	today := time.Now().Format("2006-01-02")
-	var todayItems int64
-	db.Model(&Item{}).Where("user_id = ? AND DATE(created_at) = ?", userID, today).Count(&todayItems)
-	
+// statsHandler returns the user's item stats as a script fragment that
+// fills in the dashboard counters or, for JSON clients, in the JSON of
+// /api/v1/stats.
+func (app *App) statsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Let the browser reuse the response, or revalidate it with either
+	// validator, until the user's items change or the day rolls over. Both
+	// formats share the URL, so caches must key on the headers that choose
+	// between them.
+	now := time.Now()
+	lastModified := app.itemsLastModified(userID, now)
+	if app.config.StatsCacheMaxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(app.config.StatsCacheMaxAge.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+	w.Header().Set("Vary", "Accept, HX-Request")
+	etag := statsETag(userID, lastModified, r)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	// If-None-Match takes precedence over If-Modified-Since when both are
+	// sent (RFC 9110, section 13.2.2)
+	notModified := notModifiedSince(r, lastModified)
+	if r.Header.Get("If-None-Match") != "" {
+		notModified = etagMatches(r, etag)
+	}
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Get item counts
+	stats := app.getItemStats(userID, now)
+	if respondJSON(r) {
+		writeJSON(w, http.StatusOK, statsResponse{itemStats: stats, Limit: app.getItemLimit(userID)})
+		return
+	}
+
 	// Return stats as HTML fragment
 	statsHTML := fmt.Sprintf(`
 		<script>
 			document.getElementById('total-items').textContent = '%d';
 			document.getElementById('added-today').textContent = '%d';
+			document.getElementById('added-this-week').textContent = '%d';
+			document.getElementById('added-this-month').textContent = '%d';
+			document.getElementById('active-items').textContent = '%d';
+			document.getElementById('archived-items').textContent = '%d';
 			document.getElementById('items-count').textContent = '%d Total Items';
+			document.getElementById('total-quantity').textContent = '%d';
+			document.getElementById('active-quantity').textContent = '%d';
+			document.getElementById('total-value').textContent = '%s';
+			document.getElementById('value-added-today').textContent = '%s';
 		</script>
-	`, totalItems, todayItems, totalItems)
-	
+	`, stats.TotalItems, stats.AddedToday, stats.ThisWeek, stats.ThisMonth, stats.ActiveItems, stats.ArchivedItems, stats.TotalItems, stats.TotalQuantity, stats.ActiveQuantity, stats.TotalValue, stats.ValueAddedToday)
+
 	w.Write([]byte(statsHTML))
-}
\ No newline at end of file
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testSessionSecret signs the session cookies of test apps.
+const testSessionSecret = "test-session-secret-0123456789abcdef"
+
+// newTestApp builds an App with the default configuration on a throwaway
+// in-memory database, with a cookie session store and the parsed templates,
+// and returns its routes. configure, if given, adjusts the configuration
+// first. Tests that need the database or other dependencies can reach them
+// through the returned App.
+func newTestApp(t *testing.T, configure ...func(*Config)) (http.Handler, *App) {
+	t.Helper()
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	cfg.DBPath = ":memory:"
+	cfg.TemplatesDir = "templates"
+	cfg.SessionStore = SessionStoreCookie
+	cfg.SessionSecret = testSessionSecret
+	cfg.OldSessionSecrets = nil
+	cfg.RateLimitStore = RateLimitStoreMemory
+	cfg.FieldEncryptionKey = ""
+	cfg.AuthEventLog = ""
+	cfg.DataExportDir = t.TempDir()
+	// Cheap hashes and no login backoff keep the tests fast
+	cfg.PasswordHasher = PasswordHasherBcrypt
+	cfg.BcryptCost = bcrypt.MinCost
+	cfg.LoginBackoffBase = 0
+	for _, f := range configure {
+		f(&cfg)
+	}
+
+	app, err := newApp(cfg)
+	if err != nil {
+		t.Fatalf("newApp: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := app.db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return app.routes(), app
+}
+
+// createTestUser adds a verified user with the given role and password.
+func createTestUser(t *testing.T, app *App, email, password, role string) User {
+	t.Helper()
+	hash, err := app.passwords.Hash(password)
+	if err != nil {
+		t.Fatalf("hashing password: %v", err)
+	}
+	user := User{Email: email, PasswordHash: hash, Role: role, Verified: true, CreatedAt: time.Now()}
+	if err := app.db.Create(&user).Error; err != nil {
+		t.Fatalf("creating user %s: %v", email, err)
+	}
+	return user
+}
+
+// createTestItem adds an active item with quantity 1 to user's default
+// list, in their organization.
+func createTestItem(t *testing.T, app *App, user User, name string) Item {
+	t.Helper()
+	item := Item{UserID: user.ID, OrgID: user.OrgID, Name: name, Status: ItemStatusActive, Quantity: 1, CreatedAt: time.Now()}
+	if err := app.createItem(&item); err != nil {
+		t.Fatalf("creating item %s: %v", name, err)
+	}
+	return item
+}
+
+// createTestToken issues an API token for user and returns its value.
+// rateLimit is the token's own requests-per-minute budget; 0 uses the
+// default.
+func createTestToken(t *testing.T, app *App, user User, rateLimit int) string {
+	t.Helper()
+	value, err := generateAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := UserToken{
+		UserID:    user.ID,
+		Name:      "test",
+		TokenHash: hashToken(value),
+		Prefix:    value[:tokenPrefixLength],
+		RateLimit: rateLimit,
+	}
+	if err := app.db.Create(&token).Error; err != nil {
+		t.Fatalf("creating token: %v", err)
+	}
+	return value
+}
+
+// testClient is a browser for a test app: it keeps cookies between
+// requests and sends the page's CSRF token with every request.
+type testClient struct {
+	t      *testing.T
+	server *httptest.Server
+	client *http.Client
+	csrf   string
+}
+
+var csrfMetaPattern = regexp.MustCompile(`csrf-token" content="([^"]+)"`)
+
+// newTestClient serves handler on a local test server and loads the home
+// page, as a browser would, to get a CSRF token.
+func newTestClient(t *testing.T, handler http.Handler) *testClient {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	jar, _ := cookiejar.New(nil)
+	c := &testClient{t: t, server: server, client: &http.Client{Jar: jar}}
+
+	_, body := c.get("/")
+	m := csrfMetaPattern.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatal("home page has no CSRF token")
+	}
+	c.csrf = m[1]
+	return c
+}
+
+// do sends a request with the CSRF token and as an HTMX request unless
+// header overrides them, and returns the response and its body.
+func (c *testClient) do(method, path string, body io.Reader, header http.Header) (*http.Response, string) {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.server.URL+path, body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set(csrfHeader, c.csrf)
+	req.Header.Set("HX-Request", "true")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for name, values := range header {
+		req.Header.Del(name)
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func (c *testClient) get(path string) (*http.Response, string) {
+	c.t.Helper()
+	return c.do("GET", path, nil, nil)
+}
+
+func (c *testClient) post(path string, form url.Values) (*http.Response, string) {
+	c.t.Helper()
+	return c.do("POST", path, strings.NewReader(form.Encode()), nil)
+}
+
+// api sends a JSON API request, with body encoded as JSON unless it is nil
+// and authenticated with token unless it is empty (the session cookie is
+// still sent). A successful response is decoded into v unless it is nil.
+func (c *testClient) api(method, path, token string, body, v interface{}) *http.Response {
+	c.t.Helper()
+	header := http.Header{"HX-Request": nil, "Accept": {"application/json"}}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		reader = bytes.NewReader(b)
+		header.Set("Content-Type", "application/json")
+	}
+	resp, respBody := c.do(method, path, reader, header)
+	if v != nil && resp.StatusCode < 300 {
+		if err := json.Unmarshal([]byte(respBody), v); err != nil {
+			c.t.Fatalf("%s %s: decoding %q: %v", method, path, respBody, err)
+		}
+	}
+	return resp
+}
+
+// login signs in with email and password and fails the test unless the
+// dashboard comes back.
+func (c *testClient) login(email, password string) {
+	c.t.Helper()
+	resp, body := c.post("/login", url.Values{"email": {email}, "password": {password}})
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "<h1>Dashboard</h1>") {
+		c.t.Fatalf("login as %s: status %d, no dashboard in:\n%s", email, resp.StatusCode, body)
+	}
+}
+
+func TestLoginAndCreateItem(t *testing.T) {
+	handler, app := newTestApp(t)
+	createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	c := newTestClient(t, handler)
+
+	c.login("alice@example.com", "Correct-Horse-1")
+	resp, body := c.post("/items", url.Values{"name": {"Milk"}, "quantity": {"2"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("create item: status %d\n%s", resp.StatusCode, body)
+	}
+	if !strings.Contains(body, "Milk") {
+		t.Errorf("create item response doesn't show the new item:\n%s", body)
+	}
+
+	var item Item
+	if err := app.db.Where("name = ?", "Milk").First(&item).Error; err != nil {
+		t.Fatalf("item not stored: %v", err)
+	}
+	if item.Quantity != 2 || item.Status != ItemStatusActive {
+		t.Errorf("stored item = quantity %d, status %q; want 2, %q", item.Quantity, item.Status, ItemStatusActive)
+	}
+}
+
+func TestCreateItemRequiresLogin(t *testing.T) {
+	handler, app := newTestApp(t)
+	c := newTestClient(t, handler)
+
+	resp, _ := c.post("/items", url.Values{"name": {"Milk"}})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	var count int64
+	app.db.Model(&Item{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d items stored without a session", count)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	handler, _ := newTestApp(t)
+	c := newTestClient(t, handler)
+
+	resp, body := c.get("/healthz")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `"status":"ok"`) {
+		t.Errorf("GET: status %d\n%s", resp.StatusCode, body)
+	}
+
+	// Load balancers probe with HEAD, which gets the status without a body
+	resp, body = c.do("HEAD", "/healthz", nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("HEAD: status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if body != "" {
+		t.Errorf("HEAD: body = %q, want none", body)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// markdownRenderer converts item notes to HTML with GitHub Flavored
+// Markdown: tables, strikethrough, task lists and bare links. goldmark
+// leaves raw HTML in the source out of its output.
+var markdownRenderer = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// markdownPolicy is the allowlist rendered notes are sanitized with: the
+// formatting Markdown produces, links with rel="nofollow", the disabled
+// checkboxes of task lists and no scripts, styles or event handlers.
+var markdownPolicy = func() *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+	policy.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	policy.AllowAttrs("checked", "disabled").OnElements("input")
+	return policy
+}()
+
+// renderMarkdown renders Markdown source as sanitized HTML. It is the
+// "markdown" template function and returns template.HTML, so its output
+// isn't escaped again: raw HTML in the source is dropped by goldmark and
+// everything goldmark produces is filtered through markdownPolicy, so notes
+// can't inject markup beyond that allowlist.
+func renderMarkdown(source string) template.HTML {
+	var buf bytes.Buffer
+	if err := markdownRenderer.Convert([]byte(source), &buf); err != nil {
+		log.Println("Error rendering Markdown:", err)
+		return template.HTML(template.HTMLEscapeString(source))
+	}
+	return template.HTML(markdownPolicy.SanitizeBytes(buf.Bytes()))
+}
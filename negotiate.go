@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+)
+
+// respondJSON reports whether a route shared by the browser and API clients
+// should answer r with JSON. htmx requests always get HTML fragments; other
+// clients get JSON when their Accept header asks for it.
+func respondJSON(r *http.Request) bool {
+	return r.Header.Get("HX-Request") != "true" && wantsJSON(r)
+}
+
+// itemRouteUser authenticates a request to the shared item routes. JSON
+// clients authenticate like the API, with a bearer token or the session,
+// and share its rate limit; browsers need the session.
+func (app *App) itemRouteUser(w http.ResponseWriter, r *http.Request) (interface{}, bool) {
+	if respondJSON(r) {
+		return app.apiUserID(w, r)
+	}
+	session, _ := app.store.Get(r, "session")
+	userID, ok := session.Values["user_id"]
+	if !ok || userID == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`<div class="error">Unauthorized. Please log in.</div>`))
+		return nil, false
+	}
+	return userID, true
+}
+
+// itemListResponse is the JSON form of the items list: one page of items
+// and where it sits in the whole list.
+type itemListResponse struct {
+	Items    []itemResponse `json:"items"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+	Total    int64          `json:"total"`
+}
+
+// writeItemList answers an item route with a page of items: the
+// items.templ fragment for the browser, or an itemListResponse for JSON
+// clients. data is the template data and must hold Items.
+func (app *App) writeItemList(w http.ResponseWriter, r *http.Request, userID interface{}, data map[string]interface{}) {
+	app.addItemListData(w, data, userID)
+	if !respondJSON(r) {
+		app.tmpl.ExecuteTemplate(w, "items.templ", data)
+		return
+	}
+	items, _ := data["Items"].([]Item)
+	page := data["Page"].(itemPage)
+	resp := itemListResponse{Items: []itemResponse{}, Page: page.Number, PageSize: page.Size, Total: page.Total}
+	for _, item := range items {
+		resp.Items = append(resp.Items, newItemResponse(item))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// writeItemError reports a rejected item change. JSON clients get status and
+// the message; the browser gets the active items list with the message
+// shown above it.
+func (app *App) writeItemError(w http.ResponseWriter, r *http.Request, userID interface{}, status int, message string) {
+	if respondJSON(r) {
+		writeJSONError(w, status, message)
+		return
+	}
+	data := app.itemListData(userID, defaultItemFilter(parseItemFilter(r).ListID), parseItemPage(r))
+	data["Error"] = message
+	app.writeItemList(w, r, userID, data)
+}
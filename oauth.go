@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"gorm.io/gorm"
+)
+
+// oauthTimeout bounds each call to a provider's token and user endpoints.
+const oauthTimeout = 10 * time.Second
+
+// oauthProvider is an OAuth2 identity provider users can sign in with.
+// fetchIdentity returns who the user is given the token response and the
+// nonce sent with the authorization request. OpenID Connect providers leave
+// AuthURL and TokenURL empty and look them up through discovery instead.
+type oauthProvider struct {
+	Name          string // used in /auth/{provider}/...
+	Label         string // shown on the login page
+	AuthURL       string
+	TokenURL      string
+	Scopes        []string
+	ClientID      string
+	ClientSecret  string
+	fetchIdentity func(ctx context.Context, client *http.Client, token oauthToken, nonce string) (externalIdentity, error)
+	discovery     *oidcDiscovery
+}
+
+// oauthToken is the part of a token endpoint response we use. IDToken is
+// only set by OpenID Connect providers.
+type oauthToken struct {
+	AccessToken string
+	IDToken     string
+}
+
+// externalIdentity is what an identity provider tells us about a user.
+// Provider and Subject identify the user's account at an OAuth or OpenID
+// Connect provider; directories (SAML and LDAP) leave them empty and their
+// users are matched by email address.
+type externalIdentity struct {
+	Email      string // verified by the provider unless Unverified is set
+	Unverified bool
+	Role       string // "" leaves the user's role alone
+	Provider   string
+	Subject    string
+}
+
+// endpoints returns the provider's authorization and token endpoints.
+func (p *oauthProvider) endpoints(ctx context.Context, client *http.Client) (authURL, tokenURL string, err error) {
+	if p.discovery == nil {
+		return p.AuthURL, p.TokenURL, nil
+	}
+	doc, err := p.discovery.get(ctx, client)
+	if err != nil {
+		return "", "", err
+	}
+	return doc.AuthorizationEndpoint, doc.TokenEndpoint, nil
+}
+
+// oauthProviders returns the providers configured in cfg, in the order they
+// are shown on the login page.
+func oauthProviders(cfg Config) []*oauthProvider {
+	var providers []*oauthProvider
+	if cfg.GoogleClientID != "" {
+		providers = append(providers, &oauthProvider{
+			Name:          "google",
+			Label:         "Google",
+			AuthURL:       "https://accounts.google.com/o/oauth2/v2/auth",
+			TokenURL:      "https://oauth2.googleapis.com/token",
+			Scopes:        []string{"openid", "email"},
+			ClientID:      cfg.GoogleClientID,
+			ClientSecret:  cfg.GoogleClientSecret,
+			fetchIdentity: googleIdentity,
+		})
+	}
+	if cfg.OIDCIssuer != "" {
+		providers = append(providers, newOIDCProvider(cfg))
+	}
+	if cfg.GitHubClientID != "" {
+		providers = append(providers, &oauthProvider{
+			Name:          "github",
+			Label:         "GitHub",
+			AuthURL:       "https://github.com/login/oauth/authorize",
+			TokenURL:      "https://github.com/login/oauth/access_token",
+			Scopes:        []string{"user:email"},
+			ClientID:      cfg.GitHubClientID,
+			ClientSecret:  cfg.GitHubClientSecret,
+			fetchIdentity: githubIdentity,
+		})
+	}
+	return providers
+}
+
+// oauthProvider returns the configured provider called name.
+func (app *App) oauthProvider(name string) (*oauthProvider, bool) {
+	for _, p := range app.oauth {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+func (app *App) oauthRedirectURL(p *oauthProvider) string {
+	return app.config.BaseURL + "/auth/" + p.Name + "/callback"
+}
+
+// getJSON fetches url with a bearer token and decodes the JSON response
+// into v.
+func getJSON(ctx context.Context, client *http.Client, url, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func googleIdentity(ctx context.Context, client *http.Client, token oauthToken, nonce string) (externalIdentity, error) {
+	var info struct {
+		Subject       string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := getJSON(ctx, client, "https://openidconnect.googleapis.com/v1/userinfo", token.AccessToken, &info); err != nil {
+		return externalIdentity{}, err
+	}
+	if info.Subject == "" {
+		return externalIdentity{}, errors.New("Google did not say which account you signed in with")
+	}
+	if info.Email == "" || !info.EmailVerified {
+		return externalIdentity{}, errors.New("your Google account has no verified email address")
+	}
+	return externalIdentity{Email: info.Email, Subject: info.Subject}, nil
+}
+
+func githubIdentity(ctx context.Context, client *http.Client, token oauthToken, nonce string) (externalIdentity, error) {
+	var account struct {
+		ID int64 `json:"id"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user", token.AccessToken, &account); err != nil {
+		return externalIdentity{}, err
+	}
+	if account.ID == 0 {
+		return externalIdentity{}, errors.New("GitHub did not say which account you signed in with")
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user/emails", token.AccessToken, &emails); err != nil {
+		return externalIdentity{}, err
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			return externalIdentity{Email: e.Email, Subject: strconv.FormatInt(account.ID, 10)}, nil
+		}
+	}
+	return externalIdentity{}, errors.New("your GitHub account has no verified primary email address")
+}
+
+// exchangeCode trades an authorization code for the provider's tokens.
+func (app *App) exchangeCode(ctx context.Context, p *oauthProvider, code string) (oauthToken, error) {
+	_, tokenURL, err := p.endpoints(ctx, app.oauthClient)
+	if err != nil {
+		return oauthToken{}, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {app.oauthRedirectURL(p)},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oauthToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := app.oauthClient.Do(req)
+	if err != nil {
+		return oauthToken{}, err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return oauthToken{}, fmt.Errorf("decoding token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return oauthToken{}, fmt.Errorf("token endpoint returned %s %s", resp.Status, token.Error)
+	}
+	return oauthToken{AccessToken: token.AccessToken, IDToken: token.IDToken}, nil
+}
+
+// oauthLoginHandler sends the user to the provider's consent page.
+func (app *App) oauthLoginHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := app.oauthProvider(mux.Vars(r)["provider"])
+	if !ok {
+		app.notFoundHandler(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), oauthTimeout)
+	defer cancel()
+	authURL, _, err := p.endpoints(ctx, app.oauthClient)
+	if err != nil {
+		log.Printf("Error loading %s configuration: %v", p.Name, err)
+		app.renderLoginPage(w, r, http.StatusBadGateway, p.Label+" sign-in is unavailable right now. Please try again later.")
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Println("Error generating OAuth state:", err)
+		writeServerError(w)
+		return
+	}
+	state, nonce := hex.EncodeToString(b[:16]), hex.EncodeToString(b[16:])
+	session, _ := app.store.Get(r, "session")
+	session.Values["oauth_state"] = state
+	session.Values["oauth_provider"] = p.Name
+	session.Values["oauth_nonce"] = nonce
+	if err := session.Save(r, w); err != nil {
+		log.Println("Error saving session:", err)
+		writeServerError(w)
+		return
+	}
+
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {app.oauthRedirectURL(p)},
+		"scope":         {strings.Join(p.Scopes, " ")},
+		"state":         {state},
+	}
+	if p.discovery != nil {
+		// OpenID Connect providers put the nonce in the ID token, tying it
+		// to this sign-in
+		q.Set("nonce", nonce)
+	}
+	http.Redirect(w, r, authURL+"?"+q.Encode(), http.StatusFound)
+}
+
+// oauthCallbackHandler completes the OAuth flow: it checks the state,
+// exchanges the code, and signs in the user connected to the provider
+// account, creating one if registration is enabled. An existing account
+// with the same email address is only connected once its owner confirms
+// by email.
+func (app *App) oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := app.oauthProvider(mux.Vars(r)["provider"])
+	if !ok {
+		app.notFoundHandler(w, r)
+		return
+	}
+
+	session, _ := app.store.Get(r, "session")
+	state, _ := session.Values["oauth_state"].(string)
+	provider, _ := session.Values["oauth_provider"].(string)
+	nonce, _ := session.Values["oauth_nonce"].(string)
+	delete(session.Values, "oauth_state")
+	delete(session.Values, "oauth_provider")
+	delete(session.Values, "oauth_nonce")
+	if state == "" || provider != p.Name ||
+		subtle.ConstantTimeCompare([]byte(state), []byte(r.FormValue("state"))) != 1 {
+		app.renderLoginPage(w, r, http.StatusBadRequest, "Your sign-in request expired. Please try again.")
+		return
+	}
+	if r.FormValue("error") != "" {
+		app.renderLoginPage(w, r, http.StatusUnauthorized, p.Label+" sign-in was cancelled.")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), oauthTimeout)
+	defer cancel()
+	token, err := app.exchangeCode(ctx, p, r.FormValue("code"))
+	if err != nil {
+		log.Printf("Error exchanging %s OAuth code: %v", p.Name, err)
+		app.renderLoginPage(w, r, http.StatusBadGateway, "Could not sign in with "+p.Label+". Please try again.")
+		return
+	}
+	identity, err := p.fetchIdentity(ctx, app.oauthClient, token, nonce)
+	if err != nil {
+		log.Printf("Error fetching %s identity: %v", p.Name, err)
+		app.renderLoginPage(w, r, http.StatusUnauthorized, "Could not sign in with "+p.Label+": "+err.Error())
+		return
+	}
+	identity.Provider = p.Name
+
+	user, err := app.findOrCreateExternalUser(identity, app.config.RegistrationEnabled)
+	if errors.Is(err, errExternalLoginUnconfirmed) {
+		app.confirmExternalLogin(w, r, session, user, identity, p.Label)
+		return
+	}
+	if errors.Is(err, errRegistrationClosed) {
+		app.logLogin(r, AuthOutcomeFailure, User{}, identity.Email)
+		app.renderLoginPage(w, r, http.StatusForbidden, "There is no account for "+identity.Email+". Ask an administrator to create one.")
+		return
+	}
+	if err != nil {
+		log.Println("Error creating user:", err)
+		writeServerError(w)
+		return
+	}
+	app.startExternalLogin(w, r, session, user)
+}
+
+var (
+	errRegistrationClosed       = errors.New("registration is disabled")
+	errExternalLoginUnconfirmed = errors.New("the account's owner has not confirmed the external sign-in")
+)
+
+// findOrCreateExternalUser returns the account for an identity provider's
+// user, creating it if create is true (usually when registration is
+// enabled). Identities with a Subject are looked up by their ExternalLogin;
+// an existing account that merely has the same email address is returned
+// with errExternalLoginUnconfirmed, since its owner has to confirm before
+// anyone signing in through the provider may use it. Directory identities
+// are matched by email address. A role from the provider replaces the
+// user's role on every sign-in.
+func (app *App) findOrCreateExternalUser(identity externalIdentity, create bool) (User, error) {
+	var user User
+	var err error
+	if identity.Subject != "" {
+		err = app.db.Joins("JOIN external_logins ON external_logins.user_id = users.id").
+			Where("external_logins.provider = ? AND external_logins.subject = ?", identity.Provider, identity.Subject).
+			First(&user).Error
+		if err == nil {
+			return user, app.updateExternalRole(&user, identity)
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, err
+		}
+	}
+
+	err = app.db.Where("LOWER(email) = LOWER(?)", identity.Email).First(&user).Error
+	if err == nil {
+		if identity.Subject != "" {
+			return user, errExternalLoginUnconfirmed
+		}
+		return user, app.updateExternalRole(&user, identity)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+	if !create {
+		return user, errRegistrationClosed
+	}
+
+	// The account has no usable password until the user resets it
+	password, err := app.passwordPolicy().temporaryPassword()
+	if err != nil {
+		return user, err
+	}
+	hash, err := app.passwords.Hash(password)
+	if err != nil {
+		return user, err
+	}
+	user = User{Email: identity.Email, PasswordHash: hash, Role: identity.Role, Verified: !identity.Unverified}
+	err = app.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if identity.Subject == "" {
+			return nil
+		}
+		return tx.Create(&ExternalLogin{UserID: user.ID, Provider: identity.Provider, Subject: identity.Subject}).Error
+	})
+	return user, err
+}
+
+// updateExternalRole gives user the role their identity provider assigned,
+// if it set one.
+func (app *App) updateExternalRole(user *User, identity externalIdentity) error {
+	if identity.Role == "" || identity.Role == user.Role {
+		return nil
+	}
+	user.Role = identity.Role
+	return app.db.Model(user).Update("role", identity.Role).Error
+}
+
+// confirmExternalLogin handles a provider sign-in for an existing account
+// that isn't connected to the provider yet: it emails the account's owner a
+// link that connects the two and signs them in, and tells the user to open
+// it. Nothing changes until the link is used.
+func (app *App) confirmExternalLogin(w http.ResponseWriter, r *http.Request, session *sessions.Session, user User, identity externalIdentity, label string) {
+	// The session was changed when the state was checked
+	if err := session.Save(r, w); err != nil {
+		log.Println("Error saving session:", err)
+		writeServerError(w)
+		return
+	}
+	message := "There is already an account for " + user.Email + ". We've emailed it a link that connects your " +
+		label + " sign-in to the account; open it to continue."
+	app.logLogin(r, AuthOutcomeFailure, user, "")
+	now := time.Now()
+	limit := app.limiter.allow(fmt.Sprintf("external-login:user:%d", user.ID), magicLinksPerHour, time.Hour, now)
+	if !limit.Allowed || user.Disabled || user.Demo {
+		app.renderPage(w, r, http.StatusOK, "login", map[string]interface{}{"Success": message})
+		return
+	}
+
+	token, err := app.createMagicLink(MagicLink{UserID: user.ID, Provider: identity.Provider, Subject: identity.Subject}, now)
+	if err != nil {
+		log.Println("Error creating external login link:", err)
+		writeServerError(w)
+		return
+	}
+	link := app.config.BaseURL + "/login/magic?token=" + url.QueryEscape(token)
+	go func() {
+		body := fmt.Sprintf("Someone signed in with %s as %s, and your account has the same email address.\n\n"+
+			"If it was you, open this link within %s to connect %s to your account and sign in. It works only once:\n%s\n\n"+
+			"If it wasn't you, ignore this email; nothing changes.\n",
+			label, user.Email, app.config.MagicLinkTTL, label, link)
+		if err := app.mailer.Send(user.Email, "Connect your "+label+" sign-in", body); err != nil {
+			log.Println("Error sending external login email:", err)
+		}
+	}()
+	app.renderPage(w, r, http.StatusOK, "login", map[string]interface{}{"Success": message})
+}
+
+// startExternalLogin signs in a user authenticated by an identity provider
+// and sends them home. Two-factor authentication and forced password
+// changes still apply; homeHandler shows the pending step.
+func (app *App) startExternalLogin(w http.ResponseWriter, r *http.Request, session *sessions.Session, user User) {
+	if user.Disabled {
+		app.logLogin(r, AuthOutcomeDisabled, user, "")
+		app.renderLoginPage(w, r, http.StatusForbidden, accountSuspendedMessage)
+		return
+	}
+
+	switch {
+	case user.TOTPEnabled:
+		session.Values["totp_user_id"] = user.ID
+		session.Values["totp_started"] = time.Now().Unix()
+	case user.MustChangePassword:
+		session.Values["password_change_user_id"] = user.ID
+		app.logLogin(r, AuthOutcomeSuccess, user, "")
+	default:
+		if err := app.setSessionUser(r, session, user); err != nil {
+			log.Println("Error starting session:", err)
+			writeServerError(w)
+			return
+		}
+		app.logLogin(r, AuthOutcomeSuccess, user, "")
+	}
+	if err := session.Save(r, w); err != nil {
+		log.Println("Error saving session:", err)
+		writeServerError(w)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// pendingLoginStep returns the base.templ page for a sign-in that is
+// waiting for a TOTP code or a new password, or "" if there is none.
+func pendingLoginStep(session *sessions.Session) string {
+	if id, ok := session.Values["totp_user_id"]; ok && id != nil {
+		return "login_2fa"
+	}
+	if id, ok := session.Values["password_change_user_id"]; ok && id != nil {
+		return "change_password"
+	}
+	return ""
+}
+
+// renderLoginPage renders the full login page with an error message.
+func (app *App) renderLoginPage(w http.ResponseWriter, r *http.Request, status int, message string) {
+	app.renderPage(w, r, status, "login", map[string]interface{}{"Error": message})
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcConfiguration is the part of an OpenID Connect discovery document we
+// use.
+type oidcConfiguration struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// oidcDiscovery loads an issuer's discovery document on first use and
+// caches it, so the app starts even while the identity provider is down.
+type oidcDiscovery struct {
+	issuer string
+
+	mu  sync.Mutex
+	doc *oidcConfiguration
+}
+
+func (d *oidcDiscovery) get(ctx context.Context, client *http.Client) (*oidcConfiguration, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.doc != nil {
+		return d.doc, nil
+	}
+
+	var doc oidcConfiguration
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC discovery returned %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decoding OIDC discovery document: %w", err)
+	}
+	// The issuer must match exactly, so a compromised discovery URL can't
+	// point sign-in at another provider
+	if strings.TrimSuffix(doc.Issuer, "/") != d.issuer {
+		return nil, fmt.Errorf("OIDC discovery issuer %q does not match OIDC_ISSUER", doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.UserinfoEndpoint == "" {
+		return nil, errors.New("OIDC discovery document is missing an endpoint")
+	}
+	d.doc = &doc
+	return d.doc, nil
+}
+
+// newOIDCProvider returns the sign-in provider for the configured OpenID
+// Connect issuer. Claims are read from the userinfo endpoint once the ID
+// token checks out, and must be about the same subject.
+func newOIDCProvider(cfg Config) *oauthProvider {
+	discovery := &oidcDiscovery{issuer: cfg.OIDCIssuer}
+	return &oauthProvider{
+		Name:         "oidc",
+		Label:        cfg.OIDCLabel,
+		Scopes:       strings.Fields(cfg.OIDCScopes),
+		ClientID:     cfg.OIDCClientID,
+		ClientSecret: cfg.OIDCClientSecret,
+		discovery:    discovery,
+		fetchIdentity: func(ctx context.Context, client *http.Client, token oauthToken, nonce string) (externalIdentity, error) {
+			doc, err := discovery.get(ctx, client)
+			if err != nil {
+				return externalIdentity{}, err
+			}
+			idClaims, err := idTokenClaims(cfg, doc, token.IDToken, nonce, time.Now())
+			if err != nil {
+				return externalIdentity{}, err
+			}
+			var claims map[string]interface{}
+			if err := getJSON(ctx, client, doc.UserinfoEndpoint, token.AccessToken, &claims); err != nil {
+				return externalIdentity{}, err
+			}
+			if claims["sub"] != idClaims["sub"] {
+				return externalIdentity{}, errors.New("the userinfo response is about another user")
+			}
+			return oidcIdentity(cfg, claims)
+		},
+	}
+}
+
+// oidcClockSkew is how far our clock may be behind the provider's when
+// checking an ID token's expiry.
+const oidcClockSkew = time.Minute
+
+// idTokenClaims checks an ID token as OpenID Connect Core 3.1.3.7 requires
+// and returns its claims. The token comes straight from the token endpoint,
+// which the spec lets us trust in place of checking its signature, but the
+// issuer, audience, expiry and nonce show it was issued to us for this
+// sign-in.
+func idTokenClaims(cfg Config, doc *oidcConfiguration, idToken, nonce string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("the provider did not return a valid ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("the provider did not return a valid ID token")
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("the provider did not return a valid ID token")
+	}
+
+	if iss, _ := claims["iss"].(string); iss != doc.Issuer {
+		return nil, errors.New("the ID token is from another issuer")
+	}
+	if !claimContains(claims["aud"], cfg.OIDCClientID) {
+		return nil, errors.New("the ID token is for another application")
+	}
+	if azp, ok := claims["azp"]; ok && azp != cfg.OIDCClientID {
+		return nil, errors.New("the ID token is for another application")
+	}
+	if exp, ok := claims["exp"].(float64); !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, errors.New("the ID token has expired")
+	}
+	if got, _ := claims["nonce"].(string); nonce == "" || subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return nil, errors.New("the ID token is for another sign-in")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("the ID token has no subject")
+	}
+	return claims, nil
+}
+
+// oidcIdentity maps userinfo claims to a user. The email address must be
+// verified unless OIDC_ALLOW_UNVERIFIED_EMAIL is set, for the many
+// corporate identity providers that omit email_verified.
+func oidcIdentity(cfg Config, claims map[string]interface{}) (externalIdentity, error) {
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return externalIdentity{}, errors.New("the \"sub\" claim is missing")
+	}
+	email, _ := claims[cfg.OIDCEmailClaim].(string)
+	if email == "" {
+		return externalIdentity{}, fmt.Errorf("the %q claim is missing", cfg.OIDCEmailClaim)
+	}
+	verified, _ := claims["email_verified"].(bool)
+	if !verified && !cfg.OIDCUnverifiedEmail {
+		return externalIdentity{}, errors.New("your email address is not verified")
+	}
+
+	identity := externalIdentity{Email: email, Unverified: !verified, Subject: subject}
+	if cfg.OIDCAdminGroup != "" {
+		identity.Role = RoleUser
+		if claimContains(claims[cfg.OIDCGroupsClaim], cfg.OIDCAdminGroup) {
+			identity.Role = RoleAdmin
+		}
+	}
+	return identity, nil
+}
+
+// claimContains reports whether a string or string-array claim contains
+// want.
+func claimContains(claim interface{}, want string) bool {
+	switch v := claim.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isLoopbackHost reports whether host names this machine.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testOIDCClientID = "test-client"
+
+// fakeOIDCProvider is an OpenID Connect provider that signs everyone in as
+// the subject of its ID token and userinfo claims. idToken entries are
+// added to, or with a nil value removed from, a valid ID token.
+type fakeOIDCProvider struct {
+	server   *httptest.Server
+	nonce    string
+	idToken  map[string]interface{}
+	userinfo map[string]interface{}
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	p := &fakeOIDCProvider{
+		userinfo: map[string]interface{}{"sub": "user-1", "email": "alice@example.com", "email_verified": true},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcConfiguration{
+			Issuer:                p.server.URL,
+			AuthorizationEndpoint: p.server.URL + "/authorize",
+			TokenEndpoint:         p.server.URL + "/token",
+			UserinfoEndpoint:      p.server.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		claims := map[string]interface{}{
+			"iss":   p.server.URL,
+			"aud":   testOIDCClientID,
+			"sub":   "user-1",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": p.nonce,
+		}
+		for name, v := range p.idToken {
+			if v == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = v
+			}
+		}
+		payload, _ := json.Marshal(claims)
+		idToken := "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "id_token": idToken})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(p.userinfo)
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// configure points an app at the provider.
+func (p *fakeOIDCProvider) configure(cfg *Config) {
+	cfg.OIDCIssuer = p.server.URL
+	cfg.OIDCClientID = testOIDCClientID
+	cfg.OIDCClientSecret = "test-secret"
+	cfg.OIDCScopes = "openid email"
+	cfg.RegistrationEnabled = true
+}
+
+// signIn goes through the sign-in flow as c and returns the callback's
+// response.
+func (p *fakeOIDCProvider) signIn(c *testClient) (*http.Response, string) {
+	c.t.Helper()
+	c.client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, _ := c.get("/auth/oidc/login")
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound || err != nil {
+		c.t.Fatalf("starting sign-in: status %d, location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	q := location.Query()
+	p.nonce = q.Get("nonce")
+	if p.nonce == "" {
+		c.t.Fatal("the authorization request has no nonce")
+	}
+	return c.get("/auth/oidc/callback?" + url.Values{"state": {q.Get("state")}, "code": {"code"}}.Encode())
+}
+
+// signedIn reports whether c has a session.
+func signedIn(c *testClient) bool {
+	c.t.Helper()
+	_, body := c.get("/")
+	return strings.Contains(body, "<h1>Dashboard</h1>")
+}
+
+// testMailer hands sent messages to the test.
+type testMailer chan string
+
+func (m testMailer) Send(to, subject, body string) error {
+	m <- body
+	return nil
+}
+
+func TestOIDCSignInCreatesAccount(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	handler, app := newTestApp(t, provider.configure)
+	c := newTestClient(t, handler)
+
+	if resp, body := provider.signIn(c); resp.StatusCode != http.StatusSeeOther || !signedIn(c) {
+		t.Fatalf("sign-in: status %d, not signed in\n%s", resp.StatusCode, body)
+	}
+	var user User
+	if err := app.db.Where("email = ?", "alice@example.com").First(&user).Error; err != nil {
+		t.Fatalf("account not created: %v", err)
+	}
+	if !user.Verified {
+		t.Error("the account isn't verified")
+	}
+	var login ExternalLogin
+	if err := app.db.Where("user_id = ?", user.ID).First(&login).Error; err != nil || login.Provider != "oidc" || login.Subject != "user-1" {
+		t.Fatalf("external login = %+v, %v; want oidc user-1", login, err)
+	}
+
+	// The account is found by subject, even once the address changes
+	provider.userinfo["email"] = "alice@example.org"
+	c = newTestClient(t, handler)
+	provider.signIn(c)
+	if !signedIn(c) {
+		t.Fatal("not signed in after the email address changed")
+	}
+	var count int64
+	app.db.Model(&User{}).Where("email LIKE ?", "alice@%").Count(&count)
+	if count != 1 {
+		t.Errorf("%d accounts for alice, want 1", count)
+	}
+}
+
+func TestOIDCRequiresVerifiedEmail(t *testing.T) {
+	for _, verified := range []interface{}{nil, false, "true"} {
+		provider := newFakeOIDCProvider(t)
+		provider.userinfo["email_verified"] = verified
+		handler, app := newTestApp(t, provider.configure)
+		c := newTestClient(t, handler)
+
+		resp, body := provider.signIn(c)
+		if resp.StatusCode != http.StatusUnauthorized || !strings.Contains(body, "not verified") {
+			t.Errorf("email_verified %v: status %d\n%s", verified, resp.StatusCode, body)
+		}
+		var count int64
+		app.db.Model(&User{}).Where("email = ?", "alice@example.com").Count(&count)
+		if count != 0 || signedIn(c) {
+			t.Errorf("email_verified %v: signed in", verified)
+		}
+	}
+
+	// Unless unverified addresses are allowed, when the account starts out
+	// unverified
+	provider := newFakeOIDCProvider(t)
+	delete(provider.userinfo, "email_verified")
+	handler, app := newTestApp(t, provider.configure, func(cfg *Config) { cfg.OIDCUnverifiedEmail = true })
+	c := newTestClient(t, handler)
+	provider.signIn(c)
+	if !signedIn(c) {
+		t.Fatal("not signed in with OIDC_ALLOW_UNVERIFIED_EMAIL")
+	}
+	var user User
+	app.db.Where("email = ?", "alice@example.com").First(&user)
+	if user.Verified {
+		t.Error("an account with an unverified address is marked verified")
+	}
+}
+
+func TestOIDCChecksIDToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		idToken  map[string]interface{}
+		userinfo map[string]interface{}
+	}{
+		{name: "another issuer", idToken: map[string]interface{}{"iss": "https://evil.example.com"}},
+		{name: "no issuer", idToken: map[string]interface{}{"iss": nil}},
+		{name: "another audience", idToken: map[string]interface{}{"aud": "other-client"}},
+		{name: "audience list without us", idToken: map[string]interface{}{"aud": []string{"a", "b"}}},
+		{name: "another authorized party", idToken: map[string]interface{}{"aud": []string{testOIDCClientID, "b"}, "azp": "b"}},
+		{name: "expired", idToken: map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}},
+		{name: "no expiry", idToken: map[string]interface{}{"exp": nil}},
+		{name: "another nonce", idToken: map[string]interface{}{"nonce": "replayed"}},
+		{name: "no nonce", idToken: map[string]interface{}{"nonce": nil}},
+		{name: "no subject", idToken: map[string]interface{}{"sub": nil}},
+		{name: "userinfo about another user", userinfo: map[string]interface{}{"sub": "user-2"}},
+	}
+	for _, tt := range tests {
+		provider := newFakeOIDCProvider(t)
+		provider.idToken = tt.idToken
+		for name, v := range tt.userinfo {
+			provider.userinfo[name] = v
+		}
+		handler, app := newTestApp(t, provider.configure)
+		c := newTestClient(t, handler)
+
+		if resp, body := provider.signIn(c); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want %d\n%s", tt.name, resp.StatusCode, http.StatusUnauthorized, body)
+		}
+		var count int64
+		app.db.Model(&User{}).Where("email = ?", "alice@example.com").Count(&count)
+		if count != 0 || signedIn(c) {
+			t.Errorf("%s: signed in", tt.name)
+		}
+	}
+}
+
+var connectLinkPattern = regexp.MustCompile(`/login/magic\?token=([0-9a-f]+)`)
+
+func TestOIDCConfirmsExistingAccount(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	provider.userinfo["groups"] = []string{"admins"}
+	handler, app := newTestApp(t, provider.configure, func(cfg *Config) { cfg.OIDCAdminGroup = "admins" })
+	mail := make(testMailer, 1)
+	app.mailer = mail
+	alice := createTestUser(t, app, "Alice@example.com", "Correct-Horse-1", RoleUser)
+
+	// Someone signing in with the same address doesn't get the account...
+	attacker := newTestClient(t, handler)
+	resp, body := provider.signIn(attacker)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "emailed") {
+		t.Fatalf("sign-in: status %d\n%s", resp.StatusCode, body)
+	}
+	if signedIn(attacker) {
+		t.Fatal("signed in to an existing account without its owner confirming")
+	}
+	var user User
+	app.db.First(&user, alice.ID)
+	if user.Role != RoleUser {
+		t.Errorf("role = %q before the owner confirmed, want %q", user.Role, RoleUser)
+	}
+	var count int64
+	app.db.Model(&ExternalLogin{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d external logins before the owner confirmed", count)
+	}
+
+	// ...until its owner opens the emailed link
+	var link string
+	select {
+	case body := <-mail:
+		link = connectLinkPattern.FindString(body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email sent")
+	}
+	if link == "" {
+		t.Fatal("the email has no link")
+	}
+	owner := newTestClient(t, handler)
+	owner.client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	if resp, body := owner.get(link); resp.StatusCode != http.StatusSeeOther || !signedIn(owner) {
+		t.Fatalf("opening the link: status %d\n%s", resp.StatusCode, body)
+	}
+	if resp, _ := owner.get(link); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("reusing the link: status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	// From then on the provider signs in to the account and sets its role
+	c := newTestClient(t, handler)
+	provider.signIn(c)
+	if !signedIn(c) {
+		t.Fatal("not signed in once the account is connected")
+	}
+	app.db.First(&user, alice.ID)
+	if user.Role != RoleAdmin {
+		t.Errorf("role = %q, want %q", user.Role, RoleAdmin)
+	}
+}
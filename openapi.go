@@ -0,0 +1,235 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiParam documents a query parameter of an API operation.
+type apiParam struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// apiOperation documents one route of apiV1Routes for the OpenAPI
+// document. Request and Response are zero values of the Go types the
+// handler decodes and encodes; their schemas are generated from the struct
+// fields and json tags, so they can't drift from the code. Add an entry here
+// whenever a route is added to apiV1Routes.
+type apiOperation struct {
+	Method   string
+	Path     string
+	Summary  string
+	Query    []apiParam
+	Request  interface{}
+	Status   int
+	Response interface{}
+	Errors   []int
+}
+
+// apiV1Operations lists the documented operations of API v1.
+var apiV1Operations = []apiOperation{
+	{Method: "get", Path: "/me", Summary: "Get the current user", Status: http.StatusOK, Response: userResponse{}},
+	{Method: "get", Path: "/stats", Summary: "Get item statistics and the item limit", Status: http.StatusOK, Response: statsResponse{}},
+	{
+		Method:  "get",
+		Path:    "/items",
+		Summary: "List items, newest first",
+		Query: []apiParam{
+			{Name: "limit", Type: "integer", Description: "Page size, 1 to 100 (default 20)"},
+			{Name: "cursor", Type: "string", Description: "The next_cursor or prev_cursor of another page"},
+			{Name: "search", Type: "string", Description: "Only items whose name or ID contains this"},
+			{Name: "status", Type: "string", Description: "active (default), archived or all"},
+		},
+		Status:   http.StatusOK,
+		Response: itemsPageResponse{},
+		Errors:   []int{http.StatusBadRequest},
+	},
+	{Method: "post", Path: "/items", Summary: "Create an item; name is required", Request: itemRequest{}, Status: http.StatusCreated, Response: itemResponse{}, Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusUnprocessableEntity}},
+	{Method: "get", Path: "/items/{id}", Summary: "Get an item", Status: http.StatusOK, Response: itemResponse{}, Errors: []int{http.StatusNotFound}},
+	{Method: "put", Path: "/items/{id}", Summary: "Update an item; omitted fields are kept", Request: itemRequest{}, Status: http.StatusOK, Response: itemResponse{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity}},
+	{Method: "delete", Path: "/items/{id}", Summary: "Delete an item", Status: http.StatusNoContent, Errors: []int{http.StatusNotFound}},
+}
+
+// openAPIComponents names the types that get a shared schema under
+// components/schemas and are referenced from operations.
+var openAPIComponents = map[reflect.Type]string{
+	reflect.TypeOf(userResponse{}):      "User",
+	reflect.TypeOf(statsResponse{}):     "Stats",
+	reflect.TypeOf(itemResponse{}):      "Item",
+	reflect.TypeOf(itemsPageResponse{}): "ItemPage",
+	reflect.TypeOf(itemRequest{}):       "ItemInput",
+	reflect.TypeOf(errorResponse{}):     "Error",
+}
+
+// openAPIDocument builds the OpenAPI 3 document for API v1.
+func openAPIDocument() map[string]interface{} {
+	schemas := map[string]interface{}{}
+	for t, name := range openAPIComponents {
+		schemas[name] = openAPISchema(t, false)
+	}
+
+	paths := map[string]map[string]interface{}{}
+	for _, op := range apiV1Operations {
+		var params []interface{}
+		if strings.Contains(op.Path, "{id}") {
+			params = append(params, map[string]interface{}{
+				"name": "id", "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "integer"},
+			})
+		}
+		for _, p := range op.Query {
+			params = append(params, map[string]interface{}{
+				"name": p.Name, "in": "query", "description": p.Description,
+				"schema": map[string]interface{}{"type": p.Type},
+			})
+		}
+
+		success := map[string]interface{}{"description": http.StatusText(op.Status)}
+		if op.Response != nil {
+			success["content"] = openAPIJSONContent(op.Response)
+		}
+		responses := map[string]interface{}{
+			strconv.Itoa(op.Status): success,
+		}
+		errorStatuses := append([]int{http.StatusUnauthorized, http.StatusTooManyRequests}, op.Errors...)
+		for _, status := range errorStatuses {
+			responses[strconv.Itoa(status)] = map[string]interface{}{
+				"description": http.StatusText(status),
+				"content":     openAPIJSONContent(errorResponse{}),
+			}
+		}
+
+		operation := map[string]interface{}{
+			"summary":   op.Summary,
+			"responses": responses,
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+		if op.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  openAPIJSONContent(op.Request),
+			}
+		}
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]interface{}{}
+		}
+		paths[op.Path][op.Method] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Go + HTMX Auth App API",
+			"version":     "v1",
+			"description": "Requests are rate limited; every response carries X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers.",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": "/api/v1"}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "A personal access token from the dashboard"},
+				"cookieAuth": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": "session", "description": "The browser session; state-changing requests also need X-CSRF-Token"},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"bearerAuth": []string{}},
+			map[string]interface{}{"cookieAuth": []string{}},
+		},
+	}
+}
+
+// openAPIJSONContent returns a content map with the schema of v.
+func openAPIJSONContent(v interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{
+			"schema": openAPISchema(reflect.TypeOf(v), true),
+		},
+	}
+}
+
+// openAPISchema returns the JSON schema of t as encoding/json would encode
+// it. When ref is true, types in openAPIComponents become references.
+// Pointer and omitempty fields are optional; pointers are also nullable.
+func openAPISchema(t reflect.Type, ref bool) map[string]interface{} {
+	if name, ok := openAPIComponents[t]; ok && ref {
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := openAPISchema(t.Elem(), true)
+		schema["nullable"] = true
+		return schema
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Uint, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": openAPISchema(t.Elem(), true)}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		var required []string
+		addStructFields(t, properties, &required)
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]interface{}{}
+}
+
+// addStructFields adds the JSON properties of struct type t, including
+// those of embedded structs, which encoding/json flattens.
+func addStructFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			addStructFields(field.Type, properties, required)
+			continue
+		}
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = openAPISchema(field.Type, true)
+		if field.Type.Kind() != reflect.Ptr && !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// openAPIHandler serves the OpenAPI document of API v1. It is public so
+// integrators can read it before they have a token.
+func (app *App) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPIDocument())
+}
+
+// apiDocsHandler serves Swagger UI for the OpenAPI document. "Try it out"
+// uses the session cookie, or a token entered under Authorize.
+func (app *App) apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	app.tmpl.ExecuteTemplate(w, "api_docs.templ", map[string]interface{}{
+		"SpecURL":   "/api/v1/openapi.json",
+		"CSRFToken": app.csrfToken(w, r),
+	})
+}
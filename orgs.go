@@ -0,0 +1,174 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// RoleOrgAdmin can see every item in their organization in multi-tenant
+// mode. Elsewhere it behaves like a regular user.
+const RoleOrgAdmin = "org_admin"
+
+// itemAccess is what the item scopes need to know about a user.
+type itemAccess struct {
+	userID   uint
+	orgID    *uint
+	orgAdmin bool
+}
+
+func (app *App) loadItemAccess(userID interface{}) itemAccess {
+	access := itemAccess{userID: toUint(userID)}
+	if !app.config.MultiTenant {
+		return access
+	}
+	var user User
+	if err := app.db.Select("id", "role", "org_id").First(&user, userID).Error; err == nil {
+		access.orgID = user.OrgID
+		access.orgAdmin = user.Role == RoleOrgAdmin && user.OrgID != nil
+	}
+	return access
+}
+
+// whereOrg restricts an Item query to orgID, where nil means items that
+// belong to no organization.
+func whereOrg(db *gorm.DB, orgID *uint) *gorm.DB {
+	if orgID == nil {
+		return db.Where("org_id IS NULL")
+	}
+	return db.Where("org_id = ?", *orgID)
+}
+
+// visibleItems scopes an Item query to the items userID may read: their own
+// items, or in multi-tenant mode every item in the organization for an
+// organization admin.
+func (app *App) visibleItems(userID interface{}) func(*gorm.DB) *gorm.DB {
+	access := app.loadItemAccess(userID)
+	return func(db *gorm.DB) *gorm.DB {
+		if !app.config.MultiTenant {
+			return db.Where("user_id = ?", access.userID)
+		}
+		if access.orgAdmin {
+			return whereOrg(db, access.orgID)
+		}
+		return whereOrg(db.Where("user_id = ?", access.userID), access.orgID)
+	}
+}
+
+// ownedItems scopes an Item query to the items userID may change: only
+// their own, and in multi-tenant mode only within their current
+// organization.
+func (app *App) ownedItems(userID interface{}) func(*gorm.DB) *gorm.DB {
+	access := app.loadItemAccess(userID)
+	return func(db *gorm.DB) *gorm.DB {
+		db = db.Where("user_id = ?", access.userID)
+		if app.config.MultiTenant {
+			db = whereOrg(db, access.orgID)
+		}
+		return db
+	}
+}
+
+// userOrgID returns the organization new items of userID belong to, or nil
+// outside multi-tenant mode.
+func (app *App) userOrgID(userID interface{}) *uint {
+	return app.loadItemAccess(userID).orgID
+}
+
+// adminCreateOrgHandler creates an organization from the "name" form value.
+func (app *App) adminCreateOrgHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := app.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`<div class="error">Organization name cannot be empty.</div>`))
+		return
+	}
+	var existing int64
+	app.db.Model(&Organization{}).Where("name = ?", name).Count(&existing)
+	if existing > 0 {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`<div class="error">An organization with this name already exists.</div>`))
+		return
+	}
+
+	org := Organization{Name: name}
+	if err := app.db.Create(&org).Error; err != nil {
+		log.Println("Error creating organization:", err)
+		writeServerError(w)
+		return
+	}
+	app.recordAudit(admin.ID, "create_org", 0)
+
+	app.tmpl.ExecuteTemplate(w, "admin_org.templ", map[string]interface{}{
+		"Org": org,
+	})
+}
+
+// adminAssignOrgHandler moves a user, and their items, into the
+// organization given by the "org_id" form value (empty removes them from
+// any organization). "role" may be "user" or "org_admin".
+func (app *App) adminAssignOrgHandler(w http.ResponseWriter, r *http.Request) {
+	admin, user, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var org *Organization
+	if value := r.FormValue("org_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		org = &Organization{}
+		if err != nil || app.db.First(org, id).Error != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<div class="error">Organization not found.</div>`))
+			return
+		}
+	}
+
+	role := r.FormValue("role")
+	if role == "" {
+		role = RoleUser
+	}
+	if user.Role == RoleAdmin {
+		role = RoleAdmin // site admins keep their role
+	} else if role != RoleUser && role != RoleOrgAdmin {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`<div class="error">Role must be user or org_admin.</div>`))
+		return
+	}
+	if org == nil && role == RoleOrgAdmin {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`<div class="error">An organization admin needs an organization.</div>`))
+		return
+	}
+
+	var orgID *uint
+	if org != nil {
+		orgID = &org.ID
+	}
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{"org_id": orgID, "role": role}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Item{}).Where("user_id = ?", user.ID).Update("org_id", orgID).Error
+	})
+	if err != nil {
+		log.Println("Error assigning organization:", err)
+		writeServerError(w)
+		return
+	}
+	app.recordAudit(admin.ID, "assign_org", user.ID)
+
+	app.tmpl.ExecuteTemplate(w, "admin_org.templ", map[string]interface{}{
+		"Org":  org,
+		"User": user,
+		"Role": role,
+	})
+}
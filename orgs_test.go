@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// createOrgUser adds a user with role in org.
+func createOrgUser(t *testing.T, app *App, email, role string, org Organization) User {
+	t.Helper()
+	user := createTestUser(t, app, email, "Correct-Horse-1", role)
+	user.OrgID = &org.ID
+	if err := app.db.Model(&user).Update("org_id", org.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestOrgItemIsolation(t *testing.T) {
+	handler, app := newTestApp(t, func(cfg *Config) { cfg.MultiTenant = true })
+	orgA, orgB := Organization{Name: "A"}, Organization{Name: "B"}
+	app.db.Create(&orgA)
+	app.db.Create(&orgB)
+	member := createOrgUser(t, app, "member@a.example.com", RoleUser, orgA)
+	orgAdmin := createOrgUser(t, app, "admin@a.example.com", RoleOrgAdmin, orgA)
+	outsider := createOrgUser(t, app, "user@b.example.com", RoleUser, orgB)
+	item := createTestItem(t, app, outsider, "B's item")
+	path := fmt.Sprintf("/items/%d", item.ID)
+
+	for _, user := range []User{member, orgAdmin} {
+		c := newTestClient(t, handler)
+		c.login(user.Email, "Correct-Horse-1")
+		requests := map[string]func() *http.Response{
+			"GET":    func() *http.Response { resp, _ := c.get(path); return resp },
+			"PUT":    func() *http.Response { resp, _ := c.do("PUT", path, strings.NewReader("name=Taken"), nil); return resp },
+			"DELETE": func() *http.Response { resp, _ := c.do("DELETE", path, nil, nil); return resp },
+		}
+		for method, send := range requests {
+			if resp := send(); resp.StatusCode != http.StatusNotFound {
+				t.Errorf("%s: %s %s: status = %d, want %d", user.Email, method, path, resp.StatusCode, http.StatusNotFound)
+			}
+		}
+
+		token := createTestToken(t, app, user, 0)
+		api := "/api/v1" + path
+		if resp := c.api("GET", api, token, nil, nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: GET %s: status = %d, want %d", user.Email, api, resp.StatusCode, http.StatusNotFound)
+		}
+		if resp := c.api("PUT", api, token, map[string]string{"name": "Taken"}, nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: PUT %s: status = %d, want %d", user.Email, api, resp.StatusCode, http.StatusNotFound)
+		}
+		if resp := c.api("DELETE", api, token, nil, nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: DELETE %s: status = %d, want %d", user.Email, api, resp.StatusCode, http.StatusNotFound)
+		}
+	}
+
+	var stored Item
+	if err := app.db.First(&stored, item.ID).Error; err != nil || stored.Name != "B's item" {
+		t.Errorf("org B's item after the requests = %+v, %v; want it unchanged", stored, err)
+	}
+}
+
+func TestOrgAdminSeesWholeOrg(t *testing.T) {
+	handler, app := newTestApp(t, func(cfg *Config) { cfg.MultiTenant = true })
+	orgA, orgB := Organization{Name: "A"}, Organization{Name: "B"}
+	app.db.Create(&orgA)
+	app.db.Create(&orgB)
+	member := createOrgUser(t, app, "member@a.example.com", RoleUser, orgA)
+	orgAdmin := createOrgUser(t, app, "admin@a.example.com", RoleOrgAdmin, orgA)
+	outsider := createOrgUser(t, app, "user@b.example.com", RoleUser, orgB)
+	createTestItem(t, app, member, "member's item")
+	createTestItem(t, app, orgAdmin, "admin's item")
+	createTestItem(t, app, outsider, "B's item")
+	c := newTestClient(t, handler)
+
+	names := func(user User) []string {
+		var page itemsPageResponse
+		c.api("GET", "/api/v1/items", createTestToken(t, app, user, 0), nil, &page)
+		var names []string
+		for _, item := range page.Items {
+			names = append(names, item.Name)
+		}
+		return names
+	}
+	if got := strings.Join(names(orgAdmin), ", "); got != "admin's item, member's item" {
+		t.Errorf("org admin sees %q, want both org A items", got)
+	}
+	if got := strings.Join(names(member), ", "); got != "member's item" {
+		t.Errorf("member sees %q, want only their own item", got)
+	}
+	if got := strings.Join(names(outsider), ", "); got != "B's item" {
+		t.Errorf("org B user sees %q, want only their own item", got)
+	}
+}
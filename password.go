@@ -0,0 +1,233 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"gorm.io/gorm"
+)
+
+// passwordProblems checks a new password against the password policy and,
+// if it passes, against known breaches. The breach check fails open: when
+// it can't be done the password is accepted.
+func (app *App) passwordProblems(r *http.Request, password, email string) []string {
+	problems := app.passwordPolicy().check(password, email)
+	if len(problems) > 0 {
+		return problems
+	}
+	breached, err := app.breaches.Breached(r.Context(), password)
+	if err != nil {
+		log.Println("Error checking for a breached password:", err)
+		return nil
+	}
+	if breached {
+		return []string{"This password has appeared in a data breach; choose a different one"}
+	}
+	return nil
+}
+
+// newPasswordErrors checks a new password and its confirmation, and
+// returns the template data showing what is wrong next to each field, or
+// nil if the password can be used.
+func (app *App) newPasswordErrors(r *http.Request, password, confirm, email string) map[string]interface{} {
+	problems := app.passwordProblems(r, password, email)
+	if len(problems) == 0 && password == confirm {
+		return nil
+	}
+	data := map[string]interface{}{"PasswordErrors": problems}
+	if password != confirm {
+		data["ConfirmError"] = "Passwords do not match"
+	}
+	return data
+}
+
+// passwordSettingsHandler shows the signed-in user's change password form.
+func (app *App) passwordSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.PasswordLoginDisabled {
+		app.notFoundHandler(w, r)
+		return
+	}
+	if _, ok := app.sessionUser(w, r); !ok {
+		return
+	}
+	app.tmpl.ExecuteTemplate(w, "account_password.templ", map[string]interface{}{})
+}
+
+// changePasswordHandler changes the signed-in user's password, or completes
+// a forced password change for a user who logged in with a temporary
+// password and then starts their session.
+func (app *App) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := app.store.Get(r, "session")
+	if userID, ok := session.Values["user_id"]; ok && userID != nil {
+		app.changeOwnPassword(w, r, session, userID)
+		return
+	}
+	userID, ok := session.Values["password_change_user_id"]
+	if !ok || userID == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`<div class="error">Unauthorized. Please log in.</div>`))
+		return
+	}
+
+	var user User
+	if err := app.db.First(&user, userID).Error; err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`<div class="error">Unauthorized. Please log in.</div>`))
+		return
+	}
+	// The account may have been suspended, or the password changed, since
+	// the sign-in started
+	if user.Disabled || !user.MustChangePassword {
+		app.endPasswordChange(w, r, session, user)
+		return
+	}
+
+	password := r.FormValue("password")
+	if errs := app.newPasswordErrors(r, password, r.FormValue("confirm_password"), user.Email); errs != nil {
+		app.tmpl.ExecuteTemplate(w, "change_password.templ", errs)
+		return
+	}
+
+	hashedPassword, err := app.passwords.Hash(password)
+	if err != nil {
+		log.Println("Error hashing password:", err)
+		writeServerError(w)
+		return
+	}
+	// Only one of several sign-ins with the temporary password can set the
+	// new one
+	result := app.db.Model(&User{}).Where("id = ? AND must_change_password = ?", user.ID, true).Updates(map[string]interface{}{
+		"password_hash":        hashedPassword,
+		"must_change_password": false,
+	})
+	if result.Error != nil {
+		log.Println("Error changing password:", result.Error)
+		writeServerError(w)
+		return
+	}
+	if result.RowsAffected == 0 {
+		app.endPasswordChange(w, r, session, user)
+		return
+	}
+
+	app.authEvents.log(r, AuthEventPasswordChange, AuthOutcomeSuccess, user, "")
+
+	delete(session.Values, "password_change_user_id")
+	if err := app.setSessionUser(r, session, user); err != nil {
+		log.Println("Error starting session:", err)
+		writeServerError(w)
+		return
+	}
+	if err := session.Save(r, w); err != nil {
+		log.Println("Error saving session:", err)
+		writeServerError(w)
+		return
+	}
+
+	app.tmpl.ExecuteTemplate(w, "dashboard.templ", map[string]interface{}{
+		"User": user,
+	})
+}
+
+// endPasswordChange abandons a forced password change that can no longer
+// be completed and shows the login form with the reason.
+func (app *App) endPasswordChange(w http.ResponseWriter, r *http.Request, session *sessions.Session, user User) {
+	delete(session.Values, "password_change_user_id")
+	if err := session.Save(r, w); err != nil {
+		log.Println("Error saving session:", err)
+	}
+	data := map[string]interface{}{"Error": sessionEndedMessage, "Email": user.Email}
+	if user.Disabled {
+		app.logLogin(r, AuthOutcomeDisabled, user, "")
+		data["Error"] = accountSuspendedMessage
+		w.WriteHeader(http.StatusForbidden)
+	}
+	app.tmpl.ExecuteTemplate(w, "login.templ", data)
+}
+
+// changeOwnPassword sets a new password for a signed-in user after checking
+// their current one. The user's other sessions are signed out; this one is
+// kept.
+func (app *App) changeOwnPassword(w http.ResponseWriter, r *http.Request, session *sessions.Session, userID interface{}) {
+	if app.config.PasswordLoginDisabled {
+		app.notFoundHandler(w, r)
+		return
+	}
+	var user User
+	if err := app.db.First(&user, userID).Error; err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`<div class="error">Unauthorized. Please log in.</div>`))
+		return
+	}
+	renderError := func(message string) {
+		app.tmpl.ExecuteTemplate(w, "account_password.templ", map[string]interface{}{
+			"Error": message,
+		})
+	}
+
+	// Failed guesses slow down like failed logins, so a session left open
+	// can't be used to brute-force the password
+	current := r.FormValue("current_password")
+	backoffKey := "password|" + strconv.FormatUint(uint64(user.ID), 10)
+	if err := sleepContext(r.Context(), app.loginBackoff.delay(backoffKey, time.Now())); err != nil {
+		return
+	}
+	if !app.passwords.Verify(user.PasswordHash, current) {
+		app.authEvents.log(r, AuthEventPasswordChange, AuthOutcomeFailure, user, "")
+		app.loginBackoff.fail(backoffKey, time.Now())
+		renderError("Current password is incorrect")
+		return
+	}
+	app.loginBackoff.reset(backoffKey)
+
+	password := r.FormValue("password")
+	if errs := app.newPasswordErrors(r, password, r.FormValue("confirm_password"), user.Email); errs != nil {
+		app.tmpl.ExecuteTemplate(w, "account_password.templ", errs)
+		return
+	}
+	if password == current {
+		renderError("New password must be different from the current one")
+		return
+	}
+
+	hashedPassword, err := app.passwords.Hash(password)
+	if err != nil {
+		log.Println("Error hashing password:", err)
+		writeServerError(w)
+		return
+	}
+	err = app.db.Model(&user).Updates(map[string]interface{}{
+		"password_hash":        hashedPassword,
+		"must_change_password": false,
+		"session_version":      gorm.Expr("session_version + 1"),
+	}).Error
+	if err == nil {
+		err = revokeUserSessions(app.db, user.ID)
+	}
+	if err == nil {
+		err = app.db.Select("session_version").First(&user, user.ID).Error
+	}
+	if err != nil {
+		log.Println("Error changing password:", err)
+		writeServerError(w)
+		return
+	}
+	app.authEvents.log(r, AuthEventPasswordChange, AuthOutcomeSuccess, user, "")
+
+	if err := app.setSessionUser(r, session, user); err != nil {
+		log.Println("Error starting session:", err)
+		writeServerError(w)
+		return
+	}
+	if err := session.Save(r, w); err != nil {
+		log.Println("Error saving session:", err)
+		writeServerError(w)
+		return
+	}
+	app.tmpl.ExecuteTemplate(w, "account_password.templ", map[string]interface{}{
+		"Success": "Your password has been changed. Other devices have been signed out.",
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var temporaryPasswordPattern = regexp.MustCompile(`Temporary password: <code>([^<]+)</code>`)
+
+// resetPassword has admin reset user's password and returns the temporary
+// one.
+func resetPassword(t *testing.T, admin *testClient, user User) string {
+	t.Helper()
+	_, body := admin.post(fmt.Sprintf("/admin/users/%d/reset-password", user.ID), nil)
+	m := temporaryPasswordPattern.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("no temporary password in:\n%s", body)
+	}
+	return m[1]
+}
+
+// startForcedPasswordChange signs in with a temporary password and fails
+// the test unless the new password form comes back.
+func startForcedPasswordChange(t *testing.T, c *testClient, email, password string) {
+	t.Helper()
+	_, body := c.post("/login", url.Values{"email": {email}, "password": {password}})
+	if !strings.Contains(body, "Choose a new one to continue") {
+		t.Fatalf("signing in with the temporary password didn't ask for a new one:\n%s", body)
+	}
+}
+
+func newPasswordForm(password string) url.Values {
+	return url.Values{"password": {password}, "confirm_password": {password}}
+}
+
+func TestAdminResetPasswordEndsSessions(t *testing.T) {
+	handler, app := newTestApp(t)
+	createTestUser(t, app, "admin@test.example", "Correct-Horse-1", RoleAdmin)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	admin := newTestClient(t, handler)
+	admin.login("admin@test.example", "Correct-Horse-1")
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	temporary := resetPassword(t, admin, alice)
+	if signedIn(c) {
+		t.Error("still signed in after an admin reset the password")
+	}
+	var sessions int64
+	app.db.Model(&UserSession{}).Where("user_id = ?", alice.ID).Count(&sessions)
+	if sessions != 0 {
+		t.Errorf("%d sessions left after the reset", sessions)
+	}
+
+	// The temporary password leads to the forced change, then the dashboard
+	c = newTestClient(t, handler)
+	startForcedPasswordChange(t, c, "alice@example.com", temporary)
+	if signedIn(c) {
+		t.Fatal("signed in before choosing a new password")
+	}
+	resp, body := c.post("/account/password", newPasswordForm("Brand-New-Pass-2"))
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "<h1>Dashboard</h1>") {
+		t.Fatalf("choosing a new password: status %d\n%s", resp.StatusCode, body)
+	}
+	newTestClient(t, handler).login("alice@example.com", "Brand-New-Pass-2")
+}
+
+func TestForcedPasswordChangeRejectsSuspendedUser(t *testing.T) {
+	handler, app := newTestApp(t)
+	createTestUser(t, app, "admin@test.example", "Correct-Horse-1", RoleAdmin)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	admin := newTestClient(t, handler)
+	admin.login("admin@test.example", "Correct-Horse-1")
+	temporary := resetPassword(t, admin, alice)
+
+	c := newTestClient(t, handler)
+	startForcedPasswordChange(t, c, "alice@example.com", temporary)
+	admin.post(fmt.Sprintf("/admin/users/%d/disable", alice.ID), nil)
+
+	resp, body := c.post("/account/password", newPasswordForm("Brand-New-Pass-2"))
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(body, accountSuspendedMessage) {
+		t.Errorf("status %d, want %d and the suspension message\n%s", resp.StatusCode, http.StatusForbidden, body)
+	}
+	if signedIn(c) {
+		t.Error("a suspended user got a session")
+	}
+	var user User
+	app.db.First(&user, alice.ID)
+	if !user.MustChangePassword || !app.passwords.Verify(user.PasswordHash, temporary) {
+		t.Error("a suspended user changed their password")
+	}
+
+	// The pending change is gone, so retrying doesn't help
+	if resp, _ := c.post("/account/password", newPasswordForm("Brand-New-Pass-2")); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("retry: status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestForcedPasswordChangeOnlyOnce(t *testing.T) {
+	handler, app := newTestApp(t)
+	createTestUser(t, app, "admin@test.example", "Correct-Horse-1", RoleAdmin)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	admin := newTestClient(t, handler)
+	admin.login("admin@test.example", "Correct-Horse-1")
+	temporary := resetPassword(t, admin, alice)
+
+	// Two sign-ins with the temporary password; the first to finish wins
+	first, second := newTestClient(t, handler), newTestClient(t, handler)
+	startForcedPasswordChange(t, first, "alice@example.com", temporary)
+	startForcedPasswordChange(t, second, "alice@example.com", temporary)
+	if _, body := first.post("/account/password", newPasswordForm("Brand-New-Pass-2")); !strings.Contains(body, "<h1>Dashboard</h1>") {
+		t.Fatalf("first change failed:\n%s", body)
+	}
+
+	_, body := second.post("/account/password", newPasswordForm("Attacker-Pass-3"))
+	if !strings.Contains(body, sessionEndedMessage) {
+		t.Errorf("second change wasn't turned away:\n%s", body)
+	}
+	if signedIn(second) {
+		t.Error("the second sign-in got a session")
+	}
+	var user User
+	app.db.First(&user, alice.ID)
+	if !app.passwords.Verify(user.PasswordHash, "Brand-New-Pass-2") {
+		t.Error("the second change replaced the new password")
+	}
+}
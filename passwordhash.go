@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher turns passwords into hashes for the users table and
+// checks passwords against them.
+type PasswordHasher interface {
+	// Hash returns a salted hash of password.
+	Hash(password string) (string, error)
+	// Verify reports whether password matches hash. Hashes of another
+	// scheme never match.
+	Verify(hash, password string) bool
+	// NeedsRehash reports whether hash should be replaced by a fresh Hash
+	// of the same password, because it uses another scheme or weaker
+	// parameters.
+	NeedsRehash(hash string) bool
+}
+
+// Password hashing schemes
+const (
+	PasswordHasherArgon2id = "argon2id"
+	PasswordHasherBcrypt   = "bcrypt"
+)
+
+// newPasswordHasher returns the hasher chosen by PASSWORD_HASHER for new
+// passwords. It also accepts hashes of the other scheme, and reports them,
+// like bcrypt hashes below BCRYPT_COST, as needing a rehash.
+func newPasswordHasher(cfg Config) PasswordHasher {
+	argon := argon2idHasher{time: 3, memory: 64 * 1024, threads: 4, keyLen: 32, saltLen: 16}
+	bc := bcryptHasher{cost: cfg.BcryptCost}
+	if cfg.PasswordHasher == PasswordHasherBcrypt {
+		return upgradingHasher{current: bc, legacy: []PasswordHasher{argon}}
+	}
+	return upgradingHasher{current: argon, legacy: []PasswordHasher{bc}}
+}
+
+// upgradingHasher hashes with current and also verifies hashes made by the
+// legacy hashers, which always need a rehash.
+type upgradingHasher struct {
+	current PasswordHasher
+	legacy  []PasswordHasher
+}
+
+func (h upgradingHasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+func (h upgradingHasher) Verify(hash, password string) bool {
+	if h.current.Verify(hash, password) {
+		return true
+	}
+	for _, l := range h.legacy {
+		if l.Verify(hash, password) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h upgradingHasher) NeedsRehash(hash string) bool {
+	return h.current.NeedsRehash(hash)
+}
+
+// argon2idHasher stores hashes in the PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>, so the parameters can be
+// raised later without breaking existing hashes.
+type argon2idHasher struct {
+	time    uint32
+	memory  uint32 // KiB
+	threads uint8
+	keyLen  uint32
+	saltLen int
+}
+
+func (h argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.time, h.memory, h.threads, h.keyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h argon2idHasher) Verify(hash, password string) bool {
+	params, salt, key, ok := parseArgon2idHash(hash)
+	if !ok {
+		return false
+	}
+	got := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1
+}
+
+func (h argon2idHasher) NeedsRehash(hash string) bool {
+	params, salt, key, ok := parseArgon2idHash(hash)
+	return !ok || params.time < h.time || params.memory < h.memory || params.threads < h.threads ||
+		uint32(len(key)) < h.keyLen || len(salt) < h.saltLen
+}
+
+// parseArgon2idHash splits a hash made by argon2idHasher into its
+// parameters, salt and key.
+func parseArgon2idHash(hash string) (params argon2idHasher, salt, key []byte, ok bool) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return params, nil, nil, false
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, false
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 || params.time == 0 || params.threads == 0 {
+		return params, nil, nil, false
+	}
+	return params, salt, key, true
+}
+
+// bcryptHasher is the scheme passwords were hashed with before Argon2id,
+// and can still be chosen with PASSWORD_HASHER. Raising its cost makes
+// existing hashes need a rehash.
+type bcryptHasher struct {
+	cost int
+}
+
+func (h bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(hash), err
+}
+
+func (h bcryptHasher) Verify(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (h bcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.cost
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// minPasswordLength is the shortest PASSWORD_MIN_LENGTH allowed.
+const minPasswordLength = 8
+
+// maxPasswordLength caps passwords so hashing one stays cheap.
+const maxPasswordLength = 128
+
+// commonPasswords are passwords that meet the length and character rules
+// but are among the first an attacker tries. They are compared in lower
+// case.
+var commonPasswords = map[string]bool{
+	"password1": true, "password12": true, "password123": true, "passw0rd": true, "passw0rd1": true,
+	"p4ssw0rd": true, "qwerty123": true, "qwerty12": true, "qwertyuiop1": true, "abc12345": true,
+	"abcd1234": true, "1q2w3e4r": true, "1q2w3e4r5t": true, "q1w2e3r4": true, "zaq12wsx": true,
+	"1qaz2wsx": true, "iloveyou1": true, "welcome1": true, "welcome123": true, "letmein1": true,
+	"monkey123": true, "dragon123": true, "sunshine1": true, "princess1": true, "football1": true,
+	"baseball1": true, "superman1": true, "trustno1": true, "admin123": true, "admin1234": true,
+	"changeme1": true, "changeme123": true, "test1234": true, "test12345": true, "secret123": true,
+	"master123": true, "michael1": true, "charlie1": true, "shadow123": true, "summer2024": true,
+	"winter2024": true, "spring2024": true, "autumn2024": true, "summer2025": true, "winter2025": true,
+	"password2024": true, "password2025": true, "asdf1234": true, "asdfgh123": true, "computer1": true,
+}
+
+// passwordPolicy holds the rules every new password must follow, whether
+// it is chosen at registration, on a password change or after a reset.
+type passwordPolicy struct {
+	minLength int
+}
+
+func (app *App) passwordPolicy() passwordPolicy {
+	return passwordPolicy{minLength: app.config.PasswordMinLength}
+}
+
+// check returns a message for every rule password breaks, for showing
+// next to the password field; none means the password is acceptable.
+// email is the account's address, which the password must not be built
+// from.
+func (p passwordPolicy) check(password, email string) []string {
+	var problems []string
+	length := utf8.RuneCountInString(password)
+	if length < p.minLength {
+		problems = append(problems, fmt.Sprintf("Use at least %d characters", p.minLength))
+	}
+	if length > maxPasswordLength {
+		problems = append(problems, fmt.Sprintf("Use at most %d characters", maxPasswordLength))
+	}
+
+	var hasLetter, hasDigit bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLetter(c):
+			hasLetter = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		problems = append(problems, "Use both letters and numbers")
+	}
+
+	lower := strings.ToLower(password)
+	name, _, _ := strings.Cut(strings.ToLower(email), "@")
+	switch {
+	case commonPasswords[lower]:
+		problems = append(problems, "This password is too common; choose one that is harder to guess")
+	case isPredictable(password):
+		problems = append(problems, "Avoid repeated characters and sequences like 1234 or abcd")
+	case len(name) >= 3 && strings.Contains(lower, name):
+		problems = append(problems, "Don't use your email address in your password")
+	}
+	return problems
+}
+
+// isPredictable reports whether password is mostly one repeated character,
+// or one run such as "abcd1234" or "98765432" when its letters and digits
+// are read separately.
+func isPredictable(password string) bool {
+	distinct := map[rune]bool{}
+	for _, c := range password {
+		distinct[unicode.ToLower(c)] = true
+	}
+	if len(distinct) < 4 {
+		return true
+	}
+	var letters, digits []rune
+	for _, c := range strings.ToLower(password) {
+		switch {
+		case unicode.IsLetter(c):
+			letters = append(letters, c)
+		case unicode.IsDigit(c):
+			digits = append(digits, c)
+		default:
+			return false
+		}
+	}
+	return isRun(letters) && isRun(digits)
+}
+
+// isRun reports whether every character in s is one more, or every one is
+// one less, than the one before it.
+func isRun(s []rune) bool {
+	if len(s) < 2 {
+		return true
+	}
+	step := s[1] - s[0]
+	if step != 1 && step != -1 {
+		return false
+	}
+	for i := 2; i < len(s); i++ {
+		if s[i]-s[i-1] != step {
+			return false
+		}
+	}
+	return true
+}
+
+// temporaryPassword returns a random password that satisfies the policy,
+// for accounts whose user must choose their own before signing in.
+func (p passwordPolicy) temporaryPassword() (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	length := 14
+	if p.minLength > length {
+		length = p.minLength
+	}
+	for {
+		password := make([]byte, length)
+		for i := range password {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+			if err != nil {
+				return "", err
+			}
+			password[i] = alphabet[n.Int64()]
+		}
+		if len(p.check(string(password), "")) == 0 {
+			return string(password), nil
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// passwordResetsPerHour limits reset requests per IP and per email address.
+const passwordResetsPerHour = 5
+
+const passwordResetSentMessage = "If an account exists for that email, we've sent a link to reset your password."
+
+// passwordResetPageHandler shows the "forgot password" form, or the new
+// password form when the emailed link's token is in the query string.
+func (app *App) passwordResetPageHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.PasswordLoginDisabled {
+		app.notFoundHandler(w, r)
+		return
+	}
+	data := map[string]interface{}{}
+	if token := r.URL.Query().Get("token"); token != "" {
+		if _, err := app.findPasswordReset(token, time.Now()); err != nil {
+			data["Error"] = "This reset link is invalid or has expired. Please request a new one."
+		} else {
+			data["Token"] = token
+		}
+	}
+
+	if r.Header.Get("HX-Request") == "true" {
+		app.tmpl.ExecuteTemplate(w, "password_reset.templ", data)
+		return
+	}
+	app.renderPage(w, r, http.StatusOK, "password_reset", data)
+}
+
+// requestPasswordResetHandler emails a reset link to the "email" form
+// value. The response is the same whether or not the account exists, and
+// the mail is sent in the background so timing doesn't tell either.
+func (app *App) requestPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.PasswordLoginDisabled {
+		app.notFoundHandler(w, r)
+		return
+	}
+	email := strings.TrimSpace(r.FormValue("email"))
+	data := map[string]interface{}{"Email": email}
+
+	now := time.Now()
+	ipLimit := app.limiter.allow("password-reset:ip:"+clientIP(r), passwordResetsPerHour, time.Hour, now)
+	if !ipLimit.Allowed {
+		setRateLimitHeaders(w, ipLimit, now)
+		w.WriteHeader(http.StatusTooManyRequests)
+		data["Error"] = "Too many reset requests. Please try again later."
+		app.tmpl.ExecuteTemplate(w, "password_reset.templ", data)
+		return
+	}
+	data["Success"] = passwordResetSentMessage
+
+	// A quiet per-email limit stops the form being used to flood an inbox
+	emailLimit := app.limiter.allow("password-reset:email:"+strings.ToLower(email), passwordResetsPerHour, time.Hour, now)
+	var user User
+	if !emailLimit.Allowed || app.db.Where("email = ?", email).First(&user).Error != nil || user.Disabled || user.Demo {
+		app.tmpl.ExecuteTemplate(w, "password_reset.templ", data)
+		return
+	}
+
+	token, err := app.createPasswordReset(user, now)
+	if err != nil {
+		log.Println("Error creating password reset:", err)
+		writeServerError(w)
+		return
+	}
+	link := app.config.BaseURL + "/password/reset?token=" + url.QueryEscape(token)
+	go func() {
+		body := fmt.Sprintf("Someone asked to reset the password for %s.\n\n"+
+			"Open this link within %s to choose a new password:\n%s\n\n"+
+			"If it wasn't you, you can ignore this email; your password has not changed.\n",
+			user.Email, app.config.PasswordResetTTL, link)
+		if err := app.mailer.Send(user.Email, "Reset your password", body); err != nil {
+			log.Println("Error sending password reset email:", err)
+		}
+	}()
+
+	app.tmpl.ExecuteTemplate(w, "password_reset.templ", data)
+}
+
+// confirmPasswordResetHandler sets a new password for the user behind a
+// valid reset token. The token is single use, and any other outstanding
+// tokens for the user and all of their sessions are invalidated.
+func (app *App) confirmPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.PasswordLoginDisabled {
+		app.notFoundHandler(w, r)
+		return
+	}
+	token := r.FormValue("token")
+	password := r.FormValue("password")
+	now := time.Now()
+
+	reset, err := app.findPasswordReset(token, now)
+	if err != nil {
+		app.tmpl.ExecuteTemplate(w, "password_reset.templ", map[string]interface{}{
+			"Error": "This reset link is invalid or has expired. Please request a new one.",
+		})
+		return
+	}
+	var user User
+	if err := app.db.First(&user, reset.UserID).Error; err != nil {
+		writeServerError(w)
+		return
+	}
+	if errs := app.newPasswordErrors(r, password, r.FormValue("confirm_password"), user.Email); errs != nil {
+		errs["Token"] = token
+		app.tmpl.ExecuteTemplate(w, "password_reset.templ", errs)
+		return
+	}
+
+	hashedPassword, err := app.passwords.Hash(password)
+	if err != nil {
+		log.Println("Error hashing password:", err)
+		writeServerError(w)
+		return
+	}
+	err = app.db.Transaction(func(tx *gorm.DB) error {
+		// Claim the token first so two concurrent requests can't both use it
+		claim := tx.Model(&PasswordReset{}).Where("id = ? AND used_at IS NULL", reset.ID).Update("used_at", now)
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := tx.Model(&PasswordReset{}).Where("user_id = ? AND used_at IS NULL", reset.UserID).Update("used_at", now).Error; err != nil {
+			return err
+		}
+		// Sign out every session, in case someone else had the old password
+		err := tx.Model(&User{}).Where("id = ?", reset.UserID).Updates(map[string]interface{}{
+			"password_hash":        hashedPassword,
+			"must_change_password": false,
+			"session_version":      gorm.Expr("session_version + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, reset.UserID)
+	})
+	if err == gorm.ErrRecordNotFound {
+		app.tmpl.ExecuteTemplate(w, "password_reset.templ", map[string]interface{}{
+			"Error": "This reset link has already been used. Please request a new one.",
+		})
+		return
+	}
+	if err != nil {
+		log.Println("Error resetting password:", err)
+		writeServerError(w)
+		return
+	}
+
+	app.authEvents.log(r, AuthEventPasswordReset, AuthOutcomeSuccess, user, "")
+	app.tmpl.ExecuteTemplate(w, "login.templ", map[string]interface{}{
+		"Success": "Your password has been changed. You can now sign in.",
+		"Email":   user.Email,
+	})
+}
+
+// createPasswordReset stores a new reset token for user and returns it. Only
+// its hash is kept in the database.
+func (app *App) createPasswordReset(user User, now time.Time) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	reset := PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(app.config.PasswordResetTTL),
+	}
+	if err := app.db.Create(&reset).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// findPasswordReset looks up an unused, unexpired reset by its token.
+func (app *App) findPasswordReset(token string, now time.Time) (PasswordReset, error) {
+	var reset PasswordReset
+	err := app.db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), now).First(&reset).Error
+	return reset, err
+}
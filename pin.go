@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// pinnedFirst is the start of every items list ORDER BY: pinned items come
+// before the rest, whatever the chosen sort.
+const pinnedFirst = "items.pinned DESC, "
+
+// pinItemHandler pins one of the user's items to the top of their list, or
+// unpins it when it is pinned. The browser gets the items list with the
+// current filters and page; JSON clients get the item.
+func (app *App) pinItemHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	var item Item
+	if err := app.db.Scopes(app.ownedItems(userID)).Preload("Category").Where("id = ?", mux.Vars(r)["id"]).First(&item).Error; err != nil {
+		writeItemNotFound(w, r)
+		return
+	}
+	item.Pinned = !item.Pinned
+	if err := app.db.Model(&item).Select("pinned").Updates(&item).Error; err != nil {
+		log.Println("Error pinning item:", err)
+		writeServerError(w)
+		return
+	}
+	app.loadItemTags(&item)
+	app.touchItems(userID)
+	app.notifyItem(WebhookItemUpdated, item)
+	if respondJSON(r) {
+		writeJSON(w, http.StatusOK, newItemResponse(item))
+		return
+	}
+	app.writeItemList(w, r, userID, app.itemListData(userID, parseItemFilter(r), parseItemPage(r)))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPprofAbsentByDefault(t *testing.T) {
+	handler, app := newTestApp(t, func(cfg *Config) { cfg.DebugPprof = false })
+	createTestUser(t, app, "root@example.com", "Correct-Horse-1", RoleAdmin)
+	c := newTestClient(t, handler)
+	c.login("root@example.com", "Correct-Horse-1")
+
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/heap"} {
+		if resp, _ := c.get(path); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s: status = %d, want %d", path, resp.StatusCode, http.StatusNotFound)
+		}
+	}
+}
+
+func TestPprofAdminOnly(t *testing.T) {
+	handler, app := newTestApp(t, func(cfg *Config) { cfg.DebugPprof = true })
+	createTestUser(t, app, "root@example.com", "Correct-Horse-1", RoleAdmin)
+	createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+
+	anonymous := newTestClient(t, handler)
+	if resp, _ := anonymous.get("/debug/pprof/"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("signed out: status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	user := newTestClient(t, handler)
+	user.login("alice@example.com", "Correct-Horse-1")
+	if resp, _ := user.get("/debug/pprof/"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("user: status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	admin := newTestClient(t, handler)
+	admin.login("root@example.com", "Correct-Horse-1")
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/heap?debug=1"} {
+		if resp, _ := admin.get(path); resp.StatusCode != http.StatusOK {
+			t.Errorf("admin GET %s: status = %d, want %d", path, resp.StatusCode, http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Item priorities, lowest first
+const (
+	ItemPriorityLow    = "low"
+	ItemPriorityNormal = "normal"
+	ItemPriorityHigh   = "high"
+	ItemPriorityUrgent = "urgent"
+)
+
+// itemPriorities lists the priorities from lowest to highest.
+var itemPriorities = []string{ItemPriorityLow, ItemPriorityNormal, ItemPriorityHigh, ItemPriorityUrgent}
+
+// ItemSortPriority is the "sort" parameter value that lists items by
+// priority, most urgent first and newest first within a priority.
+const ItemSortPriority = "priority"
+
+// priorityOrder ranks the priorities for ORDER BY, most urgent first.
+const priorityOrder = "CASE items.priority WHEN 'urgent' THEN 0 WHEN 'high' THEN 1 WHEN 'normal' THEN 2 ELSE 3 END"
+
+// parsePriority reads a priority, ignoring case and surrounding spaces. An
+// empty value is ItemPriorityNormal.
+func parsePriority(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return ItemPriorityNormal, nil
+	}
+	if !slices.Contains(itemPriorities, value) {
+		return "", errors.New("Priority must be low, normal, high or urgent")
+	}
+	return value, nil
+}
+
+// withPriority scopes an Item query to one priority. An empty or unknown
+// value doesn't filter.
+func withPriority(priority string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if !slices.Contains(itemPriorities, priority) {
+			return db
+		}
+		return db.Where("items.priority = ?", priority)
+	}
+}
+
+// Prioritized reports whether the item's priority is above normal, which
+// the items list marks.
+func (item Item) Prioritized() bool {
+	return item.Priority == ItemPriorityHigh || item.Priority == ItemPriorityUrgent
+}
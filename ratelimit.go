@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limit counter backends
+const (
+	RateLimitStoreMemory = "memory"
+	RateLimitStoreRedis  = "redis"
+)
+
+// rateLimitCounter takes requests from token buckets keyed by an arbitrary
+// string: allow takes a token from key's bucket, which holds at most limit
+// tokens and refills at limit tokens per period. rateLimiter keeps the
+// buckets in memory, so each app instance limits on its own;
+// redisRateLimiter shares them between instances.
+type rateLimitCounter interface {
+	allow(key string, limit int, period time.Duration, now time.Time) rateLimitResult
+}
+
+// rateLimiter is an in-memory token bucket limiter keyed by an arbitrary
+// string. Each key gets its own bucket that refills continuously at the
+// rate passed to allow. Prefix keys with their purpose ("token:",
+// "signup:"...) so unrelated limits sharing a limiter don't collide.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // when the bucket is back to capacity, and no different from a new one
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// rateLimitResult describes the state of a bucket after a call to allow.
+type rateLimitResult struct {
+	Allowed bool
+	// Limit is the bucket capacity and Remaining the whole tokens left.
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until the next token is available when the
+	// request was refused, and ResetAfter how long until the bucket is
+	// full again.
+	RetryAfter time.Duration
+	ResetAfter time.Duration
+}
+
+// allow takes a token from key's bucket, which holds at most limit tokens
+// and refills at limit tokens per period.
+func (l *rateLimiter) allow(key string, limit int, period time.Duration, now time.Time) rateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget full buckets now and then so the map can't grow forever
+	if len(l.buckets) > 10000 {
+		for k, b := range l.buckets {
+			if !now.Before(b.full) {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	capacity := float64(limit)
+	b, found := l.buckets[key]
+	if !found {
+		b = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[key] = b
+	}
+
+	// Refill for the time elapsed since the last request
+	refillPerSecond := capacity / period.Seconds()
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*refillPerSecond)
+	b.last = now
+
+	result := rateLimitResult{Limit: limit}
+	if b.tokens < 1 {
+		result.RetryAfter = secondsToDuration((1 - b.tokens) / refillPerSecond)
+	} else {
+		b.tokens--
+		result.Allowed = true
+		result.Remaining = int(b.tokens)
+	}
+	result.ResetAfter = secondsToDuration((capacity - b.tokens) / refillPerSecond)
+	b.full = now.Add(result.ResetAfter)
+	return result
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// setRateLimitHeaders describes result in X-RateLimit-* headers, plus
+// Retry-After when the request was refused. X-RateLimit-Reset is the Unix
+// time at which the bucket is full again.
+func setRateLimitHeaders(w http.ResponseWriter, result rateLimitResult, now time.Time) {
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(now.Add(result.ResetAfter).Unix(), 10))
+	if !result.Allowed {
+		h.Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+	}
+}
+
+// rateLimit charges state-changing requests and API calls to the client
+// IP's budget (IP_RATE_LIMIT) and, for signed-in users, to the user's
+// (USER_RATE_LIMIT), answering 429 with Retry-After once either is spent.
+// It sits in front of the route-specific limits, such as the login and API
+// token ones, as a general cap on how fast one client can change things.
+func (app *App) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if !strings.HasPrefix(r.URL.Path, "/api/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		now := time.Now()
+		if !app.allowRequest(w, r, "ip:"+clientIP(r), app.config.IPRateLimit, now) {
+			return
+		}
+		session, _ := app.store.Get(r, "session")
+		if userID, ok := session.Values["user_id"]; ok && userID != nil {
+			key := "user:" + strconv.Itoa(int(toUint(userID)))
+			if !app.allowRequest(w, r, key, app.config.UserRateLimit, now) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowRequest takes one request from key's per-minute budget for
+// rateLimit, writing the 429 response when it is spent. A perMinute of 0
+// means no limit.
+func (app *App) allowRequest(w http.ResponseWriter, r *http.Request, key string, perMinute int, now time.Time) bool {
+	if perMinute <= 0 {
+		return true
+	}
+	limit := app.limiter.allow("requests:"+key, perMinute, time.Minute, now)
+	if limit.Allowed {
+		return true
+	}
+	setRateLimitHeaders(w, limit, now)
+	if wantsJSON(r) {
+		writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		return false
+	}
+	app.writeErrorPage(w, r, http.StatusTooManyRequests,
+		fmt.Sprintf("Too many requests. Please wait %d seconds and try again.", int(math.Ceil(limit.RetryAfter.Seconds()))))
+	return false
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkRateLimited fails unless resp is a 429 with the rate limit headers
+// for a spent budget of limit requests.
+func checkRateLimited(t *testing.T, resp *http.Response, limit int) {
+	t.Helper()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+	if retry, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || retry < 1 {
+		t.Errorf("Retry-After = %q, want a positive number of seconds", resp.Header.Get("Retry-After"))
+	}
+	if got := resp.Header.Get("X-RateLimit-Limit"); got != strconv.Itoa(limit) {
+		t.Errorf("X-RateLimit-Limit = %q, want %d", got, limit)
+	}
+	if got := resp.Header.Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 0", got)
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if now := time.Now().Unix(); err != nil || reset < now || reset > now+60 {
+		t.Errorf("X-RateLimit-Reset = %q, want a Unix time within the next minute", resp.Header.Get("X-RateLimit-Reset"))
+	}
+}
+
+func TestTokenRateLimit(t *testing.T) {
+	handler, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	token := createTestToken(t, app, alice, 3)
+	other := createTestToken(t, app, alice, 3)
+	c := newTestClient(t, handler)
+
+	for want := 2; want >= 0; want-- {
+		resp := c.api("GET", "/api/v1/items", token, nil, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request within the budget: status %d", resp.StatusCode)
+		}
+		if got := resp.Header.Get("X-RateLimit-Remaining"); got != strconv.Itoa(want) {
+			t.Errorf("X-RateLimit-Remaining = %q, want %d", got, want)
+		}
+	}
+	checkRateLimited(t, c.api("GET", "/api/v1/items", token, nil, nil), 3)
+
+	// Each token has its own budget
+	if resp := c.api("GET", "/api/v1/items", other, nil, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("another token: status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestCreateTokenWithRateLimit(t *testing.T) {
+	handler, app := newTestApp(t, func(cfg *Config) { cfg.MaxTokenRateLimit = 100 })
+	createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	for _, limit := range []string{"-1", "1.5", "x", "101"} {
+		_, body := c.post("/account/tokens", url.Values{"name": {"Script"}, "rate_limit": {limit}})
+		if !strings.Contains(body, "up to 100") {
+			t.Errorf("rate_limit %q: no error in\n%s", limit, body)
+		}
+	}
+	var count int64
+	app.db.Model(&UserToken{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d tokens created with invalid rate limits", count)
+	}
+
+	_, body := c.post("/account/tokens", url.Values{"name": {"Script"}, "rate_limit": {"2"}})
+	m := regexp.MustCompile(`<code>([0-9a-f]{64})</code>`).FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("new token not shown:\n%s", body)
+	}
+	if !strings.Contains(body, "2/min") {
+		t.Errorf("the token list doesn't show the rate limit:\n%s", body)
+	}
+	c.post("/account/tokens", url.Values{"name": {"Default"}})
+	if _, body := c.get("/account/tokens"); !strings.Contains(body, fmt.Sprintf("Default (%d/min)", app.config.TokenRateLimit)) {
+		t.Errorf("the token list doesn't show the default rate limit:\n%s", body)
+	}
+
+	for i := 0; i < 2; i++ {
+		if resp := c.api("GET", "/api/v1/items", m[1], nil, nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, resp.StatusCode)
+		}
+	}
+	checkRateLimited(t, c.api("GET", "/api/v1/items", m[1], nil, nil), 2)
+}
+
+func TestSessionAPIRateLimit(t *testing.T) {
+	handler, app := newTestApp(t, func(cfg *Config) { cfg.APIRateLimit = 2 })
+	createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	for i := 0; i < 2; i++ {
+		if resp := c.api("GET", "/api/v1/me", "", nil, nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, resp.StatusCode)
+		}
+	}
+	checkRateLimited(t, c.api("GET", "/api/v1/me", "", nil, nil), 2)
+}
+
+func TestRateLimiterRefills(t *testing.T) {
+	l := newRateLimiter()
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if !l.allow("k", 2, time.Minute, now).Allowed {
+			t.Fatalf("request %d refused", i+1)
+		}
+	}
+	refused := l.allow("k", 2, time.Minute, now)
+	if refused.Allowed || refused.RetryAfter != 30*time.Second {
+		t.Errorf("third request = %+v, want refused with RetryAfter 30s", refused)
+	}
+	if !l.allow("k", 2, time.Minute, now.Add(30*time.Second)).Allowed {
+		t.Error("request after a token refilled was refused")
+	}
+}
+
+func TestRateLimiterForgetsFullBuckets(t *testing.T) {
+	l := newRateLimiter()
+	now := time.Now()
+	for i := 0; i <= 10000; i++ {
+		l.allow(fmt.Sprint("idle:", i), 60, time.Minute, now)
+	}
+	l.allow("busy", 1, time.Hour, now.Add(30*time.Second))
+
+	// By now the idle buckets are full again, so they are dropped
+	l.allow("new", 60, time.Minute, now.Add(31*time.Second))
+	if len(l.buckets) != 2 {
+		t.Errorf("%d buckets kept, want the busy and new ones", len(l.buckets))
+	}
+	if l.allow("busy", 1, time.Hour, now.Add(time.Minute)).Allowed {
+		t.Error("a bucket that wasn't full was forgotten")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newRateLimitCounter returns the rate limit backend chosen by
+// RATE_LIMIT_STORE. Like the Redis session store, the Redis counter is
+// checked with a PING so a wrong REDIS_URL fails at startup.
+func newRateLimitCounter(cfg Config) (rateLimitCounter, error) {
+	if cfg.RateLimitStore != RateLimitStoreRedis {
+		return newRateLimiter(), nil
+	}
+	client, err := connectRedis(cfg.RedisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &redisRateLimiter{client: client, fallback: newRateLimiter()}, nil
+}
+
+// redisTokenBucket is the token bucket of rateLimiter.allow as a Lua
+// script, so concurrent requests from several app instances update a
+// bucket atomically. ARGV holds the capacity, the period and the current
+// time in milliseconds. It returns whether a token was taken and the
+// tokens left, as a string because Redis truncates Lua numbers to
+// integers. Idle buckets expire once they would be full again.
+var redisTokenBucket = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(bucket[1]) or capacity
+local last = tonumber(bucket[2]) or now
+local rate = capacity / period
+tokens = math.min(capacity, tokens + math.max(0, now - last) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) / rate) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// redisRateLimiter keeps token buckets in Redis, so every app instance
+// sharing the Redis server charges requests to the same budgets. When
+// Redis can't be reached it falls back to in-memory buckets, limiting each
+// instance on its own rather than not at all.
+type redisRateLimiter struct {
+	client   *redis.Client
+	fallback *rateLimiter
+}
+
+func (l *redisRateLimiter) allow(key string, limit int, period time.Duration, now time.Time) rateLimitResult {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	reply, err := redisTokenBucket.Run(ctx, l.client, []string{"ratelimit:" + key},
+		limit, period.Milliseconds(), now.UnixMilli()).Slice()
+	if err != nil || len(reply) != 2 {
+		log.Println("Error checking rate limit in Redis, using in-memory limits:", err)
+		return l.fallback.allow(key, limit, period, now)
+	}
+	allowed, _ := reply[0].(int64)
+	tokensText, _ := reply[1].(string)
+	tokens, err := strconv.ParseFloat(tokensText, 64)
+	if err != nil {
+		log.Println("Error reading rate limit from Redis, using in-memory limits:", err)
+		return l.fallback.allow(key, limit, period, now)
+	}
+
+	capacity := float64(limit)
+	refillPerSecond := capacity / period.Seconds()
+	result := rateLimitResult{Limit: limit, Allowed: allowed == 1}
+	if result.Allowed {
+		result.Remaining = int(tokens)
+	} else {
+		result.RetryAfter = secondsToDuration((1 - tokens) / refillPerSecond)
+	}
+	result.ResetAfter = secondsToDuration((capacity - tokens) / refillPerSecond)
+	return result
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// recordSearch remembers a search term for the user, keeping only the most
+// recent RecentSearchesLimit distinct terms. Blank terms are ignored.
+func (app *App) recordSearch(userID interface{}, term string) {
+	term = strings.TrimSpace(term)
+	if term == "" || app.config.RecentSearchesLimit <= 0 {
+		return
+	}
+
+	// Move an existing copy of the term to the front by re-inserting it
+	app.db.Where("user_id = ? AND term = ?", userID, term).Delete(&RecentSearch{})
+	app.db.Create(&RecentSearch{
+		UserID:    toUint(userID),
+		Term:      term,
+		CreatedAt: time.Now(),
+	})
+
+	// Drop everything past the limit
+	keep := app.db.Model(&RecentSearch{}).Select("id").Where("user_id = ?", userID).Order("id desc").Limit(app.config.RecentSearchesLimit)
+	app.db.Where("user_id = ? AND id NOT IN (?)", userID, keep).Delete(&RecentSearch{})
+}
+
+// recentSearchesHandler returns the user's recent search terms as JSON, most
+// recent first.
+func (app *App) recentSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.apiUserID(w, r)
+	if !ok {
+		return
+	}
+
+	terms := []string{}
+	app.db.Model(&RecentSearch{}).Where("user_id = ?", userID).Order("id desc").Pluck("term", &terms)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"searches": terms,
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestRecentSearches(t *testing.T) {
+	handler, app := newTestApp(t, func(cfg *Config) { cfg.RecentSearchesLimit = 3 })
+	createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	createTestUser(t, app, "bob@example.com", "Correct-Horse-1", RoleUser)
+	alice := newTestClient(t, handler)
+	alice.login("alice@example.com", "Correct-Horse-1")
+	bob := newTestClient(t, handler)
+	bob.login("bob@example.com", "Correct-Horse-1")
+
+	recent := func(c *testClient) []string {
+		t.Helper()
+		var list struct {
+			Searches []string `json:"searches"`
+		}
+		if resp := c.api("GET", "/items/recent-searches", "", nil, &list); resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d", resp.StatusCode)
+		}
+		return list.Searches
+	}
+	search := func(c *testClient, terms ...string) {
+		t.Helper()
+		for _, term := range terms {
+			c.get("/items?" + url.Values{"search": {term}}.Encode())
+		}
+	}
+
+	search(alice, "milk", "bread")
+	if got, want := recent(alice), []string{"bread", "milk"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("recent searches = %q, want %q", got, want)
+	}
+
+	// Repeating a term moves it to the front, blank terms aren't kept, and
+	// only the newest RecentSearchesLimit terms are
+	search(alice, "eggs", "milk", "  ", "tea")
+	if got, want := recent(alice), []string{"tea", "milk", "eggs"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("recent searches = %q, want %q", got, want)
+	}
+	var count int64
+	app.db.Model(&RecentSearch{}).Count(&count)
+	if count != 3 {
+		t.Errorf("%d stored searches, want 3", count)
+	}
+
+	// Each user has their own
+	if got := recent(bob); len(got) != 0 {
+		t.Errorf("bob's recent searches = %q, want none", got)
+	}
+}
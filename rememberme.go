@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// rememberCookie holds "<series>:<token>" for a device whose user ticked
+// "remember me" at sign-in.
+const rememberCookie = "remember"
+
+// rememberGrace is how long the previous token of a series keeps working
+// after it was rotated. The requests of one page load can all carry the old
+// cookie, and only the first of them rotates it.
+const rememberGrace = time.Minute
+
+// Remember-me sign-in follows the series and token pattern: the series
+// identifies a device and stays the same, while the token changes every
+// time it signs the device in. A valid series with a wrong token means an
+// old token was copied and used, so all of the user's sessions are ended.
+
+func newRememberValue() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// issueRememberToken starts a new remember-me series for user, ties it to
+// the session just started by setSessionUser and sets its cookie.
+func (app *App) issueRememberToken(w http.ResponseWriter, session *sessions.Session, user User, now time.Time) error {
+	series, err := newRememberValue()
+	if err != nil {
+		return err
+	}
+	token, err := newRememberValue()
+	if err != nil {
+		return err
+	}
+	rt := RememberToken{
+		UserID:    user.ID,
+		Series:    series,
+		TokenHash: hashToken(token),
+		RotatedAt: now,
+		ExpiresAt: now.Add(app.config.RememberMeDuration),
+	}
+	if err := app.db.Create(&rt).Error; err != nil {
+		return err
+	}
+	app.linkRememberToken(session, rt.ID)
+	app.setRememberCookie(w, series, token, rt.ExpiresAt)
+	return nil
+}
+
+// linkRememberToken records which remember-me token started the session, so
+// revoking the session also forgets the device.
+func (app *App) linkRememberToken(session *sessions.Session, id uint) {
+	token, _ := session.Values["session_token"].(string)
+	if err := app.db.Model(&UserSession{}).Where("token_hash = ?", hashToken(token)).Update("remember_id", id).Error; err != nil {
+		log.Println("Error linking remember-me token:", err)
+	}
+}
+
+func (app *App) setRememberCookie(w http.ResponseWriter, series, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookie,
+		Value:    series + ":" + token,
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   int(time.Until(expires).Seconds()),
+		HttpOnly: true,
+		Secure:   app.config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (app *App) clearRememberCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   app.config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// forgetRememberedDevice deletes the remember-me token of the request's
+// device and removes its cookie, for example on logout.
+func (app *App) forgetRememberedDevice(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(rememberCookie)
+	if err != nil {
+		return
+	}
+	if series, _, ok := strings.Cut(cookie.Value, ":"); ok {
+		if err := app.db.Where("series = ?", series).Delete(&RememberToken{}).Error; err != nil {
+			log.Println("Error deleting remember-me token:", err)
+		}
+	}
+	app.clearRememberCookie(w)
+}
+
+// rememberMe signs in a request that has no session but carries a valid
+// remember-me cookie, rotating the cookie's token.
+func (app *App) rememberMe(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.RememberMeDuration > 0 {
+			app.resumeRememberedLogin(w, r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *App) resumeRememberedLogin(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(rememberCookie)
+	if err != nil {
+		return
+	}
+	session, _ := app.store.Get(r, "session")
+	if userID, ok := session.Values["user_id"]; ok && userID != nil {
+		return
+	}
+
+	now := time.Now()
+	var rt RememberToken
+	series, token, ok := strings.Cut(cookie.Value, ":")
+	if !ok || app.db.Where("series = ? AND expires_at > ?", series, now).First(&rt).Error != nil {
+		app.clearRememberCookie(w)
+		return
+	}
+	var user User
+	if err := app.db.First(&user, rt.UserID).Error; err != nil {
+		app.clearRememberCookie(w)
+		return
+	}
+
+	hash := []byte(hashToken(token))
+	rotate := true
+	switch {
+	case subtle.ConstantTimeCompare(hash, []byte(rt.TokenHash)) == 1:
+	case subtle.ConstantTimeCompare(hash, []byte(rt.PrevTokenHash)) == 1 && now.Sub(rt.RotatedAt) < rememberGrace:
+		rotate = false
+	default:
+		// Whoever holds the current token, the thief or the owner, used
+		// it after the other did
+		log.Printf("Remember-me token reused for user %d; ending all of their sessions", user.ID)
+		app.authEvents.log(r, AuthEventRememberMe, AuthOutcomeFailure, user, "")
+		if err := revokeUserSessions(app.db, user.ID); err != nil {
+			log.Println("Error ending sessions:", err)
+		}
+		app.clearRememberCookie(w)
+		return
+	}
+
+	// Accounts that couldn't sign in with a password can't be resumed
+	// either
+	if user.Disabled || user.MustChangePassword || (user.ExpiresAt != nil && !now.Before(*user.ExpiresAt)) {
+		app.db.Delete(&rt)
+		app.clearRememberCookie(w)
+		return
+	}
+
+	if rotate {
+		next, err := newRememberValue()
+		if err != nil {
+			log.Println("Error rotating remember-me token:", err)
+			return
+		}
+		// Only one of several concurrent requests rotates the token; the
+		// others count as inside the grace period
+		result := app.db.Model(&RememberToken{}).Where("id = ? AND token_hash = ?", rt.ID, rt.TokenHash).Updates(map[string]interface{}{
+			"token_hash":      hashToken(next),
+			"prev_token_hash": rt.TokenHash,
+			"rotated_at":      now,
+		})
+		if result.Error != nil {
+			log.Println("Error rotating remember-me token:", result.Error)
+			return
+		}
+		if result.RowsAffected == 1 {
+			app.setRememberCookie(w, rt.Series, next, rt.ExpiresAt)
+		}
+	}
+
+	if err := app.setSessionUser(r, session, user); err != nil {
+		log.Println("Error starting session:", err)
+		return
+	}
+	app.linkRememberToken(session, rt.ID)
+	if err := session.Save(r, w); err != nil {
+		log.Println("Error saving session:", err)
+		return
+	}
+	app.authEvents.log(r, AuthEventRememberMe, AuthOutcomeSuccess, user, "")
+}
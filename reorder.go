@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxReorderIDs caps how many items one reorder request can move.
+const maxReorderIDs = 500
+
+// manualOrder is the ORDER BY of the items list when no other sort is
+// chosen: the order the user dragged their items into. Items that were
+// never moved have position 0, so new items come first, newest first.
+const manualOrder = "items.position, items.created_at desc, items.id desc"
+
+// reorderItems puts the user's own items with the given IDs in that order.
+// The moved items take the places they held among all of the user's items,
+// so reordering one page or one filtered view leaves the other items where
+// they were. Every item is then numbered by its place, in one transaction.
+// IDs of items the user doesn't own are ignored.
+func (app *App) reorderItems(userID interface{}, ids []uint) error {
+	seen := map[uint]bool{}
+	for _, id := range ids {
+		seen[id] = true
+	}
+	return app.db.Transaction(func(tx *gorm.DB) error {
+		var items []Item
+		err := tx.Scopes(app.ownedItems(userID)).Select("id", "position").Order(manualOrder).Find(&items).Error
+		if err != nil {
+			return err
+		}
+		owned := map[uint]bool{}
+		for _, item := range items {
+			owned[item.ID] = true
+		}
+		var moved []uint
+		for _, id := range ids {
+			if owned[id] {
+				moved = append(moved, id)
+			}
+		}
+
+		now := time.Now()
+		next := 0
+		for i, item := range items {
+			id := item.ID
+			if seen[id] {
+				id = moved[next]
+				next++
+			}
+			position := i + 1
+			if id == item.ID && item.Position == position {
+				continue
+			}
+			// updated_at moves on so the list's ETag changes
+			err := tx.Model(&Item{}).Where("id = ?", id).
+				Updates(map[string]interface{}{"position": position, "updated_at": now}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// checkReorderIDs rejects a reorder request without items, with too many
+// or with an item listed twice.
+func checkReorderIDs(ids []uint) error {
+	if len(ids) == 0 {
+		return errors.New("List the items in their new order")
+	}
+	if len(ids) > maxReorderIDs {
+		return fmt.Errorf("At most %d items can be reordered at once", maxReorderIDs)
+	}
+	seen := map[uint]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			return fmt.Errorf("Item %d is listed twice", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// reorderItemsHandler saves the order the user dragged their items into:
+// "ids" as repeated or comma-separated form values, or a JSON body
+// {"ids": [...]}, in the new order. The browser gets the items list with
+// the current filters; JSON clients get 204.
+func (app *App) reorderItemsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	// The form and JSON bodies are those of the export request
+	req, err := parseExportRequest(r)
+	if err == nil {
+		err = checkReorderIDs(req.IDs)
+	}
+	if err != nil {
+		app.writeItemError(w, r, userID, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := app.reorderItems(userID, req.IDs); err != nil {
+		log.Println("Error reordering items:", err)
+		writeServerError(w)
+		return
+	}
+	app.touchItems(userID)
+	if respondJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	app.writeItemList(w, r, userID, app.itemListData(userID, parseItemFilter(r), parseItemPage(r)))
+}
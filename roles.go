@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// User roles. RoleOrgAdmin is described in orgs.go.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// roleUserKey is the request context key under which requireRole stores the
+// signed-in user.
+type roleUserKey struct{}
+
+// requireRole returns middleware that only lets through requests whose
+// session belongs to a user with one of roles. Others get a 401 or 403
+// fragment. Handlers behind it can get the user with roleUser.
+//
+//	adminOnly := app.requireRole(RoleAdmin)
+//	r.Handle("/admin/orgs", adminOnly(http.HandlerFunc(app.adminCreateOrgHandler)))
+func (app *App) requireRole(roles ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := app.checkRole(w, r, roles...)
+			if !ok {
+				return
+			}
+			ctx := context.WithValue(r.Context(), roleUserKey{}, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// roleUser returns the user stored by requireRole, if the request passed
+// through it.
+func roleUser(r *http.Request) (User, bool) {
+	user, ok := r.Context().Value(roleUserKey{}).(User)
+	return user, ok
+}
+
+// checkRole loads the session's user and checks that they have one of
+// roles. It writes the error response and returns false otherwise.
+func (app *App) checkRole(w http.ResponseWriter, r *http.Request, roles ...string) (User, bool) {
+	var user User
+	session, _ := app.store.Get(r, "session")
+	userID, ok := session.Values["user_id"]
+	if !ok || userID == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`<div class="error">Unauthorized. Please log in.</div>`))
+		return user, false
+	}
+	if err := app.db.First(&user, userID).Error; err != nil || !hasRole(user, roles...) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<div class="error">Forbidden.</div>`))
+		return user, false
+	}
+	return user, true
+}
+
+// hasRole reports whether user has one of roles.
+func hasRole(user User, roles ...string) bool {
+	for _, role := range roles {
+		if user.Role == role {
+			return true
+		}
+	}
+	return false
+}
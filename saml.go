@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/gorilla/sessions"
+	xrv "github.com/mattermost/xml-roundtrip-validator"
+)
+
+// samlRequestCookie remembers the ID of the pending AuthnRequest. The IdP
+// posts its response cross-site, so it can't live in the SameSite=Lax
+// session cookie.
+const samlRequestCookie = "saml_request"
+
+// newSAMLServiceProvider builds the SAML service provider from cfg, or
+// returns nil when SAML is not configured.
+func newSAMLServiceProvider(cfg Config) (*saml.ServiceProvider, error) {
+	if cfg.SAMLIDPMetadata == "" {
+		return nil, nil
+	}
+
+	keyPair, err := tls.LoadX509KeyPair(cfg.SAMLCertFile, cfg.SAMLKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading SAML_CERT_FILE and SAML_KEY_FILE: %w", err)
+	}
+	key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("SAML_KEY_FILE: must be an RSA key")
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("SAML_CERT_FILE: %w", err)
+	}
+
+	idp, err := loadSAMLMetadata(cfg.SAMLIDPMetadata)
+	if err != nil {
+		return nil, fmt.Errorf("loading SAML_IDP_METADATA: %w", err)
+	}
+
+	metadataURL, _ := url.Parse(cfg.BaseURL + "/saml/metadata")
+	acsURL, _ := url.Parse(cfg.BaseURL + "/saml/acs")
+	return &saml.ServiceProvider{
+		EntityID:          cfg.SAMLEntityID,
+		Key:               key,
+		Certificate:       cert,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       idp,
+		AuthnNameIDFormat: saml.EmailAddressNameIDFormat,
+	}, nil
+}
+
+// loadSAMLMetadata reads IdP metadata from an http(s) URL or a file.
+func loadSAMLMetadata(location string) (*saml.EntityDescriptor, error) {
+	var data []byte
+	if strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://") {
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Get(location)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s returned %s", location, resp.Status)
+		}
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20)); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if data, err = os.ReadFile(location); err != nil {
+			return nil, err
+		}
+	}
+	return parseSAMLMetadata(data)
+}
+
+// parseSAMLMetadata parses IdP metadata, which is either an
+// EntityDescriptor or an EntitiesDescriptor wrapping one.
+func parseSAMLMetadata(data []byte) (*saml.EntityDescriptor, error) {
+	if err := xrv.Validate(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	var entity saml.EntityDescriptor
+	if err := xml.Unmarshal(data, &entity); err == nil {
+		return &entity, nil
+	}
+	var entities saml.EntitiesDescriptor
+	if err := xml.Unmarshal(data, &entities); err != nil {
+		return nil, err
+	}
+	for i, e := range entities.EntityDescriptors {
+		if len(e.IDPSSODescriptors) > 0 {
+			return &entities.EntityDescriptors[i], nil
+		}
+	}
+	return nil, errors.New("no entity with an IDPSSODescriptor")
+}
+
+// samlRequestStore holds the pending request ID in a short-lived cookie
+// that is sent on the IdP's cross-site POST to /saml/acs.
+func (app *App) samlRequestStore() *sessions.CookieStore {
+	store := sessions.NewCookieStore(app.config.sessionKeyPairs()...)
+	store.Options = &sessions.Options{
+		Path:     "/saml/acs",
+		MaxAge:   int((5 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	}
+	return store
+}
+
+// samlMetadataHandler serves the service provider metadata to register
+// with the IdP.
+func (app *App) samlMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	if app.saml == nil {
+		app.notFoundHandler(w, r)
+		return
+	}
+	out, err := xml.MarshalIndent(app.saml.Metadata(), "", "  ")
+	if err != nil {
+		log.Println("Error generating SAML metadata:", err)
+		writeServerError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(out)
+}
+
+// samlLoginHandler sends the user to the IdP with an AuthnRequest.
+func (app *App) samlLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if app.saml == nil {
+		app.notFoundHandler(w, r)
+		return
+	}
+	idpURL := app.saml.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+	req, err := app.saml.MakeAuthenticationRequest(idpURL, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		log.Println("Error creating SAML request:", err)
+		writeServerError(w)
+		return
+	}
+	redirectURL, err := req.Redirect("", app.saml)
+	if err != nil {
+		log.Println("Error creating SAML redirect:", err)
+		writeServerError(w)
+		return
+	}
+
+	session, _ := app.samlRequestStore().New(r, samlRequestCookie)
+	session.Values["id"] = req.ID
+	if err := session.Save(r, w); err != nil {
+		log.Println("Error saving SAML request:", err)
+		writeServerError(w)
+		return
+	}
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+// samlACSHandler is the assertion consumer service: it validates the IdP's
+// response to our request and signs the user in.
+func (app *App) samlACSHandler(w http.ResponseWriter, r *http.Request) {
+	if app.saml == nil {
+		app.notFoundHandler(w, r)
+		return
+	}
+
+	// ParseResponse reads the already parsed form
+	if err := r.ParseForm(); err != nil {
+		app.renderLoginPage(w, r, http.StatusBadRequest, "Invalid sign-in response.")
+		return
+	}
+
+	tracker, _ := app.samlRequestStore().Get(r, samlRequestCookie)
+	requestID, _ := tracker.Values["id"].(string)
+	tracker.Options.MaxAge = -1
+	if err := tracker.Save(r, w); err != nil {
+		log.Println("Error clearing SAML request:", err)
+	}
+	if requestID == "" {
+		app.renderLoginPage(w, r, http.StatusBadRequest, "Your sign-in request expired. Please try again.")
+		return
+	}
+
+	assertion, err := app.saml.ParseResponse(r, []string{requestID})
+	if err != nil {
+		// The wrapped error explains why the response was rejected
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		log.Println("Rejected SAML response:", err)
+		app.renderLoginPage(w, r, http.StatusUnauthorized, "Could not sign in with "+app.config.SAMLLabel+". Please try again.")
+		return
+	}
+
+	identity, err := samlIdentity(app.config, assertion)
+	if err != nil {
+		app.renderLoginPage(w, r, http.StatusUnauthorized, "Could not sign in with "+app.config.SAMLLabel+": "+err.Error())
+		return
+	}
+	user, err := app.findOrCreateExternalUser(identity, app.config.RegistrationEnabled)
+	if errors.Is(err, errRegistrationClosed) {
+		app.logLogin(r, AuthOutcomeFailure, User{}, identity.Email)
+		app.renderLoginPage(w, r, http.StatusForbidden, "There is no account for "+identity.Email+". Ask an administrator to create one.")
+		return
+	}
+	if err != nil {
+		log.Println("Error creating user:", err)
+		writeServerError(w)
+		return
+	}
+	session, _ := app.store.Get(r, "session")
+	app.startExternalLogin(w, r, session, user)
+}
+
+// samlIdentity maps assertion attributes to a user. The email comes from
+// SAML_EMAIL_ATTRIBUTE, falling back to an email-format NameID.
+func samlIdentity(cfg Config, assertion *saml.Assertion) (externalIdentity, error) {
+	email := ""
+	if values := samlAttribute(assertion, cfg.SAMLEmailAttribute); len(values) > 0 {
+		email = values[0]
+	} else if assertion.Subject != nil && assertion.Subject.NameID != nil &&
+		assertion.Subject.NameID.Format == string(saml.EmailAddressNameIDFormat) {
+		email = assertion.Subject.NameID.Value
+	}
+	if email == "" {
+		return externalIdentity{}, fmt.Errorf("the %q attribute is missing", cfg.SAMLEmailAttribute)
+	}
+
+	identity := externalIdentity{Email: email}
+	if cfg.SAMLAdminGroup != "" {
+		identity.Role = RoleUser
+		for _, group := range samlAttribute(assertion, cfg.SAMLGroupsAttribute) {
+			if group == cfg.SAMLAdminGroup {
+				identity.Role = RoleAdmin
+			}
+		}
+	}
+	return identity, nil
+}
+
+// samlAttribute returns the values of the attribute with the given Name or
+// FriendlyName.
+func samlAttribute(assertion *saml.Assertion, name string) []string {
+	var values []string
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			if attr.Name != name && attr.FriendlyName != name {
+				continue
+			}
+			for _, v := range attr.Values {
+				values = append(values, v.Value)
+			}
+		}
+	}
+	return values
+}
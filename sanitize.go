@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Item name policies accepted by ITEM_NAME_POLICY
+const (
+	ItemNamePolicyStrip  = "strip"
+	ItemNamePolicyReject = "reject"
+)
+
+var errDisallowedNameChars = errors.New("Item name contains control or invisible formatting characters")
+
+const (
+	zeroWidthNonJoiner = '\u200c'
+	zeroWidthJoiner    = '\u200d'
+)
+
+// sanitizeItemName applies the item name policy to name. Control characters,
+// bidi overrides and other invisible formatting characters are either
+// removed (ItemNamePolicyStrip) or cause an error (ItemNamePolicyReject).
+// Zero-width joiners and non-joiners are kept when they sit between two
+// visible characters, where emoji sequences and scripts such as Persian or
+// Devanagari need them. The result is trimmed of surrounding whitespace.
+func sanitizeItemName(name, policy string) (string, error) {
+	runes := []rune(strings.ToValidUTF8(name, string(utf8.RuneError)))
+	clean := make([]rune, 0, len(runes))
+	for i, c := range runes {
+		if isAllowedNameRune(runes, i) {
+			clean = append(clean, c)
+			continue
+		}
+		if policy == ItemNamePolicyReject {
+			return "", errDisallowedNameChars
+		}
+	}
+	return strings.TrimSpace(string(clean)), nil
+}
+
+func isAllowedNameRune(runes []rune, i int) bool {
+	c := runes[i]
+	switch {
+	case c == utf8.RuneError:
+		return false
+	case c == zeroWidthJoiner || c == zeroWidthNonJoiner:
+		return i > 0 && i < len(runes)-1 && isVisibleRune(runes[i-1]) && isVisibleRune(runes[i+1])
+	case unicode.IsControl(c), unicode.Is(unicode.Cf, c):
+		return false
+	}
+	return true
+}
+
+func isVisibleRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsMark(c) || unicode.IsSymbol(c)
+}
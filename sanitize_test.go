@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestSanitizeItemName(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		stripped string
+		rejected bool // under ItemNamePolicyReject
+	}{
+		{"plain", "  Milk ", "Milk", false},
+		{"right-to-left override", "Invoice\u202egpj.exe", "Invoicegpj.exe", true},
+		{"left-to-right isolate", "\u2066Milk\u2069", "Milk", true},
+		{"null byte", "Milk\x00", "Milk", true},
+		{"other control characters", "Mi\tlk\r\n", "Milk", true},
+		{"zero-width space", "Mi\u200bl\ufeffk", "Milk", true},
+		{"invalid UTF-8", "Milk\xff", "Milk", true},
+		{"emoji joiner", "👩\u200d💻 laptop", "👩\u200d💻 laptop", false},
+		{"Persian non-joiner", "می\u200cخواهم", "می\u200cخواهم", false},
+		{"dangling joiner", "\u200dMilk\u200d", "Milk", true},
+	}
+	for _, tt := range tests {
+		got, err := sanitizeItemName(tt.in, ItemNamePolicyStrip)
+		if err != nil || got != tt.stripped {
+			t.Errorf("%s: strip gave %q, %v; want %q", tt.name, got, err, tt.stripped)
+		}
+
+		got, err = sanitizeItemName(tt.in, ItemNamePolicyReject)
+		if tt.rejected {
+			if err != errDisallowedNameChars {
+				t.Errorf("%s: reject gave %q, %v; want %v", tt.name, got, err, errDisallowedNameChars)
+			}
+		} else if err != nil || got != tt.stripped {
+			t.Errorf("%s: reject gave %q, %v; want %q", tt.name, got, err, tt.stripped)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// fuzzyCandidateLimit caps how many of the user's most recent items are
+	// loaded for fuzzy ranking.
+	fuzzyCandidateLimit = 1000
+	// fuzzyResultLimit caps how many ranked items are returned.
+	fuzzyResultLimit = 20
+	// fuzzyMinScore is the lowest similarity score that still counts as a
+	// match.
+	fuzzyMinScore = 0.6
+)
+
+// fuzzyFindItems returns the user's items matching filter's other filters,
+// ranked by how closely their names match its search, best first. It
+// tolerates typos that a LIKE search would miss.
+//
+// Ranking happens in Go over a bounded set of candidates, which is fine for
+// per-user lists. For much larger datasets this could move into the database,
+// e.g. with Postgres pg_trgm similarity().
+func (app *App) fuzzyFindItems(userID interface{}, filter itemFilter) []Item {
+	search := filter.Search
+	filter.Search = ""
+	var candidates []Item
+	filter.query(app, userID).Preload("Category").Order("created_at desc").Limit(fuzzyCandidateLimit).Find(&candidates)
+
+	type scoredItem struct {
+		item  Item
+		score float64
+	}
+	var matches []scoredItem
+	for _, item := range candidates {
+		if score := fuzzyScore(search, item.Name); score >= fuzzyMinScore {
+			matches = append(matches, scoredItem{item, score})
+		}
+	}
+
+	// Stable sort keeps newer items first among equal scores
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+
+	if len(matches) > fuzzyResultLimit {
+		matches = matches[:fuzzyResultLimit]
+	}
+	// Pinned items lead here too, keeping their ranking among themselves
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].item.Pinned && !matches[j].item.Pinned
+	})
+	items := make([]Item, len(matches))
+	for i, m := range matches {
+		items[i] = m.item
+	}
+	app.loadTags(items)
+	return items
+}
+
+// fuzzyScore rates how well query matches name, from 0 (no resemblance) to 1
+// (exact or substring match). The query is compared against the whole name
+// and against each word in it, and the best score wins.
+func fuzzyScore(query, name string) float64 {
+	query = strings.ToLower(strings.TrimSpace(query))
+	name = strings.ToLower(name)
+	if query == "" {
+		return 0
+	}
+	if strings.Contains(name, query) {
+		return 1
+	}
+
+	best := similarity(query, name)
+	for _, word := range strings.Fields(name) {
+		if score := similarity(query, word); score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+// similarity turns the edit distance between a and b into a score between 0
+// and 1.
+func similarity(a, b string) float64 {
+	longest := utf8.RuneCountInString(a)
+	if n := utf8.RuneCountInString(b); n > longest {
+		longest = n
+	}
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(editDistance(a, b))/float64(longest)
+}
+
+// editDistance returns the Levenshtein distance between a and b, counted in
+// runes, with adjacent transpositions ("mlik" for "milk") counting as a
+// single edit.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prevPrev := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prevPrev[j-2]+1)
+			}
+		}
+		prevPrev, prev, curr = prev, curr, prevPrev
+	}
+	return prev[len(rb)]
+}
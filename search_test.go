@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestFuzzySearch(t *testing.T) {
+	handler, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	createTestItem(t, app, alice, "Milk")
+	createTestItem(t, app, alice, "Whole wheat bread")
+	createTestItem(t, app, alice, "Batteries")
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	search := func(query string, fuzzy bool) string {
+		t.Helper()
+		form := url.Values{"search": {query}}
+		if fuzzy {
+			form.Set("fuzzy", "true")
+		}
+		resp, body := c.get("/items?" + form.Encode())
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("search %q: status %d\n%s", query, resp.StatusCode, body)
+		}
+		return body
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"Mlik", "Milk"},
+		{"braed", "Whole wheat bread"}, // a transposition inside one word of the name
+		{"Batterys", "Batteries"},
+	}
+	for _, tt := range tests {
+		if body := search(tt.query, false); strings.Contains(body, tt.want) {
+			t.Errorf("plain search %q found %q", tt.query, tt.want)
+		}
+		body := search(tt.query, true)
+		if !strings.Contains(body, tt.want) {
+			t.Errorf("fuzzy search %q didn't find %q:\n%s", tt.query, tt.want, body)
+		}
+		for _, other := range []string{"Milk", "Whole wheat bread", "Batteries"} {
+			if other != tt.want && strings.Contains(body, other) {
+				t.Errorf("fuzzy search %q also found %q", tt.query, other)
+			}
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"milk", "milk", 0},
+		{"mlik", "milk", 1},
+		{"teh", "the", 1},
+		{"milk", "silk", 1},
+		{"milk", "mil", 1},
+		{"café", "cafe", 1},
+		{"", "milk", 4},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
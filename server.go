@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// tlsVersions maps TLS_MIN_VERSION values to crypto/tls constants.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// serve runs the HTTP server for handler. When a TLS certificate is
+// configured it serves HTTPS and, if HTTPRedirectAddr is set, starts a plain
+// HTTP listener that redirects to it; otherwise it serves plain HTTP.
+func serve(cfg Config, handler http.Handler) error {
+	srv, redirect := newServers(cfg, handler)
+	if cfg.TLSCertFile == "" {
+		return srv.ListenAndServe()
+	}
+	if redirect != nil {
+		go func() {
+			log.Printf("Redirecting HTTP on %s to HTTPS", cfg.HTTPRedirectAddr)
+			if err := redirect.ListenAndServe(); err != nil {
+				log.Println("HTTP redirect listener stopped:", err)
+			}
+		}()
+	}
+	return srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+}
+
+// newServers returns the server for handler and, when it serves HTTPS and
+// HTTPRedirectAddr is set, the plain HTTP server redirecting to it (nil
+// otherwise). Neither is started.
+func newServers(cfg Config, handler http.Handler) (srv, redirect *http.Server) {
+	srv = &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if cfg.TLSCertFile == "" {
+		return srv, nil
+	}
+
+	srv.TLSConfig = &tls.Config{MinVersion: tlsVersions[cfg.TLSMinVersion]}
+	if cfg.HTTPRedirectAddr != "" {
+		redirect = &http.Server{
+			Addr:              cfg.HTTPRedirectAddr,
+			Handler:           httpsRedirectHandler(cfg.Port),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+	return srv, redirect
+}
+
+// httpsRedirectHandler permanently redirects every request to the same
+// host and path on the HTTPS port.
+func httpsRedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		target := fmt.Sprintf("https://%s%s", host, r.URL.RequestURI())
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	handler, app := newTestApp(t)
+	cfg := app.config
+	cfg.Port = "8443"
+	cfg.TLSCertFile, cfg.TLSKeyFile = "cert.pem", "key.pem"
+	cfg.TLSMinVersion = "1.3"
+	cfg.HTTPRedirectAddr = ":8080"
+	srv, redirect := newServers(cfg, handler)
+	if redirect == nil {
+		t.Fatal("no redirect server with HTTP_REDIRECT_ADDR set")
+	}
+
+	// Plain HTTP is sent to the same host and path over HTTPS
+	plain := httptest.NewServer(redirect.Handler)
+	defer plain.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	for _, method := range []string{"GET", "POST"} {
+		req, _ := http.NewRequest(method, plain.URL+"/items?status=all", nil)
+		req.Host = "app.example.com:8080"
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMovedPermanently && resp.StatusCode != http.StatusPermanentRedirect {
+			t.Errorf("%s: status %d, want a permanent redirect", method, resp.StatusCode)
+		}
+		if got, want := resp.Header.Get("Location"), "https://app.example.com:8443/items?status=all"; got != want {
+			t.Errorf("%s: Location = %q, want %q", method, got, want)
+		}
+	}
+
+	// HTTPS requests reach the app
+	secure := httptest.NewUnstartedServer(srv.Handler)
+	secure.TLS = srv.TLSConfig
+	secure.StartTLS()
+	defer secure.Close()
+	resp, err := secure.Client().Get(secure.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("HTTPS: status %d\n%s", resp.StatusCode, body)
+	}
+
+	// ...over TLS_MIN_VERSION or later only
+	transport := secure.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.MaxVersion = tls.VersionTLS12
+	old := &http.Client{Transport: transport}
+	if resp, err := old.Get(secure.URL + "/healthz"); err == nil {
+		resp.Body.Close()
+		t.Error("a TLS 1.2 client connected with TLS_MIN_VERSION=1.3")
+	}
+}
+
+func TestHTTPSRedirectDefaultPort(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://app.example.com/login", nil)
+	httpsRedirectHandler("443").ServeHTTP(rec, req)
+	if got, want := rec.Header().Get("Location"), "https://app.example.com/login"; got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+}
+
+func TestNoRedirectWithoutTLS(t *testing.T) {
+	handler, app := newTestApp(t)
+	cfg := app.config
+	cfg.HTTPRedirectAddr = ":8080"
+	srv, redirect := newServers(cfg, handler)
+	if redirect != nil || srv.TLSConfig != nil {
+		t.Error("plain HTTP serving has TLS settings or a redirect server")
+	}
+}
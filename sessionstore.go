@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
+)
+
+// Session store backends
+const (
+	SessionStoreCookie = "cookie"
+	SessionStoreRedis  = "redis"
+)
+
+// redisTimeout bounds each Redis command, so a stalled server fails the
+// request instead of hanging it.
+const redisTimeout = 3 * time.Second
+
+// newSessionStore returns the session backend chosen by SESSION_STORE. The
+// Redis store is checked with a PING so a wrong REDIS_URL fails at startup.
+func newSessionStore(cfg Config) (sessions.Store, error) {
+	options := sessions.Options{
+		Path:     "/",
+		MaxAge:   cfg.SessionMaxAge,
+		HttpOnly: true,
+		Secure:   cfg.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if cfg.SessionStore != SessionStoreRedis {
+		store := sessions.NewCookieStore(cfg.sessionKeyPairs()...)
+		store.Options = &options
+		return store, nil
+	}
+
+	client, err := connectRedis(cfg.RedisURL)
+	if err != nil {
+		return nil, err
+	}
+	return newRedisStore(client, cfg.sessionKeyPairs(), options), nil
+}
+
+// connectRedis opens a client for REDIS_URL and checks it with a PING.
+func connectRedis(redisURL string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("REDIS_URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connecting to Redis: %w", err)
+	}
+	return client, nil
+}
+
+// redisStore keeps session values in Redis and only a signed session ID in
+// the cookie. Unlike the cookie store, a session can be ended on the server,
+// and every app instance sharing the Redis server sees the same sessions.
+type redisStore struct {
+	client  *redis.Client
+	codecs  []securecookie.Codec
+	options sessions.Options
+}
+
+func newRedisStore(client *redis.Client, keyPairs [][]byte, options sessions.Options) *redisStore {
+	s := &redisStore{
+		client:  client,
+		codecs:  securecookie.CodecsFromPairs(keyPairs...),
+		options: options,
+	}
+	// The codecs check the age of what they decode; keep it in step with
+	// the session lifetime
+	for _, codec := range s.codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(options.MaxAge)
+		}
+	}
+	return s
+}
+
+// Get returns the named session for the request, loading it once per
+// request.
+func (s *redisStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named by the request's cookie. If there is no
+// cookie, or its session has expired or been deleted, it returns a new
+// session, along with the error for anything other than a missing cookie.
+func (s *redisStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.codecs...); err != nil {
+		return session, err
+	}
+	if err := s.load(r.Context(), session); err != nil {
+		if errors.Is(err, redis.Nil) {
+			return session, nil
+		}
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save writes the session to Redis and sets its cookie. A session with a
+// MaxAge of zero or less is deleted from Redis and its cookie removed.
+func (s *redisStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if err := s.delete(r.Context(), session); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		id, err := newSessionID()
+		if err != nil {
+			return err
+		}
+		session.ID = id
+	}
+	if err := s.save(r.Context(), session); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func (s *redisStore) load(ctx context.Context, session *sessions.Session) error {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	data, err := s.client.Get(ctx, redisSessionKey(session)).Result()
+	if err != nil {
+		return err
+	}
+	return securecookie.DecodeMulti(session.Name(), data, &session.Values, s.codecs...)
+}
+
+func (s *redisStore) save(ctx context.Context, session *sessions.Session) error {
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.codecs...)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	ttl := time.Duration(session.Options.MaxAge) * time.Second
+	return s.client.Set(ctx, redisSessionKey(session), data, ttl).Err()
+}
+
+func (s *redisStore) delete(ctx context.Context, session *sessions.Session) error {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	return s.client.Del(ctx, redisSessionKey(session)).Err()
+}
+
+func redisSessionKey(session *sessions.Session) string {
+	return "session:" + session.Name() + ":" + session.ID
+}
+
+// newSessionID returns a random session ID.
+func newSessionID() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(raw), "="), nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+// failingStore is a session store whose Save fails for the "session"
+// cookie, like a cookie that has grown too large. Sessions remember the
+// store that made them and session.Save goes to it, so failingStore makes
+// its own sessions from those of the wrapped store.
+type failingStore struct {
+	sessions.Store
+}
+
+func (s failingStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s failingStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	inner, err := s.Store.New(r, name)
+	session := sessions.NewSession(s, name)
+	if inner != nil {
+		session.ID = inner.ID
+		session.Values = inner.Values
+		session.Options = inner.Options
+		session.IsNew = inner.IsNew
+	}
+	return session, err
+}
+
+func (s failingStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Name() == "session" {
+		return errors.New("session too large")
+	}
+	return s.Store.Save(r, w, session)
+}
+
+func TestLoginFailsWhenSessionCannotBeSaved(t *testing.T) {
+	handler, app := newTestApp(t)
+	createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	c := newTestClient(t, handler)
+
+	store := app.store
+	app.store = failingStore{store}
+	resp, body := c.post("/login", url.Values{"email": {"alice@example.com"}, "password": {"Correct-Horse-1"}})
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+	if strings.Contains(body, "<h1>Dashboard</h1>") {
+		t.Error("the dashboard was rendered although the session wasn't saved")
+	}
+
+	app.store = store
+	if resp := c.api("GET", "/api/v1/me", "", nil, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("after the failed login /api/v1/me status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestLogoutFailsWhenSessionCannotBeSaved(t *testing.T) {
+	handler, app := newTestApp(t)
+	createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	app.store = failingStore{app.store}
+	resp, body := c.post("/logout", nil)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+	if strings.Contains(body, `action="/login"`) || strings.Contains(body, `hx-post="/login"`) {
+		t.Error("the login form was rendered although the session wasn't cleared")
+	}
+}
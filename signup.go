@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// invalidInviteMessage is shown when registering with a missing, wrong,
+// expired or used invite code.
+const invalidInviteMessage = "This invite code is invalid, expired or already used"
+
+// registerPageHandler shows the sign-up form. htmx requests get the
+// fragment; direct visits get the full page.
+func (app *App) registerPageHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config.RegistrationEnabled || app.config.PasswordLoginDisabled {
+		app.notFoundHandler(w, r)
+		return
+	}
+	data := app.registerData(r, "", "")
+	if r.Header.Get("HX-Request") == "true" {
+		app.tmpl.ExecuteTemplate(w, "register.templ", data)
+		return
+	}
+	app.renderPage(w, r, http.StatusOK, "register", data)
+}
+
+// registerHandler creates a user account. Every attempt counts against the
+// per-IP registration limit, and the CAPTCHA must pass before the account
+// is created.
+func (app *App) registerHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config.RegistrationEnabled || app.config.PasswordLoginDisabled {
+		app.notFoundHandler(w, r)
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	password := r.FormValue("password")
+
+	now := time.Now()
+	if limit := app.limiter.allow("signup:"+clientIP(r), app.config.RegistrationRateLimit, time.Hour, now); !limit.Allowed {
+		setRateLimitHeaders(w, limit, now)
+		w.WriteHeader(http.StatusTooManyRequests)
+		app.tmpl.ExecuteTemplate(w, "register.templ", app.registerData(r, "Too many sign-ups from your network. Please try again later.", email))
+		return
+	}
+
+	ok, err := app.captcha.Verify(captchaToken(r))
+	if err != nil {
+		log.Println("Error verifying captcha:", err)
+	}
+	if !ok {
+		app.authEvents.log(r, AuthEventRegister, AuthOutcomeFailure, User{}, email)
+		app.tmpl.ExecuteTemplate(w, "register.templ", app.registerData(r, "Please complete the verification challenge", email))
+		return
+	}
+
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		app.tmpl.ExecuteTemplate(w, "register.templ", app.registerData(r, "Please enter a valid email address", email))
+		return
+	}
+	if problems := app.passwordProblems(r, password, email); len(problems) > 0 {
+		data := app.registerData(r, "", email)
+		data["PasswordErrors"] = problems
+		app.tmpl.ExecuteTemplate(w, "register.templ", data)
+		return
+	}
+
+	var existing int64
+	app.db.Model(&User{}).Where("email = ?", email).Count(&existing)
+	if existing > 0 {
+		app.tmpl.ExecuteTemplate(w, "register.templ", app.registerData(r, "An account with this email already exists", email))
+		return
+	}
+	var invitation Invitation
+	if app.config.InviteOnly {
+		if invitation, err = app.findInvitation(strings.TrimSpace(r.FormValue("invite")), now); err != nil {
+			app.tmpl.ExecuteTemplate(w, "register.templ", app.registerData(r, invalidInviteMessage, email))
+			return
+		}
+	}
+
+	hash, err := app.passwords.Hash(password)
+	if err != nil {
+		log.Println("Error hashing password:", err)
+		writeServerError(w)
+		return
+	}
+	user := User{Email: email, PasswordHash: hash}
+	err = app.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if app.config.InviteOnly {
+			return claimInvitation(tx, invitation, user.ID, now)
+		}
+		return nil
+	})
+	if errors.Is(err, errInvitationUsed) {
+		app.tmpl.ExecuteTemplate(w, "register.templ", app.registerData(r, invalidInviteMessage, email))
+		return
+	}
+	if err != nil {
+		log.Println("Error creating user:", err)
+		writeServerError(w)
+		return
+	}
+	app.authEvents.log(r, AuthEventRegister, AuthOutcomeSuccess, user, "")
+	app.sendVerificationEmail(user)
+
+	session, _ := app.store.Get(r, "session")
+	if err := app.setSessionUser(r, session, user); err != nil {
+		log.Println("Error starting session:", err)
+		writeServerError(w)
+		return
+	}
+	if err := session.Save(r, w); err != nil {
+		log.Println("Error saving session:", err)
+		writeServerError(w)
+		return
+	}
+	app.tmpl.ExecuteTemplate(w, "dashboard.templ", map[string]interface{}{
+		"User": user,
+	})
+}
+
+func (app *App) registerData(r *http.Request, errMsg, email string) map[string]interface{} {
+	return map[string]interface{}{
+		"Error":           errMsg,
+		"Email":           email,
+		"Invite":          strings.TrimSpace(r.FormValue("invite")),
+		"CaptchaProvider": app.config.CaptchaProvider,
+		"CaptchaSiteKey":  app.config.CaptchaSiteKey,
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// itemStats holds the item counts shown on the dashboard, the summed
+// quantities of all of the user's items and of their active ones, and the
+// value of all of their items and of those added today. Values are
+// decimal amounts in Currency, each item counting its value times its
+// quantity.
+type itemStats struct {
+	TotalItems      int64  `json:"total_items"`
+	ActiveItems     int64  `json:"active_items"`
+	ArchivedItems   int64  `json:"archived_items"`
+	AddedToday      int64  `json:"added_today"`
+	ThisWeek        int64  `json:"this_week"`
+	ThisMonth       int64  `json:"this_month"`
+	TotalQuantity   int64  `json:"total_quantity"`
+	ActiveQuantity  int64  `json:"active_quantity"`
+	TotalValue      string `json:"total_value"`
+	ValueAddedToday string `json:"value_added_today"`
+	Currency        string `json:"currency"`
+}
+
+// startOfDay returns midnight of the day containing t, in t's location.
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// startOfWeek returns midnight of the most recent firstDay on or before t,
+// in t's location. With time.Monday this is the start of the ISO week.
+func startOfWeek(t time.Time, firstDay time.Weekday) time.Time {
+	daysSinceStart := (int(t.Weekday()) - int(firstDay) + 7) % 7
+	return startOfDay(t).AddDate(0, 0, -daysSinceStart)
+}
+
+// startOfMonth returns midnight of the first day of t's month, in t's
+// location.
+func startOfMonth(t time.Time) time.Time {
+	year, month, _ := t.Date()
+	return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+}
+
+// getItemStats counts a user's items overall and since the start of the
+// current day, week and month in the configured timezone, and sums their
+// quantities and values. The ranges are computed in Go so the queries
+// don't depend on database date functions.
+func (app *App) getItemStats(userID interface{}, now time.Time) itemStats {
+	now = now.In(app.config.Location)
+
+	var stats itemStats
+	app.db.Model(&Item{}).Where("user_id = ?", userID).Count(&stats.TotalItems)
+	app.db.Model(&Item{}).Where("user_id = ? AND status = ?", userID, ItemStatusActive).Count(&stats.ActiveItems)
+	app.db.Model(&Item{}).Where("user_id = ? AND status = ?", userID, ItemStatusArchived).Count(&stats.ArchivedItems)
+	app.db.Model(&Item{}).Where("user_id = ?", userID).Select("COALESCE(SUM(quantity), 0)").Scan(&stats.TotalQuantity)
+	app.db.Model(&Item{}).Where("user_id = ? AND status = ?", userID, ItemStatusActive).Select("COALESCE(SUM(quantity), 0)").Scan(&stats.ActiveQuantity)
+	stats.AddedToday = app.countItemsSince(userID, startOfDay(now))
+	stats.ThisWeek = app.countItemsSince(userID, startOfWeek(now, app.config.FirstWeekday))
+	stats.ThisMonth = app.countItemsSince(userID, startOfMonth(now))
+
+	var totalValue, valueToday int64
+	app.db.Model(&Item{}).Where("user_id = ?", userID).Select("COALESCE(SUM(value_cents * quantity), 0)").Scan(&totalValue)
+	app.db.Model(&Item{}).Where("user_id = ? AND created_at >= ?", userID, startOfDay(now).In(time.Local)).
+		Select("COALESCE(SUM(value_cents * quantity), 0)").Scan(&valueToday)
+	stats.TotalValue = formatValue(totalValue)
+	stats.ValueAddedToday = formatValue(valueToday)
+	stats.Currency = app.userCurrency(userID)
+	return stats
+}
+
+// countItemsSince counts a user's items created at or after since.
+func (app *App) countItemsSince(userID interface{}, since time.Time) int64 {
+	// Timestamps are stored in the server's local zone, so compare in the
+	// same zone to keep SQLite's text comparison correct.
+	var count int64
+	app.db.Model(&Item{}).Where("user_id = ? AND created_at >= ?", userID, since.In(time.Local)).Count(&count)
+	return count
+}
+
+// touchItems records that the user's items changed, which invalidates
+// cached stats and pushes fresh ones to their open stats streams. Every
+// handler that creates, updates or deletes items must call it.
+func (app *App) touchItems(userID interface{}) {
+	if err := app.db.Model(&User{}).Where("id = ?", userID).Update("items_changed_at", time.Now()).Error; err != nil {
+		log.Println("Error updating items_changed_at:", err)
+	}
+	app.statsWatchers.notify(toUint(userID))
+}
+
+// itemsLastModified returns when the user's stats last changed: the later
+// of their last item change and the start of the current day, since the
+// "added today/this week/this month" counts roll over at midnight.
+func (app *App) itemsLastModified(userID interface{}, now time.Time) time.Time {
+	lastModified := startOfDay(now.In(app.config.Location))
+
+	var user User
+	if err := app.db.Select("items_changed_at", "created_at").First(&user, userID).Error; err == nil {
+		changed := user.CreatedAt
+		if user.ItemsChangedAt != nil {
+			changed = *user.ItemsChangedAt
+		}
+		if changed.After(lastModified) {
+			lastModified = changed
+		}
+	}
+	return lastModified
+}
+
+// notModifiedSince reports whether r carries an If-Modified-Since header at
+// or after lastModified. HTTP dates have one-second precision.
+func notModifiedSince(r *http.Request, lastModified time.Time) bool {
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
+// statsETag returns a weak ETag for the stats userID sees at r. It changes
+// with lastModified, to the nanosecond, so unlike Last-Modified it also
+// tells apart changes made within the same second.
+func statsETag(userID interface{}, lastModified time.Time, r *http.Request) string {
+	format := "html"
+	if respondJSON(r) {
+		format = "json"
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%v|%d|%s", userID, lastModified.UnixNano(), format)
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// etagMatches reports whether r's If-None-Match header lists etag, using
+// the weak comparison GET requests call for.
+func etagMatches(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+	_ "time/tzdata" // APP_TIMEZONE below must load on systems without a zoneinfo database
+)
+
+func TestStartOfWeek(t *testing.T) {
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	tests := []struct {
+		now      time.Time
+		firstDay time.Weekday
+		want     time.Time
+	}{
+		{time.Date(2026, 3, 10, 12, 0, 0, 0, loc), time.Monday, time.Date(2026, 3, 9, 0, 0, 0, 0, loc)},
+		{time.Date(2026, 3, 9, 0, 0, 0, 0, loc), time.Monday, time.Date(2026, 3, 9, 0, 0, 0, 0, loc)},
+		{time.Date(2026, 3, 8, 23, 59, 0, 0, loc), time.Monday, time.Date(2026, 3, 2, 0, 0, 0, 0, loc)},
+		{time.Date(2026, 3, 10, 12, 0, 0, 0, loc), time.Sunday, time.Date(2026, 3, 8, 0, 0, 0, 0, loc)},
+		{time.Date(2026, 3, 8, 0, 0, 0, 0, loc), time.Sunday, time.Date(2026, 3, 8, 0, 0, 0, 0, loc)},
+		{time.Date(2026, 3, 7, 23, 59, 0, 0, loc), time.Sunday, time.Date(2026, 3, 1, 0, 0, 0, 0, loc)},
+		// A week that started in the previous month
+		{time.Date(2026, 4, 2, 8, 0, 0, 0, loc), time.Monday, time.Date(2026, 3, 30, 0, 0, 0, 0, loc)},
+	}
+	for _, tt := range tests {
+		if got := startOfWeek(tt.now, tt.firstDay); !got.Equal(tt.want) {
+			t.Errorf("startOfWeek(%s, %s) = %s, want %s", tt.now, tt.firstDay, got, tt.want)
+		}
+	}
+}
+
+func TestItemStatsBoundaries(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, newYork)
+	}
+	tests := []struct {
+		name         string
+		firstWeekday string
+		now          time.Time
+		created      []time.Time
+		today        int64
+		week         int64
+		month        int64
+	}{
+		{
+			// Saturday night in New York is already Sunday in UTC
+			name:         "weeks start on Sunday",
+			firstWeekday: "sunday",
+			now:          at(time.March, 10, 12, 0),
+			created:      []time.Time{at(time.March, 7, 23, 30), at(time.March, 8, 0, 30), at(time.March, 10, 9, 0)},
+			today:        1,
+			week:         2,
+			month:        3,
+		},
+		{
+			name:         "weeks start on Monday",
+			firstWeekday: "monday",
+			now:          at(time.March, 10, 12, 0),
+			created:      []time.Time{at(time.March, 8, 23, 30), at(time.March, 9, 0, 30), at(time.March, 10, 9, 0)},
+			today:        1,
+			week:         2,
+			month:        3,
+		},
+		{
+			// The last evening of February is March 1 in UTC
+			name:         "month rollover",
+			firstWeekday: "monday",
+			now:          at(time.March, 1, 10, 0),
+			created:      []time.Time{at(time.February, 28, 23, 30), at(time.March, 1, 0, 15)},
+			today:        1,
+			week:         2, // the week began on Monday, February 23
+			month:        1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("APP_TIMEZONE", "America/New_York")
+			t.Setenv("FIRST_WEEKDAY", tt.firstWeekday)
+			_, app := newTestApp(t)
+			user := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+			for _, created := range tt.created {
+				// Handlers store timestamps in the server's zone
+				item := Item{UserID: user.ID, Name: "item", Status: ItemStatusActive, Quantity: 1, CreatedAt: created.In(time.Local)}
+				if err := app.db.Create(&item).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+			// An item from last month, in every case outside all the ranges
+			old := Item{UserID: user.ID, Name: "old", Status: ItemStatusActive, Quantity: 1, CreatedAt: tt.now.AddDate(0, -1, -1).In(time.Local)}
+			app.db.Create(&old)
+
+			stats := app.getItemStats(user.ID, tt.now)
+			if stats.AddedToday != tt.today || stats.ThisWeek != tt.week || stats.ThisMonth != tt.month {
+				t.Errorf("today/week/month = %d/%d/%d, want %d/%d/%d",
+					stats.AddedToday, stats.ThisWeek, stats.ThisMonth, tt.today, tt.week, tt.month)
+			}
+			if want := int64(len(tt.created) + 1); stats.TotalItems != want {
+				t.Errorf("TotalItems = %d, want %d", stats.TotalItems, want)
+			}
+		})
+	}
+}
+
+func TestStatsConditionalRequests(t *testing.T) {
+	handler, app := newTestApp(t)
+	createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	resp, _ := c.get("/stats")
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if resp.StatusCode != http.StatusOK || etag == "" || lastModified == "" {
+		t.Fatalf("status %d, ETag %q, Last-Modified %q", resp.StatusCode, etag, lastModified)
+	}
+
+	revalidate := func(header http.Header) int {
+		t.Helper()
+		resp, _ := c.do("GET", "/stats", nil, header)
+		return resp.StatusCode
+	}
+	unchanged := map[string]http.Header{
+		"If-Modified-Since": {"If-Modified-Since": {lastModified}},
+		"If-None-Match":     {"If-None-Match": {etag}},
+		"both":              {"If-Modified-Since": {lastModified}, "If-None-Match": {etag}},
+	}
+	for name, header := range unchanged {
+		if status := revalidate(header); status != http.StatusNotModified {
+			t.Errorf("%s, unchanged: status %d, want %d", name, status, http.StatusNotModified)
+		}
+	}
+	// JSON has its own tag
+	if status := revalidate(http.Header{"If-None-Match": {etag}, "HX-Request": nil, "Accept": {"application/json"}}); status != http.StatusOK {
+		t.Errorf("HTML tag for JSON: status %d, want %d", status, http.StatusOK)
+	}
+
+	// A change, even within the same second, gives a new tag, which wins
+	// over If-Modified-Since
+	c.post("/items", url.Values{"name": {"Milk"}})
+	changed := map[string]http.Header{
+		"If-None-Match": {"If-None-Match": {etag}},
+		"both":          {"If-Modified-Since": {lastModified}, "If-None-Match": {etag}},
+	}
+	for name, header := range changed {
+		if status := revalidate(header); status != http.StatusOK {
+			t.Errorf("%s, changed: status %d, want %d", name, status, http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// statsHeartbeatInterval is how often an idle stats stream sends a comment
+// line, so proxies don't close it.
+const statsHeartbeatInterval = 30 * time.Second
+
+// statsWatchers wakes the open /stats/stream connections of a user when
+// touchItems records that their items changed.
+type statsWatchers struct {
+	mu       sync.Mutex
+	watchers map[uint]map[chan struct{}]bool
+}
+
+func newStatsWatchers() *statsWatchers {
+	return &statsWatchers{watchers: map[uint]map[chan struct{}]bool{}}
+}
+
+// watch returns a channel that receives a value after each change to the
+// user's items, or nil when the user already has maxLiveConnections
+// streams open. Changes arriving faster than they are read are coalesced.
+func (s *statsWatchers) watch(userID uint) chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.watchers[userID]) >= maxLiveConnections {
+		return nil
+	}
+	if s.watchers[userID] == nil {
+		s.watchers[userID] = map[chan struct{}]bool{}
+	}
+	ch := make(chan struct{}, 1)
+	s.watchers[userID][ch] = true
+	return ch
+}
+
+func (s *statsWatchers) unwatch(userID uint, ch chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.watchers[userID], ch)
+	if len(s.watchers[userID]) == 0 {
+		delete(s.watchers, userID)
+	}
+}
+
+func (s *statsWatchers) notify(userID uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.watchers[userID] {
+		select {
+		case ch <- struct{}{}:
+		default: // a wake-up is already pending
+		}
+	}
+}
+
+// statsStreamHandler streams the user's item statistics as server-sent
+// events: a "stats" event with the same JSON as /api/v1/stats when the
+// stream opens, after every change to their items and at midnight, when
+// the daily counts roll over.
+func (app *App) statsStreamHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	changed := app.statsWatchers.watch(user.ID)
+	if changed == nil {
+		http.Error(w, "Too many open connections", http.StatusTooManyRequests)
+		return
+	}
+	defer app.statsWatchers.unwatch(user.ID, changed)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+
+	heartbeat := time.NewTicker(statsHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		now := time.Now()
+		stats := statsResponse{
+			itemStats: app.getItemStats(user.ID, now),
+			Limit:     app.getItemLimit(user.ID),
+		}
+		data, err := json.Marshal(stats)
+		if err != nil {
+			log.Println("Error encoding stats:", err)
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: stats\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		midnight := time.NewTimer(startOfDay(now.In(app.config.Location)).AddDate(0, 0, 1).Sub(now))
+	wait:
+		for {
+			select {
+			case <-r.Context().Done():
+				midnight.Stop()
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					midnight.Stop()
+					return
+				}
+				flusher.Flush()
+			case <-changed:
+				midnight.Stop()
+				break wait
+			case <-midnight.C:
+				break wait
+			}
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxTagLength caps tag names, in characters.
+	maxTagLength = 30
+	// maxItemTags caps how many tags one item can have.
+	maxItemTags = 10
+)
+
+// cleanTagName normalizes a tag name: the item name policy is applied and
+// the result lowercased, so "Work" and "work " are the same tag.
+func cleanTagName(name, policy string) (string, error) {
+	name, err := sanitizeItemName(name, policy)
+	if err != nil {
+		return "", errors.New("Tag names cannot contain control or invisible formatting characters")
+	}
+	name = strings.ToLower(name)
+	if utf8.RuneCountInString(name) > maxTagLength {
+		return "", fmt.Errorf("Tags can be at most %d characters", maxTagLength)
+	}
+	return name, nil
+}
+
+// cleanTags normalizes a list of tag names with cleanTagName, dropping
+// empty names and duplicates, and sorts them the way loadTags does.
+func cleanTags(names []string, policy string) ([]string, error) {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		tag, err := cleanTagName(name, policy)
+		if err != nil {
+			return nil, err
+		}
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxItemTags {
+		return nil, fmt.Errorf("An item can have at most %d tags", maxItemTags)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// parseTags reads the comma-separated tags of a form field.
+func parseTags(value, policy string) ([]string, error) {
+	return cleanTags(strings.Split(value, ","), policy)
+}
+
+// TagList returns the item's tags as the comma-separated text of the tag
+// form fields.
+func (item Item) TagList() string {
+	return strings.Join(item.Tags, ", ")
+}
+
+// taggedWith scopes an Item query to items with the named tag. An empty
+// name doesn't filter.
+func taggedWith(tag string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			return db
+		}
+		return db.Where("items.id IN (SELECT item_tags.item_id FROM item_tags JOIN tags ON tags.id = item_tags.tag_id WHERE tags.name = ?)", tag)
+	}
+}
+
+// loadTags fills in the Tags of items with one query.
+func (app *App) loadTags(items []Item) {
+	if len(items) == 0 {
+		return
+	}
+	ids := make([]uint, len(items))
+	for i := range items {
+		ids[i] = items[i].ID
+		items[i].Tags = []string{}
+	}
+	var rows []struct {
+		ItemID uint
+		Name   string
+	}
+	err := app.db.Table("item_tags").
+		Select("item_tags.item_id, tags.name").
+		Joins("JOIN tags ON tags.id = item_tags.tag_id").
+		Where("item_tags.item_id IN ?", ids).
+		Order("tags.name").
+		Scan(&rows).Error
+	if err != nil {
+		log.Println("Error loading item tags:", err)
+		return
+	}
+	byItem := map[uint][]string{}
+	for _, row := range rows {
+		byItem[row.ItemID] = append(byItem[row.ItemID], row.Name)
+	}
+	for i := range items {
+		if tags, ok := byItem[items[i].ID]; ok {
+			items[i].Tags = tags
+		}
+	}
+}
+
+// loadItemTags fills in the Tags of a single item.
+func (app *App) loadItemTags(item *Item) {
+	items := []Item{*item}
+	app.loadTags(items)
+	item.Tags = items[0].Tags
+}
+
+// saveItemTags replaces the tags of item with item.Tags, creating the
+// owner's tags that don't exist yet. Tags left without items are kept, so
+// they can be reused or deleted from the tag list.
+func (app *App) saveItemTags(item *Item) error {
+	return app.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("item_id = ?", item.ID).Delete(&ItemTag{}).Error; err != nil {
+			return err
+		}
+		for _, name := range item.Tags {
+			tag := Tag{UserID: item.UserID, Name: name}
+			if err := tx.Where(Tag{UserID: item.UserID, Name: name}).FirstOrCreate(&tag).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&ItemTag{ItemID: item.ID, TagID: tag.ID, UserID: item.UserID}).Error; err != nil {
+				return err
+			}
+		}
+		// A tag change is a change to the item, for the list's ETag
+		return tx.Model(item).Update("updated_at", time.Now()).Error
+	})
+}
+
+// deleteItem deletes item along with its tag assignments. The item keeps
+// its Tags for the deletion event.
+func (app *App) deleteItem(item *Item) error {
+	if item.Tags == nil {
+		app.loadItemTags(item)
+	}
+	return app.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("item_id = ?", item.ID).Delete(&ItemTag{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(item).Error
+	})
+}
+
+// tagResponse is the JSON form of a tag in the tag list.
+type tagResponse struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	ItemCount int64  `json:"item_count"`
+}
+
+// userTags returns the user's tags in name order with how many items
+// carry each.
+func (app *App) userTags(userID interface{}) []tagResponse {
+	tags := []tagResponse{}
+	app.db.Model(&Tag{}).
+		Select("tags.id, tags.name, COUNT(item_tags.item_id) AS item_count").
+		Joins("LEFT JOIN item_tags ON item_tags.tag_id = tags.id").
+		Where("tags.user_id = ?", userID).
+		Group("tags.id, tags.name").
+		Order("tags.name").
+		Scan(&tags)
+	return tags
+}
+
+// tagsHandler lists the user's tags with their item counts: the tags.templ
+// fragment, or JSON {"tags": [...]}.
+func (app *App) tagsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	if respondJSON(r) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"tags": app.userTags(userID)})
+		return
+	}
+	app.renderTags(w, userID, nil)
+}
+
+// renderTags renders the tag list for userID, merging data into the
+// template data.
+func (app *App) renderTags(w http.ResponseWriter, userID interface{}, data map[string]interface{}) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data["Tags"] = app.userTags(userID)
+	app.tmpl.ExecuteTemplate(w, "tags.templ", data)
+}
+
+// writeTagError reports a rejected tag change: JSON clients get status
+// and the message, the browser gets the tag list with the message.
+func (app *App) writeTagError(w http.ResponseWriter, r *http.Request, userID interface{}, status int, message string) {
+	if respondJSON(r) {
+		writeJSONError(w, status, message)
+		return
+	}
+	app.renderTags(w, userID, map[string]interface{}{"Error": message})
+}
+
+// tagChanged records that renaming or deleting tag changed the items that
+// carry it: their updated_at moves on, and the page that made the change
+// reloads its item list through the itemsChanged event.
+func (app *App) tagChanged(w http.ResponseWriter, userID interface{}, tag Tag) {
+	err := app.db.Model(&Item{}).
+		Where("id IN (SELECT item_id FROM item_tags WHERE tag_id = ?)", tag.ID).
+		Update("updated_at", time.Now()).Error
+	if err != nil {
+		log.Println("Error touching tagged items:", err)
+	}
+	app.touchItems(userID)
+	w.Header().Set("HX-Trigger", "itemsChanged")
+}
+
+// renameTagHandler renames one of the user's tags from the "name" form
+// value; every item carrying it shows the new name.
+func (app *App) renameTagHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	var tag Tag
+	if err := app.db.Where("id = ? AND user_id = ?", mux.Vars(r)["id"], userID).First(&tag).Error; err != nil {
+		app.writeTagError(w, r, userID, http.StatusNotFound, "Tag not found")
+		return
+	}
+	name, err := cleanTagName(r.FormValue("name"), app.config.ItemNamePolicy)
+	if err == nil && name == "" {
+		err = errors.New("Tag name cannot be empty")
+	}
+	if err != nil {
+		app.writeTagError(w, r, userID, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if name != tag.Name {
+		var count int64
+		app.db.Model(&Tag{}).Where("user_id = ? AND name = ?", userID, name).Count(&count)
+		if count > 0 {
+			app.writeTagError(w, r, userID, http.StatusConflict, fmt.Sprintf("You already have a tag named %q", name))
+			return
+		}
+		if err := app.db.Model(&tag).Update("name", name).Error; err != nil {
+			log.Println("Error renaming tag:", err)
+			writeServerError(w)
+			return
+		}
+		app.tagChanged(w, userID, tag)
+	}
+	if respondJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	app.renderTags(w, userID, nil)
+}
+
+// deleteTagHandler deletes one of the user's tags and removes it from
+// their items; the items themselves are kept.
+func (app *App) deleteTagHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.itemRouteUser(w, r)
+	if !ok {
+		return
+	}
+	var tag Tag
+	if err := app.db.Where("id = ? AND user_id = ?", mux.Vars(r)["id"], userID).First(&tag).Error; err != nil {
+		app.writeTagError(w, r, userID, http.StatusNotFound, "Tag not found")
+		return
+	}
+	app.tagChanged(w, userID, tag)
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tag_id = ?", tag.ID).Delete(&ItemTag{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&tag).Error
+	})
+	if err != nil {
+		log.Println("Error deleting tag:", err)
+		writeServerError(w)
+		return
+	}
+	if respondJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	app.renderTags(w, userID, nil)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// templateFuncs returns the custom functions available to all templates.
+// Dates are rendered in cfg.Location.
+//
+// Functions must return plain strings (or other non-content types) so that
+// html/template escapes their output. Returning template.HTML, template.JS,
+// template.URL and friends bypasses escaping; such a function is only
+// allowed once it has been reviewed and added to reviewedUnescapedFuncs.
+func templateFuncs(cfg Config) template.FuncMap {
+	return template.FuncMap{
+		"substr": func(s string, start, length int) string {
+			if start >= len(s) {
+				return ""
+			}
+			end := start + length
+			if end > len(s) {
+				end = len(s)
+			}
+			return s[start:end]
+		},
+		"upper": func(s string) string {
+			return strings.ToUpper(s)
+		},
+		"add": func(a, b int) int {
+			return a + b
+		},
+		"registrationEnabled": func() bool {
+			return cfg.RegistrationEnabled && !cfg.PasswordLoginDisabled
+		},
+		"inviteOnly": func() bool {
+			return cfg.InviteOnly
+		},
+		"passwordLoginEnabled": func() bool {
+			return !cfg.PasswordLoginDisabled
+		},
+		"loginCaptcha": func() map[string]string {
+			if !cfg.CaptchaOnLogin {
+				return map[string]string{"CaptchaProvider": CaptchaProviderNone}
+			}
+			return map[string]string{
+				"CaptchaProvider": cfg.CaptchaProvider,
+				"CaptchaSiteKey":  cfg.CaptchaSiteKey,
+			}
+		},
+		"magicLinkEnabled": func() bool {
+			return cfg.MagicLinkEnabled
+		},
+		"rememberMeEnabled": func() bool {
+			return cfg.RememberMeDuration > 0
+		},
+		"passwordMinLength": func() int {
+			return cfg.PasswordMinLength
+		},
+		"demoEnabled": func() bool {
+			return cfg.DemoMode
+		},
+		// oauthProviders lists the identity providers users can sign in
+		// with.
+		"oauthProviders": func() []*oauthProvider {
+			return oauthProviders(cfg)
+		},
+		"samlEnabled": func() bool {
+			return cfg.SAMLIDPMetadata != ""
+		},
+		"samlLabel": func() string {
+			return cfg.SAMLLabel
+		},
+		// markdown renders item notes as HTML. It returns template.HTML
+		// so the markup survives; renderMarkdown drops raw HTML and
+		// sanitizes the result.
+		"markdown": renderMarkdown,
+		// money formats an amount in cents in a currency, like $12.50.
+		"money": formatMoney,
+		// itemColors is the palette of item color labels.
+		"itemColors": func() []string {
+			return itemColors
+		},
+		// formatDate formats t with a Go time layout in the configured
+		// timezone rather than the server's local one. Nil times render
+		// as an empty string.
+		"formatDate": func(t interface{}, layout string) string {
+			switch v := t.(type) {
+			case time.Time:
+				return v.In(cfg.Location).Format(layout)
+			case *time.Time:
+				if v == nil {
+					return ""
+				}
+				return v.In(cfg.Location).Format(layout)
+			default:
+				return ""
+			}
+		},
+	}
+}
+
+// reviewedUnescapedFuncs lists template functions that are allowed to return
+// pre-escaped content. Before adding a function here, check that:
+//
+//   - every piece of user input it includes is escaped or sanitized,
+//   - the output is only used in the context its type claims (HTML, JS, URL...),
+//   - there is a comment on the function explaining why escaping is skipped.
+var reviewedUnescapedFuncs = map[string]bool{
+	"markdown": true, // renderMarkdown sanitizes its output with bluemonday
+}
+
+// unescapedTypes are the html/template content types that skip escaping.
+var unescapedTypes = []reflect.Type{
+	reflect.TypeOf(template.HTML("")),
+	reflect.TypeOf(template.HTMLAttr("")),
+	reflect.TypeOf(template.JS("")),
+	reflect.TypeOf(template.JSStr("")),
+	reflect.TypeOf(template.CSS("")),
+	reflect.TypeOf(template.URL("")),
+	reflect.TypeOf(template.Srcset("")),
+}
+
+// checkTemplateFuncs returns an error naming any function in funcs that
+// returns an unescaped content type without being listed in
+// reviewedUnescapedFuncs. It runs at startup so an unreviewed function can't
+// ship.
+func checkTemplateFuncs(funcs template.FuncMap) error {
+	var unsafe []string
+	for name, fn := range funcs {
+		if reviewedUnescapedFuncs[name] {
+			continue
+		}
+		fnType := reflect.TypeOf(fn)
+		if fnType.Kind() != reflect.Func {
+			continue
+		}
+		for i := 0; i < fnType.NumOut(); i++ {
+			if isUnescapedType(fnType.Out(i)) {
+				unsafe = append(unsafe, fmt.Sprintf("%s returns %s", name, fnType.Out(i)))
+			}
+		}
+	}
+	if len(unsafe) == 0 {
+		return nil
+	}
+	sort.Strings(unsafe)
+	return fmt.Errorf("%s (review it and add it to reviewedUnescapedFuncs)", strings.Join(unsafe, ", "))
+}
+
+func isUnescapedType(t reflect.Type) bool {
+	for _, unescaped := range unescapedTypes {
+		if t == unescaped {
+			return true
+		}
+	}
+	return false
+}
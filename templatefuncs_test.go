@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// xssPayload is put in every field a user controls. It must never come back
+// unescaped.
+const xssPayload = "<script>alert(1)</script>"
+
+func TestTemplatesEscapeUserInput(t *testing.T) {
+	handler, app := newTestApp(t)
+	email := xssPayload + "@example.com"
+	admin := createTestUser(t, app, email, "Correct-Horse-1", RoleAdmin)
+	app.db.Model(&admin).Update("pending_email", xssPayload)
+	c := newTestClient(t, handler)
+	c.login(email, "Correct-Horse-1")
+
+	// Fill every user-controlled field through the forms that set them
+	forms := []struct {
+		path string
+		form url.Values
+	}{
+		{"/categories", url.Values{"name": {xssPayload}}},
+		{"/lists", url.Values{"name": {xssPayload}}},
+		{"/items", url.Values{
+			"name":        {xssPayload},
+			"description": {xssPayload},
+			"notes":       {xssPayload + "\n\n[link](javascript:alert(1))"},
+			"tags":        {xssPayload},
+			"category_id": {"1"},
+		}},
+		{"/items", url.Values{"name": {xssPayload}}}, // a duplicate
+		{"/account/tokens", url.Values{"name": {xssPayload}}},
+		{"/account/webhook", url.Values{"url": {"https://example.com/" + xssPayload}, "events": {WebhookItemCreated}}},
+		{"/account/hooks", url.Values{"name": {xssPayload}, "name_field": {xssPayload}}},
+	}
+	for _, f := range forms {
+		resp, body := c.post(f.path, f.form)
+		if resp.StatusCode >= 400 {
+			t.Fatalf("POST %s: status %d\n%s", f.path, resp.StatusCode, body)
+		}
+		if strings.Contains(body, xssPayload) {
+			t.Errorf("POST %s returned the payload unescaped:\n%s", f.path, body)
+		}
+	}
+
+	// The user agent shows up in the session and sign-in activity lists
+	header := http.Header{"User-Agent": {xssPayload}}
+	paths := []string{
+		"/",
+		"/items",
+		"/items?search=" + url.QueryEscape(xssPayload),
+		"/items?tag=" + url.QueryEscape(xssPayload),
+		"/items/1",
+		"/items/1/row",
+		"/items/1/edit",
+		"/items/recent-searches",
+		"/items/duplicates",
+		"/lists",
+		"/lists/options",
+		"/tags",
+		"/categories",
+		"/categories/options",
+		"/stats",
+		"/account/tokens",
+		"/account/webhook",
+		"/account/hooks",
+		"/account/sessions",
+		"/account/activity",
+		"/account/email",
+		"/account/password",
+		"/account/2fa",
+		"/account/passkeys",
+		"/account/currency",
+		"/account/delete",
+		"/admin/users",
+		"/admin/users/list",
+		"/admin/stats",
+		"/no-such-page/" + url.PathEscape(xssPayload),
+	}
+	for _, path := range paths {
+		resp, body := c.do("GET", path, nil, header)
+		if resp.StatusCode >= 500 {
+			t.Errorf("GET %s: status %d", path, resp.StatusCode)
+		}
+		if strings.Contains(body, xssPayload) {
+			t.Errorf("GET %s returned the payload unescaped:\n%s", path, body)
+		}
+	}
+	if _, body := c.get("/items/1"); !strings.Contains(body, template.HTMLEscapeString(xssPayload)) {
+		t.Errorf("item detail doesn't show the escaped name:\n%s", body)
+	}
+}
+
+// TestEveryTemplateEscapesData renders each template directly, including
+// ones no route above reaches, with the payload in every commonly used
+// field. Templates that expect data of another shape stop early; what they
+// wrote up to then must still be escaped.
+func TestEveryTemplateEscapesData(t *testing.T) {
+	_, app := newTestApp(t)
+	now := time.Now()
+	user := User{ID: 1, Email: xssPayload, PendingEmail: xssPayload, CreatedAt: now}
+	item := Item{
+		ID:          1,
+		Name:        xssPayload,
+		Description: xssPayload,
+		Notes:       xssPayload,
+		Tags:        []string{xssPayload},
+		Category:    &Category{ID: 1, Name: xssPayload},
+		List:        &List{ID: 1, Name: xssPayload},
+		CreatedAt:   now,
+	}
+	data := map[string]interface{}{
+		"User":     user,
+		"Item":     item,
+		"Items":    []Item{item},
+		"Error":    xssPayload,
+		"Message":  xssPayload,
+		"Success":  xssPayload,
+		"Email":    xssPayload,
+		"Name":     xssPayload,
+		"Search":   xssPayload,
+		"Query":    xssPayload,
+		"URL":      xssPayload,
+		"Token":    xssPayload,
+		"Selected": xssPayload,
+		"Currency": xssPayload,
+		"Content":  "error",
+		"Data":     map[string]interface{}{"Error": xssPayload, "User": user},
+	}
+	for _, tmpl := range app.tmpl.Templates() {
+		if tmpl.Name() == "" {
+			continue
+		}
+		var buf bytes.Buffer
+		tmpl.Execute(&buf, data)
+		if strings.Contains(buf.String(), xssPayload) {
+			t.Errorf("%s rendered the payload unescaped:\n%s", tmpl.Name(), buf.String())
+		}
+	}
+}
+
+func TestTemplateFuncsAreReviewed(t *testing.T) {
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	funcs := templateFuncs(cfg)
+	if err := checkTemplateFuncs(funcs); err != nil {
+		t.Fatalf("a template function skips escaping without review: %v", err)
+	}
+	for name := range reviewedUnescapedFuncs {
+		if _, ok := funcs[name]; !ok {
+			t.Errorf("reviewedUnescapedFuncs lists %q, which is not a template function", name)
+		}
+	}
+
+	// The check has to catch a new function that returns template.HTML
+	funcs["unreviewed"] = func(s string) template.HTML { return template.HTML(s) }
+	err = checkTemplateFuncs(funcs)
+	if err == nil || !strings.Contains(err.Error(), "unreviewed returns template.HTML") {
+		t.Errorf("checkTemplateFuncs with an unreviewed template.HTML function = %v, want an error naming it", err)
+	}
+}
+
+func TestFormatDateUsesLocation(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no timezone data:", err)
+	}
+	formatDate := templateFuncs(Config{Location: loc})["formatDate"].(func(interface{}, string) string)
+
+	// 02:30 UTC is still the evening before in New York
+	utc := time.Date(2026, 3, 14, 2, 30, 0, 0, time.UTC)
+	if got, want := formatDate(utc, "2006-01-02 15:04"), "2026-03-13 22:30"; got != want {
+		t.Errorf("formatDate(%v) = %q, want %q", utc, got, want)
+	}
+	if got, want := formatDate(&utc, "Jan 2"), "Mar 13"; got != want {
+		t.Errorf("formatDate(&%v) = %q, want %q", utc, got, want)
+	}
+	// A time in another zone is converted too
+	tokyo := utc.In(time.FixedZone("JST", 9*60*60))
+	if got, want := formatDate(tokyo, "2006-01-02"), "2026-03-13"; got != want {
+		t.Errorf("formatDate(%v) = %q, want %q", tokyo, got, want)
+	}
+	if got := formatDate((*time.Time)(nil), "Jan 2"); got != "" {
+		t.Errorf("formatDate(nil) = %q, want empty", got)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// tokenPrefixLength is how many leading characters of a token are stored in
+// the clear so it can be recognised in listings.
+const tokenPrefixLength = 8
+
+// maxTokenNameLength caps the label users give their API tokens.
+const maxTokenNameLength = 100
+
+// hashToken returns the value stored in UserToken.TokenHash for a token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// MaskedToken returns the token prefix followed by a mask, for display.
+func (t UserToken) MaskedToken() string {
+	return t.Prefix + strings.Repeat("•", 8)
+}
+
+// apiPrincipalKey is the request context key under which apiRateLimit
+// stores the authenticated user ID.
+type apiPrincipalKey struct{}
+
+// apiUserID resolves the user for an API request from either an
+// "Authorization: Bearer" token or the session cookie. When neither is valid,
+// or the caller is over its rate limit, it writes the error response and
+// returns false. Requests that already passed apiRateLimit are not charged
+// twice.
+func (app *App) apiUserID(w http.ResponseWriter, r *http.Request) (interface{}, bool) {
+	if userID, ok := r.Context().Value(apiPrincipalKey{}).(uint); ok {
+		return userID, true
+	}
+	userID, ok := app.authenticateAPI(w, r)
+	return userID, ok
+}
+
+// apiRateLimit authenticates every request to an API version and charges
+// it to the caller's rate limit before the handler runs.
+func (app *App) apiRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := app.authenticateAPI(w, r)
+		if !ok {
+			return
+		}
+		ctx := context.WithValue(r.Context(), apiPrincipalKey{}, userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticateAPI resolves the caller and takes one request from its
+// budget: token requests are limited per token (the token's own RateLimit,
+// or TOKEN_RATE_LIMIT) and session requests per user (API_RATE_LIMIT).
+// The X-RateLimit-* headers are set on every response.
+func (app *App) authenticateAPI(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	var (
+		userID    uint
+		key       string
+		perMinute int
+		token     UserToken
+	)
+
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		var err error
+		token, err = app.lookupToken(strings.TrimPrefix(auth, "Bearer "))
+		switch {
+		case errors.Is(err, errAccountSuspended):
+			writeJSONError(w, http.StatusForbidden, "account suspended")
+			return 0, false
+		case errors.Is(err, errEmailUnverified):
+			if requiresVerifiedEmail(r.URL.Path) {
+				writeJSONError(w, http.StatusForbidden, "email address not verified")
+				return 0, false
+			}
+		case err != nil:
+			writeJSONError(w, http.StatusUnauthorized, "invalid token")
+			return 0, false
+		}
+		userID = token.UserID
+		key = tokenRateLimitKey(token)
+		perMinute = app.tokenRateLimit(token)
+	} else {
+		session, _ := app.store.Get(r, "session")
+		sessionUserID, ok := session.Values["user_id"]
+		if !ok || sessionUserID == nil {
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return 0, false
+		}
+		userID = toUint(sessionUserID)
+		key = "user:" + strconv.Itoa(int(userID))
+		perMinute = app.config.APIRateLimit
+	}
+
+	now := time.Now()
+	limit := app.limiter.allow(key, perMinute, time.Minute, now)
+	setRateLimitHeaders(w, limit, now)
+	if !limit.Allowed {
+		writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		return 0, false
+	}
+
+	if token.ID != 0 {
+		app.recordTokenUse(token, now)
+	}
+	return userID, true
+}
+
+var (
+	// errAccountSuspended is returned by lookupToken for tokens of
+	// suspended users.
+	errAccountSuspended = errors.New("account suspended")
+	// errEmailUnverified is returned by lookupToken for tokens of users who
+	// haven't verified their email address.
+	errEmailUnverified = errors.New("email address not verified")
+)
+
+// lookupToken returns the active token with the given value. It fails
+// with errAccountSuspended when the token's user is suspended, and with
+// errEmailUnverified, still returning the token, when they haven't
+// verified their email address; like their sessions, such tokens only
+// work outside the routes requiresVerifiedEmail lists.
+func (app *App) lookupToken(value string) (UserToken, error) {
+	var token UserToken
+	if err := app.db.Where("token_hash = ? AND revoked_at IS NULL", hashToken(value)).First(&token).Error; err != nil {
+		return token, err
+	}
+	if app.isUserDisabled(token.UserID) {
+		return token, errAccountSuspended
+	}
+	if !app.isUserVerified(token.UserID) {
+		return token, errEmailUnverified
+	}
+	return token, nil
+}
+
+// tokenRateLimitKey is the limiter key requests with token are charged to.
+func tokenRateLimitKey(token UserToken) string {
+	return "token:" + strconv.Itoa(int(token.ID))
+}
+
+// tokenRateLimit returns the requests per minute allowed for token: its
+// own RateLimit, or TOKEN_RATE_LIMIT.
+func (app *App) tokenRateLimit(token UserToken) int {
+	if token.RateLimit > 0 {
+		return token.RateLimit
+	}
+	return app.config.TokenRateLimit
+}
+
+// recordTokenUse counts a request made with token.
+func (app *App) recordTokenUse(token UserToken, now time.Time) {
+	app.db.Model(&token).Updates(map[string]interface{}{
+		"request_count": gorm.Expr("request_count + 1"),
+		"last_used_at":  now,
+	})
+}
+
+// tokensHandler lists the user's active API tokens.
+func (app *App) tokensHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	app.renderTokens(w, user.ID, nil)
+}
+
+// renderTokens renders the token list for userID, merging data into the
+// template data.
+func (app *App) renderTokens(w http.ResponseWriter, userID uint, data map[string]interface{}) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	var tokens []UserToken
+	app.db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("created_at desc").Find(&tokens)
+	data["Tokens"] = tokens
+	data["DefaultRateLimit"] = app.config.TokenRateLimit
+	data["MaxRateLimit"] = app.config.MaxTokenRateLimit
+	app.tmpl.ExecuteTemplate(w, "tokens.templ", data)
+}
+
+// generateAPIToken returns a new random API token: 32 bytes, hex encoded.
+func generateAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// createTokenHandler issues a named API token, with its own requests per
+// minute if "rate_limit" is set. Only its hash and prefix are stored, so
+// the full value is shown this one time.
+func (app *App) createTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	rateLimitValue := strings.TrimSpace(r.FormValue("rate_limit"))
+	if user.Demo {
+		w.WriteHeader(http.StatusForbidden)
+		app.renderTokens(w, user.ID, map[string]interface{}{
+			"Error":     "API tokens are not available for demo accounts",
+			"Name":      name,
+			"RateLimit": rateLimitValue,
+		})
+		return
+	}
+	if name == "" || len(name) > maxTokenNameLength {
+		app.renderTokens(w, user.ID, map[string]interface{}{
+			"Error":     "Token name is required (up to 100 characters)",
+			"Name":      name,
+			"RateLimit": rateLimitValue,
+		})
+		return
+	}
+	rateLimit := 0
+	if rateLimitValue != "" {
+		n, err := strconv.Atoi(rateLimitValue)
+		if err != nil || n < 0 || n > app.config.MaxTokenRateLimit {
+			app.renderTokens(w, user.ID, map[string]interface{}{
+				"Error":     fmt.Sprintf("Rate limit must be a whole number of requests per minute, up to %d", app.config.MaxTokenRateLimit),
+				"Name":      name,
+				"RateLimit": rateLimitValue,
+			})
+			return
+		}
+		rateLimit = n
+	}
+
+	value, err := generateAPIToken()
+	if err != nil {
+		log.Println("Error generating API token:", err)
+		writeServerError(w)
+		return
+	}
+	token := UserToken{
+		UserID:    user.ID,
+		Name:      name,
+		TokenHash: hashToken(value),
+		Prefix:    value[:tokenPrefixLength],
+		RateLimit: rateLimit,
+	}
+	if err := app.db.Create(&token).Error; err != nil {
+		log.Println("Error creating API token:", err)
+		writeServerError(w)
+		return
+	}
+
+	app.renderTokens(w, user.ID, map[string]interface{}{
+		"Created":  token,
+		"NewToken": value,
+	})
+}
+
+// revokeTokenHandler revokes one of the user's API tokens. The row is kept
+// so the token shows up in data exports with its usage.
+func (app *App) revokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	err := app.db.Model(&UserToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", mux.Vars(r)["id"], user.ID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		log.Println("Error revoking API token:", err)
+		writeServerError(w)
+		return
+	}
+	app.renderTokens(w, user.ID, nil)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// totpIssuer names the account in authenticator apps.
+	totpIssuer = "HTMX Auth App"
+	// totpPeriod and totpDigits are the RFC 6238 defaults that every
+	// authenticator app supports.
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is how many periods either side of now a code is accepted,
+	// to allow for clock drift.
+	totpSkew = 1
+	// totpLoginTimeout is how long a user has to enter their code after
+	// their password was accepted.
+	totpLoginTimeout = 5 * time.Minute
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateTOTPSecret returns a new random base32 TOTP secret.
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpCode returns the code for secret in the given time step.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// validateTOTP checks code against secret at now and returns the time step
+// it matched. Codes from steps up to lastStep are rejected, so a code can't
+// be used twice.
+func validateTOTP(secret, code string, lastStep int64, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		want, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(code), []byte(want)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpProvisioningURI returns the otpauth:// URI authenticator apps read
+// from a QR code to add the account.
+func totpProvisioningURI(email, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", totpIssuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", strconv.Itoa(totpDigits))
+	v.Set("period", strconv.Itoa(totpPeriod))
+	label := url.PathEscape(totpIssuer + ":" + email)
+	// Some authenticator apps show "+" literally, so encode spaces as %20
+	return "otpauth://totp/" + label + "?" + strings.ReplaceAll(v.Encode(), "+", "%20")
+}
+
+// twoFactorData builds the data for the two_factor.templ fragment.
+func twoFactorData(user User) map[string]interface{} {
+	data := map[string]interface{}{
+		"Enabled": user.TOTPEnabled,
+	}
+	if !user.TOTPEnabled && user.TOTPSecret != "" {
+		data["Secret"] = user.TOTPSecret
+		// html/template would otherwise replace the otpauth: link with "#ZgotmplZ"
+		data["URI"] = template.URL(totpProvisioningURI(user.Email, user.TOTPSecret))
+	}
+	return data
+}
+
+// sessionUser loads the signed-in user, writing a 401 fragment if there
+// isn't one.
+func (app *App) sessionUser(w http.ResponseWriter, r *http.Request) (User, bool) {
+	var user User
+	session, _ := app.store.Get(r, "session")
+	userID, ok := session.Values["user_id"]
+	if !ok || userID == nil || app.db.First(&user, userID).Error != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`<div class="error">Unauthorized. Please log in.</div>`))
+		return user, false
+	}
+	return user, true
+}
+
+// twoFactorHandler shows the user's two-factor authentication settings.
+func (app *App) twoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	app.tmpl.ExecuteTemplate(w, "two_factor.templ", twoFactorData(user))
+}
+
+// setupTwoFactorHandler generates a new TOTP secret for the user. Two-factor
+// authentication stays off until a code from it is confirmed.
+func (app *App) setupTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		app.tmpl.ExecuteTemplate(w, "two_factor.templ", twoFactorData(user))
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		log.Println("Error generating TOTP secret:", err)
+		writeServerError(w)
+		return
+	}
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+	if err := app.db.Model(&user).Select("totp_secret", "totp_last_step").Updates(&user).Error; err != nil {
+		log.Println("Error saving TOTP secret:", err)
+		writeServerError(w)
+		return
+	}
+	app.tmpl.ExecuteTemplate(w, "two_factor.templ", twoFactorData(user))
+}
+
+// confirmTwoFactorHandler turns on two-factor authentication once the user
+// enters a valid code for their new secret.
+func (app *App) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled || user.TOTPSecret == "" {
+		app.tmpl.ExecuteTemplate(w, "two_factor.templ", twoFactorData(user))
+		return
+	}
+
+	step, valid := validateTOTP(user.TOTPSecret, r.FormValue("code"), user.TOTPLastStep, time.Now())
+	if !valid {
+		data := twoFactorData(user)
+		data["Error"] = "That code didn't match. Check your device's clock and try the next code."
+		app.tmpl.ExecuteTemplate(w, "two_factor.templ", data)
+		return
+	}
+	if err := app.db.Model(&user).Updates(map[string]interface{}{
+		"totp_enabled":   true,
+		"totp_last_step": step,
+	}).Error; err != nil {
+		log.Println("Error enabling two-factor authentication:", err)
+		writeServerError(w)
+		return
+	}
+	user.TOTPEnabled = true
+	data := twoFactorData(user)
+	data["Success"] = "Two-factor authentication is on. You'll be asked for a code when you sign in."
+	app.tmpl.ExecuteTemplate(w, "two_factor.templ", data)
+}
+
+// disableTwoFactorHandler turns off two-factor authentication. A current
+// code is required so a hijacked session can't remove it.
+func (app *App) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		app.tmpl.ExecuteTemplate(w, "two_factor.templ", twoFactorData(user))
+		return
+	}
+
+	if _, valid := validateTOTP(user.TOTPSecret, r.FormValue("code"), user.TOTPLastStep, time.Now()); !valid {
+		data := twoFactorData(user)
+		data["Error"] = "Invalid code"
+		app.tmpl.ExecuteTemplate(w, "two_factor.templ", data)
+		return
+	}
+	if err := app.db.Model(&user).Updates(map[string]interface{}{
+		"totp_enabled":   false,
+		"totp_secret":    "",
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		log.Println("Error disabling two-factor authentication:", err)
+		writeServerError(w)
+		return
+	}
+	user.TOTPEnabled, user.TOTPSecret = false, ""
+	data := twoFactorData(user)
+	data["Success"] = "Two-factor authentication is off."
+	app.tmpl.ExecuteTemplate(w, "two_factor.templ", data)
+}
+
+// loginTwoFactorHandler is the second login stage for users with
+// two-factor authentication: it checks the code and then starts the
+// session the password stage held back.
+func (app *App) loginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := app.store.Get(r, "session")
+	userID, ok := session.Values["totp_user_id"]
+	started, _ := session.Values["totp_started"].(int64)
+	if !ok || userID == nil || time.Since(time.Unix(started, 0)) > totpLoginTimeout {
+		delete(session.Values, "totp_user_id")
+		delete(session.Values, "totp_started")
+		if err := session.Save(r, w); err != nil {
+			log.Println("Error saving session:", err)
+		}
+		app.tmpl.ExecuteTemplate(w, "login.templ", map[string]interface{}{
+			"Error": "Your sign-in timed out. Please enter your password again.",
+		})
+		return
+	}
+
+	var user User
+	if err := app.db.First(&user, userID).Error; err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`<div class="error">Unauthorized. Please log in.</div>`))
+		return
+	}
+
+	backoffKey := "totp|" + strconv.FormatUint(uint64(user.ID), 10)
+	if err := sleepContext(r.Context(), app.loginBackoff.delay(backoffKey, time.Now())); err != nil {
+		return
+	}
+	step, valid := validateTOTP(user.TOTPSecret, r.FormValue("code"), user.TOTPLastStep, time.Now())
+	if !valid {
+		app.logLogin(r, AuthOutcomeFailure, user, "")
+		app.loginBackoff.fail(backoffKey, time.Now())
+		app.tmpl.ExecuteTemplate(w, "login_2fa.templ", map[string]interface{}{
+			"Error": "Invalid authentication code",
+		})
+		return
+	}
+	app.loginBackoff.reset(backoffKey)
+	app.db.Model(&user).Update("totp_last_step", step)
+
+	delete(session.Values, "totp_user_id")
+	delete(session.Values, "totp_started")
+	app.completeLogin(w, r, session, user)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"gorm.io/gorm"
+)
+
+// sessionTouchInterval is how often a session's last seen time is updated,
+// so not every request writes to the database.
+const sessionTouchInterval = time.Minute
+
+// maxUserAgentLength caps the user agent stored with a session.
+const maxUserAgentLength = 255
+
+// userAgentBrowsers and userAgentSystems map user agent substrings to the
+// names shown in the sessions list. Order matters: Edge and Opera also
+// claim to be Chrome, Chrome claims to be Safari, and Android is Linux.
+var (
+	userAgentBrowsers = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	userAgentSystems = []struct{ token, name string }{
+		{"Windows", "Windows"},
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// Device describes the browser and operating system the session was
+// started from, such as "Firefox on Linux".
+func (s UserSession) Device() string {
+	return describeUserAgent(s.UserAgent)
+}
+
+func describeUserAgent(userAgent string) string {
+	var browser, system string
+	for _, b := range userAgentBrowsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, o := range userAgentSystems {
+		if strings.Contains(userAgent, o.token) {
+			system = o.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return "Browser on " + system
+	case userAgent != "":
+		return userAgent
+	}
+	return "Unknown device"
+}
+
+// createUserSession stores a new session for user and returns its token.
+// Only the token's hash is kept in the database.
+func (app *App) createUserSession(r *http.Request, user User, now time.Time) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	us := UserSession{
+		UserID:     user.ID,
+		TokenHash:  hashToken(token),
+		UserAgent:  userAgent,
+		IP:         clientIP(r),
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(time.Duration(app.config.SessionMaxAge) * time.Second),
+	}
+	if err := app.db.Create(&us).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// currentUserSession returns the unexpired session row behind the cookie
+// session of userID.
+func (app *App) currentUserSession(session *sessions.Session, userID interface{}, now time.Time) (UserSession, error) {
+	var us UserSession
+	token, _ := session.Values["session_token"].(string)
+	if token == "" {
+		return us, gorm.ErrRecordNotFound
+	}
+	err := app.db.Where("token_hash = ? AND user_id = ? AND expires_at > ?", hashToken(token), userID, now).First(&us).Error
+	return us, err
+}
+
+// touchUserSession records that us was just used, at most once per
+// sessionTouchInterval.
+func (app *App) touchUserSession(us UserSession, now time.Time) {
+	if now.Sub(us.LastSeenAt) < sessionTouchInterval {
+		return
+	}
+	if err := app.db.Model(&us).Update("last_seen_at", now).Error; err != nil {
+		log.Println("Error updating session:", err)
+	}
+}
+
+// deleteUserSession removes the session row behind a cookie session, for
+// example on logout.
+func (app *App) deleteUserSession(session *sessions.Session) {
+	token, _ := session.Values["session_token"].(string)
+	if token == "" {
+		return
+	}
+	if err := app.db.Where("token_hash = ?", hashToken(token)).Delete(&UserSession{}).Error; err != nil {
+		log.Println("Error deleting session:", err)
+	}
+}
+
+// revokeUserSessions ends all of userID's sessions and forgets their
+// remembered devices. Use it alongside a session version bump.
+func revokeUserSessions(tx *gorm.DB, userID uint) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&RememberToken{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", userID).Delete(&UserSession{}).Error
+}
+
+// userSessionsHandler shows the active sessions page, which lists the
+// signed-in user's sessions.
+func (app *App) userSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	data := app.userSessionsData(r, user.ID)
+	if r.Header.Get("HX-Request") == "true" {
+		app.tmpl.ExecuteTemplate(w, "account_sessions.templ", data)
+		return
+	}
+	app.renderPage(w, r, http.StatusOK, "account_sessions", data)
+}
+
+// revokeUserSessionHandler ends one of the signed-in user's sessions. It
+// stops working on the device's next request, and a device remembered at
+// sign-in is forgotten.
+func (app *App) revokeUserSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	var us UserSession
+	if err := app.db.Where("id = ? AND user_id = ?", mux.Vars(r)["id"], user.ID).First(&us).Error; err != nil {
+		app.notFoundHandler(w, r)
+		return
+	}
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		if us.RememberID != nil {
+			if err := tx.Delete(&RememberToken{}, *us.RememberID).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&us).Error
+	})
+	if err != nil {
+		writeServerError(w)
+		return
+	}
+	app.tmpl.ExecuteTemplate(w, "user_sessions.templ", app.userSessionsData(r, user.ID))
+}
+
+// revokeOtherUserSessionsHandler ends all of the signed-in user's sessions
+// except the one making the request.
+func (app *App) revokeOtherUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	session, _ := app.store.Get(r, "session")
+	current, err := app.currentUserSession(session, user.ID, time.Now())
+	if err != nil {
+		writeServerError(w)
+		return
+	}
+	err = app.db.Transaction(func(tx *gorm.DB) error {
+		remembered := tx.Where("user_id = ?", user.ID)
+		if current.RememberID != nil {
+			remembered = remembered.Where("id <> ?", *current.RememberID)
+		}
+		if err := remembered.Delete(&RememberToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND id <> ?", user.ID, current.ID).Delete(&UserSession{}).Error
+	})
+	if err != nil {
+		writeServerError(w)
+		return
+	}
+	data := app.userSessionsData(r, user.ID)
+	data["Success"] = "All other sessions have been signed out."
+	app.tmpl.ExecuteTemplate(w, "user_sessions.templ", data)
+}
+
+// userSessionsData loads the user's active sessions, most recently used
+// first, and marks the one making the request.
+func (app *App) userSessionsData(r *http.Request, userID uint) map[string]interface{} {
+	now := time.Now()
+	var list []UserSession
+	app.db.Where("user_id = ? AND expires_at > ?", userID, now).Order("last_seen_at desc").Find(&list)
+	session, _ := app.store.Get(r, "session")
+	current, _ := app.currentUserSession(session, userID, now)
+	return map[string]interface{}{
+		"Sessions":  list,
+		"CurrentID": current.ID,
+	}
+}
+
+// startSessionCleanup deletes expired sessions every hour.
+func (app *App) startSessionCleanup() {
+	go func() {
+		for range time.Tick(time.Hour) {
+			app.deleteExpiredUserSessions(time.Now())
+		}
+	}()
+}
+
+func (app *App) deleteExpiredUserSessions(now time.Time) {
+	if err := app.db.Where("expires_at < ?", now).Delete(&UserSession{}).Error; err != nil {
+		log.Println("Error deleting expired sessions:", err)
+	}
+}
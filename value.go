@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultCurrency is the currency of accounts that haven't chosen one.
+	defaultCurrency = "USD"
+	// maxValueCents caps an item's value, in cents: 1,000,000,000.00.
+	maxValueCents = 100_000_000_000
+)
+
+// currency is one of the currencies users can show item values in.
+type currency struct {
+	Code   string
+	Symbol string
+}
+
+// currencies are the supported currencies, in the order of the currency
+// select. Values are kept in hundredths of the unit whatever the currency.
+var currencies = []currency{
+	{"USD", "$"},
+	{"EUR", "€"},
+	{"GBP", "£"},
+	{"CAD", "CA$"},
+	{"AUD", "A$"},
+	{"CHF", "CHF "},
+}
+
+// findCurrency returns the supported currency with the given code.
+func findCurrency(code string) (currency, bool) {
+	for _, c := range currencies {
+		if c.Code == code {
+			return c, true
+		}
+	}
+	return currency{}, false
+}
+
+// parseValue reads a decimal amount like 12, 12.5 or 1234.99 as cents,
+// without going through floating point. An empty value is 0; negative
+// amounts and fractions of a cent are rejected.
+func parseValue(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	invalid := errors.New("Value must be an amount like 12.50")
+	whole, fraction, _ := strings.Cut(value, ".")
+	if whole == "" {
+		whole = "0"
+	}
+	if len(fraction) > 2 || strings.ContainsAny(whole, "+-") {
+		return 0, invalid
+	}
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || units > maxValueCents/100 {
+		return 0, invalid
+	}
+	cents := int64(0)
+	if fraction != "" {
+		if strings.ContainsAny(fraction, "+-") {
+			return 0, invalid
+		}
+		if cents, err = strconv.ParseInt(fraction, 10, 64); err != nil {
+			return 0, invalid
+		}
+		if len(fraction) == 1 {
+			cents *= 10
+		}
+	}
+	total := units*100 + cents
+	if total > maxValueCents {
+		return 0, fmt.Errorf("Value can be at most %s", formatValue(maxValueCents))
+	}
+	return total, nil
+}
+
+// formatValue writes cents as a decimal amount with two places, the form
+// parseValue reads and the JSON API uses.
+func formatValue(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// formatMoney writes cents as an amount in the currency with the given
+// code, like $12.50. It is the "money" template function.
+func formatMoney(cents int64, code string) string {
+	c, ok := findCurrency(code)
+	if !ok {
+		return formatValue(cents) + " " + code
+	}
+	return c.Symbol + formatValue(cents)
+}
+
+// Amount returns the item's value as the decimal amount of the item
+// forms, or "" when it has none.
+func (item Item) Amount() string {
+	if item.ValueCents == 0 {
+		return ""
+	}
+	return formatValue(item.ValueCents)
+}
+
+// userCurrency returns the code of the currency the user shows values in.
+func (app *App) userCurrency(userID interface{}) string {
+	var user User
+	if err := app.db.Select("currency").First(&user, userID).Error; err != nil || user.Currency == "" {
+		return defaultCurrency
+	}
+	return user.Currency
+}
+
+// currencySettingsHandler shows the user's currency in the currency.templ
+// form.
+func (app *App) currencySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	app.tmpl.ExecuteTemplate(w, "currency.templ", map[string]interface{}{
+		"Currencies": currencies,
+		"Selected":   user.Currency,
+	})
+}
+
+// saveCurrencyHandler sets the user's currency from the "currency" form
+// value. Values and stats all over the dashboard change with it, so the page
+// reloads.
+func (app *App) saveCurrencyHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	code := r.FormValue("currency")
+	if _, ok := findCurrency(code); !ok {
+		app.tmpl.ExecuteTemplate(w, "currency.templ", map[string]interface{}{
+			"Currencies": currencies,
+			"Selected":   user.Currency,
+			"Error":      "Choose one of the listed currencies",
+		})
+		return
+	}
+	if err := app.db.Model(&user).Update("currency", code).Error; err != nil {
+		log.Println("Error saving currency:", err)
+		writeServerError(w)
+		return
+	}
+	app.touchItems(user.ID)
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusNoContent)
+}
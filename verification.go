@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// verificationResendsPerHour limits how often a user can ask for a new
+// verification email.
+const verificationResendsPerHour = 3
+
+const verifyEmailMessage = "Please verify your email address to use your items. Check your inbox for the link we sent."
+
+// signEmailVerification returns the HMAC that authenticates a verification
+// link for userID and email, valid until expires. Signing the email means a
+// link stops working if the address changes.
+func signEmailVerification(secret string, userID uint, email string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "verify-email\x00%d\x00%s\x00%d", userID, email, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// emailVerificationToken builds the token for a verification link:
+// "<user id>.<expiry unix time>.<signature>". Nothing is stored server side.
+func (app *App) emailVerificationToken(user User, now time.Time) string {
+	expires := now.Add(app.config.EmailVerificationTTL).Unix()
+	sig := signEmailVerification(app.config.SessionSecret, user.ID, user.Email, expires)
+	return fmt.Sprintf("%d.%d.%s", user.ID, expires, sig)
+}
+
+// checkEmailVerificationToken returns the user a verification token was
+// issued for, or false if it is malformed, expired or forged.
+func (app *App) checkEmailVerificationToken(token string, now time.Time) (User, bool) {
+	var user User
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return user, false
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return user, false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return user, false
+	}
+	if err := app.db.First(&user, id).Error; err != nil {
+		return user, false
+	}
+	// Links signed before a secret rotation keep working
+	for _, secret := range app.config.sessionSecrets() {
+		want := signEmailVerification(secret, user.ID, user.Email, expires)
+		if hmac.Equal([]byte(parts[2]), []byte(want)) {
+			return user, true
+		}
+	}
+	return user, false
+}
+
+// sendVerificationEmail emails user a verification link in the background.
+func (app *App) sendVerificationEmail(user User) {
+	link := app.config.BaseURL + "/verify-email?token=" + url.QueryEscape(app.emailVerificationToken(user, time.Now()))
+	go func() {
+		body := fmt.Sprintf("Welcome! Please confirm that %s is your email address by opening this link within %s:\n%s\n\n"+
+			"If you didn't create an account, you can ignore this email.\n",
+			user.Email, app.config.EmailVerificationTTL, link)
+		if err := app.mailer.Send(user.Email, "Verify your email address", body); err != nil {
+			log.Println("Error sending verification email:", err)
+		}
+	}()
+}
+
+// verifyEmailHandler marks the account behind a verification link as
+// verified and sends the user to the dashboard.
+func (app *App) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.checkEmailVerificationToken(r.URL.Query().Get("token"), time.Now())
+	if !ok {
+		app.writeErrorPage(w, r, http.StatusBadRequest, "This verification link is invalid or has expired. Sign in to request a new one.")
+		return
+	}
+	if !user.Verified {
+		if err := app.db.Model(&user).Update("verified", true).Error; err != nil {
+			log.Println("Error verifying email:", err)
+			writeServerError(w)
+			return
+		}
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// resendVerificationHandler sends the signed-in user a fresh verification
+// link.
+func (app *App) resendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	// Check authentication
+	session, _ := app.store.Get(r, "session")
+	userID, ok := session.Values["user_id"]
+	if !ok || userID == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`<div class="error">Unauthorized. Please log in.</div>`))
+		return
+	}
+
+	var user User
+	if err := app.db.First(&user, userID).Error; err != nil {
+		writeServerError(w)
+		return
+	}
+	data := map[string]interface{}{"User": user}
+	if user.Verified {
+		app.tmpl.ExecuteTemplate(w, "verify_email.templ", data)
+		return
+	}
+
+	now := time.Now()
+	limit := app.limiter.allow("verify-resend:"+strconv.Itoa(int(user.ID)), verificationResendsPerHour, time.Hour, now)
+	if !limit.Allowed {
+		setRateLimitHeaders(w, limit, now)
+		w.WriteHeader(http.StatusTooManyRequests)
+		data["Error"] = "You've asked for several links already. Please wait a while and check your spam folder."
+		app.tmpl.ExecuteTemplate(w, "verify_email.templ", data)
+		return
+	}
+	app.sendVerificationEmail(user)
+	data["Sent"] = true
+	app.tmpl.ExecuteTemplate(w, "verify_email.templ", data)
+}
+
+// requiresVerifiedEmail reports whether path is only open to users with a
+// verified email address.
+func requiresVerifiedEmail(path string) bool {
+	return path == "/items" || strings.HasPrefix(path, "/items/") || path == "/stats"
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	webauthnRPName = "HTMX Auth App"
+	// webauthnTimeout is how long the browser and the server wait for a
+	// registration or login ceremony to finish.
+	webauthnTimeout = 5 * time.Minute
+	// maxPasskeyNameLength limits the label users give a passkey.
+	maxPasskeyNameLength = 100
+)
+
+// COSE algorithm identifiers for the public key types we accept.
+const (
+	coseES256 = -7
+	coseEdDSA = -8
+	coseRS256 = -257
+)
+
+// Authenticator data flags.
+const (
+	authDataUserPresent  = 0x01
+	authDataAttestedData = 0x40
+)
+
+// WebAuthnCredential is a passkey registered by a user. PublicKey holds the
+// DER-encoded SubjectPublicKeyInfo the browser reports for the credential.
+type WebAuthnCredential struct {
+	ID           uint   `gorm:"primaryKey"`
+	UserID       uint   `gorm:"not null;index"`
+	CredentialID string `gorm:"unique;not null"` // base64url, as sent by the browser
+	PublicKey    []byte `gorm:"not null"`
+	Algorithm    int    `gorm:"not null"`
+	SignCount    uint32
+	Name         string `gorm:"not null"`
+	LastUsedAt   *time.Time
+	CreatedAt    time.Time
+}
+
+var b64url = base64.RawURLEncoding
+
+// webauthnRP returns the relying party ID and the origin ceremonies must
+// come from, both taken from BASE_URL.
+func (app *App) webauthnRP() (rpID, origin string) {
+	u, err := url.Parse(app.config.BaseURL)
+	if err != nil {
+		return "", ""
+	}
+	return u.Hostname(), u.Scheme + "://" + u.Host
+}
+
+// newWebAuthnChallenge stores a fresh challenge in the session and returns
+// it base64url encoded.
+func (app *App) newWebAuthnChallenge(w http.ResponseWriter, r *http.Request) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	challenge := b64url.EncodeToString(b)
+	session, _ := app.store.Get(r, "session")
+	session.Values["webauthn_challenge"] = challenge
+	session.Values["webauthn_challenge_at"] = time.Now().Unix()
+	return challenge, session.Save(r, w)
+}
+
+// takeWebAuthnChallenge returns the session's pending challenge and clears
+// it, so each challenge can only be answered once.
+func (app *App) takeWebAuthnChallenge(w http.ResponseWriter, r *http.Request) (string, bool) {
+	session, _ := app.store.Get(r, "session")
+	challenge, _ := session.Values["webauthn_challenge"].(string)
+	issued, _ := session.Values["webauthn_challenge_at"].(int64)
+	delete(session.Values, "webauthn_challenge")
+	delete(session.Values, "webauthn_challenge_at")
+	if err := session.Save(r, w); err != nil {
+		log.Println("Error saving session:", err)
+	}
+	if challenge == "" || time.Since(time.Unix(issued, 0)) > webauthnTimeout {
+		return "", false
+	}
+	return challenge, true
+}
+
+// checkClientData verifies the clientDataJSON of a ceremony response.
+func (app *App) checkClientData(raw []byte, ceremony, challenge string) error {
+	var clientData struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return errors.New("invalid client data")
+	}
+	_, origin := app.webauthnRP()
+	switch {
+	case clientData.Type != ceremony:
+		return errors.New("wrong ceremony type")
+	case subtle.ConstantTimeCompare([]byte(clientData.Challenge), []byte(challenge)) != 1:
+		return errors.New("challenge mismatch")
+	case clientData.Origin != origin:
+		return errors.New("origin mismatch")
+	}
+	return nil
+}
+
+// authenticatorData is the part of the authenticator data we check.
+type authenticatorData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte // only present during registration
+}
+
+// parseAuthenticatorData checks the RP ID hash and user presence flag and
+// returns the rest of the authenticator data.
+func (app *App) parseAuthenticatorData(raw []byte) (authenticatorData, error) {
+	var ad authenticatorData
+	if len(raw) < 37 {
+		return ad, errors.New("authenticator data too short")
+	}
+	rpID, _ := app.webauthnRP()
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(raw[:32], rpIDHash[:]) {
+		return ad, errors.New("RP ID mismatch")
+	}
+	ad.flags = raw[32]
+	if ad.flags&authDataUserPresent == 0 {
+		return ad, errors.New("user not present")
+	}
+	ad.signCount = binary.BigEndian.Uint32(raw[33:37])
+
+	if ad.flags&authDataAttestedData != 0 {
+		// AAGUID (16 bytes), credential ID length (2 bytes), credential ID
+		rest := raw[37:]
+		if len(rest) < 18 {
+			return ad, errors.New("attested credential data too short")
+		}
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		if len(rest) < 18+n {
+			return ad, errors.New("attested credential data too short")
+		}
+		ad.credentialID = rest[18 : 18+n]
+	}
+	return ad, nil
+}
+
+// parseCredentialPublicKey parses a DER SubjectPublicKeyInfo and checks it
+// matches the COSE algorithm the browser reported.
+func parseCredentialPublicKey(der []byte, alg int) (crypto.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, errors.New("invalid public key")
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey:
+		if alg == coseES256 {
+			return key, nil
+		}
+	case ed25519.PublicKey:
+		if alg == coseEdDSA {
+			return key, nil
+		}
+	case *rsa.PublicKey:
+		if alg == coseRS256 {
+			return key, nil
+		}
+	}
+	return nil, errors.New("unsupported public key algorithm")
+}
+
+// verifyAssertionSignature checks an assertion signature over the
+// authenticator data and the hash of the client data.
+func verifyAssertionSignature(cred WebAuthnCredential, authData, clientDataJSON, sig []byte) error {
+	key, err := parseCredentialPublicKey(cred.PublicKey, cred.Algorithm)
+	if err != nil {
+		return err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+	digest := sha256.Sum256(signed)
+
+	var ok bool
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(k, digest[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(k, signed, sig)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// webauthnUserHandle is the opaque user handle stored with a passkey.
+func webauthnUserHandle(userID uint) string {
+	return b64url.EncodeToString([]byte(strconv.FormatUint(uint64(userID), 10)))
+}
+
+type webauthnCredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+func (app *App) credentialDescriptors(userID uint) []webauthnCredentialDescriptor {
+	var creds []WebAuthnCredential
+	app.db.Where("user_id = ?", userID).Find(&creds)
+	descriptors := []webauthnCredentialDescriptor{}
+	for _, c := range creds {
+		descriptors = append(descriptors, webauthnCredentialDescriptor{Type: "public-key", ID: c.CredentialID})
+	}
+	return descriptors
+}
+
+// passkeysHandler shows the user's registered passkeys.
+func (app *App) passkeysHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	app.renderPasskeys(w, user.ID, nil)
+}
+
+func (app *App) renderPasskeys(w http.ResponseWriter, userID uint, data map[string]interface{}) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	var creds []WebAuthnCredential
+	app.db.Where("user_id = ?", userID).Order("created_at").Find(&creds)
+	data["Passkeys"] = creds
+	app.tmpl.ExecuteTemplate(w, "passkeys.templ", data)
+}
+
+// beginPasskeyRegistrationHandler returns the options for
+// navigator.credentials.create().
+func (app *App) beginPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := app.store.Get(r, "session")
+	userID, ok := session.Values["user_id"]
+	if !ok || userID == nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var user User
+	if err := app.db.First(&user, userID).Error; err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	challenge, err := app.newWebAuthnChallenge(w, r)
+	if err != nil {
+		log.Println("Error starting passkey registration:", err)
+		writeJSONError(w, http.StatusInternalServerError, "Could not start passkey registration")
+		return
+	}
+	rpID, _ := app.webauthnRP()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"challenge": challenge,
+		"rp":        map[string]string{"id": rpID, "name": webauthnRPName},
+		"user": map[string]string{
+			"id":          webauthnUserHandle(user.ID),
+			"name":        user.Email,
+			"displayName": user.Email,
+		},
+		"pubKeyCredParams": []map[string]interface{}{
+			{"type": "public-key", "alg": coseES256},
+			{"type": "public-key", "alg": coseEdDSA},
+			{"type": "public-key", "alg": coseRS256},
+		},
+		"excludeCredentials": app.credentialDescriptors(user.ID),
+		"authenticatorSelection": map[string]string{
+			"residentKey":      "preferred",
+			"userVerification": "preferred",
+		},
+		"attestation": "none",
+		"timeout":     webauthnTimeout.Milliseconds(),
+	})
+}
+
+// finishPasskeyRegistrationHandler verifies the browser's attestation
+// response and stores the new passkey.
+func (app *App) finishPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := app.store.Get(r, "session")
+	userID, ok := session.Values["user_id"]
+	if !ok || userID == nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		ID                 string `json:"id"`
+		ClientDataJSON     string `json:"clientDataJSON"`
+		AuthenticatorData  string `json:"authenticatorData"`
+		PublicKey          string `json:"publicKey"`
+		PublicKeyAlgorithm int    `json:"publicKeyAlgorithm"`
+		Name               string `json:"name"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	challenge, ok := app.takeWebAuthnChallenge(w, r)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "Passkey registration timed out. Please try again.")
+		return
+	}
+
+	clientDataJSON, err1 := b64url.DecodeString(req.ClientDataJSON)
+	authData, err2 := b64url.DecodeString(req.AuthenticatorData)
+	publicKey, err3 := b64url.DecodeString(req.PublicKey)
+	credentialID, err4 := b64url.DecodeString(req.ID)
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid passkey response")
+		return
+	}
+	if err := app.checkClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Passkey registration failed: "+err.Error())
+		return
+	}
+	ad, err := app.parseAuthenticatorData(authData)
+	if err == nil && !bytes.Equal(ad.credentialID, credentialID) {
+		err = errors.New("credential ID mismatch")
+	}
+	if err == nil {
+		_, err = parseCredentialPublicKey(publicKey, req.PublicKeyAlgorithm)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Passkey registration failed: "+err.Error())
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > maxPasskeyNameLength {
+		writeJSONError(w, http.StatusBadRequest, "Passkey name is too long")
+		return
+	}
+	cred := WebAuthnCredential{
+		UserID:       toUint(userID),
+		CredentialID: req.ID,
+		PublicKey:    publicKey,
+		Algorithm:    req.PublicKeyAlgorithm,
+		SignCount:    ad.signCount,
+		Name:         name,
+	}
+	if err := app.db.Create(&cred).Error; err != nil {
+		log.Println("Error saving passkey:", err)
+		writeJSONError(w, http.StatusConflict, "This passkey is already registered")
+		return
+	}
+	app.renderPasskeys(w, cred.UserID, map[string]interface{}{"Success": "Passkey added. You can now sign in with it."})
+}
+
+// deletePasskeyHandler removes one of the user's passkeys.
+func (app *App) deletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	result := app.db.Where("id = ? AND user_id = ?", mux.Vars(r)["id"], user.ID).Delete(&WebAuthnCredential{})
+	if result.Error != nil {
+		writeServerError(w)
+		return
+	}
+	app.renderPasskeys(w, user.ID, nil)
+}
+
+// beginPasskeyLoginHandler returns the options for
+// navigator.credentials.get(). Passkeys are discoverable, so no email is
+// needed.
+func (app *App) beginPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	challenge, err := app.newWebAuthnChallenge(w, r)
+	if err != nil {
+		log.Println("Error starting passkey login:", err)
+		writeJSONError(w, http.StatusInternalServerError, "Could not start passkey login")
+		return
+	}
+	rpID, _ := app.webauthnRP()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"challenge":        challenge,
+		"rpId":             rpID,
+		"allowCredentials": []webauthnCredentialDescriptor{},
+		"userVerification": "preferred",
+		"timeout":          webauthnTimeout.Milliseconds(),
+	})
+}
+
+// finishPasskeyLoginHandler verifies the browser's assertion and signs the
+// user in.
+func (app *App) finishPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID                string `json:"id"`
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	challenge, ok := app.takeWebAuthnChallenge(w, r)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "Passkey sign-in timed out. Please try again.")
+		return
+	}
+
+	var cred WebAuthnCredential
+	if err := app.db.Where("credential_id = ?", req.ID).First(&cred).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println("Error loading passkey:", err)
+		}
+		writeJSONError(w, http.StatusUnauthorized, "This passkey isn't registered")
+		return
+	}
+	var user User
+	if err := app.db.First(&user, cred.UserID).Error; err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "This passkey isn't registered")
+		return
+	}
+
+	clientDataJSON, err1 := b64url.DecodeString(req.ClientDataJSON)
+	authData, err2 := b64url.DecodeString(req.AuthenticatorData)
+	sig, err3 := b64url.DecodeString(req.Signature)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid passkey response")
+		return
+	}
+	err := app.checkClientData(clientDataJSON, "webauthn.get", challenge)
+	var ad authenticatorData
+	if err == nil {
+		ad, err = app.parseAuthenticatorData(authData)
+	}
+	if err == nil && req.UserHandle != "" && req.UserHandle != webauthnUserHandle(user.ID) {
+		err = errors.New("user handle mismatch")
+	}
+	if err == nil {
+		err = verifyAssertionSignature(cred, authData, clientDataJSON, sig)
+	}
+	// A counter that doesn't increase suggests a cloned authenticator.
+	// Authenticators that don't count always report zero.
+	if err == nil && (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		err = errors.New("signature counter did not increase")
+	}
+	if err != nil {
+		app.logLogin(r, AuthOutcomeFailure, user, "")
+		writeJSONError(w, http.StatusUnauthorized, "Passkey sign-in failed: "+err.Error())
+		return
+	}
+
+	if user.Disabled {
+		app.logLogin(r, AuthOutcomeDisabled, user, "")
+		writeJSONError(w, http.StatusForbidden, accountSuspendedMessage)
+		return
+	}
+
+	now := time.Now()
+	app.db.Model(&cred).Updates(map[string]interface{}{"sign_count": ad.signCount, "last_used_at": now})
+
+	// A passkey already proves possession of a device, so the TOTP stage
+	// is skipped
+	session, _ := app.store.Get(r, "session")
+	app.completeLogin(w, r, session, user)
+}
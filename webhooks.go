@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Item webhook events
+const (
+	WebhookItemCreated = "item.created"
+	WebhookItemUpdated = "item.updated"
+	WebhookItemDeleted = "item.deleted"
+)
+
+// webhookEvents lists every event a webhook can subscribe to.
+var webhookEvents = []string{WebhookItemCreated, WebhookItemUpdated, WebhookItemDeleted}
+
+// Failed deliveries are retried webhookRetries times, waiting
+// webhookRetryBase before the first retry and twice as long before each
+// following one: 1s, 2s, 4s, 8s, 16s.
+const (
+	webhookRetries   = 5
+	webhookRetryBase = time.Second
+)
+
+// Subscribes reports whether the webhook wants event. A webhook without
+// event types gets every event.
+func (hook Webhook) Subscribes(event string) bool {
+	if hook.Events == "" {
+		return true
+	}
+	for _, e := range strings.Split(hook.Events, ",") {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWebhookEvents validates the selected event types and returns them
+// in the form stored in Webhook.Events. Selecting every event, or none,
+// stores "" so events added later are sent too.
+func parseWebhookEvents(selected []string) (string, error) {
+	chosen := map[string]bool{}
+	for _, event := range selected {
+		known := false
+		for _, e := range webhookEvents {
+			known = known || e == event
+		}
+		if !known {
+			return "", fmt.Errorf("Unknown webhook event %q", event)
+		}
+		chosen[event] = true
+	}
+	if len(chosen) == 0 || len(chosen) == len(webhookEvents) {
+		return "", nil
+	}
+	var events []string
+	for _, e := range webhookEvents {
+		if chosen[e] {
+			events = append(events, e)
+		}
+	}
+	return strings.Join(events, ","), nil
+}
+
+// EventList describes the webhook's events for display.
+func (hook Webhook) EventList() string {
+	if hook.Events == "" {
+		return "all events"
+	}
+	return strings.ReplaceAll(hook.Events, ",", ", ")
+}
+
+// webhookEventOption is one event checkbox of the webhook form.
+type webhookEventOption struct {
+	Name    string
+	Checked bool
+}
+
+// webhookEventOptions returns the form's event checkboxes, with the events
+// in selected (stored form) checked.
+func webhookEventOptions(selected string) []webhookEventOption {
+	hook := Webhook{Events: selected}
+	options := make([]webhookEventOption, len(webhookEvents))
+	for i, e := range webhookEvents {
+		options[i] = webhookEventOption{Name: e, Checked: hook.Subscribes(e)}
+	}
+	return options
+}
+
+// webhookPayload is the JSON body POSTed to a user's webhook.
+type webhookPayload struct {
+	Event     string       `json:"event"`
+	Item      itemResponse `json:"item"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+// webhookJob is one event to deliver to a user's webhook. attempt counts
+// the deliveries that already failed.
+type webhookJob struct {
+	userID  uint
+	payload webhookPayload
+	attempt int
+}
+
+// webhookDispatcher delivers item events to users' webhooks in the
+// background so handlers never wait on a remote server. Failed deliveries
+// are retried with exponential backoff.
+type webhookDispatcher struct {
+	db          *gorm.DB
+	client      *http.Client
+	queue       chan webhookJob
+	retryDelays []time.Duration
+}
+
+// newWebhookDispatcher starts a dispatcher with a fixed pool of workers.
+func newWebhookDispatcher(db *gorm.DB) *webhookDispatcher {
+	d := &webhookDispatcher{
+		db:          db,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan webhookJob, 256),
+		retryDelays: backoffDelays(webhookRetryBase, webhookRetries),
+	}
+	for i := 0; i < 4; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// notify queues an event about item for its owner's webhook. It never
+// blocks; if the queue is full the event is dropped and logged.
+func (d *webhookDispatcher) notify(event string, item Item) {
+	d.enqueue(webhookJob{
+		userID: item.UserID,
+		payload: webhookPayload{
+			Event:     event,
+			Item:      newItemResponse(item),
+			Timestamp: time.Now().UTC(),
+		},
+	})
+}
+
+// enqueue adds job to the queue without blocking, dropping it if the queue
+// is full.
+func (d *webhookDispatcher) enqueue(job webhookJob) {
+	select {
+	case d.queue <- job:
+	default:
+		log.Printf("Webhook queue full, dropping %s for user %d", job.payload.Event, job.userID)
+	}
+}
+
+// work delivers queued jobs. The webhook is looked up for every attempt,
+// so a retry goes to the current URL with the current secret, and stops
+// if the webhook was removed.
+func (d *webhookDispatcher) work() {
+	for job := range d.queue {
+		var hook Webhook
+		if err := d.db.Where("user_id = ?", job.userID).First(&hook).Error; err != nil {
+			continue // no webhook configured
+		}
+		if !hook.Subscribes(job.payload.Event) {
+			continue
+		}
+		d.deliver(hook, job)
+	}
+}
+
+// deliver POSTs job's payload to hook once. A failed delivery is queued
+// again after its backoff delay, so waiting for a retry never ties up a
+// worker.
+func (d *webhookDispatcher) deliver(hook Webhook, job webhookJob) {
+	body, err := json.Marshal(job.payload)
+	if err != nil {
+		log.Println("Error encoding webhook payload:", err)
+		return
+	}
+
+	err = d.post(hook, body)
+	if err == nil {
+		return
+	}
+	if job.attempt >= len(d.retryDelays) {
+		log.Printf("Webhook delivery to %s failed after %d attempts: %v", hook.URL, job.attempt+1, err)
+		return
+	}
+	delay := d.retryDelays[job.attempt]
+	job.attempt++
+	time.AfterFunc(delay, func() { d.enqueue(job) })
+}
+
+// backoffDelays returns n delays starting at base and doubling each time.
+func backoffDelays(base time.Duration, n int) []time.Duration {
+	delays := make([]time.Duration, n)
+	for i := range delays {
+		delays[i] = base << i
+	}
+	return delays
+}
+
+func (d *webhookDispatcher) post(hook Webhook, body []byte) error {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature", signWebhook(hook.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// signWebhook returns the X-Signature header value for body: "sha256="
+// followed by the hex HMAC-SHA256 of the body keyed with the secret.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// generateWebhookSecret returns a new random signing secret.
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// webhookHandler shows the user's webhook settings.
+func (app *App) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	// Check authentication
+	session, _ := app.store.Get(r, "session")
+	userID, ok := session.Values["user_id"]
+	if !ok || userID == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`<div class="error">Unauthorized. Please log in.</div>`))
+		return
+	}
+
+	data := map[string]interface{}{
+		"EventOptions": webhookEventOptions(""),
+	}
+	var hook Webhook
+	if app.db.Where("user_id = ?", userID).First(&hook).Error == nil {
+		data["Webhook"] = hook
+	}
+	app.tmpl.ExecuteTemplate(w, "webhook.templ", data)
+}
+
+// saveWebhookHandler sets the user's webhook URL and issues a new signing
+// secret.
+func (app *App) saveWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	// Check authentication
+	session, _ := app.store.Get(r, "session")
+	userID, ok := session.Values["user_id"]
+	if !ok || userID == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`<div class="error">Unauthorized. Please log in.</div>`))
+		return
+	}
+
+	rawURL := r.FormValue("url")
+	renderError := func(message string) {
+		app.tmpl.ExecuteTemplate(w, "webhook.templ", map[string]interface{}{
+			"Error":        message,
+			"URL":          rawURL,
+			"EventOptions": webhookEventOptions(strings.Join(r.Form["events"], ",")),
+		})
+	}
+	if app.isDemoUser(userID) {
+		w.WriteHeader(http.StatusForbidden)
+		renderError("Webhooks are not available for demo accounts")
+		return
+	}
+	if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		renderError("Webhook URL must be an absolute http or https URL")
+		return
+	}
+	events, err := parseWebhookEvents(r.Form["events"])
+	if err != nil {
+		renderError(err.Error())
+		return
+	}
+	if len(r.Form["events"]) == 0 {
+		renderError("Choose at least one event")
+		return
+	}
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		log.Println("Error generating webhook secret:", err)
+		writeServerError(w)
+		return
+	}
+
+	var hook Webhook
+	app.db.Where("user_id = ?", userID).FirstOrInit(&hook)
+	hook.UserID = toUint(userID)
+	hook.URL = rawURL
+	hook.Secret = secret
+	hook.Events = events
+	if err := app.db.Save(&hook).Error; err != nil {
+		log.Println("Error saving webhook:", err)
+		writeServerError(w)
+		return
+	}
+
+	app.tmpl.ExecuteTemplate(w, "webhook.templ", map[string]interface{}{
+		"Webhook":    hook,
+		"ShowSecret": true,
+	})
+}
+
+// deleteWebhookHandler removes the user's webhook.
+func (app *App) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	// Check authentication
+	session, _ := app.store.Get(r, "session")
+	userID, ok := session.Values["user_id"]
+	if !ok || userID == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`<div class="error">Unauthorized. Please log in.</div>`))
+		return
+	}
+
+	app.db.Where("user_id = ?", userID).Delete(&Webhook{})
+	app.tmpl.ExecuteTemplate(w, "webhook.templ", map[string]interface{}{
+		"EventOptions": webhookEventOptions(""),
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type webhookDelivery struct {
+	header http.Header
+	body   []byte
+}
+
+func TestWebhookDeliversSignedCreateEvent(t *testing.T) {
+	deliveries := make(chan webhookDelivery, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- webhookDelivery{header: r.Header, body: body}
+	}))
+	defer receiver.Close()
+
+	handler, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	if resp, body := c.post("/account/webhook", url.Values{"url": {receiver.URL + "/hook"}, "events": {WebhookItemCreated}}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("saving the webhook: status %d\n%s", resp.StatusCode, body)
+	}
+	var hook Webhook
+	if err := app.db.Where("user_id = ?", alice.ID).First(&hook).Error; err != nil {
+		t.Fatalf("webhook not saved: %v", err)
+	}
+	c.post("/items", url.Values{"name": {"Milk"}})
+
+	var delivery webhookDelivery
+	select {
+	case delivery = <-deliveries:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook delivery")
+	}
+
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write(delivery.body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := delivery.header.Get("X-Signature"); !hmac.Equal([]byte(got), []byte(want)) {
+		t.Errorf("X-Signature = %q, want %q", got, want)
+	}
+	if ct := delivery.header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	var payload struct {
+		Event     string       `json:"event"`
+		Item      itemResponse `json:"item"`
+		Timestamp time.Time    `json:"timestamp"`
+	}
+	if err := json.Unmarshal(delivery.body, &payload); err != nil {
+		t.Fatalf("decoding %s: %v", delivery.body, err)
+	}
+	if payload.Event != WebhookItemCreated || payload.Item.Name != "Milk" || payload.Timestamp.IsZero() {
+		t.Errorf("payload = %+v, want an item.created event for Milk with a timestamp", payload)
+	}
+}
+
+func TestWebhookRetriesFailedDeliveries(t *testing.T) {
+	attempts := make(chan int, 10)
+	count := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		attempts <- count
+		if count <= 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	_, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	app.db.Create(&Webhook{UserID: alice.ID, URL: receiver.URL, Secret: "secret"})
+	app.webhooks.retryDelays = []time.Duration{10 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond}
+
+	app.webhooks.notify(WebhookItemCreated, createTestItem(t, app, alice, "Milk"))
+	for want := 1; want <= 3; want++ {
+		select {
+		case got := <-attempts:
+			if got != want {
+				t.Fatalf("attempt %d, want %d", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no attempt %d", want)
+		}
+	}
+	// Delivered on the third attempt, so there is no fourth
+	select {
+	case got := <-attempts:
+		t.Errorf("attempt %d after a successful delivery", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebhookRetriesDontBlockWorkers(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	delivered := make(chan struct{}, 1)
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- struct{}{}
+	}))
+	defer working.Close()
+
+	_, app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	bob := createTestUser(t, app, "bob@example.com", "Correct-Horse-1", RoleUser)
+	app.db.Create(&Webhook{UserID: alice.ID, URL: working.URL, Secret: "secret"})
+	app.db.Create(&Webhook{UserID: bob.ID, URL: failing.URL, Secret: "secret"})
+	app.webhooks.retryDelays = []time.Duration{time.Hour}
+
+	// More failing deliveries than there are workers, each waiting an hour
+	// for its retry
+	item := createTestItem(t, app, bob, "Tea")
+	for i := 0; i < 8; i++ {
+		app.webhooks.notify(WebhookItemUpdated, item)
+	}
+	app.webhooks.notify(WebhookItemCreated, createTestItem(t, app, alice, "Milk"))
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a delivery waited behind other webhooks' retries")
+	}
+}
+
+func TestSaveWebhookReportsDatabaseErrors(t *testing.T) {
+	handler, app := newTestApp(t)
+	createTestUser(t, app, "alice@example.com", "Correct-Horse-1", RoleUser)
+	c := newTestClient(t, handler)
+	c.login("alice@example.com", "Correct-Horse-1")
+
+	if err := app.db.Migrator().DropTable(&Webhook{}); err != nil {
+		t.Fatal(err)
+	}
+	resp, body := c.post("/account/webhook", url.Values{"url": {"https://example.com/hook"}, "events": {WebhookItemCreated}})
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status %d, want %d\n%s", resp.StatusCode, http.StatusInternalServerError, body)
+	}
+}